@@ -5,16 +5,13 @@ import (
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	ginadapter "github.com/awslabs/aws-lambda-go-api-proxy/gin"
-	"github.com/gin-gonic/gin"
 	"github.com/prokhorind/nextcloud/function"
 )
 
 var ginLambda *ginadapter.GinLambda
 
 func init() {
-	r := gin.Default()
-	function.InitHandlers(r)
-	ginLambda = ginadapter.New(r)
+	ginLambda = ginadapter.New(function.NewRouter())
 }
 
 func Handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
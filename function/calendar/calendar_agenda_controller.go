@@ -0,0 +1,175 @@
+package calendar
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/settings"
+	"github.com/prokhorind/nextcloud/function/user"
+	log "github.com/sirupsen/logrus"
+)
+
+func HandleAgenda(c *gin.Context) {
+	creq := apps.CallRequest{}
+	if handleJsonParsingError(c, &creq, "HandleAgenda") {
+		return
+	}
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	period := getAgendaPeriod(creq)
+	log.Infof("Received an agenda request for %s for the mm user with id: %s", period, creq.Context.ActingUser.Id)
+
+	asBot := appclient.AsBot(creq.Context)
+	userSettingsService := user.UserSettingsServiceImpl{AsBot: asBot}
+	client := settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, token.AccessToken)
+	agendaService := NewAgendaService(creq, client, userSettingsService.GetUserSettingsById(creq.Context.ActingUser.Id))
+
+	now := time.Now().In(CalendarTimePostService{}.GetMMUserLocation(creq))
+	from, to := GetAgendaRange(period, now)
+	events := agendaService.GetAgendaEvents(from, to)
+	post := AgendaPostService{}.CreateAgendaPost(events, period, from, creq)
+	if _, err := asBot.DMPost(creq.Context.ActingUser.Id, post); err != nil {
+		log.Errorf("Agenda was not sent to user %s. Error: %s", creq.Context.ActingUser.Id, err)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Agenda was not sent")))
+		return
+	}
+	c.JSON(http.StatusOK, apps.NewTextResponse(""))
+}
+
+func HandleDigestForm(c *gin.Context) {
+	creq := apps.CallRequest{}
+	if handleJsonParsingError(c, &creq, "HandleDigestForm") {
+		return
+	}
+
+	userSettingsService := user.UserSettingsServiceImpl{AsBot: appclient.AsBot(creq.Context)}
+	digest := userSettingsService.GetUserSettingsById(creq.Context.ActingUser.Id).Digest
+	if len(digest.Time) == 0 {
+		digest = user.DigestSettings{Time: "08:00", Weekdays: []string{"MO", "TU", "WE", "TH", "FR"}, Period: agendaToday}
+	}
+
+	calendarPostServiceImpl := CalendarPostServiceImpl{}
+	weekdays := make([]apps.SelectOption, 0)
+	for _, option := range calendarPostServiceImpl.PrepareWeekdayOptions() {
+		for _, d := range digest.Weekdays {
+			if d == option.Value {
+				weekdays = append(weekdays, option)
+			}
+		}
+	}
+	period := calendarPostServiceImpl.PrepareDigestPeriodOptions()[0]
+	if digest.Period == agendaWeek {
+		period = calendarPostServiceImpl.PrepareDigestPeriodOptions()[1]
+	}
+
+	form := &apps.Form{
+		Title:  "Agenda digest",
+		Icon:   "icon.png",
+		Header: "The bot sends you the agenda of all your calendars at this time of the day, in your Mattermost timezone",
+		Fields: []apps.Field{
+			{
+				Type:  apps.FieldTypeBool,
+				Name:  digestEnabledField,
+				Label: "Send the digest",
+				Value: digest.Enabled,
+			},
+			{
+				Type:                apps.FieldTypeStaticSelect,
+				Name:                digestTimeField,
+				Label:               "Time",
+				IsRequired:          true,
+				SelectStaticOptions: calendarPostServiceImpl.PrepareDigestTimeOptions(),
+				Value:               apps.SelectOption{Label: digest.Time, Value: digest.Time},
+			},
+			{
+				Type:                apps.FieldTypeStaticSelect,
+				Name:                digestWeekdaysField,
+				Label:               "Days",
+				SelectIsMulti:       true,
+				SelectStaticOptions: calendarPostServiceImpl.PrepareWeekdayOptions(),
+				Value:               weekdays,
+			},
+			{
+				Type:                apps.FieldTypeStaticSelect,
+				Name:                digestPeriodField,
+				Label:               "Events",
+				IsRequired:          true,
+				SelectStaticOptions: calendarPostServiceImpl.PrepareDigestPeriodOptions(),
+				Value:               period,
+			},
+		},
+		Submit: apps.NewCall("/digest").WithExpand(apps.Expand{
+			ActingUserAccessToken: apps.ExpandAll,
+			ActingUser:            apps.ExpandAll,
+			OAuth2App:             apps.ExpandAll,
+			OAuth2User:            apps.ExpandAll,
+		}),
+	}
+	c.JSON(http.StatusOK, apps.NewFormResponse(*form))
+}
+
+func HandleSaveDigest(c *gin.Context) {
+	creq := apps.CallRequest{}
+	if handleJsonParsingError(c, &creq, "HandleSaveDigest") {
+		return
+	}
+
+	digest, fieldErrors := CreateDigestSettings(creq.Values)
+	if len(fieldErrors) != 0 {
+		c.JSON(http.StatusOK, apps.CallResponse{
+			Type: apps.CallResponseTypeError,
+			Text: "Digest settings are not valid",
+			Data: map[string]interface{}{"errors": fieldErrors},
+		})
+		return
+	}
+
+	userId := creq.Context.ActingUser.Id
+	asBot := appclient.AsBot(creq.Context)
+	store := KVDigestStore{AsBot: asBot}
+	if digest.Enabled {
+		tokenService := oauth.TokenServiceImpl{Creq: creq}
+		token, tokenErr := tokenService.GetActualToken()
+		if tokenErr != nil {
+			c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+			return
+		}
+		if err := shareTokenWithJobs(creq, asBot, *token); err != nil {
+			log.Errorf("Token of user %s was not shared with the digest. Error: %s", userId, err)
+			c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Digest was not turned on")))
+			return
+		}
+	}
+
+	userSettingsService := user.UserSettingsServiceImpl{AsBot: asBot}
+	userSettings := userSettingsService.GetUserSettingsById(userId)
+	userSettings.Digest = digest
+	userSettingsService.SetUserSettingsById(userId, userSettings)
+
+	if !digest.Enabled {
+		if err := store.RemoveUser(userId); err != nil {
+			log.Errorf("User %s was not removed from the digests. Error: %s", userId, err)
+		}
+		if err := unshareUnusedToken(asBot, oauth.ActingUserTokenStore{Creq: creq}, userId, userSettings); err != nil {
+			log.Errorf("Token of user %s was not removed from the digests. Error: %s", userId, err)
+		}
+		c.JSON(http.StatusOK, apps.NewTextResponse("Digest is off"))
+		return
+	}
+	if err := store.AddUser(userId); err != nil {
+		log.Errorf("User %s was not added to the digests. Error: %s", userId, err)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Digest was not turned on")))
+		return
+	}
+	c.JSON(http.StatusOK, apps.NewTextResponse("Digest is on, you get it at %s", digest.Time))
+}
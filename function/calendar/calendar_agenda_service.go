@@ -0,0 +1,368 @@
+package calendar
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	"github.com/prokhorind/nextcloud/function/user"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	icalDateFormat = "20060102"
+
+	agendaField    = "range"
+	agendaToday    = "today"
+	agendaTomorrow = "tomorrow"
+	agendaWeek     = "week"
+
+	// AllCalendarsValue stands for every calendar the user has not disabled instead of a calendar id
+	AllCalendarsValue = "all-calendars"
+)
+
+// AgendaEvent is an occurrence of an event in one of the calendars of the user.
+type AgendaEvent struct {
+	Event         *ics.VEvent
+	CalendarId    string
+	CalendarName  string
+	CalendarColor string
+	EventId       string
+	Start         time.Time
+	End           time.Time
+	AllDay        bool
+}
+
+// AgendaService collects the events of all calendars the user has not disabled.
+type AgendaService struct {
+	CalendarService CalendarService
+	EventsService   func(calendarId string) CalendarService
+	UserSettings    user.UserSettings
+}
+
+func NewAgendaService(creq apps.CallRequest, client nextcloud.Client, userSettings user.UserSettings) AgendaService {
+	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
+	userId := creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string)
+	calendarsUrl := fmt.Sprintf("%s/remote.php/dav/calendars/%s", remoteUrl, userId)
+	return AgendaService{
+		CalendarService: CalendarServiceImpl{calendarRequestService: CalendarRequestServiceImpl{Url: calendarsUrl, Client: client}},
+		EventsService: func(calendarId string) CalendarService {
+			return CalendarServiceImpl{calendarRequestService: CalendarRequestServiceImpl{Url: calendarsUrl + "/" + calendarId, Client: client}}
+		},
+		UserSettings: userSettings,
+	}
+}
+
+// GetAgendaEvents queries the calendars in parallel and merges their events.
+func (s AgendaService) GetAgendaEvents(from time.Time, to time.Time) []AgendaEvent {
+	calendars := make([]UserCalendar, 0)
+	for _, calendar := range s.CalendarService.GetUserCalendarDetails() {
+		if !s.UserSettings.Contains(calendar.Id) {
+			calendars = append(calendars, calendar)
+		}
+	}
+
+	eventRange := CalendarEventRequestRange{From: from, To: to}
+	calendarEvents := make([][]AgendaEvent, len(calendars))
+	wg := sync.WaitGroup{}
+	for i, calendar := range calendars {
+		wg.Add(1)
+		go func(i int, calendar UserCalendar) {
+			defer wg.Done()
+			calendarEventsData := s.EventsService(calendar.Id).GetCalendarEvents(eventRange)
+			calendarEvents[i] = ParseAgendaEvents(calendarEventsData, calendar, from, to)
+		}(i, calendar)
+	}
+	wg.Wait()
+
+	events := make([]AgendaEvent, 0)
+	for _, e := range calendarEvents {
+		events = append(events, e...)
+	}
+	SortAgendaEvents(events)
+	return events
+}
+
+// ParseAgendaEvents returns the occurrences which overlap the range. The query expands recurring events,
+// so every occurrence is a separate VEVENT.
+func ParseAgendaEvents(calendarEventsData []CalendarEventData, calendar UserCalendar, from time.Time, to time.Time) []AgendaEvent {
+	events := make([]AgendaEvent, 0)
+	for _, e := range calendarEventsData {
+		cal, err := ics.ParseCalendar(strings.NewReader(e.CalendarStr))
+		if err != nil {
+			log.Errorf("Can't parse calendar for event %s", e.CalendarId)
+			continue
+		}
+		for _, event := range cal.Events() {
+			if len(event.Properties) == 0 {
+				continue
+			}
+			start, end, allDay, ok := getEventTimeRange(event, from.Location())
+			if !ok || !isEventInRange(start, end, from, to) {
+				continue
+			}
+			events = append(events, AgendaEvent{event, calendar.Id, calendar.Name, calendar.Color, e.CalendarId, start, end, allDay})
+		}
+	}
+	return events
+}
+
+func SortAgendaEvents(events []AgendaEvent) {
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].Start.Equal(events[j].Start) {
+			return events[i].Start.Before(events[j].Start)
+		}
+		return getEventText(events[i].Event, ics.ComponentPropertySummary) < getEventText(events[j].Event, ics.ComponentPropertySummary)
+	})
+}
+
+// getEventTimeRange reads the dates of an all day event in the timezone of the user, they have no time.
+func getEventTimeRange(event *ics.VEvent, loc *time.Location) (time.Time, time.Time, bool, bool) {
+	if property := event.GetProperty(ics.ComponentPropertyDtStart); property != nil && len(property.Value) == len(icalDateFormat) {
+		start, err := time.ParseInLocation(icalDateFormat, property.Value, loc)
+		if err != nil {
+			return start, start, true, false
+		}
+		end := start.AddDate(0, 0, 1)
+		if property := event.GetProperty(ics.ComponentPropertyDtEnd); property != nil {
+			if endDate, err := time.ParseInLocation(icalDateFormat, property.Value, loc); err == nil && endDate.After(start) {
+				end = endDate
+			}
+		} else if duration, ok := getEventDuration(event); ok && duration >= 24*time.Hour {
+			end = start.AddDate(0, 0, int(duration/(24*time.Hour)))
+		}
+		return start, end, true, true
+	}
+
+	start, err := event.GetStartAt()
+	if err != nil {
+		return start, start, false, false
+	}
+	end, err := event.GetEndAt()
+	if duration, ok := getEventDuration(event); err != nil && ok {
+		end, err = start.Add(duration), nil
+	}
+	if err != nil || end.Before(start) {
+		end = start
+	}
+	return start, end, false, true
+}
+
+// getEventDuration returns the DURATION of an event which has it instead of DTEND.
+func getEventDuration(event *ics.VEvent) (time.Duration, bool) {
+	property := event.GetProperty(ics.ComponentProperty(ics.PropertyDuration))
+	if property == nil {
+		return 0, false
+	}
+	return parseIcalDuration(property.Value)
+}
+
+// isEventInRange keeps the events which started before the range and still go on, and the events without duration.
+func isEventInRange(start time.Time, end time.Time, from time.Time, to time.Time) bool {
+	return start.Before(to) && (end.After(from) || !start.Before(from))
+}
+
+// GetAgendaRange returns the days of the agenda in the timezone of the user.
+func GetAgendaRange(period string, now time.Time) (time.Time, time.Time) {
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case agendaTomorrow:
+		return dayStart.AddDate(0, 0, 1), dayStart.AddDate(0, 0, 2)
+	case agendaWeek:
+		return dayStart, dayStart.AddDate(0, 0, 7)
+	}
+	return dayStart, dayStart.AddDate(0, 0, 1)
+}
+
+func getAgendaPeriod(creq apps.CallRequest) string {
+	period := getSelectValue(creq.Values[agendaField])
+	if len(period) == 0 {
+		period, _ = creq.Call.State.(string)
+	}
+	if period != agendaTomorrow && period != agendaWeek {
+		return agendaToday
+	}
+	return period
+}
+
+func (c CalendarPostServiceImpl) PrepareAgendaOptions() []apps.SelectOption {
+	return []apps.SelectOption{
+		{Label: "Today", Value: agendaToday},
+		{Label: "Tomorrow", Value: agendaTomorrow},
+		{Label: "Next 7 days", Value: agendaWeek},
+	}
+}
+
+type AgendaPostService struct {
+}
+
+// CreateAgendaPost lists the events of the agenda in one message. Times are shown in the timezone of the user.
+func (s AgendaPostService) CreateAgendaPost(events []AgendaEvent, period string, from time.Time, creq apps.CallRequest) *model.Post {
+	loc := from.Location()
+	dateFormatService := DateFormatLocaleService{}
+	parsedLocale := dateFormatService.GetLocaleByTag(creq.Context.ActingUser.Locale)
+	timeFormat := dateFormatService.GetTimeFormatsByLocale(parsedLocale)
+	dayFormat := dateFormatService.GetFullFormatsByLocale(parsedLocale)
+	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
+
+	builder := strings.Builder{}
+	switch period {
+	case agendaTomorrow:
+		builder.WriteString("#### Your agenda for tomorrow\n")
+	case agendaWeek:
+		builder.WriteString("#### Your agenda for the next 7 days\n")
+	default:
+		builder.WriteString("#### Your agenda for today\n")
+	}
+	if len(events) == 0 {
+		builder.WriteString("You don`t have events in this period\n")
+	}
+
+	day := ""
+	for _, e := range events {
+		start := e.Start.In(loc)
+		if start.Before(from) {
+			start = from
+		}
+		if startDay := start.Format(dayFormat); startDay != day {
+			day = startDay
+			dayUrl := fmt.Sprintf("%s/apps/calendar/timeGridDay/%s", remoteUrl, start.Format("2006-01-02"))
+			builder.WriteString(fmt.Sprintf("\n**[%s](%s)**\n", day, dayUrl))
+		}
+		builder.WriteString(s.createAgendaLine(e, loc, timeFormat))
+	}
+
+	commandBinding := apps.Binding{
+		Location:    "embedded",
+		AppID:       "nextcloud",
+		Label:       "",
+		Description: "Agenda actions",
+		Bindings:    []apps.Binding{},
+	}
+	s.createAgendaButton(&commandBinding, "Today", agendaToday)
+	s.createAgendaButton(&commandBinding, "Tomorrow", agendaTomorrow)
+	s.createAgendaButton(&commandBinding, "Next 7 days", agendaWeek)
+	commandBinding.Bindings = append(commandBinding.Bindings, apps.Binding{
+		Location: "digest",
+		Label:    "Digest settings",
+		Submit: apps.NewCall("/digest/form").WithExpand(apps.Expand{
+			ActingUser: apps.ExpandAll,
+		}),
+	})
+
+	post := model.Post{Message: builder.String()}
+	m1 := make(map[string]interface{})
+	m1["app_bindings"] = []apps.Binding{commandBinding}
+	post.SetProps(m1)
+	return &post
+}
+
+func (s AgendaPostService) createAgendaLine(e AgendaEvent, loc *time.Location, timeFormat string) string {
+	title := getEventText(e.Event, ics.ComponentPropertySummary)
+	if len(title) == 0 {
+		title = "Untitled event"
+	}
+	if status := e.Event.GetProperty(ics.ComponentPropertyStatus); status != nil && status.Value == "CANCELLED" {
+		title = fmt.Sprintf("~~%s~~ (cancelled)", title)
+	} else {
+		title = fmt.Sprintf("**%s**", title)
+	}
+
+	start := e.Start.In(loc)
+	end := e.End.In(loc)
+	when := fmt.Sprintf("%s - %s", start.Format(timeFormat), end.Format(timeFormat))
+	if e.AllDay {
+		when = "All day"
+	}
+
+	line := fmt.Sprintf("- %s %s · %s", when, title, FormatCalendarLabel(e.CalendarName, e.CalendarColor))
+	zoomLinks, googleMeetLinks := DetailsViewFormService{}.getZoomAndGoogleMeetLinksFromDescription(getEventText(e.Event, ics.ComponentPropertyDescription))
+	if len(zoomLinks) != 0 {
+		line += fmt.Sprintf(" · [Join Zoom](%s)", strings.Split(zoomLinks, " ")[0])
+	}
+	if len(googleMeetLinks) != 0 {
+		line += fmt.Sprintf(" · [Join Google Meet](%s)", strings.Split(googleMeetLinks, " ")[0])
+	}
+	return line + "\n"
+}
+
+func (s AgendaPostService) createAgendaButton(commandBinding *apps.Binding, label string, period string) {
+	commandBinding.Bindings = append(commandBinding.Bindings, apps.Binding{
+		Location: apps.Location(period),
+		Label:    label,
+		Submit: apps.NewCall("/agenda").WithExpand(apps.Expand{
+			OAuth2App:             apps.ExpandAll,
+			OAuth2User:            apps.ExpandAll,
+			ActingUserAccessToken: apps.ExpandAll,
+			ActingUser:            apps.ExpandAll,
+		}).WithState(period),
+	})
+}
+
+// FormatCalendarLabel shows the calendar with the circle emoji closest to its color, markdown has no colored text.
+func FormatCalendarLabel(name string, color string) string {
+	if marker := getColorMarker(color); len(marker) != 0 {
+		return marker + " " + name
+	}
+	return name
+}
+
+func getColorMarker(color string) string {
+	color = strings.TrimPrefix(color, "#")
+	if len(color) != 6 && len(color) != 8 {
+		return ""
+	}
+	rgb, err := strconv.ParseUint(color[:6], 16, 32)
+	if err != nil {
+		return ""
+	}
+	r := float64(rgb>>16&0xff) / 255
+	g := float64(rgb>>8&0xff) / 255
+	b := float64(rgb&0xff) / 255
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	lightness := (max + min) / 2
+	if max-min < 0.15 {
+		if lightness > 0.6 {
+			return "⚪"
+		}
+		return "⚫"
+	}
+
+	var hue float64
+	switch max {
+	case r:
+		hue = math.Mod((g-b)/(max-min), 6) * 60
+	case g:
+		hue = ((b-r)/(max-min) + 2) * 60
+	default:
+		hue = ((r-g)/(max-min) + 4) * 60
+	}
+	if hue < 0 {
+		hue += 360
+	}
+
+	switch {
+	case hue < 15 || hue >= 330:
+		return "🔴"
+	case hue < 45 && lightness < 0.35:
+		return "🟤"
+	case hue < 45:
+		return "🟠"
+	case hue < 70:
+		return "🟡"
+	case hue < 170:
+		return "🟢"
+	case hue < 260:
+		return "🔵"
+	}
+	return "🟣"
+}
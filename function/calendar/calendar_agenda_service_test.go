@@ -0,0 +1,162 @@
+package calendar
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prokhorind/nextcloud/function/user"
+)
+
+type AgendaCalendarsMock struct {
+	CalendarEventServiceImplMock
+}
+
+func (c AgendaCalendarsMock) getUserCalendars() (UserCalendarsResponse, error) {
+	items := make([]UserCalendarsResponseItems, 0)
+	colors := map[string]string{"Work": "#0082c9", "Personal": "#E6C300FF"}
+	for _, name := range []string{"Work", "Personal", "Hidden"} {
+		propstat := UserCalendarPropstat{Prop: UserCalendarProp{Displayname: name, CalendarColor: colors[name]}}
+		items = append(items, UserCalendarsResponseItems{"", "/remote.php/dav/calendars/admin/" + strings.ToLower(name) + "/", propstat})
+	}
+	return UserCalendarsResponse{Response: items}, nil
+}
+
+type AgendaEventsMock struct {
+	CalendarEventServiceImplMock
+	calendarId string
+}
+
+func (c AgendaEventsMock) getCalendarEvents(event CalendarEventRequestRange) (UserCalendarEventsResponse, error) {
+	events := map[string]string{
+		"work":     "BEGIN:VEVENT\nUID:review\nDTSTART:20230206T140000Z\nDTEND:20230206T150000Z\nSUMMARY:Review\nDESCRIPTION:Join https://us02web.zoom.us/j/123456\nEND:VEVENT\n",
+		"personal": "BEGIN:VEVENT\nUID:holiday\nDTSTART;VALUE=DATE:20230206\nDTEND;VALUE=DATE:20230207\nSUMMARY:Holiday\nEND:VEVENT\nBEGIN:VEVENT\nUID:gym\nDTSTART:20230207T070000Z\nDTEND:20230207T080000Z\nSUMMARY:Gym\nEND:VEVENT\n",
+		"hidden":   "BEGIN:VEVENT\nUID:hidden\nDTSTART:20230206T100000Z\nDTEND:20230206T110000Z\nSUMMARY:Hidden\nEND:VEVENT\n",
+	}
+	prop := CalendarProp{CalendarData: "BEGIN:VCALENDAR\nVERSION:2.0\n" + events[c.calendarId] + "END:VCALENDAR\n"}
+	item := UserCalendarEventsResponseItems{"", "/remote.php/dav/calendars/admin/" + c.calendarId + "/event.ics", CalendarPropStat{Prop: prop}}
+	return UserCalendarEventsResponse{Response: []UserCalendarEventsResponseItems{item}}, nil
+}
+
+func createAgendaTestService() AgendaService {
+	return AgendaService{
+		CalendarService: CalendarServiceImpl{calendarRequestService: AgendaCalendarsMock{}},
+		EventsService: func(calendarId string) CalendarService {
+			return CalendarServiceImpl{calendarRequestService: AgendaEventsMock{calendarId: calendarId}}
+		},
+		UserSettings: user.UserSettings{DisabledCalendars: []string{"hidden"}},
+	}
+}
+
+func TestGetAgendaEventsMergesEnabledCalendars(t *testing.T) {
+	from, to := GetAgendaRange(agendaWeek, time.Date(2023, 2, 6, 9, 0, 0, 0, time.UTC))
+
+	events := createAgendaTestService().GetAgendaEvents(from, to)
+
+	actual := make([]string, 0)
+	for _, e := range events {
+		actual = append(actual, e.Event.Id()+"-"+e.CalendarName)
+	}
+	expected := "holiday-Personal review-Work gym-Personal"
+	if strings.Join(actual, " ") != expected {
+		t.Errorf(" expected %q, actual %q", expected, strings.Join(actual, " "))
+	}
+	if !events[0].AllDay || events[1].AllDay {
+		t.Error("Only the event with a date should be an all day event")
+	}
+}
+
+func TestCreateAgendaPost(t *testing.T) {
+	loc, _ := time.LoadLocation("Europe/Kiev")
+	from, to := GetAgendaRange(agendaToday, time.Date(2023, 2, 6, 9, 0, 0, 0, loc))
+	events := createAgendaTestService().GetAgendaEvents(from, to)
+	creq := apps.CallRequest{Context: apps.Context{ExpandedContext: apps.ExpandedContext{ActingUser: &model.User{Id: "1", Locale: "en"}}}}
+
+	post := AgendaPostService{}.CreateAgendaPost(events, agendaToday, from, creq)
+
+	for _, expected := range []string{"Your agenda for today", "Monday, February 6", "All day **Holiday** · 🟡 Personal", "4:00 PM - 5:00 PM **Review** · 🔵 Work · [Join Zoom](https://us02web.zoom.us/j/123456)"} {
+		if !strings.Contains(post.Message, expected) {
+			t.Errorf(" expected %q in %q", expected, post.Message)
+		}
+	}
+	if strings.Contains(post.Message, "Gym") {
+		t.Error("Events of tomorrow should not be in the agenda of today")
+	}
+	if buttons := post.GetProps()["app_bindings"].([]apps.Binding)[0].Bindings; len(buttons) != 4 {
+		t.Errorf("Agenda should have 4 buttons, actual %d", len(buttons))
+	}
+}
+
+func TestIsDigestDue(t *testing.T) {
+	digest := user.DigestSettings{Enabled: true, Time: "08:30", Weekdays: []string{"MO", "TU"}, Period: agendaToday}
+	monday := time.Date(2023, 2, 6, 8, 40, 0, 0, time.UTC)
+
+	if !IsDigestDue(digest, monday, "2023-02-03") {
+		t.Error("Digest should be sent after its time")
+	}
+	if IsDigestDue(digest, monday, "2023-02-06") {
+		t.Error("Digest should be sent once a day")
+	}
+	if IsDigestDue(digest, monday.Add(-time.Hour), "") || IsDigestDue(digest, monday.Add(2*time.Hour), "") {
+		t.Error("Digest should not be sent before its time or long after it")
+	}
+	if IsDigestDue(digest, monday.AddDate(0, 0, 2), "") {
+		t.Error("Digest should not be sent on other days")
+	}
+}
+
+func TestCreateDigestSettings(t *testing.T) {
+	values := map[string]interface{}{
+		digestEnabledField:  true,
+		digestTimeField:     map[string]interface{}{"value": "07:30"},
+		digestWeekdaysField: []interface{}{map[string]interface{}{"value": "MO"}},
+		digestPeriodField:   map[string]interface{}{"value": agendaWeek},
+	}
+
+	digest, fieldErrors := CreateDigestSettings(values)
+	if len(fieldErrors) != 0 || digest.Time != "07:30" || digest.Period != agendaWeek || len(digest.Weekdays) != 1 {
+		t.Errorf("Unexpected digest %+v, errors %v", digest, fieldErrors)
+	}
+
+	values[digestWeekdaysField] = []interface{}{}
+	if _, fieldErrors := CreateDigestSettings(values); len(fieldErrors[digestWeekdaysField]) == 0 {
+		t.Error("Digest without days should not be allowed")
+	}
+}
+
+func TestGetAllCalendarsEventsLabelsCalendars(t *testing.T) {
+	posts := make([]*model.Post, 0)
+	bot := PostsCollectorMock{posts: &posts}
+	testedInstance := GetEventsService{CalendarServiceImpl{}, CalendarTimePostService{}, CreateCalendarEventPostService{GetMMUser: bot}, bot}
+	actingUser := &model.User{Id: "1", Locale: "en", Timezone: map[string]string{"automaticTimezone": "UTC"}}
+	creq := apps.CallRequest{Context: apps.Context{ExpandedContext: apps.ExpandedContext{ActingUser: actingUser, OAuth2: apps.OAuth2Context{User: map[string]interface{}{"user_id": "admin"}}}}}
+
+	err := testedInstance.GetAllCalendarsEvents(creq, time.Date(2023, 2, 6, 12, 0, 0, 0, time.UTC), createAgendaTestService())
+
+	if err != nil || len(posts) != 2 {
+		t.Fatalf("Events of both enabled calendars should be posted, actual %d posts, error %v", len(posts), err)
+	}
+	if posts[0].Message != "🟡 Personal" || posts[1].Message != "🔵 Work" {
+		t.Errorf(" expected %q, actual %q", "🟡 Personal 🔵 Work", posts[0].Message+" "+posts[1].Message)
+	}
+}
+
+func TestFormatCalendarLabel(t *testing.T) {
+	tests := map[string]string{
+		"#0082c9":   "🔵 Work",
+		"#EB6C1A":   "🟠 Work",
+		"#d6121e":   "🔴 Work",
+		"#31CC7CFF": "🟢 Work",
+		"#795AAB":   "🟣 Work",
+		"#ffffff":   "⚪ Work",
+		"":          "Work",
+		"blue":      "Work",
+	}
+	for color, expected := range tests {
+		if actual := FormatCalendarLabel("Work", color); actual != expected {
+			t.Errorf(" expected %q, actual %q", expected, actual)
+		}
+	}
+}
@@ -14,7 +14,11 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/mattermost/mattermost-plugin-apps/apps"
 	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
 	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/settings"
+	"github.com/prokhorind/nextcloud/function/user"
 )
 
 func HandleCreateEvent(c *gin.Context) {
@@ -23,19 +27,15 @@ func HandleCreateEvent(c *gin.Context) {
 		return
 	}
 
-	oauthService := oauth.OauthServiceImpl{creq}
-
-	token, refreshErr := oauthService.RefreshToken()
-	if refreshErr != nil {
-		c.JSON(http.StatusOK, apps.NewErrorResponse(refreshErr))
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
 	accessToken := token.AccessToken
 
 	asActingUser := appclient.AsActingUser(creq.Context)
-	if handleStoreTokenInMMError(c, asActingUser, *token, "HandleCreateEvent") {
-		return
-	}
 	log.Infof("Received a create event request for the mm user with id: %s", creq.Context.ActingUser.Id)
 
 	calendarEventService := CalendarEventServiceImpl{creq, asActingUser}
@@ -49,7 +49,23 @@ func HandleCreateEvent(c *gin.Context) {
 	} else {
 		timezone = creq.Context.ActingUser.Timezone["automaticTimezone"]
 	}
-	uuid, body := calendarEventService.CreateEventBody(fromDateUTC, duration, timezone)
+	from, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", fromDateUTC)
+	if err != nil {
+		log.Errorf("Error during parsing time %s", fromDateUTC)
+		c.JSON(http.StatusOK, apps.CallResponse{Type: apps.CallResponseTypeError, Text: fmt.Sprintf("Error during parsing time %s", fromDateUTC)})
+		return
+	}
+	calendarTimePostService := CalendarTimePostService{}
+	rrule, fieldErrors := CreateRecurrenceRule(creq.Values, from.In(calendarTimePostService.GetMMUserLocation(creq)))
+	if len(fieldErrors) != 0 {
+		c.JSON(http.StatusOK, apps.CallResponse{
+			Type: apps.CallResponseTypeError,
+			Text: "Repeat options are not valid",
+			Data: map[string]interface{}{"errors": fieldErrors},
+		})
+		return
+	}
+	uuid, body := calendarEventService.CreateEventBody(fromDateUTC, duration, timezone, rrule)
 
 	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
 	userId := creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string)
@@ -57,10 +73,10 @@ func HandleCreateEvent(c *gin.Context) {
 
 	reqUrl := fmt.Sprintf("%s/remote.php/dav/calendars/%s/%s/%s.ics", remoteUrl, userId, calendar, uuid)
 
-	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Token: accessToken}
+	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Client: settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, accessToken)}
 	calendarService := CalendarServiceImpl{calendarRequestService: calendarRequestService}
 
-	_, err := calendarService.CreateEvent(body)
+	_, err = calendarService.CreateEvent(body)
 
 	if err != nil {
 		log.Errorf("Error creating an event with uuid %s Error: %s", uuid, err)
@@ -68,11 +84,11 @@ func HandleCreateEvent(c *gin.Context) {
 		return
 	}
 
-	DMEventPost(creq, calendarService, calendar, uuid)
+	DMEventPost(creq, calendarService, calendar, uuid, "Event created")
 	c.JSON(http.StatusOK, apps.NewTextResponse(""))
 }
 
-func DMEventPost(creq apps.CallRequest, calendarService CalendarService, calendar string, uuid string) {
+func DMEventPost(creq apps.CallRequest, calendarService CalendarService, calendar string, uuid string, message string) {
 	asBot := appclient.AsBot(creq.Context)
 
 	event, eventError := calendarService.GetCalendarEvent()
@@ -96,7 +112,7 @@ func DMEventPost(creq apps.CallRequest, calendarService CalendarService, calenda
 	postDto := CalendarEventPostDTO{vEvent, asBot, calendar, uuid + ".ics", loc, creq}
 
 	post := createCalendarEventPostService.CreateCalendarEventPost(&postDto)
-	post.Message = "Event created"
+	post.Message = message
 	mmUserId := creq.Context.ActingUser.Id
 	log.Infof("Sending the event post with id: %s for a mm user with id: %s", postDto.eventId, mmUserId)
 	_, dmError := asBot.DMPost(mmUserId, post)
@@ -122,15 +138,10 @@ func HandleCreateEventForm(c *gin.Context) {
 		return
 	}
 
-	oauthService := oauth.OauthServiceImpl{creq}
-	token, refreshErr := oauthService.RefreshToken()
-	if refreshErr != nil {
-		c.JSON(http.StatusOK, apps.NewErrorResponse(refreshErr))
-		return
-	}
-
-	asActingUser := appclient.AsActingUser(creq.Context)
-	if handleStoreTokenInMMError(c, asActingUser, *token, "HandleCreateEventForm") {
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
 
@@ -142,9 +153,14 @@ func HandleCreateEventForm(c *gin.Context) {
 
 	accessToken := token.AccessToken
 
-	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Token: accessToken}
+	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Client: settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, accessToken)}
 	calendarService := CalendarServiceImpl{calendarRequestService: calendarRequestService}
 	option := creq.State.(map[string]interface{})
+	userCalendars := calendarService.GetUserCalendars()
+	calendarOption := apps.SelectOption{Label: option["label"].(string), Value: option["value"].(string)}
+	if calendarOption.Value == AllCalendarsValue && len(userCalendars) != 0 {
+		calendarOption = userCalendars[0]
+	}
 
 	calendarTimePostService := CalendarTimePostService{}
 
@@ -191,6 +207,42 @@ func HandleCreateEventForm(c *gin.Context) {
 				SelectStaticOptions: calendarPostServiceImpl.PrepareMeetingDurations(),
 				Value:               apps.SelectOption{Label: "30 minutes", Value: "30 minutes"},
 			},
+			{
+				Type:                apps.FieldTypeStaticSelect,
+				Name:                reminderField,
+				Label:               "Reminder",
+				Description:         "Turn on direct message reminders by /nextcloud reminders on",
+				SelectStaticOptions: calendarPostServiceImpl.PrepareReminderOptions(),
+				Value:               apps.SelectOption{Label: "None", Value: reminderNone},
+			},
+			{
+				Type:                apps.FieldTypeStaticSelect,
+				Name:                repeatField,
+				Label:               "Repeat",
+				SelectStaticOptions: calendarPostServiceImpl.PrepareRepeatOptions(),
+				Value:               apps.SelectOption{Label: "Does not repeat", Value: repeatNone},
+			},
+			{
+				Type:                apps.FieldTypeStaticSelect,
+				Name:                repeatDaysField,
+				Label:               "Repeat on",
+				Description:         "Days of a weekly event, by default the day of its start",
+				SelectIsMulti:       true,
+				SelectStaticOptions: calendarPostServiceImpl.PrepareWeekdayOptions(),
+			},
+			{
+				Type:        apps.FieldTypeText,
+				Name:        repeatUntilField,
+				Label:       "Repeat until",
+				Description: "Optional last day of a repeated event, like 2024-12-31",
+			},
+			{
+				Type:        apps.FieldTypeText,
+				Name:        repeatCountField,
+				Label:       "Occurrences",
+				Description: "Optional number of times a repeated event happens",
+				TextSubtype: apps.TextFieldSubtypeNumber,
+			},
 			{
 				Type:        apps.FieldTypeText,
 				Name:        "description",
@@ -211,8 +263,8 @@ func HandleCreateEventForm(c *gin.Context) {
 				Name:                "calendar",
 				Label:               "Calendar",
 				IsRequired:          true,
-				SelectStaticOptions: calendarService.GetUserCalendars(),
-				Value:               apps.SelectOption{Label: option["label"].(string), Value: option["value"].(string)},
+				SelectStaticOptions: userCalendars,
+				Value:               calendarOption,
 			},
 		},
 		Submit: apps.NewCall("/create-calendar-event").WithExpand(apps.Expand{
@@ -229,6 +281,236 @@ func HandleCreateEventForm(c *gin.Context) {
 	c.JSON(http.StatusOK, apps.NewFormResponse(*form))
 }
 
+func HandleEditEventForm(c *gin.Context) {
+	creq := apps.CallRequest{}
+	if handleJsonParsingError(c, &creq, "HandleEditEventForm") {
+		return
+	}
+
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+	log.Infof("Received an edit event form request for the mm user with id: %s", creq.Context.ActingUser.Id)
+
+	calendarId := c.Param("calendarId")
+	eventId := c.Param("eventId")
+	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
+	userId := creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string)
+	reqUrl := fmt.Sprintf("%s/remote.php/dav/calendars/%s/%s/%s", remoteUrl, userId, calendarId, eventId)
+
+	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Client: settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, token.AccessToken)}
+	calendarService := CalendarServiceImpl{calendarRequestService: calendarRequestService}
+
+	eventIcs, etag, err := calendarService.GetCalendarEventWithEtag()
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Event was not found, it may be deleted")))
+		return
+	}
+	if len(etag) == 0 {
+		log.Errorf("Nextcloud did not return an etag for event %s", eventId)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Event can not be edited")))
+		return
+	}
+	cal, parseError := ics.ParseCalendar(strings.NewReader(eventIcs))
+	if parseError != nil || FindMasterEvent(cal) == nil {
+		log.Errorf("Can't parse calendar for event %s", eventId)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Event can not be edited")))
+		return
+	}
+	event := FindMasterEvent(cal)
+
+	asBot := appclient.AsBot(creq.Context)
+	calendarTimePostService := CalendarTimePostService{}
+	loc := calendarTimePostService.GetMMUserLocation(creq)
+	start, end, _, _ := getEventTimeRange(event, loc)
+	start = start.In(loc)
+
+	dateFormatService := DateFormatLocaleService{}
+	parsedLocale := dateFormatService.GetLocaleByTag(creq.Context.ActingUser.Locale)
+	calendarPostServiceImpl := CalendarPostServiceImpl{}
+	durationOption, durations := calendarPostServiceImpl.prepareDurationOptions(start, end.In(loc))
+
+	attendees := make([]apps.SelectOption, 0)
+	for _, a := range event.Attendees() {
+		if user, _, err := asBot.GetUserByEmail(a.Email(), ""); err == nil {
+			attendees = append(attendees, apps.SelectOption{Label: user.Username, Value: user.Id})
+		}
+	}
+
+	var header string
+	if IsRecurringEvent(event) {
+		header = "This event repeats, the changes apply to all its occurrences"
+	}
+	expand := apps.Expand{
+		ActingUserAccessToken: apps.ExpandAll,
+		OAuth2App:             apps.ExpandAll,
+		OAuth2User:            apps.ExpandAll,
+		Channel:               apps.ExpandAll,
+		ActingUser:            apps.ExpandAll,
+	}
+
+	log.Info("Creating edit event form")
+	form := &apps.Form{
+		Title:  "Edit Nextcloud calendar event",
+		Header: header,
+		Icon:   "icon.png",
+		Fields: []apps.Field{
+			{
+				Type:       apps.FieldTypeText,
+				Name:       "title",
+				Label:      "Title",
+				IsRequired: true,
+				Value:      getEventText(event, ics.ComponentPropertySummary),
+			},
+			{
+				Type:                apps.FieldTypeDynamicSelect,
+				Name:                "from-event-date",
+				Label:               "From",
+				IsRequired:          true,
+				Description:         "Type \"4 PM Today\", \"Next Tuesday\" or \"Monday 13:00\" to choose a date",
+				Value:               apps.SelectOption{Label: start.Format(dateFormatService.GetDateTimeFormatsByLocale(parsedLocale)), Value: start.String()},
+				SelectDynamicLookup: apps.NewCall("/get-parsed-date").WithExpand(expand),
+			},
+			{
+				Type:                apps.FieldTypeStaticSelect,
+				Name:                "duration",
+				Label:               "Duration",
+				IsRequired:          true,
+				SelectStaticOptions: durations,
+				Value:               durationOption,
+			},
+			{
+				Type:        apps.FieldTypeText,
+				Name:        "description",
+				Label:       "Description",
+				TextSubtype: apps.TextFieldSubtypeTextarea,
+				IsRequired:  false,
+				Value:       getEventText(event, ics.ComponentPropertyDescription),
+			},
+			{
+				Type:          apps.FieldTypeUser,
+				Name:          "attendees",
+				Label:         "Attendees",
+				Description:   "Attendees without Mattermost accounts are kept",
+				IsRequired:    false,
+				SelectIsMulti: true,
+				Value:         attendees,
+			},
+		},
+		Submit: apps.NewCall(fmt.Sprintf("/edit-calendar-event/%s/events/%s", calendarId, eventId)).WithExpand(expand).WithState(etag),
+	}
+
+	c.JSON(http.StatusOK, apps.NewFormResponse(*form))
+}
+
+func HandleEditEvent(c *gin.Context) {
+	creq := apps.CallRequest{}
+	if handleJsonParsingError(c, &creq, "HandleEditEvent") {
+		return
+	}
+
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+	log.Infof("Received an edit event request for the mm user with id: %s", creq.Context.ActingUser.Id)
+
+	calendarId := c.Param("calendarId")
+	eventId := c.Param("eventId")
+	etag, _ := creq.State.(string)
+	if len(etag) == 0 {
+		log.Errorf("Edit form of event %s has no etag", eventId)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("The event can not be saved safely, open Edit again")))
+		return
+	}
+	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
+	userId := creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string)
+	reqUrl := fmt.Sprintf("%s/remote.php/dav/calendars/%s/%s/%s", remoteUrl, userId, calendarId, eventId)
+
+	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Client: settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, token.AccessToken)}
+	calendarService := CalendarServiceImpl{calendarRequestService: calendarRequestService}
+
+	eventIcs, err := calendarService.GetCalendarEvent()
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Event was not found, it may be deleted")))
+		return
+	}
+	cal, parseError := ics.ParseCalendar(strings.NewReader(eventIcs))
+	if parseError != nil {
+		log.Errorf("Can't parse calendar for event %s", eventId)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Event can not be edited")))
+		return
+	}
+
+	asBot := appclient.AsBot(creq.Context)
+	calendarEventService := CalendarEventServiceImpl{creq, asBot}
+	fromDateUTC := creq.Values["from-event-date"].(map[string]interface{})["value"].(string)
+	duration := creq.Values["duration"].(map[string]interface{})["value"].(string)
+	var timezone string
+	if creq.Context.ActingUser.Timezone["useAutomaticTimezone"] == "false" {
+		timezone = creq.Context.ActingUser.Timezone["manualTimezone"]
+	} else {
+		timezone = creq.Context.ActingUser.Timezone["automaticTimezone"]
+	}
+
+	body, update, err := calendarEventService.UpdateEventBody(cal, fromDateUTC, duration, timezone)
+	if err != nil {
+		log.Errorf("Event %s was not updated. Error: %s", eventId, err)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Event can not be edited")))
+		return
+	}
+
+	if _, err := calendarService.UpdateEvent(body, etag); err != nil {
+		if errors.Is(err, nextcloud.ErrPreconditionFailed) {
+			c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("The event was changed by someone else after you opened the form, open Edit again to see the changes")))
+			return
+		}
+		c.JSON(http.StatusOK, apps.CallResponse{Type: apps.CallResponseTypeError, Text: "Calendar event was not updated"})
+		return
+	}
+
+	DMEventPost(creq, calendarService, calendarId, strings.TrimSuffix(eventId, ".ics"), "Event updated")
+	notifyEventAttendees(creq, cal, calendarId, eventId, update)
+	c.JSON(http.StatusOK, apps.NewTextResponse(""))
+}
+
+// notifyEventAttendees sends the updated event to the attendees whose invitation changed.
+func notifyEventAttendees(creq apps.CallRequest, cal *ics.Calendar, calendarId string, eventId string, update EventUpdate) {
+	asBot := appclient.AsBot(creq.Context)
+	event := FindMasterEvent(cal)
+	calendarTimePostService := CalendarTimePostService{}
+	createCalendarEventPostService := CreateCalendarEventPostService{GetMMUser: asBot}
+	title := getEventText(event, ics.ComponentPropertySummary)
+
+	for _, email := range update.Invited {
+		user, _, err := asBot.GetUserByEmail(email, "")
+		if err != nil || user.Id == creq.Context.ActingUser.Id {
+			continue
+		}
+		postDto := CalendarEventPostDTO{event, asBot, calendarId, eventId, calendarTimePostService.GetUserLocation(user), creq}
+		post := createCalendarEventPostService.CreateAttendeeEventPost(&postDto)
+		post.Message = fmt.Sprintf("@%s updated your invitation, please answer in your calendar", creq.Context.ActingUser.Username)
+		if _, dmError := asBot.DMPost(user.Id, post); dmError != nil {
+			log.Errorf("Can`t send event post to user with id %s", user.Id)
+		}
+	}
+	for _, email := range update.Removed {
+		user, _, err := asBot.GetUserByEmail(email, "")
+		if err != nil {
+			continue
+		}
+		message := fmt.Sprintf("@%s removed you from the event **%s**", creq.Context.ActingUser.Username, title)
+		if _, dmError := asBot.DMPost(user.Id, &model.Post{Message: message}); dmError != nil {
+			log.Errorf("Can`t send event post to user with id %s", user.Id)
+		}
+	}
+}
+
 func DoNothing(c *gin.Context) {
 	c.JSON(http.StatusOK, apps.NewTextResponse(""))
 	return
@@ -240,15 +522,11 @@ func HandleDeleteCalendarEvent(c *gin.Context) {
 		return
 	}
 
-	oauthService := oauth.OauthServiceImpl{creq}
-	token, refreshErr := oauthService.RefreshToken()
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
 
-	if refreshErr != nil {
-		c.JSON(http.StatusOK, apps.NewErrorResponse(refreshErr))
-		return
-	}
-	asActingUser := appclient.AsActingUser(creq.Context)
-	if handleStoreTokenInMMError(c, asActingUser, *token, "HandleDeleteCalendarEvent") {
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
 	log.Infof("Received a delete event request for the mm user with id: %s", creq.Context.ActingUser.Id)
@@ -259,7 +537,7 @@ func HandleDeleteCalendarEvent(c *gin.Context) {
 	user := creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string)
 	deleteUrl := fmt.Sprintf("%s/remote.php/dav/calendars/%s/%s/%s", remoteUrl, user, calendarId, eventId)
 
-	calendarRequestService := CalendarRequestServiceImpl{Url: deleteUrl, Token: token.AccessToken}
+	calendarRequestService := CalendarRequestServiceImpl{Url: deleteUrl, Client: settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, token.AccessToken)}
 	calendarService := CalendarServiceImpl{calendarRequestService: calendarRequestService}
 	_, err := calendarService.DeleteUserEvent()
 
@@ -328,19 +606,14 @@ func HandleGetEventsToday(c *gin.Context) {
 	if handleJsonParsingError(c, &creq, "HandleGetEventsToday") {
 		return
 	}
-	oauthService := oauth.OauthServiceImpl{creq}
-
-	token, refreshErr := oauthService.RefreshToken()
-	if refreshErr != nil {
-		c.JSON(http.StatusOK, apps.NewErrorResponse(refreshErr))
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
 	log.Infof("Received a get events request for today for the mm user with id: %s", creq.Context.ActingUser.Id)
 
-	asActingUser := appclient.AsActingUser(creq.Context)
-	if handleStoreTokenInMMError(c, asActingUser, *token, "HandleGetEventsToday") {
-		return
-	}
 	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
 	calendar := creq.Call.State.(map[string]interface{})["value"].(string)
 	userId := creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string)
@@ -348,14 +621,15 @@ func HandleGetEventsToday(c *gin.Context) {
 	asBot := appclient.AsBot(creq.Context)
 
 	calendarTimePostService := CalendarTimePostService{}
-	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Token: token.AccessToken}
+	client := settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, token.AccessToken)
+	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Client: client}
 	calendarService := CalendarServiceImpl{calendarRequestService: calendarRequestService}
 	calendarPostServiceImpl := CreateCalendarEventPostService{GetMMUser: asBot}
 
 	location := calendarTimePostService.GetMMUserLocation(creq)
 
 	service := GetEventsService{calendarService, calendarTimePostService, calendarPostServiceImpl, asBot}
-	err := service.GetUserEvents(creq, time.Now().In(location), calendar)
+	err := getDayEvents(service, creq, client, time.Now().In(location), calendar)
 	if err != nil {
 		c.JSON(http.StatusOK, apps.NewTextResponse("You don`t have events at this date"))
 		return
@@ -368,18 +642,13 @@ func HandleGetEventsTomorrow(c *gin.Context) {
 	if handleJsonParsingError(c, &creq, "HandleGetEventsTomorrow") {
 		return
 	}
-	oauthService := oauth.OauthServiceImpl{creq}
-
-	token, refreshErr := oauthService.RefreshToken()
-	if refreshErr != nil {
-		c.JSON(http.StatusOK, apps.NewErrorResponse(refreshErr))
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
 
-	asActingUser := appclient.AsActingUser(creq.Context)
-	if handleStoreTokenInMMError(c, asActingUser, *token, "HandleGetEventsTomorrow") {
-		return
-	}
 	log.Infof("Received a get events request for tomorrow for the mm user with id: %s", creq.Context.ActingUser.Id)
 
 	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
@@ -389,14 +658,15 @@ func HandleGetEventsTomorrow(c *gin.Context) {
 	asBot := appclient.AsBot(creq.Context)
 
 	calendarTimePostService := CalendarTimePostService{}
-	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Token: token.AccessToken}
+	client := settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, token.AccessToken)
+	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Client: client}
 	calendarService := CalendarServiceImpl{calendarRequestService: calendarRequestService}
 	calendarPostServiceImpl := CreateCalendarEventPostService{GetMMUser: asBot}
 
 	location := calendarTimePostService.GetMMUserLocation(creq)
 
 	service := GetEventsService{calendarService, calendarTimePostService, calendarPostServiceImpl, asBot}
-	err := service.GetUserEvents(creq, time.Now().AddDate(0, 0, 1).In(location), calendar)
+	err := getDayEvents(service, creq, client, time.Now().AddDate(0, 0, 1).In(location), calendar)
 	if err != nil {
 		c.JSON(http.StatusOK, apps.NewTextResponse("You don`t have events at this date"))
 		return
@@ -410,18 +680,14 @@ func HandleGetEventsAtSelectedDay(c *gin.Context) {
 	if handleJsonParsingError(c, &creq, "HandleGetEventsAtSelectedDay") {
 		return
 	}
-	oauthService := oauth.OauthServiceImpl{creq}
-	token, refreshErr := oauthService.RefreshToken()
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
 
-	if refreshErr != nil {
-		c.JSON(http.StatusOK, apps.NewErrorResponse(refreshErr))
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
 
-	asActingUser := appclient.AsActingUser(creq.Context)
-	if handleStoreTokenInMMError(c, asActingUser, *token, "HandleGetEventsAtSelectedDay") {
-		return
-	}
 	log.Infof("Received a get events request for a selected date for the mm user with id: %s", creq.Context.ActingUser.Id)
 
 	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
@@ -430,7 +696,8 @@ func HandleGetEventsAtSelectedDay(c *gin.Context) {
 	asBot := appclient.AsBot(creq.Context)
 
 	calendarTimePostService := CalendarTimePostService{}
-	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Token: token.AccessToken}
+	client := settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, token.AccessToken)
+	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Client: client}
 	calendarService := CalendarServiceImpl{calendarRequestService: calendarRequestService}
 	calendarPostServiceImpl := CreateCalendarEventPostService{GetMMUser: asBot}
 
@@ -445,7 +712,7 @@ func HandleGetEventsAtSelectedDay(c *gin.Context) {
 		c.JSON(http.StatusOK, apps.CallResponse{Type: apps.CallResponseTypeError, Text: fmt.Sprintf("Error during parsing time %s", fromDateUTC)})
 		return
 	}
-	getEventErr := service.GetUserEvents(creq, from.In(location), calendar)
+	getEventErr := getDayEvents(service, creq, client, from.In(location), calendar)
 	if getEventErr != nil {
 		c.JSON(http.StatusOK, apps.NewTextResponse("You don`t have events at this date"))
 		return
@@ -453,23 +720,30 @@ func HandleGetEventsAtSelectedDay(c *gin.Context) {
 	c.JSON(http.StatusOK, apps.NewDataResponse(nil))
 }
 
+// getDayEvents sends the events of the day from one calendar or from all calendars of the user.
+func getDayEvents(service GetEventsService, creq apps.CallRequest, client nextcloud.Client, date time.Time, calendar string) error {
+	if calendar != AllCalendarsValue {
+		return service.GetUserEvents(creq, date, calendar)
+	}
+	userSettingsService := user.UserSettingsServiceImpl{AsBot: appclient.AsBot(creq.Context)}
+	agendaService := NewAgendaService(creq, client, userSettingsService.GetUserSettingsById(creq.Context.ActingUser.Id))
+	return service.GetAllCalendarsEvents(creq, date, agendaService)
+}
+
 func HandleChangeEventStatus(c *gin.Context) {
 	creq := apps.CallRequest{}
 	if handleJsonParsingError(c, &creq, "HandleChangeEventStatus") {
 		return
 	}
-	oauthService := oauth.OauthServiceImpl{creq}
-	token, refreshErr := oauthService.RefreshToken()
-	if refreshErr != nil {
-		c.JSON(http.StatusOK, apps.NewErrorResponse(refreshErr))
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
 
 	accessToken := token.AccessToken
 	asActingUser := appclient.AsActingUser(creq.Context)
-	if handleStoreTokenInMMError(c, asActingUser, *token, "HandleChangeEventStatus") {
-		return
-	}
 	log.Infof("Received a change event status request for the mm user with id: %s", creq.Context.ActingUser.Id)
 
 	user, _, _ := asActingUser.GetUser(creq.Context.ActingUser.Id, "")
@@ -482,7 +756,7 @@ func HandleChangeEventStatus(c *gin.Context) {
 	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
 	reqUrl := fmt.Sprintf("%s/remote.php/dav/calendars/%s/%s/%s", remoteUrl, userId, calendarId, eventId)
 
-	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Token: accessToken}
+	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Client: settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, accessToken)}
 	calendarService := CalendarServiceImpl{calendarRequestService: calendarRequestService}
 
 	eventIcs, getCalErr := calendarService.GetCalendarEvent()
@@ -551,19 +825,15 @@ func HandleGetUserCalendars(c *gin.Context) {
 	if handleJsonParsingError(c, &creq, "HandleChangeEventStatus") {
 		return
 	}
-	oauthService := oauth.OauthServiceImpl{creq}
-	token, refreshErr := oauthService.RefreshToken()
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
 
-	if refreshErr != nil {
-		c.JSON(http.StatusOK, apps.NewErrorResponse(refreshErr))
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
 
 	accessToken := token.AccessToken
-	asActingUser := appclient.AsActingUser(creq.Context)
-	if handleStoreTokenInMMError(c, asActingUser, *token, "HandleChangeEventStatus") {
-		return
-	}
 	log.Infof("Received a get user calendars request for the mm user with id: %s", creq.Context.ActingUser.Id)
 
 	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
@@ -571,7 +841,7 @@ func HandleGetUserCalendars(c *gin.Context) {
 
 	reqUrl := fmt.Sprintf("%s/remote.php/dav/calendars/%s", remoteUrl, userId)
 
-	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Token: accessToken}
+	calendarRequestService := CalendarRequestServiceImpl{Url: reqUrl, Client: settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, accessToken)}
 	calendarService := CalendarServiceImpl{calendarRequestService}
 
 	userCalendars := calendarService.GetUserCalendars()
@@ -584,6 +854,9 @@ func HandleGetUserCalendars(c *gin.Context) {
 	asBot := appclient.AsBot(creq.Context)
 
 	calendarPostServiceImpl := CalendarPostServiceImpl{}
+	if len(userCalendars) > 1 {
+		userCalendars = append([]apps.SelectOption{{Label: "All calendars", Value: AllCalendarsValue}}, userCalendars...)
+	}
 
 	for _, c := range userCalendars {
 		post := calendarPostServiceImpl.CreateCalendarPost(c)
@@ -606,13 +879,3 @@ func handleJsonParsingError(c *gin.Context, creq *apps.CallRequest, methodName s
 	}
 	return false
 }
-
-func handleStoreTokenInMMError(c *gin.Context, asBot *appclient.Client, token oauth.Token, methodName string) bool {
-	err := asBot.StoreOAuth2User(token)
-	if err != nil {
-		log.Errorf("Error during storing of oauthToken in %s method: %s", methodName, err.Error())
-		c.JSON(http.StatusOK, apps.CallResponse{Type: apps.CallResponseTypeError, Text: "Error during parsing of json request"})
-		return true
-	}
-	return false
-}
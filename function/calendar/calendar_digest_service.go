@@ -0,0 +1,172 @@
+package calendar
+
+import (
+	"context"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/prokhorind/nextcloud/function/settings"
+	"github.com/prokhorind/nextcloud/function/user"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	digestEnabledField  = "enabled"
+	digestTimeField     = "time"
+	digestWeekdaysField = "weekdays"
+	digestPeriodField   = "period"
+
+	DigestUsersKvKey = "digest-users"
+	DigestSentKvKey  = "digest-sent-"
+	DigestInterval   = time.Minute
+	// digestLateness is how long after its time a digest is still sent, e.g. after a restart of the app
+	digestLateness   = time.Hour
+	digestTimeFormat = "15:04"
+	digestDateFormat = "2006-01-02"
+)
+
+var weekdayCodes = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+func (c CalendarPostServiceImpl) PrepareDigestTimeOptions() []apps.SelectOption {
+	options := make([]apps.SelectOption, 0)
+	day := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for t := day; t.Day() == day.Day(); t = t.Add(30 * time.Minute) {
+		options = append(options, apps.SelectOption{Label: t.Format(digestTimeFormat), Value: t.Format(digestTimeFormat)})
+	}
+	return options
+}
+
+func (c CalendarPostServiceImpl) PrepareDigestPeriodOptions() []apps.SelectOption {
+	return []apps.SelectOption{
+		{Label: "Events of the day", Value: agendaToday},
+		{Label: "Events of the next 7 days", Value: agendaWeek},
+	}
+}
+
+// CreateDigestSettings reads the digest form.
+func CreateDigestSettings(values map[string]interface{}) (user.DigestSettings, map[string]string) {
+	fieldErrors := make(map[string]string)
+	enabled, _ := values[digestEnabledField].(bool)
+	digest := user.DigestSettings{
+		Enabled:  enabled,
+		Time:     getSelectValue(values[digestTimeField]),
+		Weekdays: getMultiSelectValues(values[digestWeekdaysField]),
+		Period:   getSelectValue(values[digestPeriodField]),
+	}
+	if digest.Period != agendaWeek {
+		digest.Period = agendaToday
+	}
+	if _, err := time.Parse(digestTimeFormat, digest.Time); err != nil {
+		fieldErrors[digestTimeField] = "Choose the time of the digest"
+	}
+	if enabled && len(digest.Weekdays) == 0 {
+		fieldErrors[digestWeekdaysField] = "Choose at least one day"
+	}
+	return digest, fieldErrors
+}
+
+// IsDigestDue is true when the time of the digest has come today in the timezone of the user
+// and the digest was not sent today yet.
+func IsDigestDue(digest user.DigestSettings, now time.Time, lastSent string) bool {
+	if !digest.Enabled || lastSent == now.Format(digestDateFormat) {
+		return false
+	}
+	isDay := false
+	for _, d := range digest.Weekdays {
+		if d == weekdayCodes[now.Weekday()] {
+			isDay = true
+		}
+	}
+	at, err := time.Parse(digestTimeFormat, digest.Time)
+	if !isDay || err != nil {
+		return false
+	}
+	scheduled := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	return !now.Before(scheduled) && now.Before(scheduled.Add(digestLateness))
+}
+
+// KVDigestStore keeps the users with a digest and the day of their last digest.
+type KVDigestStore struct {
+	AsBot JobKVClient
+}
+
+func (s KVDigestStore) GetUsers() []string {
+	return KVUserIndex{AsBot: s.AsBot, Key: DigestUsersKvKey}.GetUsers()
+}
+
+func (s KVDigestStore) AddUser(userId string) error {
+	return KVUserIndex{AsBot: s.AsBot, Key: DigestUsersKvKey}.AddUser(userId)
+}
+
+func (s KVDigestStore) RemoveUser(userId string) error {
+	if err := (KVUserIndex{AsBot: s.AsBot, Key: DigestUsersKvKey}).RemoveUser(userId); err != nil {
+		return err
+	}
+	return s.AsBot.KVDelete("", DigestSentKvKey+userId)
+}
+
+func (s KVDigestStore) GetLastSent(userId string) string {
+	lastSent := ""
+	s.AsBot.KVGet("", DigestSentKvKey+userId, &lastSent)
+	return lastSent
+}
+
+func (s KVDigestStore) SetLastSent(userId string, day string) error {
+	_, err := s.AsBot.KVSet("", DigestSentKvKey+userId, day)
+	return err
+}
+
+// SendAgendaDigests is the background job of the digests. It acts for every user who turned the digest on
+// with the token the user has shared.
+func SendAgendaDigests(creq apps.CallRequest) {
+	asBot := appclient.AsBot(creq.Context)
+	store := KVDigestStore{AsBot: asBot}
+	userSettingsService := user.UserSettingsServiceImpl{AsBot: asBot}
+
+	for _, userId := range store.GetUsers() {
+		userSettings := userSettingsService.GetUserSettingsById(userId)
+		if !userSettings.Digest.Enabled {
+			log.Infof("Digest of user %s is off, the user is removed from the digests", userId)
+			store.RemoveUser(userId)
+			continue
+		}
+		sendUserDigest(creq, asBot, store, userId, userSettings)
+	}
+}
+
+func sendUserDigest(creq apps.CallRequest, asBot *appclient.Client, store KVDigestStore, userId string, userSettings user.UserSettings) {
+	mmUser, _, err := asBot.GetUser(userId, "")
+	if err != nil {
+		log.Errorf("User %s of the digests was not found. Error: %s", userId, err)
+		return
+	}
+	loc := CalendarTimePostService{}.GetUserLocation(mmUser)
+	if loc == nil {
+		loc = time.UTC
+	}
+	now := time.Now().In(loc)
+	if !IsDigestDue(userSettings.Digest, now, store.GetLastSent(userId)) {
+		return
+	}
+
+	userCreq, token, err := createUserCallRequest(creq, asBot, mmUser)
+	if err != nil {
+		log.Errorf("Digest of user %s was not sent. Error: %s", userId, err)
+		return
+	}
+	client := settings.ForCall(userCreq).NewClient(context.Background(), token.AccessToken)
+	agendaService := NewAgendaService(userCreq, client, userSettings)
+
+	from, to := GetAgendaRange(userSettings.Digest.Period, now)
+	events := agendaService.GetAgendaEvents(from, to)
+	post := AgendaPostService{}.CreateAgendaPost(events, userSettings.Digest.Period, from, userCreq)
+	if _, err := asBot.DMPost(userId, post); err != nil {
+		log.Errorf("Digest was not sent to user %s. Error: %s", userId, err)
+		return
+	}
+	log.Infof("Digest with %d events sent to user %s", len(events), userId)
+	if err := store.SetLastSent(userId, now.Format(digestDateFormat)); err != nil {
+		log.Errorf("Digest day of user %s was not saved. Error: %s", userId, err)
+	}
+}
@@ -0,0 +1,195 @@
+package calendar
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var icsTextReplacer = strings.NewReplacer("\\n", "\n", "\\N", "\n", "\\,", ",", "\\;", ";", "\\\\", "\\")
+
+// EventUpdate keeps the emails of the attendees whose invitation changed after an edit.
+type EventUpdate struct {
+	Invited []string
+	Removed []string
+}
+
+// FindMasterEvent returns the event which is edited. A series can keep moved occurrences next to it,
+// they have a RECURRENCE-ID.
+func FindMasterEvent(cal *ics.Calendar) *ics.VEvent {
+	for _, e := range cal.Events() {
+		if e.GetProperty(ics.ComponentProperty(ics.PropertyRecurrenceId)) == nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// UpdateEventBody applies the values of the edit form to the event. Attendees without Mattermost accounts are kept,
+// the attendees of a rescheduled event have to answer again.
+func (c CalendarEventServiceImpl) UpdateEventBody(cal *ics.Calendar, fromDateUTC string, duration string, timezone string) (string, EventUpdate, error) {
+	update := EventUpdate{Invited: make([]string, 0), Removed: make([]string, 0)}
+	event := FindMasterEvent(cal)
+	if event == nil {
+		return "", update, errors.New("event was not found in the calendar object")
+	}
+
+	from, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", fromDateUTC)
+	if err != nil {
+		return "", update, errors.Wrapf(err, "time %s is not valid", fromDateUTC)
+	}
+	to := prepareEndDate(from, duration)
+	oldFrom, oldTo, allDay, _ := getEventTimeRange(event, from.Location())
+	rescheduled := !oldFrom.Equal(from) || !oldTo.Equal(to)
+
+	setEventTimes(event, from, to, timezone, allDay)
+	event.SetSummary(c.creq.Values["title"].(string))
+	removeEventProperties(event, ics.ComponentPropertyDescription)
+	if description, isPresent := c.creq.Values["description"].(string); isPresent && len(description) != 0 {
+		event.SetDescription(description)
+	}
+	event.SetDtStampTime(time.Now().UTC())
+	event.SetModifiedAt(time.Now().UTC())
+	event.SetSequence(getEventSequence(event) + 1)
+
+	emails := make(map[string]bool)
+	newEmails := make([]string, 0)
+	if c.creq.Values["attendees"] != nil {
+		userIds := make([]string, 0)
+		for _, a := range c.creq.Values["attendees"].([]interface{}) {
+			userIds = append(userIds, a.(map[string]interface{})["value"].(string))
+		}
+		users, _, _ := c.asBot.GetUsersByIds(userIds)
+		for _, u := range users {
+			emails[strings.ToLower(u.Email)] = true
+			newEmails = append(newEmails, strings.ToLower(u.Email))
+		}
+	}
+
+	properties := make([]ics.IANAProperty, 0, len(event.Properties))
+	for _, p := range event.Properties {
+		if p.IANAToken == string(ics.ComponentPropertyAttendee) {
+			attendee := ics.Attendee{IANAProperty: p}
+			email := strings.ToLower(attendee.Email())
+			if emails[email] {
+				delete(emails, email)
+				if rescheduled {
+					p.ICalParameters[string(ics.ParameterParticipationStatus)] = []string{string(ics.ParticipationStatusNeedsAction)}
+					update.Invited = append(update.Invited, attendee.Email())
+				}
+			} else if _, _, err := c.asBot.GetUserByEmail(attendee.Email(), ""); err == nil {
+				update.Removed = append(update.Removed, attendee.Email())
+				continue
+			}
+		}
+		properties = append(properties, p)
+	}
+	event.Properties = properties
+	for _, email := range newEmails {
+		if !emails[email] {
+			continue
+		}
+		event.AddAttendee(email, ics.CalendarUserTypeIndividual, ics.ParticipationStatusNeedsAction, ics.ParticipationRoleReqParticipant, ics.WithRSVP(true))
+		update.Invited = append(update.Invited, email)
+	}
+
+	log.Infof("Event body with uuid %s updated", event.Id())
+	return cal.Serialize(), update, nil
+}
+
+// setEventTimes writes the new time in the form the event already uses. An all-day event keeps date values
+// while it starts at midnight and lasts whole days, an event with DURATION keeps it instead of getting DTEND.
+func setEventTimes(event *ics.VEvent, from time.Time, to time.Time, timezone string, allDay bool) {
+	durationProperty := ics.ComponentProperty(ics.PropertyDuration)
+	keepDuration := event.GetProperty(durationProperty) != nil && event.GetProperty(ics.ComponentPropertyDtEnd) == nil
+	isDate := allDay && from.Hour() == 0 && from.Minute() == 0 && to.Sub(from) > 0 && to.Sub(from)%(24*time.Hour) == 0
+
+	if isDate {
+		dateValue := &ics.KeyValues{Key: string(ics.ParameterValue), Value: []string{string(ics.ValueDataTypeDate)}}
+		event.SetProperty(ics.ComponentPropertyDtStart, from.Format(icalDateFormat), dateValue)
+		if keepDuration {
+			event.SetProperty(durationProperty, formatIcalDuration(to.Sub(from)))
+		} else {
+			event.SetProperty(ics.ComponentPropertyDtEnd, to.Format(icalDateFormat), dateValue)
+		}
+		return
+	}
+
+	event.SetProperty(ics.ComponentPropertyDtStart, from.Format(icalTimestampFormatUtcLocal), &ics.KeyValues{Key: "TZID", Value: []string{timezone}})
+	if keepDuration {
+		event.SetProperty(durationProperty, formatIcalDuration(to.Sub(from)))
+	} else {
+		event.SetProperty(ics.ComponentPropertyDtEnd, to.Format(icalTimestampFormatUtcLocal), &ics.KeyValues{Key: "TZID", Value: []string{timezone}})
+	}
+}
+
+// formatIcalDuration writes a duration like P1D or PT1H30M, the form durations do not have seconds.
+func formatIcalDuration(d time.Duration) string {
+	days := d / (24 * time.Hour)
+	rest := d % (24 * time.Hour)
+	value := "P"
+	if days > 0 {
+		value += fmt.Sprintf("%dD", days)
+	}
+	if rest == 0 && days > 0 {
+		return value
+	}
+	value += "T"
+	if hours := rest / time.Hour; hours > 0 {
+		value += fmt.Sprintf("%dH", hours)
+	}
+	if minutes := rest % time.Hour / time.Minute; minutes > 0 || rest < time.Hour {
+		value += fmt.Sprintf("%dM", minutes)
+	}
+	return value
+}
+
+func removeEventProperties(event *ics.VEvent, property ics.ComponentProperty) {
+	properties := make([]ics.IANAProperty, 0, len(event.Properties))
+	for _, p := range event.Properties {
+		if p.IANAToken != string(property) {
+			properties = append(properties, p)
+		}
+	}
+	event.Properties = properties
+}
+
+func getEventSequence(event *ics.VEvent) int {
+	property := event.GetProperty(ics.ComponentPropertySequence)
+	if property == nil {
+		return 0
+	}
+	sequence, _ := strconv.Atoi(property.Value)
+	return sequence
+}
+
+func getEventText(event *ics.VEvent, property ics.ComponentProperty) string {
+	p := event.GetProperty(property)
+	if p == nil {
+		return ""
+	}
+	return icsTextReplacer.Replace(p.Value)
+}
+
+// prepareDurationOptions chooses the duration of the event among the form options. Other durations are
+// added to the options in minutes.
+func (c CalendarPostServiceImpl) prepareDurationOptions(from time.Time, to time.Time) (apps.SelectOption, []apps.SelectOption) {
+	durations := c.PrepareMeetingDurations()
+	if from.Hour() == 0 && from.Minute() == 0 && to.Equal(prepareEndDate(from, "All day")) {
+		return durations[len(durations)-1], durations
+	}
+	for _, option := range durations {
+		if option.Value != "All day" && prepareEndDate(from, option.Value).Equal(to) {
+			return option, durations
+		}
+	}
+	minutes := fmt.Sprintf("%d minutes", int(to.Sub(from).Minutes()))
+	option := apps.SelectOption{Label: minutes, Value: minutes}
+	return option, append(durations, option)
+}
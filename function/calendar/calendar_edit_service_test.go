@@ -0,0 +1,172 @@
+package calendar
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+)
+
+type KnownUsersMock struct {
+	MMClientMock
+	emails map[string]bool
+}
+
+func (s KnownUsersMock) GetUserByEmail(email, etag string) (*model.User, *model.Response, error) {
+	if !s.emails[email] {
+		return nil, nil, errors.New("not found")
+	}
+	return &model.User{Id: email, Email: email, Username: email}, nil, nil
+}
+
+const editedEventIcs = "BEGIN:VCALENDAR\nVERSION:2.0\nBEGIN:VEVENT\nUID:event\nSEQUENCE:2\nDTSTART;TZID=Europe/Kiev:20230206T100000\nDTEND;TZID=Europe/Kiev:20230206T103000\nSUMMARY:Planning\\, Q1\nDESCRIPTION:Old description\nATTENDEE;PARTSTAT=ACCEPTED:mailto:test1@avenga.com\nATTENDEE;PARTSTAT=ACCEPTED:mailto:old@avenga.com\nATTENDEE;PARTSTAT=ACCEPTED:mailto:external@example.com\nEND:VEVENT\nEND:VCALENDAR\n"
+
+func TestUpdateEventBody(t *testing.T) {
+	attendees := []interface{}{map[string]interface{}{"label": "test1", "value": "1"}}
+	values := map[string]interface{}{
+		"title":     "Planning, Q2",
+		"attendees": attendees,
+	}
+	creq := apps.CallRequest{Values: values, Context: apps.Context{ExpandedContext: apps.ExpandedContext{ActingUser: &model.User{Id: "1"}}}}
+	bot := KnownUsersMock{emails: map[string]bool{"test1@avenga.com": true, "old@avenga.com": true}}
+	testedInstance := CalendarEventServiceImpl{creq: creq, asBot: bot}
+	cal, _ := ics.ParseCalendar(strings.NewReader(editedEventIcs))
+
+	body, update, err := testedInstance.UpdateEventBody(cal, "2023-02-06 11:00:00 +0200 EET", "1 hour", "Europe/Kiev")
+	if err != nil {
+		t.Fatalf("Event should be updated, error %v", err)
+	}
+
+	updated, _ := ics.ParseCalendar(strings.NewReader(body))
+	event := updated.Events()[0]
+	if title := getEventText(event, ics.ComponentPropertySummary); title != "Planning, Q2" {
+		t.Errorf(" expected %q, actual %q", "Planning, Q2", title)
+	}
+	if event.GetProperty(ics.ComponentPropertyDescription) != nil || getEventSequence(event) != 3 {
+		t.Error("Description should be removed and sequence increased")
+	}
+	if start := event.GetProperty(ics.ComponentPropertyDtStart).Value; start != "20230206T110000" {
+		t.Errorf(" expected %q, actual %q", "20230206T110000", start)
+	}
+	emails := make([]string, 0)
+	for _, a := range event.Attendees() {
+		emails = append(emails, a.Email()+"-"+string(a.ParticipationStatus()))
+	}
+	expected := "test1@avenga.com-NEEDS-ACTION external@example.com-ACCEPTED test2@avenga.com-NEEDS-ACTION test3@avenga.com-NEEDS-ACTION"
+	if actual := strings.Join(emails, " "); actual != expected {
+		t.Errorf(" expected %q, actual %q", expected, actual)
+	}
+	if len(update.Invited) != 3 || len(update.Removed) != 1 || update.Removed[0] != "old@avenga.com" {
+		t.Errorf("Unexpected update %+v", update)
+	}
+}
+
+func TestUpdateEventBodyKeepsAnswersOfNotRescheduledEvent(t *testing.T) {
+	values := map[string]interface{}{"title": "Planning"}
+	creq := apps.CallRequest{Values: values, Context: apps.Context{ExpandedContext: apps.ExpandedContext{ActingUser: &model.User{Id: "1"}}}}
+	testedInstance := CalendarEventServiceImpl{creq: creq, asBot: KnownUsersMock{emails: map[string]bool{}}}
+	cal, _ := ics.ParseCalendar(strings.NewReader(editedEventIcs))
+
+	body, update, _ := testedInstance.UpdateEventBody(cal, "2023-02-06 10:00:00 +0200 EET", "30 minutes", "Europe/Kiev")
+
+	if len(update.Invited) != 0 || len(update.Removed) != 0 || strings.Contains(body, "NEEDS-ACTION") {
+		t.Errorf("Attendees should keep their answers, actual %+v", update)
+	}
+}
+
+func TestPrepareDurationOptions(t *testing.T) {
+	testedInstance := CalendarPostServiceImpl{}
+	from := time.Date(2023, 2, 6, 10, 0, 0, 0, time.UTC)
+
+	if option, options := testedInstance.prepareDurationOptions(from, from.Add(90*time.Minute)); option.Value != "1.5 hours" || len(options) != 7 {
+		t.Errorf(" expected %q, actual %q", "1.5 hours", option.Value)
+	}
+	if option, options := testedInstance.prepareDurationOptions(from, from.Add(20*time.Minute)); option.Value != "20 minutes" || len(options) != 8 {
+		t.Errorf(" expected %q, actual %q", "20 minutes", option.Value)
+	}
+}
+
+func TestUpdateEventSendsEtag(t *testing.T) {
+	var ifMatch string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifMatch = r.Header.Get("If-Match")
+		if ifMatch != `"current"` {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	testedInstance := CalendarRequestServiceImpl{Url: server.URL, Client: nextcloud.NewClient("token")}
+
+	if _, err := testedInstance.updateEvent("body", `"current"`); err != nil || ifMatch != `"current"` {
+		t.Errorf("Event should be updated with the etag, actual %q, error %v", ifMatch, err)
+	}
+	if _, err := testedInstance.updateEvent("body", `"outdated"`); !errors.Is(err, nextcloud.ErrPreconditionFailed) {
+		t.Errorf("Changed event should be reported, actual error %v", err)
+	}
+}
+
+func TestUpdateEventBodyKeepsDuration(t *testing.T) {
+	values := map[string]interface{}{"title": "Planning"}
+	creq := apps.CallRequest{Values: values, Context: apps.Context{ExpandedContext: apps.ExpandedContext{ActingUser: &model.User{Id: "1"}}}}
+	testedInstance := CalendarEventServiceImpl{creq: creq, asBot: KnownUsersMock{emails: map[string]bool{}}}
+	eventIcs := "BEGIN:VCALENDAR\nVERSION:2.0\nBEGIN:VEVENT\nUID:event\nDTSTART;TZID=Europe/Kiev:20230206T100000\nDURATION:PT30M\nSUMMARY:Planning\nEND:VEVENT\nEND:VCALENDAR\n"
+	cal, _ := ics.ParseCalendar(strings.NewReader(eventIcs))
+
+	body, _, err := testedInstance.UpdateEventBody(cal, "2023-02-06 11:00:00 +0200 EET", "1.5 hours", "Europe/Kiev")
+	if err != nil {
+		t.Fatalf("Event should be updated, error %v", err)
+	}
+
+	event := FindMasterEvent(cal)
+	if event.GetProperty(ics.ComponentPropertyDtEnd) != nil || strings.Contains(body, "DTEND") {
+		t.Error("Event with a duration should not get DTEND")
+	}
+	if duration := event.GetProperty(ics.ComponentProperty(ics.PropertyDuration)).Value; duration != "PT1H30M" {
+		t.Errorf(" expected %q, actual %q", "PT1H30M", duration)
+	}
+}
+
+func TestUpdateEventBodyKeepsAllDayEvent(t *testing.T) {
+	values := map[string]interface{}{"title": "Holiday"}
+	creq := apps.CallRequest{Values: values, Context: apps.Context{ExpandedContext: apps.ExpandedContext{ActingUser: &model.User{Id: "1"}}}}
+	testedInstance := CalendarEventServiceImpl{creq: creq, asBot: KnownUsersMock{emails: map[string]bool{}}}
+	eventIcs := "BEGIN:VCALENDAR\nVERSION:2.0\nBEGIN:VEVENT\nUID:event\nDTSTART;VALUE=DATE:20230206\nDTEND;VALUE=DATE:20230207\nSUMMARY:Holiday\nEND:VEVENT\nEND:VCALENDAR\n"
+	cal, _ := ics.ParseCalendar(strings.NewReader(eventIcs))
+
+	_, _, err := testedInstance.UpdateEventBody(cal, "2023-02-08 00:00:00 +0200 EET", "All day", "Europe/Kiev")
+	if err != nil {
+		t.Fatalf("Event should be updated, error %v", err)
+	}
+
+	event := FindMasterEvent(cal)
+	for property, expected := range map[ics.ComponentProperty]string{ics.ComponentPropertyDtStart: "20230208", ics.ComponentPropertyDtEnd: "20230209"} {
+		p := event.GetProperty(property)
+		if p.Value != expected || p.ICalParameters["VALUE"][0] != "DATE" || p.ICalParameters["TZID"] != nil {
+			t.Errorf(" expected date %q, actual %q %v", expected, p.Value, p.ICalParameters)
+		}
+	}
+}
+
+func TestFormatIcalDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		30 * time.Minute: "PT30M",
+		time.Hour:        "PT1H",
+		90 * time.Minute: "PT1H30M",
+		24 * time.Hour:   "P1D",
+		25 * time.Hour:   "P1DT1H",
+	}
+	for duration, expected := range tests {
+		if actual := formatIcalDuration(duration); actual != expected {
+			t.Errorf(" expected %q, actual %q", expected, actual)
+		}
+	}
+}
@@ -15,7 +15,7 @@ type CalendarEventServiceImpl struct {
 	asBot GetMMUser
 }
 
-func (c CalendarEventServiceImpl) CreateEventBody(fromDateUTC string, duration string, timezone string) (string, string) {
+func (c CalendarEventServiceImpl) CreateEventBody(fromDateUTC string, duration string, timezone string, rrule string) (string, string) {
 	log.Info("Creating event body")
 	from, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", fromDateUTC)
 	if err != nil {
@@ -40,11 +40,17 @@ func (c CalendarEventServiceImpl) CreateEventBody(fromDateUTC string, duration s
 	event.SetModifiedAt(time.Now().UTC())
 	event.SetProperty(ics.ComponentPropertyDtStart, from.Format(icalTimestampFormatUtcLocal), &ics.KeyValues{Key: "TZID", Value: []string{timezone}})
 	event.SetProperty(ics.ComponentPropertyDtEnd, to.Format(icalTimestampFormatUtcLocal), &ics.KeyValues{Key: "TZID", Value: []string{timezone}})
+	if len(rrule) != 0 {
+		event.AddRrule(rrule)
+	}
 	event.SetSummary(title)
 	event.SetLocation("Address")
 	if isPresent {
 		event.SetDescription(description)
 	}
+	if trigger := getSelectValue(c.creq.Values[reminderField]); len(trigger) != 0 && trigger != reminderNone {
+		addReminderToEvent(event, trigger, title)
+	}
 	event.SetOrganizer("mailto:"+organizer.Email, ics.WithCN("Owner"))
 
 	if c.creq.Values["attendees"] != nil {
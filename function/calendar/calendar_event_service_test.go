@@ -19,7 +19,7 @@ func TestCreateEventBody(t *testing.T) {
 	creq := apps.CallRequest{Values: values, Context: apps.Context{ExpandedContext: apps.ExpandedContext{ActingUser: &model.User{Id: "1"}}}}
 	testedInstance := CalendarEventServiceImpl{creq: creq, asBot: MMClientMock{}}
 
-	id, eventBody := testedInstance.CreateEventBody("2023-02-06 01:23:32.76349399 +0200 EET", "30 minutes", "Europe/Kiev")
+	id, eventBody := testedInstance.CreateEventBody("2023-02-06 01:23:32.76349399 +0200 EET", "30 minutes", "Europe/Kiev", "")
 
 	if len(id) == 0 || len(eventBody) == 0 {
 		t.Error("Error during creation of event body")
@@ -34,7 +34,7 @@ func TestCreateEventBodyWithDurationInHours(t *testing.T) {
 	creq := apps.CallRequest{Values: values, Context: apps.Context{ExpandedContext: apps.ExpandedContext{ActingUser: &model.User{Id: "1"}}}}
 	testedInstance := CalendarEventServiceImpl{creq: creq, asBot: MMClientMock{}}
 
-	id, eventBody := testedInstance.CreateEventBody("2023-02-06 01:23:32.76349399 +0200 EET", "2 hours", "Europe/Kiev")
+	id, eventBody := testedInstance.CreateEventBody("2023-02-06 01:23:32.76349399 +0200 EET", "2 hours", "Europe/Kiev", "")
 
 	if len(id) == 0 || len(eventBody) == 0 {
 		t.Error("Error during creation of event body")
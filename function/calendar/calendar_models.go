@@ -36,9 +36,17 @@ type UserCalendarPropstat struct {
 }
 
 type UserCalendarProp struct {
-	Text        string `xml:",chardata"`
-	Displayname string `xml:"displayname"`
-	Getctag     string `xml:"getctag"`
+	Text          string `xml:",chardata"`
+	Displayname   string `xml:"displayname"`
+	Getctag       string `xml:"getctag"`
+	CalendarColor string `xml:"calendar-color"`
+}
+
+// UserCalendar is a calendar of the user with the color chosen in Nextcloud, e.g. #0082c9.
+type UserCalendar struct {
+	Id    string
+	Name  string
+	Color string
 }
 
 type UserCalendarEventsResponse struct {
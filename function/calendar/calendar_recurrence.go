@@ -0,0 +1,139 @@
+package calendar
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+const (
+	repeatField      = "repeat"
+	repeatDaysField  = "repeat-days"
+	repeatUntilField = "repeat-until"
+	repeatCountField = "repeat-count"
+
+	repeatNone     = "NONE"
+	repeatDaily    = "DAILY"
+	repeatWeekdays = "WEEKDAYS"
+	repeatWeekly   = "WEEKLY"
+	repeatMonthly  = "MONTHLY"
+
+	repeatUntilFormat = "2006-01-02"
+)
+
+func (c CalendarPostServiceImpl) PrepareRepeatOptions() []apps.SelectOption {
+	return []apps.SelectOption{
+		{Label: "Does not repeat", Value: repeatNone},
+		{Label: "Daily", Value: repeatDaily},
+		{Label: "Every weekday (Monday to Friday)", Value: repeatWeekdays},
+		{Label: "Weekly", Value: repeatWeekly},
+		{Label: "Monthly", Value: repeatMonthly},
+	}
+}
+
+func (c CalendarPostServiceImpl) PrepareWeekdayOptions() []apps.SelectOption {
+	return []apps.SelectOption{
+		{Label: "Monday", Value: "MO"},
+		{Label: "Tuesday", Value: "TU"},
+		{Label: "Wednesday", Value: "WE"},
+		{Label: "Thursday", Value: "TH"},
+		{Label: "Friday", Value: "FR"},
+		{Label: "Saturday", Value: "SA"},
+		{Label: "Sunday", Value: "SU"},
+	}
+}
+
+// CreateRecurrenceRule builds the RRULE of the create form values. An empty rule is returned for events which
+// do not repeat, the errors are keyed by the form fields.
+func CreateRecurrenceRule(values map[string]interface{}, from time.Time) (string, map[string]string) {
+	fieldErrors := make(map[string]string)
+	frequency := getSelectValue(values[repeatField])
+	if len(frequency) == 0 || frequency == repeatNone {
+		return "", fieldErrors
+	}
+
+	var rule []string
+	switch frequency {
+	case repeatDaily:
+		rule = append(rule, "FREQ=DAILY")
+	case repeatWeekdays:
+		rule = append(rule, "FREQ=WEEKLY", "BYDAY=MO,TU,WE,TH,FR")
+	case repeatWeekly:
+		rule = append(rule, "FREQ=WEEKLY")
+		if days := getMultiSelectValues(values[repeatDaysField]); len(days) != 0 {
+			rule = append(rule, "BYDAY="+strings.Join(days, ","))
+		}
+	case repeatMonthly:
+		rule = append(rule, "FREQ=MONTHLY")
+	default:
+		fieldErrors[repeatField] = "Unknown repeat option"
+		return "", fieldErrors
+	}
+
+	until := strings.TrimSpace(getTextValue(values[repeatUntilField]))
+	count := strings.TrimSpace(getTextValue(values[repeatCountField]))
+	if len(until) != 0 && len(count) != 0 {
+		fieldErrors[repeatCountField] = "Choose either an end date or a number of occurrences"
+		return "", fieldErrors
+	}
+	if len(until) != 0 {
+		untilDate, err := time.ParseInLocation(repeatUntilFormat, until, from.Location())
+		if err != nil {
+			fieldErrors[repeatUntilField] = "Type a date like 2024-12-31"
+			return "", fieldErrors
+		}
+		// UNTIL has to be in UTC when the start has a TZID, the last day is included
+		untilDate = untilDate.AddDate(0, 0, 1).Add(-time.Second)
+		if untilDate.Before(from) {
+			fieldErrors[repeatUntilField] = "The end date is before the start of the event"
+			return "", fieldErrors
+		}
+		rule = append(rule, "UNTIL="+untilDate.UTC().Format(icalTimestampFormatUtc))
+	}
+	if len(count) != 0 {
+		occurrences, err := strconv.Atoi(count)
+		if err != nil || occurrences < 1 {
+			fieldErrors[repeatCountField] = "Type a positive number of occurrences"
+			return "", fieldErrors
+		}
+		rule = append(rule, fmt.Sprintf("COUNT=%d", occurrences))
+	}
+
+	return strings.Join(rule, ";"), fieldErrors
+}
+
+// IsRecurringEvent is true for the series and for their instances returned by an expanded calendar query.
+func IsRecurringEvent(event *ics.VEvent) bool {
+	return event.GetProperty(ics.ComponentPropertyRrule) != nil || event.GetProperty(ics.ComponentProperty(ics.PropertyRecurrenceId)) != nil
+}
+
+func getSelectValue(value interface{}) string {
+	option, _ := value.(map[string]interface{})
+	selected, _ := option["value"].(string)
+	return selected
+}
+
+func getMultiSelectValues(value interface{}) []string {
+	options, _ := value.([]interface{})
+	selected := make([]string, 0)
+	for _, o := range options {
+		if v := getSelectValue(o); len(v) != 0 {
+			selected = append(selected, v)
+		}
+	}
+	return selected
+}
+
+func getTextValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package calendar
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+type ExpandedEventsMock struct {
+	CalendarEventServiceImplMock
+}
+
+func (c ExpandedEventsMock) getCalendarEvents(event CalendarEventRequestRange) (UserCalendarEventsResponse, error) {
+	// a weekly series expanded by the server, its second occurrence is moved to the next day
+	prop := CalendarProp{CalendarData: "BEGIN:VCALENDAR\nVERSION:2.0\nBEGIN:VEVENT\nUID:series\nDTSTART:20230206T090000Z\nDTEND:20230206T093000Z\nRECURRENCE-ID:20230206T090000Z\nSUMMARY:Standup\nEND:VEVENT\nBEGIN:VEVENT\nUID:series\nDTSTART:20230208T090000Z\nDTEND:20230208T093000Z\nRECURRENCE-ID:20230207T090000Z\nSUMMARY:Standup\nEND:VEVENT\nEND:VCALENDAR\n"}
+	item := UserCalendarEventsResponseItems{"", "/remote.php/dav/calendars/admin/personal/series.ics", CalendarPropStat{Prop: prop}}
+	return UserCalendarEventsResponse{Response: []UserCalendarEventsResponseItems{item}}, nil
+}
+
+type PostsCollectorMock struct {
+	MMClientMock
+	posts *[]*model.Post
+}
+
+func (s PostsCollectorMock) DMPost(userID string, post *model.Post) (*model.Post, error) {
+	*s.posts = append(*s.posts, post)
+	return post, nil
+}
+
+func TestCreateRecurrenceRule(t *testing.T) {
+	from := time.Date(2023, 2, 6, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		values   map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{}, ""},
+		{map[string]interface{}{repeatField: map[string]interface{}{"value": repeatNone}}, ""},
+		{map[string]interface{}{repeatField: map[string]interface{}{"value": repeatDaily}, repeatCountField: "5"}, "FREQ=DAILY;COUNT=5"},
+		{map[string]interface{}{repeatField: map[string]interface{}{"value": repeatWeekdays}}, "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"},
+		{map[string]interface{}{repeatField: map[string]interface{}{"value": repeatWeekly}, repeatDaysField: []interface{}{map[string]interface{}{"value": "MO"}, map[string]interface{}{"value": "TH"}}}, "FREQ=WEEKLY;BYDAY=MO,TH"},
+		{map[string]interface{}{repeatField: map[string]interface{}{"value": repeatMonthly}, repeatUntilField: "2023-12-31"}, "FREQ=MONTHLY;UNTIL=20231231T235959Z"},
+	}
+
+	for _, test := range tests {
+		actual, fieldErrors := CreateRecurrenceRule(test.values, from)
+		if actual != test.expected || len(fieldErrors) != 0 {
+			t.Errorf(" expected %q, actual %q, errors %v", test.expected, actual, fieldErrors)
+		}
+	}
+}
+
+func TestCreateRecurrenceRuleErrors(t *testing.T) {
+	from := time.Date(2023, 2, 6, 9, 0, 0, 0, time.UTC)
+	daily := map[string]interface{}{"value": repeatDaily}
+
+	if _, fieldErrors := CreateRecurrenceRule(map[string]interface{}{repeatField: daily, repeatUntilField: "2023-12-31", repeatCountField: "3"}, from); len(fieldErrors[repeatCountField]) == 0 {
+		t.Error("End date and number of occurrences should not be allowed together")
+	}
+	if _, fieldErrors := CreateRecurrenceRule(map[string]interface{}{repeatField: daily, repeatUntilField: "2023-01-31"}, from); len(fieldErrors[repeatUntilField]) == 0 {
+		t.Error("End date before the start should not be allowed")
+	}
+	if _, fieldErrors := CreateRecurrenceRule(map[string]interface{}{repeatField: daily, repeatCountField: "0"}, from); len(fieldErrors[repeatCountField]) == 0 {
+		t.Error("Number of occurrences should be positive")
+	}
+}
+
+func TestCreateEventBodyWithRecurrence(t *testing.T) {
+	values := map[string]interface{}{
+		"title": "title",
+	}
+	creq := apps.CallRequest{Values: values, Context: apps.Context{ExpandedContext: apps.ExpandedContext{ActingUser: &model.User{Id: "1"}}}}
+	testedInstance := CalendarEventServiceImpl{creq: creq, asBot: MMClientMock{}}
+
+	_, eventBody := testedInstance.CreateEventBody("2023-02-06 01:23:32.76349399 +0200 EET", "30 minutes", "Europe/Kiev", "FREQ=WEEKLY;BYDAY=MO")
+
+	cal, _ := ics.ParseCalendar(strings.NewReader(eventBody))
+	rrule := cal.Events()[0].GetProperty(ics.ComponentPropertyRrule)
+	if rrule == nil || rrule.Value != "FREQ=WEEKLY;BYDAY=MO" {
+		t.Errorf(" expected %q, actual %+v", "FREQ=WEEKLY;BYDAY=MO", rrule)
+	}
+}
+
+func TestGetUserEventsShowsOccurrencesOfTheDay(t *testing.T) {
+	posts := make([]*model.Post, 0)
+	bot := PostsCollectorMock{posts: &posts}
+	calendarService := CalendarServiceImpl{calendarRequestService: ExpandedEventsMock{}}
+	testedInstance := GetEventsService{calendarService, CalendarTimePostService{}, CreateCalendarEventPostService{GetMMUser: bot}, bot}
+	userMap := map[string]interface{}{
+		"user_id": "1",
+	}
+	actingUser := &model.User{Id: "1", Locale: "en", Timezone: map[string]string{"automaticTimezone": "UTC"}}
+	creq := apps.CallRequest{Context: apps.Context{ExpandedContext: apps.ExpandedContext{ActingUser: actingUser, OAuth2: apps.OAuth2Context{User: userMap}}}}
+
+	err := testedInstance.GetUserEvents(creq, time.Date(2023, 2, 8, 12, 0, 0, 0, time.UTC), "personal")
+
+	if err != nil || len(posts) != 1 {
+		t.Fatalf("Only the moved occurrence should be posted, actual %d posts, error %v", len(posts), err)
+	}
+	label := posts[0].GetProps()["app_bindings"].([]apps.Binding)[0].Label
+	if !strings.Contains(label, "(repeats)") {
+		t.Errorf("Occurrence should be marked as repeated, actual %q", label)
+	}
+}
@@ -0,0 +1,112 @@
+package calendar
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/scheduler"
+	"github.com/prokhorind/nextcloud/function/user"
+	log "github.com/sirupsen/logrus"
+)
+
+func HandleEnableReminders(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	userId := creq.Context.ActingUser.Id
+	asBot := appclient.AsBot(creq.Context)
+	if err := shareTokenWithJobs(creq, asBot, *token); err != nil {
+		log.Errorf("Token of user %s was not shared with the reminders. Error: %s", userId, err)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Reminders were not turned on")))
+		return
+	}
+	if err := setUserReminders(asBot, userId, true); err != nil {
+		log.Errorf("Reminders of user %s were not turned on. Error: %s", userId, err)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Reminders were not turned on")))
+		return
+	}
+
+	c.JSON(http.StatusOK, apps.NewTextResponse("Reminders are on. You get a direct message when a reminder of your Nextcloud event goes off"))
+}
+
+func HandleDisableReminders(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+
+	userId := creq.Context.ActingUser.Id
+	asBot := appclient.AsBot(creq.Context)
+	if err := setUserReminders(asBot, userId, false); err != nil {
+		log.Errorf("Reminders of user %s were not turned off. Error: %s", userId, err)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Reminders were not turned off")))
+		return
+	}
+	userSettingsService := user.UserSettingsServiceImpl{AsBot: asBot}
+	userSettings := userSettingsService.GetUserSettingsById(userId)
+	if err := unshareUnusedToken(asBot, oauth.ActingUserTokenStore{Creq: creq}, userId, userSettings); err != nil {
+		log.Errorf("Token of user %s was not removed from the reminders. Error: %s", userId, err)
+	}
+
+	c.JSON(http.StatusOK, apps.NewTextResponse("Reminders are off"))
+}
+
+func setUserReminders(asBot *appclient.Client, userId string, enabled bool) error {
+	userSettingsService := user.UserSettingsServiceImpl{AsBot: asBot}
+	userSettings := userSettingsService.GetUserSettingsById(userId)
+	userSettings.Reminders = enabled
+	userSettingsService.SetUserSettingsById(userId, userSettings)
+
+	store := KVReminderStore{AsBot: asBot}
+	if enabled {
+		return store.AddUser(userId)
+	}
+	return store.RemoveUser(userId)
+}
+
+// shareTokenWithJobs lets the background jobs act for the user, they have no call of the user.
+func shareTokenWithJobs(creq apps.CallRequest, asBot *appclient.Client, token oauth.Token) error {
+	tokenStore := oauth.BotTokenStore{AsBot: asBot, UserId: creq.Context.ActingUser.Id}
+	if err := tokenStore.StoreToken(token); err != nil {
+		return err
+	}
+	scheduler.Capture(creq)
+	return nil
+}
+
+// unshareUnusedToken removes the token shared with the background jobs when neither the reminders
+// nor the digest of the user are on. The jobs keep the refreshed token only in the bot KV, so it is written
+// back to the user first, the token of the user may already be rotated. Only a call of the user can do it.
+func unshareUnusedToken(asBot oauth.TokenKVClient, userStore oauth.TokenStore, userId string, userSettings user.UserSettings) error {
+	if userSettings.Reminders || userSettings.Digest.Enabled {
+		return nil
+	}
+	sharedStore := oauth.BotTokenStore{AsBot: asBot, UserId: userId}
+	if shared := sharedStore.GetToken(); len(shared.RefreshToken) != 0 {
+		if err := userStore.StoreToken(shared); err != nil {
+			return errors.Wrap(err, "shared token was not stored for the user")
+		}
+	}
+	return asBot.KVDelete("", oauth.LatestTokenKvKey+userId)
+}
+
+// ForgetUser is the disconnect hook of the calendar, it stops the reminders and the digest of the user.
+func ForgetUser(creq apps.CallRequest, mmUserId string, accessToken string, forgetEverything bool) error {
+	asBot := appclient.AsBot(creq.Context)
+	if err := (KVReminderStore{AsBot: asBot}).RemoveUser(mmUserId); err != nil {
+		return errors.Wrap(err, "reminders were not removed")
+	}
+	if err := (KVDigestStore{AsBot: asBot}).RemoveUser(mmUserId); err != nil {
+		return errors.Wrap(err, "digest was not removed")
+	}
+	return nil
+}
@@ -0,0 +1,364 @@
+package calendar
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/settings"
+	"github.com/prokhorind/nextcloud/function/user"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	reminderField = "reminder"
+	reminderNone  = "NONE"
+
+	ReminderUsersKvKey = "reminder-users"
+	ReminderSentKvKey  = "reminder-sent-"
+	ReminderInterval   = time.Minute
+	// reminderLookback also sends the reminders which were due while the app was not running
+	reminderLookback = 5 * time.Minute
+	// reminderHorizon is the longest reminder of the create form
+	reminderHorizon = 24 * time.Hour
+)
+
+var icalDurationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+func (c CalendarPostServiceImpl) PrepareReminderOptions() []apps.SelectOption {
+	return []apps.SelectOption{
+		{Label: "None", Value: reminderNone},
+		{Label: "5 minutes before", Value: "PT5M"},
+		{Label: "10 minutes before", Value: "PT10M"},
+		{Label: "15 minutes before", Value: "PT15M"},
+		{Label: "30 minutes before", Value: "PT30M"},
+		{Label: "1 hour before", Value: "PT1H"},
+		{Label: "1 day before", Value: "P1D"},
+	}
+}
+
+func addReminderToEvent(event *ics.VEvent, duration string, title string) {
+	alarm := event.AddAlarm()
+	alarm.SetAction(ics.ActionDisplay)
+	alarm.SetTrigger("-" + duration)
+	alarm.SetProperty(ics.ComponentPropertyDescription, ics.ToText(title))
+}
+
+// parseIcalDuration reads durations like -PT15M or P1DT2H of the RFC 5545.
+func parseIcalDuration(value string) (time.Duration, bool) {
+	matched := icalDurationPattern.FindStringSubmatch(value)
+	if matched == nil || value == "P" || value == "-P" || value == "+P" {
+		return 0, false
+	}
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var duration time.Duration
+	for i, unit := range units {
+		if len(matched[i+2]) != 0 {
+			number, _ := strconv.Atoi(matched[i+2])
+			duration += time.Duration(number) * unit
+		}
+	}
+	if matched[1] == "-" {
+		duration = -duration
+	}
+	return duration, true
+}
+
+// getAlarmTime returns when the alarm of the event occurrence goes off.
+func getAlarmTime(alarm *ics.VAlarm, start time.Time, end time.Time) (time.Time, bool) {
+	trigger := alarm.GetProperty(ics.ComponentPropertyTrigger)
+	if trigger == nil {
+		return time.Time{}, false
+	}
+	if values := trigger.ICalParameters["VALUE"]; len(values) != 0 && values[0] == "DATE-TIME" {
+		at, err := time.Parse(icalTimestampFormatUtc, trigger.Value)
+		return at, err == nil
+	}
+	offset, ok := parseIcalDuration(trigger.Value)
+	if !ok {
+		return time.Time{}, false
+	}
+	if related := trigger.ICalParameters["RELATED"]; len(related) != 0 && related[0] == "END" {
+		return end.Add(offset), true
+	}
+	return start.Add(offset), true
+}
+
+// DueReminder is an alarm which went off since the previous run.
+type DueReminder struct {
+	Key   string
+	Event AgendaEvent
+}
+
+// FindDueReminders returns the alarms which went off in the lookback period before now and were not sent yet.
+func FindDueReminders(events []AgendaEvent, now time.Time, sent map[string]int64) []DueReminder {
+	due := make([]DueReminder, 0)
+	for _, e := range events {
+		if !e.End.After(now) {
+			continue
+		}
+		for _, alarm := range e.Event.Alarms() {
+			at, ok := getAlarmTime(alarm, e.Start, e.End)
+			if !ok || at.After(now) || !at.After(now.Add(-reminderLookback)) {
+				continue
+			}
+			key := fmt.Sprintf("%s-%d-%d", e.Event.Id(), e.Start.Unix(), at.Unix())
+			if _, isSent := sent[key]; isSent {
+				continue
+			}
+			sent[key] = at.Unix()
+			due = append(due, DueReminder{Key: key, Event: e})
+		}
+	}
+	return due
+}
+
+// formatReminderLead describes when the event starts, e.g. in 15 minutes.
+func formatReminderLead(lead time.Duration) string {
+	minutes := int(lead.Round(time.Minute).Minutes())
+	switch {
+	case minutes <= 0:
+		return "now"
+	case minutes%(24*60) == 0:
+		return fmt.Sprintf("in %d day(s)", minutes/(24*60))
+	case minutes%60 == 0:
+		return fmt.Sprintf("in %d hour(s)", minutes/60)
+	}
+	return fmt.Sprintf("in %d minute(s)", minutes)
+}
+
+type JobKVClient interface {
+	KVGet(prefix, id string, ref interface{}) error
+	KVSet(prefix, id string, in interface{}) (bool, error)
+	KVDelete(prefix, id string) error
+}
+
+// KVUserIndex keeps the users of a background job. Every user has an own membership key, the KV store
+// cannot list its keys, so the list of the users is kept under the index key as well. The KV store has
+// no compare-and-set, a write of the list is read back and retried when a parallel write dropped the user.
+type KVUserIndex struct {
+	AsBot JobKVClient
+	Key   string
+}
+
+// indexLocks serializes the updates of an index within the instance.
+var indexLocks sync.Map
+
+const kvUserIndexAttempts = 5
+
+func (s KVUserIndex) memberKey(userId string) string {
+	return s.Key + "-" + userId
+}
+
+func (s KVUserIndex) IsMember(userId string) bool {
+	member := false
+	s.AsBot.KVGet("", s.memberKey(userId), &member)
+	return member
+}
+
+// GetUsers returns the members of the index, users whose membership key is gone are skipped.
+func (s KVUserIndex) GetUsers() []string {
+	users := make([]string, 0)
+	for _, u := range s.getList() {
+		if s.IsMember(u) {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+func (s KVUserIndex) AddUser(userId string) error {
+	if _, err := s.AsBot.KVSet("", s.memberKey(userId), true); err != nil {
+		return err
+	}
+	return s.updateList(userId, true)
+}
+
+func (s KVUserIndex) RemoveUser(userId string) error {
+	if err := s.AsBot.KVDelete("", s.memberKey(userId)); err != nil {
+		return err
+	}
+	return s.updateList(userId, false)
+}
+
+func (s KVUserIndex) getList() []string {
+	users := make([]string, 0)
+	s.AsBot.KVGet("", s.Key, &users)
+	return users
+}
+
+func (s KVUserIndex) updateList(userId string, add bool) error {
+	lock, _ := indexLocks.LoadOrStore(s.Key, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	for attempt := 0; attempt < kvUserIndexAttempts; attempt++ {
+		users := s.getList()
+		if containsString(users, userId) == add {
+			return nil
+		}
+		// the users who left are dropped on every write, a parallel remove may have missed them
+		updated := make([]string, 0)
+		for _, u := range users {
+			if u != userId && s.IsMember(u) {
+				updated = append(updated, u)
+			}
+		}
+		if add {
+			updated = append(updated, userId)
+		}
+		if _, err := s.AsBot.KVSet("", s.Key, updated); err != nil {
+			return err
+		}
+		time.Sleep(time.Duration(attempt*50+rand.Intn(50)) * time.Millisecond)
+	}
+	if containsString(s.getList(), userId) != add {
+		return fmt.Errorf("users of %s were changed in parallel", s.Key)
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// KVReminderStore keeps the users with reminders and the reminders sent to them, so a reminder is sent once.
+type KVReminderStore struct {
+	AsBot JobKVClient
+}
+
+func (s KVReminderStore) GetUsers() []string {
+	return KVUserIndex{AsBot: s.AsBot, Key: ReminderUsersKvKey}.GetUsers()
+}
+
+func (s KVReminderStore) AddUser(userId string) error {
+	return KVUserIndex{AsBot: s.AsBot, Key: ReminderUsersKvKey}.AddUser(userId)
+}
+
+func (s KVReminderStore) RemoveUser(userId string) error {
+	if err := (KVUserIndex{AsBot: s.AsBot, Key: ReminderUsersKvKey}).RemoveUser(userId); err != nil {
+		return err
+	}
+	return s.AsBot.KVDelete("", ReminderSentKvKey+userId)
+}
+
+func (s KVReminderStore) GetSent(userId string) map[string]int64 {
+	sent := make(map[string]int64)
+	s.AsBot.KVGet("", ReminderSentKvKey+userId, &sent)
+	return sent
+}
+
+// SaveSent keeps only the reminders which can still be found by FindDueReminders.
+func (s KVReminderStore) SaveSent(userId string, sent map[string]int64, now time.Time) error {
+	for key, at := range sent {
+		if at <= now.Add(-reminderLookback).Unix() {
+			delete(sent, key)
+		}
+	}
+	_, err := s.AsBot.KVSet("", ReminderSentKvKey+userId, sent)
+	return err
+}
+
+// SendEventReminders is the background job of the reminders. It acts for every user who turned them on
+// with the token the user has shared.
+func SendEventReminders(creq apps.CallRequest) {
+	asBot := appclient.AsBot(creq.Context)
+	store := KVReminderStore{AsBot: asBot}
+	userSettingsService := user.UserSettingsServiceImpl{AsBot: asBot}
+	now := time.Now()
+
+	for _, userId := range store.GetUsers() {
+		userSettings := userSettingsService.GetUserSettingsById(userId)
+		if !userSettings.Reminders {
+			log.Infof("Reminders of user %s are off, the user is removed from the reminders", userId)
+			store.RemoveUser(userId)
+			continue
+		}
+		sendUserReminders(creq, asBot, store, userId, now)
+	}
+}
+
+func sendUserReminders(creq apps.CallRequest, asBot *appclient.Client, store KVReminderStore, userId string, now time.Time) {
+	mmUser, _, err := asBot.GetUser(userId, "")
+	if err != nil {
+		log.Errorf("User %s of the reminders was not found. Error: %s", userId, err)
+		return
+	}
+	userCreq, token, err := createUserCallRequest(creq, asBot, mmUser)
+	if err != nil {
+		log.Errorf("Reminders of user %s were not checked. Error: %s", userId, err)
+		return
+	}
+
+	userSettingsService := user.UserSettingsServiceImpl{AsBot: asBot}
+	client := settings.ForCall(userCreq).NewClient(context.Background(), token.AccessToken)
+	agendaService := NewAgendaService(userCreq, client, userSettingsService.GetUserSettingsById(userId))
+
+	loc := CalendarTimePostService{}.GetUserLocation(mmUser)
+	if loc == nil {
+		loc = time.UTC
+	}
+	now = now.In(loc)
+	sent := store.GetSent(userId)
+	events := agendaService.GetAgendaEvents(now.Add(-reminderLookback), now.Add(reminderHorizon+reminderLookback))
+	postService := CreateCalendarEventPostService{GetMMUser: asBot}
+	sentCount := 0
+
+	for _, reminder := range FindDueReminders(events, now, sent) {
+		e := reminder.Event
+		postDto := CalendarEventPostDTO{e.Event, asBot, e.CalendarId, e.EventId, loc, userCreq}
+		post := postService.CreateCalendarEventPost(&postDto)
+		post.Message = fmt.Sprintf("Reminder: **%s** starts %s", getEventText(e.Event, ics.ComponentPropertySummary), formatReminderLead(e.Start.Sub(now)))
+		if _, err := asBot.DMPost(userId, post); err != nil {
+			log.Errorf("Reminder was not sent to user %s. Error: %s", userId, err)
+			delete(sent, reminder.Key)
+			continue
+		}
+		sentCount++
+	}
+	if sentCount != 0 {
+		log.Infof("%d reminders sent to user %s", sentCount, userId)
+	}
+	if err := store.SaveSent(userId, sent, now); err != nil {
+		log.Errorf("Sent reminders of user %s were not saved. Error: %s", userId, err)
+	}
+}
+
+// createUserCallRequest adds the user and the token shared by the user to the call request of a background job.
+func createUserCallRequest(creq apps.CallRequest, asBot *appclient.Client, mmUser *model.User) (apps.CallRequest, *oauth.Token, error) {
+	tokenStore := oauth.BotTokenStore{AsBot: asBot, UserId: mmUser.Id}
+	sharedToken := tokenStore.GetToken()
+	if len(sharedToken.RefreshToken) == 0 {
+		return creq, nil, fmt.Errorf("user %s has no shared token", mmUser.Id)
+	}
+
+	userCreq := creq
+	userCreq.Context.ActingUser = mmUser
+	tokenMap := make(map[string]interface{})
+	data, _ := json.Marshal(sharedToken)
+	json.Unmarshal(data, &tokenMap)
+	userCreq.Context.OAuth2.User = tokenMap
+
+	tokenService := oauth.TokenServiceImpl{Creq: userCreq, TokenStore: tokenStore, SharedStore: tokenStore}
+	token, err := tokenService.GetActualToken()
+	if err != nil {
+		return creq, nil, err
+	}
+	userCreq.Context.OAuth2.User = map[string]interface{}{"user_id": token.UserID}
+	return userCreq, token, nil
+}
@@ -0,0 +1,193 @@
+package calendar
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	ics "github.com/arran4/golang-ical"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/user"
+)
+
+const remindedEventIcs = "BEGIN:VCALENDAR\nVERSION:2.0\nBEGIN:VEVENT\nUID:event\nDTSTART:20230206T100000Z\nDTEND:20230206T110000Z\nSUMMARY:Planning\nBEGIN:VALARM\nACTION:DISPLAY\nTRIGGER:-PT15M\nEND:VALARM\nBEGIN:VALARM\nACTION:DISPLAY\nTRIGGER;RELATED=END:-PT10M\nEND:VALARM\nBEGIN:VALARM\nACTION:DISPLAY\nTRIGGER;VALUE=DATE-TIME:20230206T080000Z\nEND:VALARM\nEND:VEVENT\nEND:VCALENDAR\n"
+
+func TestParseIcalDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"-PT15M":   -15 * time.Minute,
+		"PT1H":     time.Hour,
+		"-P1D":     -24 * time.Hour,
+		"P1DT2H":   26 * time.Hour,
+		"-P1W":     -7 * 24 * time.Hour,
+		"+PT1H30M": 90 * time.Minute,
+	}
+	for value, expected := range tests {
+		if actual, ok := parseIcalDuration(value); !ok || actual != expected {
+			t.Errorf(" expected %q, actual %q", expected, actual)
+		}
+	}
+	if _, ok := parseIcalDuration("P"); ok {
+		t.Error("Empty duration should not be parsed")
+	}
+}
+
+func TestCreateEventBodyWithReminder(t *testing.T) {
+	values := map[string]interface{}{
+		"title":       "title",
+		reminderField: map[string]interface{}{"value": "PT15M"},
+	}
+	creq := apps.CallRequest{Values: values, Context: apps.Context{ExpandedContext: apps.ExpandedContext{ActingUser: &model.User{Id: "1"}}}}
+	testedInstance := CalendarEventServiceImpl{creq: creq, asBot: MMClientMock{}}
+
+	_, eventBody := testedInstance.CreateEventBody("2023-02-06 01:23:32.76349399 +0200 EET", "30 minutes", "Europe/Kiev", "")
+
+	cal, _ := ics.ParseCalendar(strings.NewReader(eventBody))
+	alarms := cal.Events()[0].Alarms()
+	if len(alarms) != 1 {
+		t.Fatalf("Event should have a reminder, actual %d", len(alarms))
+	}
+	if trigger := alarms[0].GetProperty(ics.ComponentPropertyTrigger).Value; trigger != "-PT15M" {
+		t.Errorf(" expected %q, actual %q", "-PT15M", trigger)
+	}
+}
+
+func TestFindDueReminders(t *testing.T) {
+	data := []CalendarEventData{{CalendarStr: remindedEventIcs, CalendarId: "event.ics"}}
+	from := time.Date(2023, 2, 6, 0, 0, 0, 0, time.UTC)
+	events := ParseAgendaEvents(data, UserCalendar{Id: "personal", Name: "Personal"}, from, from.AddDate(0, 0, 1))
+	sent := make(map[string]int64)
+
+	if due := FindDueReminders(events, time.Date(2023, 2, 6, 9, 46, 0, 0, time.UTC), sent); len(due) != 1 {
+		t.Fatalf("Reminder 15 minutes before the start should be due, actual %d", len(due))
+	}
+	if due := FindDueReminders(events, time.Date(2023, 2, 6, 9, 47, 0, 0, time.UTC), sent); len(due) != 0 {
+		t.Errorf("Sent reminder should not be sent again, actual %d", len(due))
+	}
+	if due := FindDueReminders(events, time.Date(2023, 2, 6, 10, 50, 0, 0, time.UTC), sent); len(due) != 1 {
+		t.Errorf("Reminder related to the end should be due, actual %d", len(due))
+	}
+	if due := FindDueReminders(events, time.Date(2023, 2, 6, 8, 10, 0, 0, time.UTC), sent); len(due) != 0 {
+		t.Errorf("Reminder older than the lookback should be skipped, actual %d", len(due))
+	}
+	if due := FindDueReminders(events, time.Date(2023, 2, 6, 8, 2, 0, 0, time.UTC), sent); len(due) != 1 {
+		t.Errorf("Reminder at a fixed time should be due, actual %d", len(due))
+	}
+}
+
+func TestFormatReminderLead(t *testing.T) {
+	tests := map[time.Duration]string{
+		15 * time.Minute: "in 15 minute(s)",
+		time.Hour:        "in 1 hour(s)",
+		24 * time.Hour:   "in 1 day(s)",
+		-time.Minute:     "now",
+	}
+	for lead, expected := range tests {
+		if actual := formatReminderLead(lead); actual != expected {
+			t.Errorf(" expected %q, actual %q", expected, actual)
+		}
+	}
+}
+
+type KVMock struct {
+	values map[string]string
+	// onListWrite simulates a write of another instance which lands right after the write of the list
+	onListWrite func(m *KVMock)
+}
+
+func (m *KVMock) KVGet(prefix, id string, ref interface{}) error {
+	if value, ok := m.values[id]; ok {
+		return json.Unmarshal([]byte(value), ref)
+	}
+	return nil
+}
+
+func (m *KVMock) KVSet(prefix, id string, in interface{}) (bool, error) {
+	data, _ := json.Marshal(in)
+	m.values[id] = string(data)
+	if id == ReminderUsersKvKey && m.onListWrite != nil {
+		onListWrite := m.onListWrite
+		m.onListWrite = nil
+		onListWrite(m)
+	}
+	return true, nil
+}
+
+func (m *KVMock) KVDelete(prefix, id string) error {
+	delete(m.values, id)
+	return nil
+}
+
+func TestKVUserIndexKeepsParallelAdds(t *testing.T) {
+	kv := &KVMock{values: make(map[string]string)}
+	kv.onListWrite = func(m *KVMock) {
+		m.values[ReminderUsersKvKey+"-second"] = "true"
+		m.values[ReminderUsersKvKey] = `["second"]`
+	}
+	store := KVReminderStore{AsBot: kv}
+
+	if err := store.AddUser("first"); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if users := strings.Join(store.GetUsers(), " "); users != "second first" {
+		t.Errorf(" expected %q, actual %q", "second first", users)
+	}
+}
+
+func TestKVUserIndexSkipsRemovedUsers(t *testing.T) {
+	kv := &KVMock{values: make(map[string]string)}
+	store := KVReminderStore{AsBot: kv}
+	store.AddUser("first")
+	store.AddUser("second")
+	kv.values[ReminderUsersKvKey] = `["first","second","removed"]`
+
+	store.RemoveUser("first")
+
+	if users := strings.Join(store.GetUsers(), " "); users != "second" {
+		t.Errorf(" expected %q, actual %q", "second", users)
+	}
+}
+
+type RotatingOauthServiceMock struct{}
+
+func (s RotatingOauthServiceMock) RefreshToken(refreshToken string) (*oauth.Token, error) {
+	return &oauth.Token{AccessToken: "access-2", RefreshToken: "refresh-2", ExpiresIn: 3600, UserID: "nc-user"}, nil
+}
+
+type UserTokenStoreMock struct {
+	stored *oauth.Token
+}
+
+func (s *UserTokenStoreMock) StoreToken(token oauth.Token) error {
+	s.stored = &token
+	return nil
+}
+
+func TestTokenRefreshedByJobIsReturnedToUser(t *testing.T) {
+	kv := &KVMock{values: make(map[string]string)}
+	botStore := oauth.BotTokenStore{AsBot: kv, UserId: "job-user"}
+	botStore.StoreToken(oauth.Token{AccessToken: "access-1", RefreshToken: "refresh-1", UserID: "nc-user"})
+	jobCreq := apps.CallRequest{Context: apps.Context{ExpandedContext: apps.ExpandedContext{
+		ActingUser: &model.User{Id: "job-user"},
+		OAuth2:     apps.OAuth2Context{User: map[string]interface{}{"access_token": "access-1", "refresh_token": "refresh-1"}},
+	}}}
+	tokenService := oauth.TokenServiceImpl{Creq: jobCreq, OauthService: RotatingOauthServiceMock{}, TokenStore: botStore, SharedStore: botStore}
+	if _, err := tokenService.GetActualToken(); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	userStore := &UserTokenStoreMock{}
+
+	if err := unshareUnusedToken(kv, userStore, "job-user", user.UserSettings{}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if userStore.stored == nil || userStore.stored.RefreshToken != "refresh-2" {
+		t.Errorf("Rotated refresh token should be stored for the user, actual %+v", userStore.stored)
+	}
+	if _, shared := kv.values[oauth.LatestTokenKvKey+"job-user"]; shared {
+		t.Error("Token should not be shared anymore")
+	}
+}
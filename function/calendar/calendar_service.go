@@ -5,14 +5,12 @@ import (
 	"errors"
 	"fmt"
 	ics "github.com/arran4/golang-ical"
-	"github.com/hashicorp/go-retryablehttp"
 	"github.com/mattermost/mattermost-plugin-apps/apps"
 	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
 )
 
@@ -25,8 +23,11 @@ type CalendarService interface {
 	CreateEvent(body string) (*http.Response, error)
 	GetUrl() string
 	GetCalendarEvent() (string, error)
+	GetCalendarEventWithEtag() (string, string, error)
+	UpdateEvent(body string, etag string) (*http.Response, error)
 	DeleteUserEvent() (*http.Response, error)
 	GetUserCalendars() []apps.SelectOption
+	GetUserCalendarDetails() []UserCalendar
 	GetCalendarEvents(event CalendarEventRequestRange) []CalendarEventData
 	UpdateAttendeeStatus(cal *ics.Calendar, user *model.User, status string) (string, error)
 	AddButtonsToEvents(commandBinding apps.Binding, status string, path string) apps.Binding
@@ -46,12 +47,20 @@ func (c CalendarServiceImpl) CreateEvent(body string) (*http.Response, error) {
 }
 
 func (c CalendarServiceImpl) GetUserCalendars() []apps.SelectOption {
-
 	selectOptions := make([]apps.SelectOption, 0)
+	for _, calendar := range c.GetUserCalendarDetails() {
+		selectOptions = append(selectOptions, apps.SelectOption{Label: calendar.Name, Value: calendar.Id})
+	}
+	return selectOptions
+}
+
+func (c CalendarServiceImpl) GetUserCalendarDetails() []UserCalendar {
+
+	calendars := make([]UserCalendar, 0)
 	calendarsResponse, err := c.calendarRequestService.getUserCalendars()
 
 	if err != nil {
-		return selectOptions
+		return calendars
 	}
 
 	for _, r := range calendarsResponse.Response {
@@ -60,15 +69,14 @@ func (c CalendarServiceImpl) GetUserCalendars() []apps.SelectOption {
 
 		if len(calendarName) > 0 {
 			splitUrl := strings.Split(r.Href, "/")
-			val := splitUrl[len(splitUrl)-2]
-			selectOption := apps.SelectOption{
-				Label: calendarName,
-				Value: val,
-			}
-			selectOptions = append(selectOptions, selectOption)
+			calendars = append(calendars, UserCalendar{
+				Id:    splitUrl[len(splitUrl)-2],
+				Name:  calendarName,
+				Color: r.Propstat.Prop.CalendarColor,
+			})
 		}
 	}
-	return selectOptions
+	return calendars
 }
 
 func (c CalendarServiceImpl) GetCalendarEvents(event CalendarEventRequestRange) []CalendarEventData {
@@ -174,9 +182,19 @@ func (c CalendarServiceImpl) GetCalendarEvent() (string, error) {
 	return c.calendarRequestService.getCalendarEvent()
 }
 
+func (c CalendarServiceImpl) GetCalendarEventWithEtag() (string, string, error) {
+	return c.calendarRequestService.getCalendarEventWithEtag()
+}
+
+func (c CalendarServiceImpl) UpdateEvent(body string, etag string) (*http.Response, error) {
+	return c.calendarRequestService.updateEvent(body, etag)
+}
+
 type CalendarRequestService interface {
 	getUrl() string
 	getCalendarEvent() (string, error)
+	getCalendarEventWithEtag() (string, string, error)
+	updateEvent(body string, etag string) (*http.Response, error)
 	getUserCalendars() (UserCalendarsResponse, error)
 	deleteUserEvent() (*http.Response, error)
 	getCalendarEvents(event CalendarEventRequestRange) (UserCalendarEventsResponse, error)
@@ -184,8 +202,8 @@ type CalendarRequestService interface {
 }
 
 type CalendarRequestServiceImpl struct {
-	Url   string
-	Token string
+	Url    string
+	Client nextcloud.Client
 }
 
 func (c CalendarRequestServiceImpl) getUrl() string {
@@ -195,37 +213,24 @@ func (c CalendarRequestServiceImpl) getUrl() string {
 func (c CalendarRequestServiceImpl) getUserCalendars() (UserCalendarsResponse, error) {
 
 	body :=
-		`<d:propfind xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/">
+		`<d:propfind xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/" xmlns:ca="http://apple.com/ns/ical/">
 	<d:prop>
 	   <d:displayname />
 	   <cs:getctag />
+	   <ca:calendar-color />
 	</d:prop>
   </d:propfind>`
 
 	req, _ := http.NewRequest("PROPFIND", c.Url, strings.NewReader(body))
 	req.Header.Set("Content-Type", "text/xml")
-	req.Header.Set("Authorization", "Bearer "+c.Token)
-
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
 
 	log.Info("Sending user get user calendar request")
-	client := retryClient.StandardClient()
-	resp, err := client.Do(req)
-
-	defer resp.Body.Close()
-
+	resp, err := c.Client.Do(req, http.StatusMultiStatus)
 	if err != nil {
 		log.Errorf("Error during getting of the user calendars. Error: %s", err)
 		return UserCalendarsResponse{}, err
 	}
-
-	if resp.StatusCode != http.StatusMultiStatus {
-		log.Errorf("getUserCalendars request failed with status %s", resp.Status)
-		respErr := fmt.Errorf("getUserCalendars request failed with code %d", resp.StatusCode)
-		return UserCalendarsResponse{}, respErr
-	}
+	defer resp.Body.Close()
 
 	xmlResp := UserCalendarsResponse{}
 	xmlError := xml.NewDecoder(resp.Body).Decode(&xmlResp)
@@ -240,27 +245,14 @@ func (c CalendarRequestServiceImpl) getUserCalendars() (UserCalendarsResponse, e
 func (c CalendarRequestServiceImpl) deleteUserEvent() (*http.Response, error) {
 	req, _ := http.NewRequest("DELETE", c.Url, nil)
 	req.Header.Set("Content-Type", "text/xml")
-	req.Header.Set("Authorization", "Bearer "+c.Token)
 	log.Info("Sending a delete request")
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
-
-	client := retryClient.StandardClient()
-	resp, err := client.Do(req)
-
-	defer resp.Body.Close()
 
+	resp, err := c.Client.Do(req, http.StatusNoContent)
 	if err != nil {
 		log.Errorf("Error during deleting of the event. Error: %s", err)
 		return nil, err
 	}
-
-	if resp.StatusCode != http.StatusNoContent {
-		log.Errorf("getCalendarEvents request failed with status %s", resp.Status)
-		respErr := fmt.Errorf("getCalendarEvents request failed with code %d", resp.StatusCode)
-		return nil, respErr
-	}
+	resp.Body.Close()
 
 	return resp, nil
 }
@@ -275,7 +267,9 @@ func (c CalendarRequestServiceImpl) getCalendarEvents(event CalendarEventRequest
     xmlns:ca="http://apple.com/ns/ical/" 
     xmlns:d="DAV:">                                                            
     <d:prop>                
-        <c:calendar-data />
+        <c:calendar-data>
+            <c:expand start="%s" end="%s"/>
+        </c:calendar-data>
     </d:prop>  
         <c:filter>
         <c:comp-filter name="VCALENDAR">
@@ -284,32 +278,18 @@ func (c CalendarRequestServiceImpl) getCalendarEvents(event CalendarEventRequest
             </c:comp-filter>
         </c:comp-filter>
     </c:filter>
-</c:calendar-query> `, from, to)
+</c:calendar-query> `, from, to, from, to)
 
 	req, _ := http.NewRequest("REPORT", c.Url, strings.NewReader(body))
 	req.Header.Set("Content-Type", "text/xml")
 	req.Header.Set("Depth", "1")
-	req.Header.Set("Authorization", "Bearer "+c.Token)
-
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
-
-	client := retryClient.StandardClient()
-	resp, err := client.Do(req)
-
-	defer resp.Body.Close()
 
+	resp, err := c.Client.Do(req, http.StatusMultiStatus)
 	if err != nil {
 		log.Errorf("Error during getting of the calendar events. Error: %s", err)
 		return UserCalendarEventsResponse{}, err
 	}
-
-	if resp.StatusCode != http.StatusMultiStatus {
-		log.Errorf("getCalendarEvents request failed with status %s", resp.Status)
-		respErr := fmt.Errorf("getCalendarEvents request failed with code %d", resp.StatusCode)
-		return UserCalendarEventsResponse{}, respErr
-	}
+	defer resp.Body.Close()
 
 	xmlResp := UserCalendarEventsResponse{}
 	xmlError := xml.NewDecoder(resp.Body).Decode(&xmlResp)
@@ -328,58 +308,73 @@ func (c CalendarRequestServiceImpl) createEvent(body string) (*http.Response, er
 	req.Header.Set("Content-Type", "text/calendar; charset=UTF-8")
 	req.Header.Set("Depth", "0")
 	req.Header.Set("X-NC-CalDAV-Webcal-Caching", "On")
-	req.Header.Set("Authorization", "Bearer "+c.Token)
-
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
 
-	client := retryClient.StandardClient()
 	log.Info("Sending create event request to Nextcloud")
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
-
+	resp, err := c.Client.Do(req, http.StatusCreated, http.StatusNoContent)
 	if err != nil {
 		log.Errorf("Error during creating of the event. Error: %s", err)
 		return nil, err
 	}
-
-	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
-		log.Errorf("createEvent request failed with status %s", resp.Status)
-		respErr := fmt.Errorf("createEvent request failed with code %d", resp.StatusCode)
-		return nil, respErr
-	}
+	resp.Body.Close()
 
 	return resp, nil
 }
 func (c CalendarRequestServiceImpl) getCalendarEvent() (string, error) {
 	req, _ := http.NewRequest("GET", c.Url, nil)
-	req.Header.Set("Authorization", "Bearer "+c.Token)
 	log.Info("Sending get calendar event request")
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
-
-	client := retryClient.StandardClient()
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
 
+	resp, err := c.Client.Do(req, http.StatusOK)
 	if err != nil {
-		log.Errorf("Error during refreshing of the token. Error: %s", err)
+		log.Errorf("Error during getting of the calendar event. Error: %s", err)
 		return "", err
 	}
+	defer resp.Body.Close()
+
+	event, parsingErr := io.ReadAll(resp.Body)
+	if parsingErr != nil {
+		log.Errorf("Error during parsing of the event. Error: %s", parsingErr)
+		return "", parsingErr
+	}
+
+	return string(event), nil
+}
+
+func (c CalendarRequestServiceImpl) getCalendarEventWithEtag() (string, string, error) {
+	req, _ := http.NewRequest("GET", c.Url, nil)
+	log.Info("Sending get calendar event request")
 
-	if resp.StatusCode != http.StatusOK {
-		log.Errorf("getCalendarEvent request failed with status %s", resp.Status)
-		respErr := fmt.Errorf("getCalendarEvent request failed with code %d", resp.StatusCode)
-		return "", respErr
+	resp, err := c.Client.Do(req, http.StatusOK)
+	if err != nil {
+		log.Errorf("Error during getting of the calendar event. Error: %s", err)
+		return "", "", err
 	}
+	defer resp.Body.Close()
 
 	event, parsingErr := io.ReadAll(resp.Body)
 	if parsingErr != nil {
-		log.Errorf("Error during parsing of the event. Error: %s", err)
-		return "", err
+		log.Errorf("Error during parsing of the event. Error: %s", parsingErr)
+		return "", "", parsingErr
 	}
 
-	return string(event), nil
+	return string(event), resp.Header.Get("ETag"), nil
+}
+
+// updateEvent replaces the event only if it still has the etag, so changes made in the meantime are not lost.
+// Nextcloud answers 412 Precondition Failed for a changed event.
+func (c CalendarRequestServiceImpl) updateEvent(body string, etag string) (*http.Response, error) {
+	req, _ := http.NewRequest("PUT", c.Url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/calendar; charset=UTF-8")
+	if len(etag) != 0 {
+		req.Header.Set("If-Match", etag)
+	}
+
+	log.Info("Sending update event request to Nextcloud")
+	resp, err := c.Client.Do(req, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		log.Errorf("Error during updating of the event. Error: %s", err)
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return resp, nil
 }
@@ -5,7 +5,9 @@ import (
 	"github.com/mattermost/mattermost-plugin-apps/apps"
 	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -20,7 +22,7 @@ func (c CalendarEventServiceImplMock) getUrl() string {
 }
 
 func (c CalendarEventServiceImplMock) getUserCalendars() (UserCalendarsResponse, error) {
-	prop := UserCalendarProp{"test", "test", "1"}
+	prop := UserCalendarProp{Text: "test", Displayname: "test", Getctag: "1"}
 	propstat := UserCalendarPropstat{Text: "test", Prop: prop, Status: "test"}
 	items := UserCalendarsResponseItems{"test", "/remote.php/dav/calendars/admin/custom/431b2eba-713f-427f-a058-65bd595db528.ics", propstat}
 	response := UserCalendarsResponse{Response: []UserCalendarsResponseItems{items}}
@@ -46,6 +48,14 @@ func (c CalendarEventServiceImplMock) getCalendarEvent() (string, error) {
 	return c.icsResponse, c.error
 }
 
+func (c CalendarEventServiceImplMock) getCalendarEventWithEtag() (string, string, error) {
+	return c.icsResponse, "\"etag\"", c.error
+}
+
+func (c CalendarEventServiceImplMock) updateEvent(body string, etag string) (*http.Response, error) {
+	return nil, c.error
+}
+
 func TestGetCalendarEvents(t *testing.T) {
 	testedInstance := CalendarServiceImpl{calendarRequestService: CalendarEventServiceImplMock{}}
 	from := time.Now().AddDate(0, 0, -1)
@@ -139,3 +149,34 @@ func TestAddButtonsToEventWithChosenStatus(t *testing.T) {
 		t.Error("Wrong label in event buttons")
 	}
 }
+
+func TestGetUserCalendarDetails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(`<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/" xmlns:x1="http://apple.com/ns/ical/">
+ <d:response>
+  <d:href>/remote.php/dav/calendars/admin/</d:href>
+  <d:propstat><d:prop><d:displayname/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>
+ </d:response>
+ <d:response>
+  <d:href>/remote.php/dav/calendars/admin/work/</d:href>
+  <d:propstat><d:prop><d:displayname>Work</d:displayname><x1:calendar-color>#0082c9</x1:calendar-color></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>
+ </d:response>
+ <d:response>
+  <d:href>/remote.php/dav/calendars/admin/personal/</d:href>
+  <d:propstat><d:prop><d:displayname>Personal</d:displayname></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>
+  <d:propstat><d:prop><x1:calendar-color/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>
+ </d:response>
+</d:multistatus>`))
+	}))
+	defer server.Close()
+	testedInstance := CalendarServiceImpl{calendarRequestService: CalendarRequestServiceImpl{Url: server.URL, Client: nextcloud.NewClient("token")}}
+
+	calendars := testedInstance.GetUserCalendarDetails()
+
+	expected := []UserCalendar{{Id: "work", Name: "Work", Color: "#0082c9"}, {Id: "personal", Name: "Personal"}}
+	if len(calendars) != 2 || calendars[0] != expected[0] || calendars[1] != expected[1] {
+		t.Errorf(" expected %v, actual %v", expected, calendars)
+	}
+}
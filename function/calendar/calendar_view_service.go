@@ -31,6 +31,9 @@ func (c CalendarPostServiceImpl) CreateCalendarPost(option apps.SelectOption) *m
 		Description: "Calendar actions",
 		Bindings:    []apps.Binding{},
 	}
+	if option.Value == AllCalendarsValue {
+		commandBinding.Label = option.Label
+	}
 
 	c.createGetCalendarEventsButton(&commandBinding, option, "Calendar", "Today", "today")
 	c.createGetCalendarEventsButton(&commandBinding, option, "Calendar", "Tomorrow", "tomorrow")
@@ -198,13 +201,17 @@ func (s CalendarTimePostService) PrepareTimeRangeForGetEventsRequest(chosenDate
 }
 
 func (s CalendarTimePostService) GetMMUserLocation(creq apps.CallRequest) *time.Location {
+	return s.GetUserLocation(creq.Context.ActingUser)
+}
+
+func (s CalendarTimePostService) GetUserLocation(user *model.User) *time.Location {
 	log.Info("Getting mm user location")
 	var timezone string
 	var loc *time.Location
-	if creq.Context.ActingUser.Timezone["useAutomaticTimezone"] == "false" {
-		timezone = creq.Context.ActingUser.Timezone["manualTimezone"]
+	if user.Timezone["useAutomaticTimezone"] == "false" {
+		timezone = user.Timezone["manualTimezone"]
 	} else {
-		timezone = creq.Context.ActingUser.Timezone["automaticTimezone"]
+		timezone = user.Timezone["automaticTimezone"]
 	}
 	loc, _ = time.LoadLocation(timezone)
 	return loc
@@ -392,7 +399,17 @@ func (s CreateCalendarEventPostService) CreateCalendarEventPost(postDTO *Calenda
 	deletePath := fmt.Sprintf("/delete-event/%s/events/%s", postDTO.calendarId, postDTO.eventId)
 	detailButtonService := DetailsViewFormService{}
 	detailButtonService.CreateViewButton(&commandBinding, "view-details", organizer, "View Details", postDTO, name, reqUrl)
-	s.сreateDeleteButton(&commandBinding, "Delete", "Delete", deletePath)
+	if len(organizer) != 0 && organizerEmail == organizer {
+		editPath := fmt.Sprintf("/edit-calendar-event-form/%s/events/%s", postDTO.calendarId, postDTO.eventId)
+		s.createEditButton(&commandBinding, "Edit", "Edit", editPath)
+		log.Info("Edit button added")
+	}
+	if IsRecurringEvent(postDTO.event) {
+		commandBinding.Label += " (repeats)"
+		s.сreateDeleteButton(&commandBinding, "Delete", "Delete series", deletePath)
+	} else {
+		s.сreateDeleteButton(&commandBinding, "Delete", "Delete", deletePath)
+	}
 	log.Info("Delete button added")
 	m1 := make(map[string]interface{})
 	m1["app_bindings"] = []apps.Binding{commandBinding}
@@ -403,6 +420,39 @@ func (s CreateCalendarEventPostService) CreateCalendarEventPost(postDTO *Calenda
 	return &post
 }
 
+// CreateAttendeeEventPost is sent to the attendees of an edited event. The organizer's calendar is not theirs,
+// so the post only shows the event.
+func (s CreateCalendarEventPostService) CreateAttendeeEventPost(postDTO *CalendarEventPostDTO) *model.Post {
+	log.Infof("Creating an attendee post for the event with id: %s", postDTO.eventId)
+	name := getEventText(postDTO.event, ics.ComponentPropertySummary)
+	organizer := ""
+	if property := postDTO.event.GetProperty(ics.ComponentPropertyOrganizer); property != nil {
+		organizer = property.Value
+	}
+	if strings.Contains(organizer, ":") {
+		organizer = strings.Split(organizer, ":")[1]
+	}
+
+	userId := postDTO.creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string)
+	remoteUrl := postDTO.creq.Context.OAuth2.OAuth2App.RemoteRootURL
+	reqUrl := fmt.Sprintf("%s/remote.php/dav/calendars/%s/%s/%s", remoteUrl, userId, postDTO.calendarId, postDTO.eventId)
+
+	commandBinding := apps.Binding{
+		Location: "embedded",
+		AppID:    "nextcloud",
+		Label:    s.createNameForEvent(name, postDTO),
+		Bindings: []apps.Binding{},
+	}
+	detailButtonService := DetailsViewFormService{}
+	detailButtonService.CreateViewButton(&commandBinding, "view-details", organizer, "View Details", postDTO, name, reqUrl)
+
+	post := model.Post{}
+	m1 := make(map[string]interface{})
+	m1["app_bindings"] = []apps.Binding{commandBinding}
+	post.SetProps(m1)
+	return &post
+}
+
 func (s CreateCalendarEventPostService) FindAttendeeStatus(event ics.VEvent, userId string) ics.ParticipationStatus {
 	user, _, _ := s.GetMMUser.GetUser(userId, "")
 	for _, a := range event.Attendees() {
@@ -427,6 +477,19 @@ func (s CreateCalendarEventPostService) сreateDeleteButton(commandBinding *apps
 	})
 }
 
+func (s CreateCalendarEventPostService) createEditButton(commandBinding *apps.Binding, location apps.Location, label string, editPath string) {
+	commandBinding.Bindings = append(commandBinding.Bindings, apps.Binding{
+		Location: location,
+		Label:    label,
+		Submit: apps.NewCall(editPath).WithExpand(apps.Expand{
+			OAuth2App:             apps.ExpandAll,
+			OAuth2User:            apps.ExpandAll,
+			ActingUserAccessToken: apps.ExpandAll,
+			ActingUser:            apps.ExpandAll,
+		}),
+	})
+}
+
 type OauthService interface {
 	RefreshToken() oauth.Token
 }
@@ -454,23 +517,33 @@ func (s GetEventsService) GetUserEvents(creq apps.CallRequest, date time.Time, c
 
 	log.Info("Parsing calendar events")
 	for _, e := range calendarEventsData {
-		cal, _ := ics.ParseCalendar(strings.NewReader(e.CalendarStr))
+		cal, err := ics.ParseCalendar(strings.NewReader(e.CalendarStr))
+		if err != nil {
+			log.Errorf("Can't parse calendar for event %s", e.CalendarId)
+			continue
+		}
 		e.CalendarIcs = *cal
-		event := *cal.Events()[0]
-		if len(event.Properties) != 0 {
-			e.Event = event
-			calendarEventsFiltered = append(calendarEventsFiltered, e)
+		// the query expands recurring events, so every occurrence is a separate VEVENT with its own start,
+		// excluded dates are left out and moved occurrences are already replaced
+		for _, event := range cal.Events() {
+			if len(event.Properties) != 0 {
+				e.Event = *event
+				calendarEventsFiltered = append(calendarEventsFiltered, e)
+			}
 		}
 	}
 
 	dailyCalendarEvents := make([]CalendarEventData, 0)
+	dayStart := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+	dayEnd := dayStart.AddDate(0, 0, 1)
 
 	for _, e := range calendarEventsFiltered {
 		at, _ := e.Event.GetStartAt()
-		endAt, _ := e.Event.GetEndAt()
-		localStartTime := at.In(loc)
-		localEndTime := endAt.In(loc)
-		if localStartTime.Day() == date.Day() || localEndTime.Day() == date.Day() {
+		endAt, err := e.Event.GetEndAt()
+		if err != nil || endAt.Before(at) {
+			endAt = at
+		}
+		if isEventInRange(at, endAt, dayStart, dayEnd) {
 			dailyCalendarEvents = append(dailyCalendarEvents, e)
 		}
 	}
@@ -492,6 +565,32 @@ func (s GetEventsService) GetUserEvents(creq apps.CallRequest, date time.Time, c
 	return nil
 }
 
+// GetAllCalendarsEvents sends the events of the day from all calendars the user has not disabled,
+// every post is labelled with the calendar of its event.
+func (s GetEventsService) GetAllCalendarsEvents(creq apps.CallRequest, date time.Time, agendaService AgendaService) error {
+	loc := s.CalendarTimePostService.GetMMUserLocation(creq)
+	mmUserId := creq.Context.ActingUser.Id
+
+	dayStart := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+	events := agendaService.GetAgendaEvents(dayStart, dayStart.AddDate(0, 0, 1))
+	if len(events) == 0 {
+		return errors.New("You don`t have events at this day")
+	}
+
+	for _, e := range events {
+		postDto := CalendarEventPostDTO{e.Event, s.GetMMUser, e.CalendarId, e.EventId, loc, creq}
+		post := s.CreateCalendarEventPostService.CreateCalendarEventPost(&postDto)
+		post.Message = FormatCalendarLabel(e.CalendarName, e.CalendarColor)
+		log.Infof("Sending the event post with id: %s for the mm user with id: %s", e.Event.Id(), mmUserId)
+		_, dmError := s.GetMMUser.DMPost(mmUserId, post)
+		if dmError != nil {
+			log.Errorf("Can`t send event post to a user with id %s: %s", mmUserId, dmError.Error())
+		}
+	}
+
+	return nil
+}
+
 func (s CalendarTimePostService) RoundTime(date *time.Time) {
 	minutes := date.Minute()
 	minutesInHour := 60
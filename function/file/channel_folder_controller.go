@@ -0,0 +1,90 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/user"
+	log "github.com/sirupsen/logrus"
+)
+
+func HandleLinkFolder(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	path, _ := creq.Values["path"].(string)
+	folderPath, err := ValidateFolderPath(path)
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Wrap(err, "Folder was not linked")))
+		return
+	}
+
+	browseService := createFileBrowseService(c, creq, token.AccessToken)
+	if _, err := browseService.GetFileDetails(folderPath); err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Errorf("Folder %s was not found in your Nextcloud, create it by /nextcloud mkdir", folderPath)))
+		return
+	}
+
+	asBot := appclient.AsBot(creq.Context)
+	store := KVChannelFolderStore{AsBot: asBot}
+	if current := store.GetFolder(creq.Context.Channel.Id); current != nil && !canManageChannelFolder(creq, current) {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Errorf("This channel is linked to %s. Only the user who linked it or a channel or team admin can change it", current.Folder)))
+		return
+	}
+	linked := ChannelFolder{Folder: folderPath + "/", LinkedBy: creq.Context.ActingUser.Id}
+	if err := store.LinkFolder(creq.Context.Channel.Id, linked); err != nil {
+		log.Errorf("Folder of channel %s was not saved. Error: %s", creq.Context.Channel.Id, err)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Folder was not linked")))
+		return
+	}
+
+	botService := user.BotServiceImpl{Creq: creq}
+	botService.AddBot()
+	message := fmt.Sprintf("@%s linked this channel to the Nextcloud folder **%s**. Files of this channel are uploaded there by default", creq.Context.ActingUser.Username, folderPath)
+	if _, err := asBot.CreatePost(&model.Post{ChannelId: creq.Context.Channel.Id, Message: message}); err != nil {
+		log.Warnf("Folder link was not announced in channel %s. Error: %s", creq.Context.Channel.Id, err)
+	}
+
+	c.JSON(http.StatusOK, apps.NewTextResponse(""))
+}
+
+func HandleUnlinkFolder(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+
+	store := KVChannelFolderStore{AsBot: appclient.AsBot(creq.Context)}
+	linked := store.GetFolder(creq.Context.Channel.Id)
+	if linked == nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("This channel is not linked to a Nextcloud folder")))
+		return
+	}
+	if !canManageChannelFolder(creq, linked) {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Only the user who linked the folder or a channel or team admin can unlink it")))
+		return
+	}
+	if err := store.UnlinkFolder(creq.Context.Channel.Id); err != nil {
+		log.Errorf("Folder of channel %s was not removed. Error: %s", creq.Context.Channel.Id, err)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Folder was not unlinked")))
+		return
+	}
+
+	c.JSON(http.StatusOK, apps.NewTextResponse("This channel is not linked to the Nextcloud folder %s anymore", linked.Folder))
+}
+
+func canManageChannelFolder(creq apps.CallRequest, linked *ChannelFolder) bool {
+	return CanManageChannelFolder(creq.Context.ActingUser, creq.Context.ChannelMember, creq.Context.TeamMember, linked)
+}
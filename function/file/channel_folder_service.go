@@ -0,0 +1,79 @@
+package file
+
+import (
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+const ChannelFolderKvKey = "channel-folder-"
+
+// ChannelFolder is the Nextcloud folder where the files of a channel are uploaded by default.
+// Folder ends with a slash like the values of the folder select, e.g. /Projects/Apollo/.
+type ChannelFolder struct {
+	Folder   string `json:"folder"`
+	LinkedBy string `json:"linked_by"`
+}
+
+type ChannelFolderStore interface {
+	GetFolder(channelId string) *ChannelFolder
+	LinkFolder(channelId string, folder ChannelFolder) error
+	UnlinkFolder(channelId string) error
+}
+
+type KVChannelFolderStore struct {
+	AsBot UploadStateKVClient
+}
+
+func (s KVChannelFolderStore) GetFolder(channelId string) *ChannelFolder {
+	folder := ChannelFolder{}
+	if err := s.AsBot.KVGet("", ChannelFolderKvKey+channelId, &folder); err != nil || len(folder.Folder) == 0 {
+		return nil
+	}
+	return &folder
+}
+
+func (s KVChannelFolderStore) LinkFolder(channelId string, folder ChannelFolder) error {
+	_, err := s.AsBot.KVSet("", ChannelFolderKvKey+channelId, folder)
+	return err
+}
+
+func (s KVChannelFolderStore) UnlinkFolder(channelId string) error {
+	return s.AsBot.KVDelete("", ChannelFolderKvKey+channelId)
+}
+
+// CanManageChannelFolder allows the user who linked the folder, channel admins, team admins and system admins
+// to replace or remove the folder of the channel.
+func CanManageChannelFolder(actingUser *model.User, channelMember *model.ChannelMember, teamMember *model.TeamMember, linked *ChannelFolder) bool {
+	switch {
+	case actingUser == nil:
+		return false
+	case linked != nil && linked.LinkedBy == actingUser.Id:
+		return true
+	case actingUser.IsSystemAdmin():
+		return true
+	case channelMember != nil && (channelMember.SchemeAdmin || strings.Contains(channelMember.Roles, model.ChannelAdminRoleId)):
+		return true
+	case teamMember != nil && (teamMember.SchemeAdmin || strings.Contains(teamMember.Roles, model.TeamAdminRoleId)):
+		return true
+	}
+	return false
+}
+
+type FileDetailsService interface {
+	GetFileDetails(filePath string) (*FileDetails, error)
+}
+
+// findLinkedFolderOption returns the option of the linked folder. The folder is linked by one user, so it is
+// offered to other users only if they have a folder with the same path, e.g. a shared team folder.
+func findLinkedFolderOption(detailsService FileDetailsService, linked *ChannelFolder) *apps.SelectOption {
+	if linked == nil {
+		return nil
+	}
+	folder := strings.TrimSuffix(linked.Folder, "/")
+	if details, err := detailsService.GetFileDetails(folder); err != nil || !details.IsFolder {
+		return nil
+	}
+	return &apps.SelectOption{Label: strings.TrimPrefix(folder, "/"), Value: linked.Folder}
+}
@@ -0,0 +1,93 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+type KVStoreMock struct {
+	values map[string][]byte
+}
+
+func (m KVStoreMock) KVGet(prefix, id string, ref interface{}) error {
+	if value, ok := m.values[id]; ok {
+		return json.Unmarshal(value, ref)
+	}
+	return nil
+}
+
+func (m KVStoreMock) KVSet(prefix, id string, in interface{}) (bool, error) {
+	m.values[id], _ = json.Marshal(in)
+	return true, nil
+}
+
+func (m KVStoreMock) KVDelete(prefix, id string) error {
+	delete(m.values, id)
+	return nil
+}
+
+func TestChannelFolderIsLinkedAndUnlinked(t *testing.T) {
+	testedInstance := KVChannelFolderStore{AsBot: KVStoreMock{values: map[string][]byte{}}}
+
+	testedInstance.LinkFolder("channel", ChannelFolder{Folder: "/Projects/Apollo/", LinkedBy: "user"})
+
+	linked := testedInstance.GetFolder("channel")
+	if linked == nil || linked.Folder != "/Projects/Apollo/" {
+		t.Fatalf(" expected %q, actual %+v", "/Projects/Apollo/", linked)
+	}
+	if testedInstance.GetFolder("other") != nil {
+		t.Error("Other channel should not be linked")
+	}
+	testedInstance.UnlinkFolder("channel")
+	if testedInstance.GetFolder("channel") != nil {
+		t.Error("Channel should be unlinked")
+	}
+}
+
+type FileDetailsServiceMock struct {
+	folders map[string]bool
+}
+
+func (s FileDetailsServiceMock) GetFileDetails(filePath string) (*FileDetails, error) {
+	if !s.folders[filePath] {
+		return nil, errors.New("not found")
+	}
+	return &FileDetails{IsFolder: true}, nil
+}
+
+func TestLinkedFolderIsChosenOnlyIfUserHasIt(t *testing.T) {
+	detailsService := FileDetailsServiceMock{folders: map[string]bool{"/Docs": true, "/Projects/Apollo": true}}
+
+	option := findLinkedFolderOption(detailsService, &ChannelFolder{Folder: "/Projects/Apollo/"})
+	if option == nil || option.Label != "Projects/Apollo" || option.Value != "/Projects/Apollo/" {
+		t.Errorf(" expected %q, actual %+v", "Projects/Apollo", option)
+	}
+	if findLinkedFolderOption(detailsService, &ChannelFolder{Folder: "/Private/"}) != nil {
+		t.Error("Folder which the user does not have should not be chosen")
+	}
+	if findLinkedFolderOption(detailsService, nil) != nil {
+		t.Error("Not linked channel should have no folder")
+	}
+}
+
+func TestOnlyLinkerAndAdminsManageChannelFolder(t *testing.T) {
+	linked := &ChannelFolder{Folder: "/Projects/", LinkedBy: "linker"}
+	member := &model.ChannelMember{Roles: model.ChannelUserRoleId}
+	teamMember := &model.TeamMember{Roles: model.TeamUserRoleId}
+
+	if !CanManageChannelFolder(&model.User{Id: "linker"}, member, teamMember, linked) {
+		t.Error("User who linked the folder should manage it")
+	}
+	if CanManageChannelFolder(&model.User{Id: "member"}, member, teamMember, linked) {
+		t.Error("Other channel members should not manage the folder")
+	}
+	if !CanManageChannelFolder(&model.User{Id: "member"}, &model.ChannelMember{SchemeAdmin: true}, teamMember, linked) {
+		t.Error("Channel admin should manage the folder")
+	}
+	if !CanManageChannelFolder(&model.User{Id: "member"}, member, &model.TeamMember{Roles: model.TeamUserRoleId + " " + model.TeamAdminRoleId}, linked) {
+		t.Error("Team admin should manage the folder")
+	}
+}
@@ -0,0 +1,202 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/settings"
+	"github.com/prokhorind/nextcloud/function/user"
+	log "github.com/sirupsen/logrus"
+)
+
+const attachField = "Attach"
+
+func FileAttachForm(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	folderName := getSelectedValue(creq.Values, "Folder")
+	browseService := createFileBrowseService(c, creq, token.AccessToken)
+	subfolderOptions, err := browseService.ListSubfolders(folderName)
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Request failed during folder search")))
+		return
+	}
+	folderSelectOptions, currentFolderOption := createBrowseFolderOptions(folderName, subfolderOptions)
+
+	expand := apps.Expand{
+		ActingUserAccessToken: apps.ExpandAll,
+		OAuth2App:             apps.ExpandAll,
+		OAuth2User:            apps.ExpandAll,
+		Channel:               apps.ExpandAll,
+		ActingUser:            apps.ExpandAll,
+	}
+	form := &apps.Form{
+		Title: "Attach files from Nextcloud",
+		Icon:  "icon.png",
+		Fields: []apps.Field{
+			{
+				Type:                "static_select",
+				Name:                "Folder",
+				Label:               "Folder",
+				Description:         "Choose a subfolder to open it, or .. to go up",
+				IsRequired:          true,
+				SelectRefresh:       true,
+				SelectStaticOptions: folderSelectOptions,
+				Value:               currentFolderOption,
+			},
+			{
+				Type:          "dynamic_select",
+				Name:          "Files",
+				Label:         "Files",
+				Description:   "Type a part of the file name to search in the folder and its subfolders",
+				IsRequired:    true,
+				SelectIsMulti: true,
+				Value:         getSelectedFiles(creq.Values),
+				SelectDynamicLookup: apps.NewCall("/file/search/lookup").WithExpand(apps.Expand{
+					ActingUserAccessToken: apps.ExpandAll,
+					OAuth2App:             apps.ExpandAll,
+					OAuth2User:            apps.ExpandAll,
+					ActingUser:            apps.ExpandAll,
+				}),
+			},
+			{
+				Type:        "text",
+				TextSubtype: apps.TextFieldSubtypeTextarea,
+				Name:        "Message",
+				Label:       "Message",
+				Value:       creq.Values["Message"],
+			},
+		},
+		Source: apps.NewCall("/file/attach/form").WithExpand(expand),
+		Submit: apps.NewCall("/file/attach").WithExpand(expand),
+	}
+
+	c.JSON(http.StatusOK, apps.NewFormResponse(*form))
+}
+
+func FileAttach(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	paths := make([]string, 0)
+	for _, option := range getSelectedFiles(creq.Values) {
+		paths = append(paths, option.Value)
+	}
+	if len(paths) == 0 {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Please, choose a file to attach")))
+		return
+	}
+
+	botService := user.BotServiceImpl{Creq: creq}
+	botService.AddBot()
+
+	message, _ := creq.Values["Message"].(string)
+	if err := startFileAttach(creq, token.AccessToken, paths, message); err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, apps.NewTextResponse("Attaching %d files from Nextcloud to this channel", len(paths)))
+}
+
+// startFileAttach checks the files against the limits and copies them by a background job. The bot has to be
+// a member of the channel.
+func startFileAttach(creq apps.CallRequest, accessToken string, paths []string, message string) error {
+	appSettings := settings.ForCall(creq)
+	asBot := appclient.AsBot(creq.Context)
+	attachService := FileAttachServiceImpl{
+		Url:      creq.Context.OAuth2.OAuth2App.RemoteRootURL + "/remote.php/dav/",
+		UserId:   creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string),
+		Client:   appSettings.NewUserClient(context.Background(), creq, accessToken).ForStreaming(),
+		MM:       asBot,
+		settings: appSettings,
+	}
+
+	files, err := attachService.GetFiles(paths)
+	if err != nil {
+		return err
+	}
+
+	header := fmt.Sprintf("@%s attached files from Nextcloud", creq.Context.ActingUser.Username)
+	if len(strings.TrimSpace(message)) != 0 {
+		header += "\n" + message
+	}
+	job := AttachJob{
+		Service:   attachService,
+		Client:    asBot,
+		ChannelId: creq.Context.Channel.Id,
+		UserId:    creq.Context.ActingUser.Id,
+		Message:   header,
+		Files:     files,
+	}
+	startBackgroundJob(job.Run)
+	return nil
+}
+
+type AttachPostClient interface {
+	CreatePost(post *model.Post) (*model.Post, error)
+	DMPost(userID string, post *model.Post) (*model.Post, error)
+}
+
+type AttachJob struct {
+	Service   FileAttachService
+	Client    AttachPostClient
+	ChannelId string
+	UserId    string
+	Message   string
+	Files     []NextcloudFile
+}
+
+// Run posts the attached files to the channel and tells the user about the files which were not attached.
+func (j AttachJob) Run() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Attach job of user %s failed: %v", j.UserId, r)
+		}
+	}()
+
+	results := j.Service.AttachFiles(j.ChannelId, j.Files)
+	for _, post := range createAttachPosts(j.ChannelId, j.Message, results) {
+		if _, err := j.Client.CreatePost(post); err != nil {
+			log.Errorf("Attached files were not posted to channel %s. Error: %s", j.ChannelId, err)
+		}
+	}
+
+	failed := make([]string, 0)
+	for _, result := range results {
+		if result.Err != nil {
+			failed = append(failed, fmt.Sprintf("- %s: %s", result.Path, result.Err))
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+	message := "Files were not attached from Nextcloud:\n" + strings.Join(failed, "\n")
+	if _, err := j.Client.DMPost(j.UserId, &model.Post{Message: message}); err != nil {
+		log.Errorf("Attach errors were not sent to user %s. Error: %s", j.UserId, err)
+	}
+}
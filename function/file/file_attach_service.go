@@ -0,0 +1,173 @@
+package file
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	"github.com/prokhorind/nextcloud/function/settings"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxFilesPerPost is the number of attachments Mattermost allows in one post.
+const maxFilesPerPost = 10
+
+type AttachClient interface {
+	CreateUpload(us *model.UploadSession) (*model.UploadSession, *model.Response, error)
+	UploadData(uploadId string, data io.Reader) (*model.FileInfo, *model.Response, error)
+}
+
+type NextcloudFile struct {
+	Path string
+	Size int64
+}
+
+type AttachResult struct {
+	Path   string
+	FileId string
+	Err    error
+}
+
+type FileAttachService interface {
+	GetFiles(paths []string) ([]NextcloudFile, error)
+	AttachFiles(channelId string, files []NextcloudFile) []AttachResult
+}
+
+// FileAttachServiceImpl copies Nextcloud files to Mattermost. The download is streamed into a Mattermost upload
+// session, so a file is never held in memory. Url is the dav root, e.g. https://cloud/remote.php/dav/.
+type FileAttachServiceImpl struct {
+	Url      string
+	UserId   string
+	Client   nextcloud.Client
+	MM       AttachClient
+	settings settings.Settings
+}
+
+// GetFiles returns the sizes of the files and checks them against the upload limits.
+func (s FileAttachServiceImpl) GetFiles(paths []string) ([]NextcloudFile, error) {
+	files := make([]NextcloudFile, 0)
+	var total int64
+	for _, filePath := range paths {
+		file, err := s.getFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if file.Size > s.settings.MaxFileSizeInBytes() {
+			return nil, fmt.Errorf("File above %d MB cannot be attached: %s", s.settings.MaxFileSizeMb, filePath)
+		}
+		total += file.Size
+		if total > s.settings.MaxFilesSizeInBytes() {
+			return nil, fmt.Errorf("Size of attached files above %d MB cannot be attached", s.settings.MaxFilesSizeMb)
+		}
+		files = append(files, *file)
+	}
+	return files, nil
+}
+
+func (s FileAttachServiceImpl) getFile(filePath string) (*NextcloudFile, error) {
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+	<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+		<d:prop>
+			<d:getcontenttype/>
+			<oc:size/>
+		</d:prop>
+	</d:propfind>`
+
+	req, _ := http.NewRequest("PROPFIND", s.fileUrl(filePath), strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/xml")
+	req.Header.Set("Depth", "0")
+
+	resp, err := s.Client.Do(req, http.StatusMultiStatus)
+	if err != nil {
+		log.Errorf("File %s was not found. Error: %s", filePath, err)
+		return nil, fmt.Errorf("File %s was not found in Nextcloud", filePath)
+	}
+	defer resp.Body.Close()
+
+	xmlResp := FileSearchResponseBody{}
+	if err := xml.NewDecoder(resp.Body).Decode(&xmlResp); err != nil || len(xmlResp.FileResponse) == 0 || len(xmlResp.FileResponse[0].PropertyStats) == 0 {
+		return nil, fmt.Errorf("File %s was not found in Nextcloud", filePath)
+	}
+	property := xmlResp.FileResponse[0].PropertyStats[0].Property
+	if len(property.Getcontenttype) == 0 || property.Getcontenttype == directoryMimeType {
+		return nil, fmt.Errorf("%s is a folder, only files can be attached", filePath)
+	}
+	size, _ := strconv.ParseInt(property.Size, 10, 64)
+	return &NextcloudFile{Path: filePath, Size: size}, nil
+}
+
+// AttachFiles uploads the files to the channel one by one. Uploaded files can be added to a post by their ids.
+func (s FileAttachServiceImpl) AttachFiles(channelId string, files []NextcloudFile) []AttachResult {
+	results := make([]AttachResult, 0)
+	for _, file := range files {
+		fileId, err := s.attachFile(channelId, file)
+		if err != nil {
+			log.Errorf("File %s was not attached. Error: %s", file.Path, err)
+		}
+		results = append(results, AttachResult{Path: file.Path, FileId: fileId, Err: err})
+	}
+	return results
+}
+
+func (s FileAttachServiceImpl) attachFile(channelId string, file NextcloudFile) (string, error) {
+	req, _ := http.NewRequest("GET", s.fileUrl(file.Path), nil)
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if err != nil {
+		return "", errors.Wrap(err, "file was not downloaded from Nextcloud")
+	}
+	defer resp.Body.Close()
+
+	session, _, err := s.MM.CreateUpload(&model.UploadSession{
+		Type:      model.UploadTypeAttachment,
+		ChannelId: channelId,
+		Filename:  path.Base(file.Path),
+		FileSize:  file.Size,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "upload to Mattermost was not started")
+	}
+
+	fileInfo, _, err := s.MM.UploadData(session.Id, resp.Body)
+	if err != nil {
+		return "", errors.Wrap(err, "file was not uploaded to Mattermost")
+	}
+	if fileInfo == nil {
+		return "", errors.New("file was uploaded to Mattermost only partly")
+	}
+	return fileInfo.Id, nil
+}
+
+func (s FileAttachServiceImpl) fileUrl(filePath string) string {
+	return s.Url + "files/" + escapePath(s.UserId+filePath)
+}
+
+// createAttachPosts groups the attached files into posts, Mattermost allows only a few files in one post.
+func createAttachPosts(channelId string, message string, results []AttachResult) []*model.Post {
+	fileIds := make([]string, 0)
+	for _, result := range results {
+		if result.Err == nil {
+			fileIds = append(fileIds, result.FileId)
+		}
+	}
+
+	posts := make([]*model.Post, 0)
+	for start := 0; start < len(fileIds); start += maxFilesPerPost {
+		end := start + maxFilesPerPost
+		if end > len(fileIds) {
+			end = len(fileIds)
+		}
+		post := &model.Post{ChannelId: channelId, FileIds: fileIds[start:end]}
+		if start == 0 {
+			post.Message = message
+		}
+		posts = append(posts, post)
+	}
+	return posts
+}
@@ -0,0 +1,139 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	"github.com/prokhorind/nextcloud/function/settings"
+)
+
+type AttachClientMock struct {
+	sessions []*model.UploadSession
+	uploaded map[string]string
+	err      error
+}
+
+func (m *AttachClientMock) CreateUpload(us *model.UploadSession) (*model.UploadSession, *model.Response, error) {
+	if m.err != nil {
+		return nil, nil, m.err
+	}
+	us.Id = fmt.Sprintf("upload-%d", len(m.sessions))
+	m.sessions = append(m.sessions, us)
+	return us, nil, nil
+}
+
+func (m *AttachClientMock) UploadData(uploadId string, data io.Reader) (*model.FileInfo, *model.Response, error) {
+	content, _ := io.ReadAll(data)
+	m.uploaded[uploadId] = string(content)
+	return &model.FileInfo{Id: "file-" + uploadId}, nil, nil
+}
+
+func createAttachTest(files map[string]string) (*httptest.Server, *AttachClientMock, FileAttachServiceImpl) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/remote.php/dav/files/user")
+		content, ok := files[name]
+		if !ok && name != "/Docs" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == "GET" {
+			w.Write([]byte(content))
+			return
+		}
+		contentType := "<d:getcontenttype>text/plain</d:getcontenttype>"
+		if !ok {
+			contentType = ""
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<d:multistatus xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns"><d:response><d:href>%s</d:href><d:propstat><d:prop>%s<oc:size>%d</oc:size></d:prop></d:propstat></d:response></d:multistatus>`,
+			r.URL.Path, contentType, len(content))
+	}))
+	mm := &AttachClientMock{uploaded: map[string]string{}}
+	testedInstance := FileAttachServiceImpl{
+		Url:      server.URL + "/remote.php/dav/",
+		UserId:   "user",
+		Client:   nextcloud.Client{},
+		MM:       mm,
+		settings: settings.Settings{MaxFileSizeMb: 1, MaxFilesSizeMb: 2},
+	}
+	return server, mm, testedInstance
+}
+
+func TestFilesAreAttachedToChannel(t *testing.T) {
+	server, mm, testedInstance := createAttachTest(map[string]string{"/Docs/a b.txt": "first", "/notes.md": "second"})
+	defer server.Close()
+
+	files, err := testedInstance.GetFiles([]string{"/Docs/a b.txt", "/notes.md"})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	results := testedInstance.AttachFiles("channel-id", files)
+
+	if len(results) != 2 || results[0].Err != nil || results[1].Err != nil {
+		t.Fatalf("Files should be attached, actual %+v", results)
+	}
+	if mm.sessions[0].Filename != "a b.txt" || mm.sessions[0].FileSize != 5 || mm.sessions[0].ChannelId != "channel-id" {
+		t.Errorf("Upload session is wrong, actual %+v", mm.sessions[0])
+	}
+	if mm.uploaded["upload-1"] != "second" {
+		t.Errorf(" expected %q, actual %q", "second", mm.uploaded["upload-1"])
+	}
+}
+
+func TestFolderIsNotAttached(t *testing.T) {
+	server, _, testedInstance := createAttachTest(map[string]string{})
+	defer server.Close()
+
+	if _, err := testedInstance.GetFiles([]string{"/Docs"}); err == nil {
+		t.Error("Folder should not be attached")
+	}
+}
+
+func TestTooBigFilesAreNotAttached(t *testing.T) {
+	big := strings.Repeat("x", 1024*1024+1)
+	server, _, testedInstance := createAttachTest(map[string]string{"/big.bin": big, "/a.bin": big[:1024*1024], "/b.bin": big[:1024*1024]})
+	defer server.Close()
+
+	if _, err := testedInstance.GetFiles([]string{"/big.bin"}); err == nil {
+		t.Error("File above the limit should not be attached")
+	}
+	if _, err := testedInstance.GetFiles([]string{"/a.bin", "/b.bin", "/a.bin"}); err == nil {
+		t.Error("Files above the total limit should not be attached")
+	}
+}
+
+func TestFailedAttachIsReported(t *testing.T) {
+	server, mm, testedInstance := createAttachTest(map[string]string{"/a.txt": "a"})
+	defer server.Close()
+	mm.err = errors.New("forbidden")
+
+	results := testedInstance.AttachFiles("channel-id", []NextcloudFile{{Path: "/a.txt", Size: 1}})
+
+	if results[0].Err == nil {
+		t.Error("Attach should fail")
+	}
+}
+
+func TestAttachedFilesAreSplitIntoPosts(t *testing.T) {
+	results := make([]AttachResult, 0)
+	for i := 0; i < 12; i++ {
+		results = append(results, AttachResult{FileId: fmt.Sprint(i)})
+	}
+	results = append(results, AttachResult{Err: errors.New("failed")})
+
+	posts := createAttachPosts("channel-id", "message", results)
+
+	if len(posts) != 2 || len(posts[0].FileIds) != maxFilesPerPost || len(posts[1].FileIds) != 2 {
+		t.Fatalf("Two posts expected, actual %d", len(posts))
+	}
+	if posts[0].Message != "message" || posts[1].Message != "" {
+		t.Error("Only the first post should have the message")
+	}
+}
@@ -0,0 +1,276 @@
+package file
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	FileSearchLimit     = 25
+	directoryMimeType   = "httpd/unix-directory"
+	searchDavNamespace  = "https://github.com/icewind1991/SearchDAV/ns"
+	lastModifiedLiteral = "2006-01-02T15:04:05Z"
+)
+
+var FileTypeOptions = []apps.SelectOption{
+	{Label: "Images", Value: "image/%"},
+	{Label: "Videos", Value: "video/%"},
+	{Label: "Audio", Value: "audio/%"},
+	{Label: "PDF", Value: "application/pdf"},
+	{Label: "Text", Value: "text/%"},
+	{Label: "Office documents", Value: "application/vnd.%"},
+}
+
+var ModifiedOptions = []apps.SelectOption{
+	{Label: "Last 24 hours", Value: "24h"},
+	{Label: "Last week", Value: "168h"},
+	{Label: "Last month", Value: "720h"},
+	{Label: "Last year", Value: "8760h"},
+}
+
+type FileSearchQuery struct {
+	Folder        string
+	Name          string
+	MimeType      string
+	ModifiedAfter time.Time
+	Page          int
+	// Folders searches folders instead of files.
+	Folders bool
+}
+
+type FileBrowseService interface {
+	ListSubfolders(folder string) ([]apps.SelectOption, error)
+	SearchFiles(query FileSearchQuery) ([]apps.SelectOption, bool, error)
+	GetFileDetails(filePath string) (*FileDetails, error)
+}
+
+type FileDetails struct {
+	FileId       string
+	Size         int64
+	LastModified time.Time
+	IsFolder     bool
+}
+
+// FileBrowseServiceImpl lists one folder level at a time and searches files page by page,
+// so big accounts are never loaded at once.
+type FileBrowseServiceImpl struct {
+	Url    string
+	UserId string
+	Client nextcloud.Client
+}
+
+func (s FileBrowseServiceImpl) ListSubfolders(folder string) ([]apps.SelectOption, error) {
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+	<d:propfind xmlns:d="DAV:">
+		<d:prop>
+			<d:displayname/>
+			<d:getcontenttype/>
+		</d:prop>
+	</d:propfind>`
+
+	req, _ := http.NewRequest("PROPFIND", s.Url+"files/"+escapePath(s.UserId+folder)+"/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/xml")
+	req.Header.Set("Depth", "1")
+
+	resp, err := s.Client.Do(req, http.StatusMultiStatus)
+	if err != nil {
+		log.Errorf("Folder %s was not listed. Error: %s", folder, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	xmlResp := FileSearchResponseBody{}
+	xml.NewDecoder(resp.Body).Decode(&xmlResp)
+
+	folders := make([]apps.SelectOption, 0)
+	for _, f := range xmlResp.FileResponse {
+		filePath, ok := hrefToPath(f.Href, s.UserId)
+		if !ok || filePath == folder || len(f.PropertyStats) == 0 || len(f.PropertyStats[0].Property.Getcontenttype) != 0 {
+			continue
+		}
+		folders = append(folders, apps.SelectOption{Label: strings.TrimPrefix(filePath, "/"), Value: filePath})
+	}
+	sort.Slice(folders, func(i, j int) bool {
+		return folders[i].Label < folders[j].Label
+	})
+	return folders, nil
+}
+
+// GetFileDetails returns the size and the last modification of the file or folder.
+func (s FileBrowseServiceImpl) GetFileDetails(filePath string) (*FileDetails, error) {
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+	<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+		<d:prop>
+			<oc:fileid/>
+			<oc:size/>
+			<d:getlastmodified/>
+			<d:resourcetype/>
+		</d:prop>
+	</d:propfind>`
+
+	req, _ := http.NewRequest("PROPFIND", s.Url+"files/"+escapePath(s.UserId+filePath), strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/xml")
+	req.Header.Set("Depth", "0")
+
+	resp, err := s.Client.Do(req, http.StatusMultiStatus)
+	if err != nil {
+		log.Errorf("Details of %s were not found. Error: %s", filePath, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	xmlResp := FileSearchResponseBody{}
+	if err := xml.NewDecoder(resp.Body).Decode(&xmlResp); err != nil || len(xmlResp.FileResponse) == 0 || len(xmlResp.FileResponse[0].PropertyStats) == 0 {
+		return nil, fmt.Errorf("details of %s are not valid", filePath)
+	}
+	property := xmlResp.FileResponse[0].PropertyStats[0].Property
+	details := FileDetails{FileId: property.Fileid, IsFolder: property.Resourcetype.Collection != nil}
+	details.Size, _ = strconv.ParseInt(property.Size, 10, 64)
+	details.LastModified, _ = http.ParseTime(property.Getlastmodified)
+	return &details, nil
+}
+
+// SearchFiles returns one page of files in the folder and its subfolders, and whether there are more pages.
+func (s FileBrowseServiceImpl) SearchFiles(query FileSearchQuery) ([]apps.SelectOption, bool, error) {
+	req, _ := http.NewRequest("SEARCH", s.Url, bytes.NewBufferString(createFileSearchRequestBody(s.UserId, query)))
+	req.Header.Set("Content-Type", "text/xml")
+
+	resp, err := s.Client.Do(req, http.StatusMultiStatus)
+	if err != nil {
+		log.Errorf("Files were not found. Error: %s", err)
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	xmlResp := FileSearchResponseBody{}
+	xml.NewDecoder(resp.Body).Decode(&xmlResp)
+
+	files := make([]apps.SelectOption, 0)
+	for _, f := range xmlResp.FileResponse {
+		filePath, ok := hrefToPath(f.Href, s.UserId)
+		if !ok {
+			continue
+		}
+		files = append(files, apps.SelectOption{Label: strings.TrimPrefix(filePath, "/"), Value: filePath})
+	}
+
+	if len(files) > FileSearchLimit {
+		return files[:FileSearchLimit], true, nil
+	}
+	return files, false, nil
+}
+
+func createFileSearchRequestBody(userId string, query FileSearchQuery) string {
+	isFolder := `<d:eq><d:prop><d:getcontenttype/></d:prop><d:literal>` + directoryMimeType + `</d:literal></d:eq>`
+	if !query.Folders {
+		isFolder = `<d:not>` + isFolder + `</d:not>`
+	}
+	conditions := []string{
+		isFolder,
+		`<d:like><d:prop><d:displayname/></d:prop><d:literal>%` + escapeXml(escapeLike(query.Name)) + `%</d:literal></d:like>`,
+	}
+	if len(query.MimeType) != 0 {
+		conditions = append(conditions, `<d:like><d:prop><d:getcontenttype/></d:prop><d:literal>`+escapeXml(query.MimeType)+`</d:literal></d:like>`)
+	}
+	if !query.ModifiedAfter.IsZero() {
+		conditions = append(conditions, `<d:gt><d:prop><d:getlastmodified/></d:prop><d:literal>`+query.ModifiedAfter.UTC().Format(lastModifiedLiteral)+`</d:literal></d:gt>`)
+	}
+
+	page := query.Page
+	if page < 1 {
+		page = 1
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+	<d:searchrequest xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns" xmlns:ns="%s">
+		<d:basicsearch>
+			<d:select>
+				<d:prop>
+					<oc:fileid/>
+					<d:displayname/>
+					<d:getcontenttype/>
+					<d:getlastmodified/>
+				</d:prop>
+			</d:select>
+			<d:from>
+				<d:scope>
+					<d:href>/files/%s</d:href>
+					<d:depth>infinity</d:depth>
+				</d:scope>
+			</d:from>
+			<d:where>
+				<d:and>
+					%s
+				</d:and>
+			</d:where>
+			<d:orderby>
+				<d:order>
+					<d:prop>
+						<d:getlastmodified/>
+					</d:prop>
+					<d:descending/>
+				</d:order>
+			</d:orderby>
+			<d:limit>
+				<d:nresults>%d</d:nresults>
+				<ns:firstresult>%d</ns:firstresult>
+			</d:limit>
+		</d:basicsearch>
+	</d:searchrequest>`, searchDavNamespace, escapeXml(userId+query.Folder), strings.Join(conditions, "\n\t\t\t\t\t"), FileSearchLimit+1, (page-1)*FileSearchLimit)
+}
+
+// hrefToPath returns the path of the file relative to the user root, e.g. /Folder/file.txt.
+func hrefToPath(href string, userId string) (string, bool) {
+	unescaped, err := url.PathUnescape(href)
+	if err != nil {
+		return "", false
+	}
+	prefix := "/remote.php/dav/files/" + userId
+	i := strings.Index(unescaped, prefix)
+	if i < 0 {
+		return "", false
+	}
+	filePath := strings.TrimSuffix(unescaped[i+len(prefix):], "/")
+	return filePath, true
+}
+
+func parentFolder(folder string) string {
+	parent := path.Dir(folder)
+	if parent == "/" || parent == "." {
+		return ""
+	}
+	return parent
+}
+
+func escapePath(filePath string) string {
+	segments := strings.Split(filePath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// escapeLike makes % and _ of the name match literally, Nextcloud uses the backslash as the escape character of LIKE.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func escapeXml(s string) string {
+	buf := bytes.Buffer{}
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,134 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+)
+
+func createMultistatus(hrefs []string, contentType string) string {
+	responses := make([]string, 0)
+	for _, href := range hrefs {
+		responses = append(responses, fmt.Sprintf(`<d:response><d:href>%s</d:href><d:propstat><d:prop><d:displayname>name</d:displayname><d:getcontenttype>%s</d:getcontenttype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, href, contentType))
+	}
+	return `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">` + strings.Join(responses, "") + `</d:multistatus>`
+}
+
+func TestSubfoldersAreListed(t *testing.T) {
+	var depth, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		depth = r.Header.Get("Depth")
+		path = r.URL.EscapedPath()
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(createMultistatus([]string{
+			"/remote.php/dav/files/user/My%20Folder/",
+			"/remote.php/dav/files/user/My%20Folder/Sub%20B/",
+			"/remote.php/dav/files/user/My%20Folder/Sub%20A/",
+		}, "")))
+	}))
+	defer server.Close()
+
+	testedInstance := FileBrowseServiceImpl{Url: server.URL + "/remote.php/dav/", UserId: "user", Client: nextcloud.NewClient("")}
+	folders, err := testedInstance.ListSubfolders("/My Folder")
+
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if depth != "1" || path != "/remote.php/dav/files/user/My%20Folder/" {
+		t.Errorf("Only one level of the folder should be requested, depth %s, path %s", depth, path)
+	}
+	if len(folders) != 2 || folders[0].Value != "/My Folder/Sub A" || folders[1].Label != "My Folder/Sub B" {
+		t.Errorf("Unexpected folders %v", folders)
+	}
+}
+
+func TestFilesAreSearchedByPage(t *testing.T) {
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		hrefs := make([]string, 0)
+		for i := 0; i <= FileSearchLimit; i++ {
+			hrefs = append(hrefs, fmt.Sprintf("/remote.php/dav/files/user/Folder/file%d.txt", i))
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(createMultistatus(hrefs, "text/plain")))
+	}))
+	defer server.Close()
+
+	testedInstance := FileBrowseServiceImpl{Url: server.URL + "/remote.php/dav/", UserId: "user", Client: nextcloud.NewClient("")}
+	files, hasMore, err := testedInstance.SearchFiles(FileSearchQuery{Folder: "/Folder", Name: "file", Page: 2})
+
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(files) != FileSearchLimit || !hasMore {
+		t.Errorf("expected %d files and more pages, actual %d, %t", FileSearchLimit, len(files), hasMore)
+	}
+	if files[0].Value != "/Folder/file0.txt" {
+		t.Errorf("Unexpected file %v", files[0])
+	}
+	for _, expected := range []string{"<d:href>/files/user/Folder</d:href>", "%file%", fmt.Sprintf("<ns:firstresult>%d</ns:firstresult>", FileSearchLimit)} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("%q is missing in search request %s", expected, body)
+		}
+	}
+}
+
+func TestSearchFiltersAreApplied(t *testing.T) {
+	modifiedAfter := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	body := createFileSearchRequestBody("user", FileSearchQuery{Name: "<report>", MimeType: "image/%", ModifiedAfter: modifiedAfter})
+
+	for _, expected := range []string{"%&lt;report&gt;%", "<d:literal>image/%</d:literal>", "<d:literal>2023-01-02T03:04:05Z</d:literal>", "<ns:firstresult>0</ns:firstresult>"} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("%q is missing in search request %s", expected, body)
+		}
+	}
+}
+
+func TestParentFolder(t *testing.T) {
+	folders := map[string]string{"/A/B": "/A", "/A": "", "": ""}
+	for folder, expected := range folders {
+		if parentFolder(folder) != expected {
+			t.Errorf("%q: expected %q, actual %q", folder, expected, parentFolder(folder))
+		}
+	}
+}
+
+func TestSearchNameIsMatchedLiterally(t *testing.T) {
+	body := createFileSearchRequestBody("user", FileSearchQuery{Name: `50%_off\`})
+
+	if expected := `<d:literal>%50\%\_off\\%</d:literal>`; !strings.Contains(body, expected) {
+		t.Errorf("%q is missing in search request %s", expected, body)
+	}
+}
+
+func TestFoldersAreSearched(t *testing.T) {
+	body := createFileSearchRequestBody("user", FileSearchQuery{Name: "docs", Folders: true})
+
+	if strings.Contains(body, "<d:not>") || !strings.Contains(body, "<d:literal>"+directoryMimeType+"</d:literal>") {
+		t.Errorf("Only folders should be searched, request %s", body)
+	}
+}
+
+func TestMoreResultsOptionIsNotSelected(t *testing.T) {
+	option := createMoreResultsOption("files", 1, true)
+	values := map[string]interface{}{"Files": []interface{}{
+		map[string]interface{}{"label": "report.pdf", "value": "/report.pdf"},
+		map[string]interface{}{"label": option.Label, "value": option.Value},
+	}}
+
+	if option.Label != "More files found, type more of the name or set Page to 2" {
+		t.Errorf("Unexpected label %q", option.Label)
+	}
+	if files := getSelectedFiles(values); len(files) != 1 || files[0].Value != "/report.pdf" {
+		t.Errorf("Only chosen files should be selected, actual %v", files)
+	}
+}
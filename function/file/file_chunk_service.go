@@ -1,185 +1,335 @@
 package file
 
 import (
-	"bytes"
+	"encoding/xml"
 	"fmt"
-	"github.com/hashicorp/go-retryablehttp"
-	"github.com/mattermost/mattermost-server/v6/model"
-	log "github.com/sirupsen/logrus"
+	"io"
 	"net/http"
-	"os"
+	"path"
 	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	log "github.com/sirupsen/logrus"
 )
 
+const (
+	defaultChunkUploadWorkers = 4
+	chunkRetryDelay           = 500 * time.Millisecond
+	totalLengthHeader         = "OC-Total-Length"
+	fileIdHeader              = "OC-FileId"
+)
+
+// FileChunkService speaks the Nextcloud chunking v2 protocol: chunks named 1..10000 are put into an upload folder
+// which knows its destination, and the folder is moved to the destination to assemble the file.
 type FileChunkService interface {
-	createChunkFolder(url string) (*http.Response, error)
-	uploadFileChunk(file []byte, start string, end string, url string) (*http.Response, error)
-	assembleChunk(dest string, url string) (*http.Response, error)
-	abortChunkUpload(url string) (*http.Response, error)
+	createChunkFolder(url string, destination string, totalLength int64) error
+	listUploadedChunks(url string) (map[int]bool, error)
+	uploadFileChunk(chunk io.Reader, length int64, number int, url string, destination string) error
+	assembleChunk(dest string, url string, totalLength int64, condition UploadCondition) (string, error)
+	abortChunkUpload(url string) error
 }
 
 type FileChunkServiceImpl struct {
-	Token string
+	Client nextcloud.Client
 }
 
-func (f *FileChunkServiceImpl) createChunkFolder(url string) (*http.Response, error) {
+func (f *FileChunkServiceImpl) createChunkFolder(url string, destination string, totalLength int64) error {
 	req, _ := http.NewRequest("MKCOL", url, nil)
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", f.Token))
+	req.Header.Set("Destination", destination)
+	req.Header.Set(totalLengthHeader, strconv.FormatInt(totalLength, 10))
 
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
+	resp, err := f.Client.Do(req, http.StatusCreated)
+	if err != nil {
+		log.Errorf("Error during creating of the chunk folder. Error: %s", err)
+		return err
+	}
+	resp.Body.Close()
 
-	client := retryClient.StandardClient()
-	resp, err := client.Do(req)
+	return nil
+}
 
-	defer resp.Body.Close()
+// listUploadedChunks returns the numbers of the chunks which are already stored in the upload folder.
+func (f *FileChunkServiceImpl) listUploadedChunks(url string) (map[int]bool, error) {
+	req, _ := http.NewRequest("PROPFIND", url, nil)
+	req.Header.Set("Depth", "1")
 
+	resp, err := f.Client.Do(req, http.StatusMultiStatus)
 	if err != nil {
-		log.Errorf("Error during refreshing of the token. Error: %s", err)
+		log.Errorf("Error during listing of uploaded chunks. Error: %s", err)
 		return nil, err
 	}
+	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
-		log.Errorf("request failed with status %s", resp.Status)
-		error := fmt.Errorf("request failed with code %d", resp.StatusCode)
-		return nil, error
+	xmlResp := FileSearchResponseBody{}
+	if err := xml.NewDecoder(resp.Body).Decode(&xmlResp); err != nil {
+		return nil, errors.Wrap(err, "Nextcloud upload folder response is not valid")
 	}
 
-	return resp, err
+	chunks := map[int]bool{}
+	for _, r := range xmlResp.FileResponse {
+		if number, err := strconv.Atoi(path.Base(r.Href)); err == nil {
+			chunks[number] = true
+		}
+	}
+	return chunks, nil
 }
 
-func (f *FileChunkServiceImpl) uploadFileChunk(file []byte, start string, end string, baseurl string) (*http.Response, error) {
-	url := fmt.Sprintf("%s/%s-%s", baseurl, start, end)
-	req, _ := http.NewRequest("PUT", url, bytes.NewBuffer(file))
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", f.Token))
-
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
-
-	client := retryClient.StandardClient()
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
+func (f *FileChunkServiceImpl) uploadFileChunk(chunk io.Reader, length int64, number int, baseurl string, destination string) error {
+	url := fmt.Sprintf("%s/%d", baseurl, number)
+	req, _ := http.NewRequest("PUT", url, chunk)
+	req.ContentLength = length
+	req.Header.Set("Destination", destination)
 
+	resp, err := f.Client.Do(req, http.StatusCreated, http.StatusNoContent)
 	if err != nil {
-		log.Errorf("Error during uploading of file chunks. Error: %s", err)
-		return nil, err
+		log.Errorf("Error during uploading of file chunk %d. Error: %s", number, err)
+		return err
 	}
+	resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
-		log.Errorf("request failed with status %s", resp.Status)
-		error := fmt.Errorf("request failed with code %d", resp.StatusCode)
-		return nil, error
-	}
-	return resp, err
+	return nil
 }
 
-func (f *FileChunkServiceImpl) assembleChunk(dest string, baseurl string) (*http.Response, error) {
+// assembleChunk returns the Nextcloud id of the assembled file. If-Match of a MOVE applies to the upload folder,
+// so an overwritten destination gets the etag in a tagged If header, like If-Match of a single PUT, and is checked
+// before the MOVE too. A new destination is protected by Overwrite: F.
+func (f *FileChunkServiceImpl) assembleChunk(dest string, baseurl string, totalLength int64, condition UploadCondition) (string, error) {
 	url := fmt.Sprintf("%s/.file", baseurl)
 	req, _ := http.NewRequest("MOVE", url, nil)
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", f.Token))
 	req.Header.Set("Destination", dest)
+	req.Header.Set(totalLengthHeader, strconv.FormatInt(totalLength, 10))
+	req.Header.Set("Overwrite", "F")
+	if len(condition.Etag) != 0 {
+		if err := f.checkEtag(dest, condition.Etag); err != nil {
+			return "", err
+		}
+		req.Header.Set("Overwrite", "T")
+		req.Header.Set("If", fmt.Sprintf("<%s> ([%s])", dest, condition.Etag))
+	}
 
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
-
-	client := retryClient.StandardClient()
-	resp, err := client.Do(req)
-
-	defer resp.Body.Close()
-
+	resp, err := f.Client.Do(req, http.StatusNoContent, http.StatusCreated)
 	if err != nil {
 		log.Errorf("Error during assembling chunks. Error: %s", err)
-		return nil, err
+		return "", err
 	}
+	resp.Body.Close()
 
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusCreated {
-		log.Errorf("request failed with status %s", resp.Status)
-		error := fmt.Errorf("request failed with code %d", resp.StatusCode)
-		return nil, error
-	}
+	return resp.Header.Get(fileIdHeader), nil
+}
 
-	return resp, err
+func (f *FileChunkServiceImpl) checkEtag(dest string, etag string) error {
+	etags, err := listEtags(f.Client, dest, "0")
+	if err != nil {
+		return err
+	}
+	for _, actual := range etags {
+		if actual != etag {
+			return &nextcloud.RequestError{Method: "MOVE", Url: dest, StatusCode: http.StatusPreconditionFailed, Err: nextcloud.ErrPreconditionFailed}
+		}
+	}
+	return nil
 }
 
-func (f *FileChunkServiceImpl) abortChunkUpload(url string) (*http.Response, error) {
+func (f *FileChunkServiceImpl) abortChunkUpload(url string) error {
 	req, _ := http.NewRequest("DELETE", url, nil)
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", f.Token))
-
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
-
-	client := retryClient.StandardClient()
-	resp, err := client.Do(req)
-
-	defer resp.Body.Close()
 
+	resp, err := f.Client.Do(req, http.StatusNoContent, http.StatusCreated)
 	if err != nil {
 		log.Errorf("Error during aborting of chunk uploading. Error: %s", err)
-		return nil, err
+		return err
 	}
+	resp.Body.Close()
 
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusCreated {
-		log.Errorf("request failed with status %s", resp.Status)
-		error := fmt.Errorf("request failed with code %d", resp.StatusCode)
-		return nil, error
-	}
+	return nil
+}
 
-	return resp, err
+// ChunkUpload describes one file which is uploaded by chunks.
+type ChunkUpload struct {
+	FileInfo *model.FileInfo
+	// UploadsUrl is the chunking root of the user, e.g. https://cloud/remote.php/dav/uploads/user.
+	UploadsUrl  string
+	Destination string
+	MMFileUrl   string
+	StateKey    string
+	Condition   UploadCondition
+	// Progress is called after every confirmed chunk, it may be nil.
+	Progress func(uploaded int, total int)
 }
 
 type ChunkFileUploadService interface {
-	uploadChunks(chunkFileSizeInBytes int64, fileInfo *model.FileInfo, url string, mmfileUrl string) bool
-	getFileChunkService() FileChunkService
-	getMMFileService() MMFileService
+	uploadFile(upload ChunkUpload) (string, error)
 }
 
+// ChunkFileUploadServiceImpl uploads chunks by a bounded pool of workers. Every confirmed chunk is saved
+// in the upload state, so an upload which failed is resumed from the confirmed chunks the next time.
 type ChunkFileUploadServiceImpl struct {
 	fileChunkService FileChunkService
 	MMFileService    MMFileService
+	stateStore       ChunkUploadStateStore
+	chunkSize        int64
+	workers          int
+	retries          int
 }
 
-func (s ChunkFileUploadServiceImpl) getFileChunkService() FileChunkService {
-	return s.fileChunkService
+// uploadFile returns the Nextcloud id of the uploaded file.
+func (s ChunkFileUploadServiceImpl) uploadFile(upload ChunkUpload) (string, error) {
+	state, uploaded := s.resumeUpload(upload)
+	uploadUrl := fmt.Sprintf("%s/%s", upload.UploadsUrl, state.UploadId)
+
+	if uploaded == nil {
+		if err := s.fileChunkService.createChunkFolder(uploadUrl, upload.Destination, upload.FileInfo.Size); err != nil {
+			return "", errors.Wrap(err, "chunk folder was not created")
+		}
+		uploaded = map[int]bool{}
+	}
+	state.Uploaded = make([]int, 0)
+	for number := range uploaded {
+		state.Uploaded = append(state.Uploaded, number)
+	}
+	s.saveState(upload.StateKey, state)
+
+	if err := s.uploadChunks(upload, state, uploaded, uploadUrl); err != nil {
+		log.Errorf("Upload of file %s is stopped, %d chunks are kept to resume it. Error: %s", upload.FileInfo.Name, len(state.Uploaded), err)
+		return "", err
+	}
+
+	ncFileId, err := s.fileChunkService.assembleChunk(upload.Destination, uploadUrl, upload.FileInfo.Size, upload.Condition)
+	if errors.Is(err, nextcloud.ErrPreconditionFailed) {
+		// The chunks are bound to the destination, so they cannot be reused for another name.
+		s.fileChunkService.abortChunkUpload(uploadUrl)
+		s.stateStore.DeleteState(upload.StateKey)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "chunks were not assembled")
+	}
+	if err := s.stateStore.DeleteState(upload.StateKey); err != nil {
+		log.Warnf("Upload state of file %s was not removed. Error: %s", upload.FileInfo.Name, err)
+	}
+	return ncFileId, nil
 }
 
-func (s ChunkFileUploadServiceImpl) getMMFileService() MMFileService {
-	return s.MMFileService
+// resumeUpload returns the saved state and the chunks which are both confirmed in the state and present in Nextcloud.
+// Nil chunks mean that a new upload folder has to be created.
+func (s ChunkFileUploadServiceImpl) resumeUpload(upload ChunkUpload) (*ChunkUploadState, map[int]bool) {
+	state := s.stateStore.GetState(upload.StateKey)
+	if state != nil && state.Size == upload.FileInfo.Size && state.ChunkSize == s.chunkSize && state.Destination == upload.Destination {
+		uploadUrl := fmt.Sprintf("%s/%s", upload.UploadsUrl, state.UploadId)
+		stored, err := s.fileChunkService.listUploadedChunks(uploadUrl)
+		if err == nil {
+			confirmed := map[int]bool{}
+			for _, number := range state.Uploaded {
+				if stored[number] {
+					confirmed[number] = true
+				}
+			}
+			log.Infof("Resuming upload of file %s from %d confirmed chunks", upload.FileInfo.Name, len(confirmed))
+			return state, confirmed
+		}
+		log.Warnf("Upload of file %s cannot be resumed, starting it again. Error: %s", upload.FileInfo.Name, err)
+	} else if state != nil {
+		s.fileChunkService.abortChunkUpload(fmt.Sprintf("%s/%s", upload.UploadsUrl, state.UploadId))
+	}
+
+	return &ChunkUploadState{
+		UploadId:    "mattermost-" + uuid.New().String(),
+		Destination: upload.Destination,
+		Size:        upload.FileInfo.Size,
+		ChunkSize:   s.chunkSize,
+	}, nil
 }
 
-func (s ChunkFileUploadServiceImpl) uploadChunks(chunkFileSizeInBytes int64, fileInfo *model.FileInfo, url string, mmfileUrl string) bool {
-	var low int64
-	var high int64
-	for low = 0; low < fileInfo.Size; low += chunkFileSizeInBytes + 1 {
-		log.Debugf("Uploaded %d bytes from %d for file %s", low, chunkFileSizeInBytes, fileInfo.Name)
-		high = chunkFileSizeInBytes + low
-		chunkUploaded := s.uploadChunk(low, high, url, mmfileUrl)
-		if !chunkUploaded {
-			log.Errorf("Uploaded %d bytes from %d for file %s failed", low, chunkFileSizeInBytes, fileInfo.Name)
-			return false
+func (s ChunkFileUploadServiceImpl) uploadChunks(upload ChunkUpload, state *ChunkUploadState, uploaded map[int]bool, uploadUrl string) error {
+	chunks := int((upload.FileInfo.Size + s.chunkSize - 1) / s.chunkSize)
+	pending := make(chan int)
+	var mu sync.Mutex
+	var uploadErr error
+	var wg sync.WaitGroup
+
+	workers := s.workers
+	if workers <= 0 {
+		workers = defaultChunkUploadWorkers
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range pending {
+				err := s.uploadChunk(upload, number, uploadUrl)
+
+				mu.Lock()
+				if err != nil && uploadErr == nil {
+					uploadErr = errors.Wrapf(err, "chunk %d was not uploaded", number)
+				}
+				if err == nil {
+					state.Uploaded = append(state.Uploaded, number)
+					s.saveState(upload.StateKey, state)
+					if upload.Progress != nil {
+						upload.Progress(len(state.Uploaded), chunks)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for number := 1; number <= chunks; number++ {
+		if uploaded[number] {
+			continue
+		}
+		mu.Lock()
+		failed := uploadErr != nil
+		mu.Unlock()
+		if failed {
+			break
 		}
+		pending <- number
 	}
-	log.Debug("Finished uploading")
-	return true
+	close(pending)
+	wg.Wait()
+
+	log.Debugf("Finished uploading of %d chunks for file %s", chunks, upload.FileInfo.Name)
+	return uploadErr
 }
 
-func (s ChunkFileUploadServiceImpl) uploadChunk(low int64, high int64, url string, mmfileUrl string) bool {
-	chunk, err := s.MMFileService.GetChunkedFile(mmfileUrl, fmt.Sprint(low), fmt.Sprint(high))
+// uploadChunk streams the range from Mattermost to Nextcloud. A streamed body cannot be replayed,
+// so a failed chunk is retried here by requesting the range again.
+func (s ChunkFileUploadServiceImpl) uploadChunk(upload ChunkUpload, number int, uploadUrl string) error {
+	low := int64(number-1) * s.chunkSize
+	high := low + s.chunkSize - 1
+	if high >= upload.FileInfo.Size {
+		high = upload.FileInfo.Size - 1
+	}
 
-	if err != nil {
-		log.Errorf("Chunk was not downloaded from MM %s", err.Error())
-		s.fileChunkService.abortChunkUpload(url)
-		return false
+	var err error
+	for attempt := 0; attempt <= s.retries; attempt++ {
+		if attempt != 0 {
+			time.Sleep(time.Duration(attempt) * chunkRetryDelay)
+			log.Infof("Retrying chunk %d of file %s, attempt %d", number, upload.FileInfo.Name, attempt+1)
+		}
+
+		var chunk io.ReadCloser
+		chunk, err = s.MMFileService.GetFileRange(upload.MMFileUrl, low, high)
+		if err != nil {
+			log.Errorf("Chunk %d was not downloaded from MM %s", number, err.Error())
+			continue
+		}
+		err = s.fileChunkService.uploadFileChunk(chunk, high-low+1, number, uploadUrl, upload.Destination)
+		chunk.Close()
+		if err == nil {
+			return nil
+		}
+		log.Errorf("Chunk %d was not uploaded to NC %s", number, err.Error())
 	}
+	return err
+}
 
-	_, uploadError := s.fileChunkService.uploadFileChunk(chunk, fmt.Sprintf("%016d", low), fmt.Sprintf("%016d", high), url)
-	if uploadError != nil {
-		s.fileChunkService.abortChunkUpload(url)
-		log.Errorf("Chunk was not uploaded to NC %s", uploadError.Error())
-		return false
+func (s ChunkFileUploadServiceImpl) saveState(key string, state *ChunkUploadState) {
+	if err := s.stateStore.SaveState(key, *state); err != nil {
+		log.Warnf("Upload state %s was not saved, the upload cannot be resumed. Error: %s", key, err)
 	}
-	return true
 }
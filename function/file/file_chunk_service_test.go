@@ -1,115 +1,328 @@
 package file
 
 import (
+	"bytes"
 	"errors"
-	"github.com/mattermost/mattermost-server/v6/model"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
 )
 
-type MMFileServiceMock struct {
-	getChunkedFileError error
+// fakeDavServer serves a Mattermost file by ranges and keeps Nextcloud chunking v2 upload folders in memory.
+type fakeDavServer struct {
+	mu        sync.Mutex
+	content   []byte
+	folders   map[string]map[int][]byte
+	assembled map[string][]byte
+	puts      map[int]int
+	failures  map[int]int
+	mkcols    int
+	deletes   int
 }
 
-func (s MMFileServiceMock) GetChunkedFile(path string, from string, to string) (chunk []byte, err error) {
-
-	return nil, s.getChunkedFileError
+func newFakeDavServer(content []byte) *fakeDavServer {
+	return &fakeDavServer{
+		content:   content,
+		folders:   map[string]map[int][]byte{},
+		assembled: map[string][]byte{},
+		puts:      map[int]int{},
+		failures:  map[int]int{},
+	}
 }
 
-type FileChunkServiceMock struct {
-	createChunkFolderError error
-	uploadFileChunkError   error
-	assembleFileChunkError error
-	abortChunkUploadError  error
+func (f *fakeDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/mm/file" {
+		var from, to int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &from, &to)
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(f.content[from : to+1])
+		return
+	}
+
+	if file, ok := f.assembled["http://"+r.Host+r.URL.Path]; ok && r.Method == "PROPFIND" {
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, "<d:multistatus xmlns:d=\"DAV:\"><d:response><d:href>%s</d:href><d:propstat><d:prop><d:getetag>\"%d\"</d:getetag></d:prop></d:propstat></d:response></d:multistatus>", r.URL.Path, len(file))
+		return
+	}
+
+	folder, name := r.URL.Path, ""
+	if i := strings.LastIndex(r.URL.Path, "/"); strings.Count(r.URL.Path, "/") > 5 {
+		folder, name = r.URL.Path[:i], r.URL.Path[i+1:]
+	}
+	if r.Method != "MKCOL" && r.Method != "DELETE" && f.folders[folder] == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "MKCOL":
+		f.mkcols++
+		f.folders[folder] = map[int][]byte{}
+		w.WriteHeader(http.StatusCreated)
+	case "PROPFIND":
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, "<d:multistatus xmlns:d=\"DAV:\"><d:response><d:href>%s/</d:href></d:response>", folder)
+		for number := range f.folders[folder] {
+			fmt.Fprintf(w, "<d:response><d:href>%s/%d</d:href></d:response>", folder, number)
+		}
+		fmt.Fprint(w, "</d:multistatus>")
+	case "PUT":
+		number, _ := strconv.Atoi(name)
+		body, _ := io.ReadAll(r.Body)
+		f.puts[number]++
+		if f.failures[number] > 0 || len(r.Header.Get("Destination")) == 0 {
+			f.failures[number]--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		f.folders[folder][number] = body
+		w.WriteHeader(http.StatusCreated)
+	case "MOVE":
+		numbers := make([]int, 0)
+		for number := range f.folders[folder] {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		file := make([]byte, 0)
+		for _, number := range numbers {
+			file = append(file, f.folders[folder][number]...)
+		}
+		if r.Header.Get(totalLengthHeader) != strconv.Itoa(len(file)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		existing, exists := f.assembled[r.Header.Get("Destination")]
+		if exists && r.Header.Get("Overwrite") == "F" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		if condition := r.Header.Get("If"); len(condition) != 0 && condition != fmt.Sprintf("<%s> ([\"%d\"])", r.Header.Get("Destination"), len(existing)) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		f.assembled[r.Header.Get("Destination")] = file
+		delete(f.folders, folder)
+		w.Header().Set(fileIdHeader, "00000042ocinstance")
+		w.WriteHeader(http.StatusCreated)
+	case "DELETE":
+		f.deletes++
+		delete(f.folders, folder)
+		w.WriteHeader(http.StatusNoContent)
+	}
 }
 
-func (f FileChunkServiceMock) createChunkFolder(url string) (*http.Response, error) {
+type ChunkUploadStateStoreMock struct {
+	mu     sync.Mutex
+	states map[string]ChunkUploadState
+}
 
-	return nil, f.createChunkFolderError
+func (s *ChunkUploadStateStoreMock) GetState(key string) *ChunkUploadState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.states[key]
+	if !ok {
+		return nil
+	}
+	return &state
 }
 
-func (f FileChunkServiceMock) uploadFileChunk(file []byte, start string, end string, baseurl string) (*http.Response, error) {
-	return nil, f.uploadFileChunkError
+func (s *ChunkUploadStateStoreMock) SaveState(key string, state ChunkUploadState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state.Uploaded = append([]int{}, state.Uploaded...)
+	s.states[key] = state
+	return nil
 }
 
-func (f FileChunkServiceMock) assembleChunk(dest string, baseurl string) (*http.Response, error) {
+func (s *ChunkUploadStateStoreMock) DeleteState(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, key)
+	return nil
+}
 
-	return nil, f.assembleFileChunkError
+func createChunkUploadTest(content []byte, workers int, retries int) (*fakeDavServer, *httptest.Server, *ChunkUploadStateStoreMock, ChunkFileUploadServiceImpl, ChunkUpload) {
+	dav := newFakeDavServer(content)
+	server := httptest.NewServer(dav)
+	store := &ChunkUploadStateStoreMock{states: map[string]ChunkUploadState{}}
+	testedInstance := ChunkFileUploadServiceImpl{
+		fileChunkService: &FileChunkServiceImpl{Client: nextcloud.Client{}},
+		MMFileService:    MMFileServiceImpl{client: nextcloud.Client{}},
+		stateStore:       store,
+		chunkSize:        4,
+		workers:          workers,
+		retries:          retries,
+	}
+	upload := ChunkUpload{
+		FileInfo:    &model.FileInfo{Name: "video.mp4", Size: int64(len(content))},
+		UploadsUrl:  server.URL + "/remote.php/dav/uploads/user",
+		Destination: server.URL + "/remote.php/dav/files/user/video.mp4",
+		MMFileUrl:   server.URL + "/mm/file",
+		StateKey:    "key",
+	}
+	return dav, server, store, testedInstance, upload
 }
 
-func (f FileChunkServiceMock) abortChunkUpload(url string) (*http.Response, error) {
+func TestChunksAreUploadedInParallelAndAssembled(t *testing.T) {
+	content := []byte("0123456789abcdefghijklmnopqrstuvw")
+	dav, server, store, testedInstance, upload := createChunkUploadTest(content, 3, 0)
+	defer server.Close()
+
+	_, err := testedInstance.uploadFile(upload)
 
-	return nil, f.abortChunkUploadError
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if !bytes.Equal(dav.assembled[upload.Destination], content) {
+		t.Errorf(" expected %q, actual %q", content, dav.assembled[upload.Destination])
+	}
+	if len(dav.puts) != 9 {
+		t.Errorf("expected 9 chunks, actual %d", len(dav.puts))
+	}
+	if store.GetState(upload.StateKey) != nil {
+		t.Error("Upload state should be removed after the file is assembled")
+	}
 }
 
-func TestChunksUpload(t *testing.T) {
-	fileService := MMFileServiceMock{}
-	fileChunkService := FileChunkServiceMock{}
-	testedInstance := ChunkFileUploadServiceImpl{MMFileService: fileService, fileChunkService: fileChunkService}
+func TestFailedUploadIsResumedFromConfirmedChunks(t *testing.T) {
+	content := []byte("0123456789abcdefghijklmnopqrstuvw")
+	dav, server, store, testedInstance, upload := createChunkUploadTest(content, 1, 0)
+	defer server.Close()
+	dav.failures[3] = 1
 
-	var fileSize int64 = 10
-	var chunkFileSize int64 = 1
-	fileInfo := &model.FileInfo{Size: fileSize}
-	url := "test_url"
-	mmFileUrl := "mm_file_url"
+	_, err := testedInstance.uploadFile(upload)
 
-	uploaded := testedInstance.uploadChunks(chunkFileSize, fileInfo, url, mmFileUrl)
+	if err == nil {
+		t.Fatal("Upload should fail on the broken chunk")
+	}
+	state := store.GetState(upload.StateKey)
+	if state == nil || len(state.Uploaded) < 2 || len(state.Uploaded) == 9 {
+		t.Fatalf("Confirmed chunks should be kept, actual %v", state)
+	}
+	for _, number := range state.Uploaded {
+		if number == 3 {
+			t.Error("Failed chunk should not be confirmed")
+		}
+	}
 
-	if !uploaded {
-		t.Error("Chunks should upload")
+	_, err = testedInstance.uploadFile(upload)
+
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if !bytes.Equal(dav.assembled[upload.Destination], content) {
+		t.Errorf(" expected %q, actual %q", content, dav.assembled[upload.Destination])
+	}
+	if dav.mkcols != 1 || dav.puts[1] != 1 || dav.puts[2] != 1 || dav.puts[3] != 2 {
+		t.Errorf("Confirmed chunks should not be uploaded again, mkcols %d, puts %v", dav.mkcols, dav.puts)
 	}
 }
 
-func TestMMFileNotFoundDuringChunksUpload(t *testing.T) {
-	fileService := MMFileServiceMock{getChunkedFileError: errors.New("error")}
-	fileChunkService := FileChunkServiceMock{}
-	testedInstance := ChunkFileUploadServiceImpl{MMFileService: fileService, fileChunkService: fileChunkService}
+func TestFailedChunkIsRetried(t *testing.T) {
+	content := []byte("0123456789")
+	dav, server, _, testedInstance, upload := createChunkUploadTest(content, 2, 1)
+	defer server.Close()
+	dav.failures[2] = 1
 
-	var fileSize int64 = 10
-	var chunkFileSize int64 = 1
-	fileInfo := &model.FileInfo{Size: fileSize}
-	url := "test_url"
-	mmFileUrl := "mm_file_url"
+	_, err := testedInstance.uploadFile(upload)
 
-	uploaded := testedInstance.uploadChunks(chunkFileSize, fileInfo, url, mmFileUrl)
-
-	if uploaded {
-		t.Error("File couldn't upload cause of get chunked file error")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if dav.puts[2] != 2 {
+		t.Errorf("expected 2 uploads of the chunk, actual %d", dav.puts[2])
 	}
 }
 
-func TestFileChunkNotUpload(t *testing.T) {
-	fileService := MMFileServiceMock{getChunkedFileError: errors.New("error")}
-	fileChunkService := FileChunkServiceMock{}
-	testedInstance := ChunkFileUploadServiceImpl{MMFileService: fileService, fileChunkService: fileChunkService}
+func TestUploadWithAnotherChunkSizeStartsAgain(t *testing.T) {
+	content := []byte("0123456789")
+	dav, server, store, testedInstance, upload := createChunkUploadTest(content, 2, 0)
+	defer server.Close()
+	store.SaveState(upload.StateKey, ChunkUploadState{UploadId: "old", Destination: upload.Destination, Size: 10, ChunkSize: 5, Uploaded: []int{1}})
+
+	_, err := testedInstance.uploadFile(upload)
 
-	var fileSize int64 = 10
-	var chunkFileSize int64 = 1
-	fileInfo := &model.FileInfo{Size: fileSize}
-	url := "test_url"
-	mmFileUrl := "mm_file_url"
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if dav.deletes != 1 || dav.mkcols != 1 || len(dav.puts) != 3 {
+		t.Errorf("Old upload should be aborted and a new one created, deletes %d, mkcols %d, puts %v", dav.deletes, dav.mkcols, dav.puts)
+	}
+}
 
-	uploaded := testedInstance.uploadChunks(chunkFileSize, fileInfo, url, mmFileUrl)
+func TestAssembledFileDoesNotReplaceCreatedFile(t *testing.T) {
+	content := []byte("0123456789")
+	dav, server, store, testedInstance, upload := createChunkUploadTest(content, 2, 0)
+	defer server.Close()
+	dav.assembled[upload.Destination] = []byte("created meanwhile")
 
-	if uploaded {
-		t.Error("File couldn't upload cause of get chunked file error")
+	_, err := testedInstance.uploadFile(upload)
+
+	if !errors.Is(err, nextcloud.ErrPreconditionFailed) {
+		t.Fatalf("Precondition error expected, actual %v", err)
+	}
+	if string(dav.assembled[upload.Destination]) != "created meanwhile" {
+		t.Error("Created file should not be replaced")
+	}
+	if dav.deletes != 1 || store.GetState(upload.StateKey) != nil {
+		t.Errorf("Upload should be aborted, deletes %d", dav.deletes)
 	}
 }
 
-func TestUploadFailedDuringChunkUpload(t *testing.T) {
-	fileService := MMFileServiceMock{}
-	fileChunkService := FileChunkServiceMock{uploadFileChunkError: errors.New("error")}
-	testedInstance := ChunkFileUploadServiceImpl{MMFileService: fileService, fileChunkService: fileChunkService}
+func TestAssembledFileOverwritesOnlyExpectedVersion(t *testing.T) {
+	content := []byte("0123456789")
+	dav, server, _, testedInstance, upload := createChunkUploadTest(content, 2, 0)
+	defer server.Close()
+	dav.assembled[upload.Destination] = []byte("old")
+	upload.Condition = UploadCondition{Etag: `"5"`}
 
-	var fileSize int64 = 10
-	var chunkFileSize int64 = 1
-	fileInfo := &model.FileInfo{Size: fileSize}
-	url := "test_url"
-	mmFileUrl := "mm_file_url"
+	_, err := testedInstance.uploadFile(upload)
 
-	uploaded := testedInstance.uploadChunks(chunkFileSize, fileInfo, url, mmFileUrl)
+	if !errors.Is(err, nextcloud.ErrPreconditionFailed) {
+		t.Fatalf("Precondition error expected, actual %v", err)
+	}
+
+	upload.Condition = UploadCondition{Etag: `"3"`}
+	_, err = testedInstance.uploadFile(upload)
 
-	if uploaded {
-		t.Error("File couldn't upload cause of  chunk upload failed")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if !bytes.Equal(dav.assembled[upload.Destination], content) {
+		t.Errorf(" expected %q, actual %q", content, dav.assembled[upload.Destination])
+	}
+}
+
+func TestAssembleSendsEtagOfOverwrittenFile(t *testing.T) {
+	var condition string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PROPFIND" {
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:"><d:response><d:href>/file.txt</d:href><d:propstat><d:prop><d:getetag>"3"</d:getetag></d:prop></d:propstat></d:response></d:multistatus>`)
+			return
+		}
+		condition = r.Header.Get("If")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	testedInstance := FileChunkServiceImpl{Client: nextcloud.Client{}}
+
+	if _, err := testedInstance.assembleChunk(server.URL+"/file.txt", server.URL+"/uploads/1", 10, UploadCondition{Etag: `"3"`}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if expected := "<" + server.URL + `/file.txt> (["3"])`; condition != expected {
+		t.Errorf(" expected %q, actual %q", expected, condition)
 	}
 }
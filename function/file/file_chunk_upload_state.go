@@ -0,0 +1,56 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const ChunkUploadKvKey = "chunk-upload-"
+
+// ChunkUploadState is kept until the chunks are assembled, Uploaded holds the numbers of the confirmed chunks.
+type ChunkUploadState struct {
+	UploadId    string `json:"upload_id"`
+	Destination string `json:"destination"`
+	Size        int64  `json:"size"`
+	ChunkSize   int64  `json:"chunk_size"`
+	Uploaded    []int  `json:"uploaded"`
+}
+
+type UploadStateKVClient interface {
+	KVGet(prefix, id string, ref interface{}) error
+	KVSet(prefix, id string, in interface{}) (bool, error)
+	KVDelete(prefix, id string) error
+}
+
+type ChunkUploadStateStore interface {
+	GetState(key string) *ChunkUploadState
+	SaveState(key string, state ChunkUploadState) error
+	DeleteState(key string) error
+}
+
+type KVChunkUploadStateStore struct {
+	AsBot UploadStateKVClient
+}
+
+func (s KVChunkUploadStateStore) GetState(key string) *ChunkUploadState {
+	state := ChunkUploadState{}
+	if err := s.AsBot.KVGet("", ChunkUploadKvKey+key, &state); err != nil || len(state.UploadId) == 0 {
+		return nil
+	}
+	return &state
+}
+
+func (s KVChunkUploadStateStore) SaveState(key string, state ChunkUploadState) error {
+	_, err := s.AsBot.KVSet("", ChunkUploadKvKey+key, state)
+	return err
+}
+
+func (s KVChunkUploadStateStore) DeleteState(key string) error {
+	return s.AsBot.KVDelete("", ChunkUploadKvKey+key)
+}
+
+// CreateChunkUploadStateKey returns the same key when the same Mattermost file is uploaded again to the same destination.
+func CreateChunkUploadStateKey(fileId string, destination string) string {
+	hash := sha256.Sum256([]byte(fileId + "|" + destination))
+	return hex.EncodeToString(hash[:])[:16]
+}
@@ -1,18 +1,30 @@
 package file
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/mattermost/mattermost-plugin-apps/apps"
 	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/calendar"
 	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/settings"
 	"github.com/prokhorind/nextcloud/function/user"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	channelMembersPerPage = 200
+	// moreResultsValue is the value of the lookup option which only tells that there are more results
+	moreResultsValue = "more-results"
 )
 
 func FileUploadForm(c *gin.Context) {
@@ -24,30 +36,23 @@ func FileUploadForm(c *gin.Context) {
 		return
 	}
 
-	oauthService := oauth.OauthServiceImpl{creq}
-	token, refreshErr := oauthService.RefreshToken()
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
 
-	if refreshErr != nil {
-		c.JSON(http.StatusOK, apps.NewErrorResponse(refreshErr))
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
 
 	asActingUser := appclient.AsActingUser(creq.Context)
-	asActingUser.StoreOAuth2User(*token)
 
 	userId := creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string)
 
 	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
 	url := fmt.Sprintf("%s%s", remoteUrl, "/remote.php/dav/")
 
-	body := createSearchRequestBody(userId, "")
-	fileSearchRequestService := FileSearchServiceRequestServiceImpl{url: url, accessToken: token.AccessToken}
-	resp, err := fileSearchRequestService.sendFileSearchRequest(body)
-	if err != nil {
-		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Request failed during file search")))
-	}
-	searchService := SearchSelectOptionsImpl{}
-	folderSelectOptions, rootSelectOption := searchService.CreateFolderSelectOptions(*resp, userId, "Root", "/", false)
+	client := settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, token.AccessToken)
+	rootSelectOption := apps.SelectOption{Label: "Root", Value: "/"}
 
 	fileSelectOptions := make([]apps.SelectOption, 0)
 	fileInfos, _, _ := asActingUser.GetFileInfosForPost(creq.Context.Post.Id, "")
@@ -57,39 +62,196 @@ func FileUploadForm(c *gin.Context) {
 		fileSelectOptions = append(fileSelectOptions, option)
 	}
 
-	sort.Slice(folderSelectOptions, func(i, j int) bool {
-		return folderSelectOptions[i].Label < folderSelectOptions[j].Label
-	})
-
 	sort.Slice(fileSelectOptions, func(i, j int) bool {
 		return fileSelectOptions[i].Label < fileSelectOptions[j].Label
 	})
 
+	var folderOption interface{} = rootSelectOption
+	folder := rootSelectOption.Value
+	channelFolders := KVChannelFolderStore{AsBot: appclient.AsBot(creq.Context)}
+	browseService := FileBrowseServiceImpl{Url: url, UserId: userId, Client: client}
+	if linked := findLinkedFolderOption(browseService, channelFolders.GetFolder(creq.Context.Post.ChannelId)); linked != nil {
+		folderOption = *linked
+		folder = linked.Value
+	}
+	if selected := getSelectedOption(creq.Values, "Folder"); selected != nil && getSelectedValue(creq.Values, "Folder") != moreResultsValue {
+		folderOption = selected
+		folder = getSelectedValue(creq.Values, "Folder")
+	}
+	selectedFiles := fileSelectOptions
+	if _, ok := creq.Values["Files"]; ok {
+		selectedFiles = getSelectedOptions(creq.Values, "Files")
+	}
+
+	fields := []apps.Field{
+		{
+			Type:          "dynamic_select",
+			Name:          "Folder",
+			Label:         "Folder",
+			Description:   "Type a part of the folder name to search it",
+			IsRequired:    true,
+			SelectRefresh: true,
+			Value:         folderOption,
+			SelectDynamicLookup: apps.NewCall("/file/upload/folder/lookup").WithExpand(apps.Expand{
+				ActingUserAccessToken: apps.ExpandAll,
+				OAuth2App:             apps.ExpandAll,
+				OAuth2User:            apps.ExpandAll,
+				ActingUser:            apps.ExpandAll,
+			}),
+		},
+
+		{
+			Type:        "text",
+			Name:        newFolderField,
+			Label:       "New subfolder",
+			Description: "Optional path like Reports/2024, it is created in the chosen folder and the files are uploaded there",
+			Value:       creq.Values[newFolderField],
+		},
+
+		{
+			Type:                "static_select",
+			Name:                "Files",
+			Label:               "Files",
+			IsRequired:          true,
+			SelectIsMulti:       true,
+			SelectRefresh:       true,
+			SelectStaticOptions: fileSelectOptions,
+			Value:               selectedFiles,
+		},
+	}
+
+	etags := map[string]string{}
+	folderFilesService := FolderFilesServiceImpl{Client: client}
+	existing, err := folderFilesService.ListFileEtags(createDavFolderUrl(remoteUrl, userId, folder))
+	if err != nil {
+		log.Warnf("Files of folder %s were not listed, conflicts are not shown. Error: %s", folder, err)
+	} else {
+		var conflictFields []apps.Field
+		conflictFields, etags = createConflictFields(creq.Values, selectedFiles, existing)
+		fields = append(fields, conflictFields...)
+	}
+
+	expand := apps.Expand{
+		ActingUserAccessToken: apps.ExpandAll,
+		OAuth2App:             apps.ExpandAll,
+		OAuth2User:            apps.ExpandAll,
+		ActingUser:            apps.ExpandAll,
+	}
+	sourceExpand, submitExpand := expand, expand
+	sourceExpand.Post = apps.ExpandAll
+	submitExpand.Channel = apps.ExpandAll
+
 	form := &apps.Form{
-		Title: "Upload to Nextcloud ",
+		Title:  "Upload to Nextcloud ",
+		Icon:   "icon.png",
+		Fields: fields,
+		Source: apps.NewCall("/file-upload-form").WithExpand(sourceExpand),
+		Submit: apps.NewCall("/file-upload").WithExpand(submitExpand).WithState(uploadConflictState{Etags: etags}),
+	}
+
+	c.JSON(http.StatusOK, apps.NewFormResponse(*form))
+}
+
+func FileShareForm(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	folderName := getSelectedValue(creq.Values, "Folder")
+	browseService := createFileBrowseService(c, creq, token.AccessToken)
+	subfolderOptions, err := browseService.ListSubfolders(folderName)
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Request failed during folder search")))
+		return
+	}
+
+	folderSelectOptions, currentFolderOption := createBrowseFolderOptions(folderName, subfolderOptions)
+
+	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
+	capabilitiesService := ShareCapabilitiesServiceImpl{Url: remoteUrl + "/ocs/v2.php/cloud/capabilities?format=json", Client: browseService.Client}
+	capabilities, err := capabilitiesService.GetShareCapabilities()
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Request failed during getting of share settings")))
+		return
+	}
+	shareWithOptions := ShareWithOptions(capabilities.Public.Enabled)
+	shareWithOption := shareWithOptions[0]
+	if selected, ok := getSelectedOption(creq.Values, "ShareWith").(apps.SelectOption); ok {
+		shareWithOption = selected
+	}
+
+	form := &apps.Form{
+		Title: "File share ",
 		Icon:  "icon.png",
 		Fields: []apps.Field{
-
 			{
 				Type:                "static_select",
 				Name:                "Folder",
 				Label:               "Folder",
+				Description:         "Choose a subfolder to open it, or .. to go up",
 				IsRequired:          true,
+				SelectRefresh:       true,
 				SelectStaticOptions: folderSelectOptions,
-				Value:               rootSelectOption,
+				Value:               currentFolderOption,
+			},
+			{
+				Type:        "text",
+				Name:        newFolderField,
+				Label:       "New subfolder",
+				Description: "Optional path like Reports/2024, it is created in the chosen folder and shared",
+				Value:       creq.Values[newFolderField],
 			},
-
 			{
 				Type:                "static_select",
-				Name:                "Files",
-				Label:               "Files",
-				IsRequired:          true,
-				SelectIsMulti:       true,
-				SelectStaticOptions: fileSelectOptions,
-				Value:               fileSelectOptions,
+				Name:                "Type",
+				Label:               "Type",
+				SelectStaticOptions: FileTypeOptions,
+				Value:               getSelectedOption(creq.Values, "Type"),
+			},
+			{
+				Type:                "static_select",
+				Name:                "Modified",
+				Label:               "Modified",
+				SelectStaticOptions: ModifiedOptions,
+				Value:               getSelectedOption(creq.Values, "Modified"),
+			},
+			{
+				Type:        "text",
+				TextSubtype: apps.TextFieldSubtypeNumber,
+				Name:        "Page",
+				Label:       "Page",
+				Description: fmt.Sprintf("Files are searched by name, %d per page", FileSearchLimit),
+				Value:       getPage(creq.Values),
+			},
+			{
+				Type:          "dynamic_select",
+				Name:          "Files",
+				Label:         "Files",
+				Description:   "Type a part of the file name to search in the folder and its subfolders",
+				SelectIsMulti: true,
+				Value:         getSelectedFiles(creq.Values),
+				SelectDynamicLookup: apps.NewCall("/file/search/lookup").WithExpand(apps.Expand{
+					ActingUserAccessToken: apps.ExpandAll,
+					OAuth2App:             apps.ExpandAll,
+					OAuth2User:            apps.ExpandAll,
+					ActingUser:            apps.ExpandAll,
+				}),
 			},
 		},
-		Submit: apps.NewCall("/file-upload").WithExpand(apps.Expand{
+		Source: apps.NewCall("/file/search/form").WithExpand(apps.Expand{
+			ActingUserAccessToken: apps.ExpandAll,
+			OAuth2App:             apps.ExpandAll,
+			OAuth2User:            apps.ExpandAll,
+			Channel:               apps.ExpandAll,
+			ActingUser:            apps.ExpandAll,
+		}),
+		Submit: apps.NewCall("/file-share").WithExpand(apps.Expand{
 			ActingUserAccessToken: apps.ExpandAll,
 			OAuth2App:             apps.ExpandAll,
 			OAuth2User:            apps.ExpandAll,
@@ -98,188 +260,443 @@ func FileUploadForm(c *gin.Context) {
 		}),
 	}
 
+	now := time.Now().In(getUserLocation(creq))
+	form.Fields = append(form.Fields, createShareWithFields(creq.Values, shareWithOptions, shareWithOption)...)
+	form.Fields = append(form.Fields, createFileShareOptionFields(creq.Values, *capabilities, GetShareType(shareWithOption.Value), now)...)
+	form.Fields = append(form.Fields, apps.Field{
+		Type:        "bool",
+		Name:        attachField,
+		Label:       "Attach copies",
+		Description: "Also post copies of the files to the channel as Mattermost attachments",
+		Value:       creq.Values[attachField],
+	})
+
 	c.JSON(http.StatusOK, apps.NewFormResponse(*form))
 }
 
-func FileShareForm(c *gin.Context) {
+func FileSearchLookup(c *gin.Context) {
 	creq := apps.CallRequest{}
 	json.NewDecoder(c.Request.Body).Decode(&creq)
-	oauthService := oauth.OauthServiceImpl{creq}
-	token, refreshErr := oauthService.RefreshToken()
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
 
-	if refreshErr != nil {
-		c.JSON(http.StatusOK, apps.NewErrorResponse(refreshErr))
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
 
-	asActingUser := appclient.AsActingUser(creq.Context)
-	asActingUser.StoreOAuth2User(*token)
-	accessToken := token.AccessToken
-
-	var folderName string
-	if creq.Values["Folder"] == nil {
-		folderName = ""
-	} else {
-		folderName = creq.Values["Folder"].(map[string]interface{})["value"].(string)
+	query := FileSearchQuery{
+		Folder:   getSelectedValue(creq.Values, "Folder"),
+		Name:     creq.Query,
+		MimeType: getSelectedValue(creq.Values, "Type"),
 	}
-	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
-	userId := creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string)
-	url := fmt.Sprintf("%s%s", remoteUrl, "/remote.php/dav/")
-
-	fileSearchBody := createSearchRequestBody(userId+folderName, "")
-	fileSearchRequestService := FileSearchServiceRequestServiceImpl{url: url, accessToken: accessToken}
-	FileSearchResp, err := fileSearchRequestService.sendFileSearchRequest(fileSearchBody)
+	if modified, err := time.ParseDuration(getSelectedValue(creq.Values, "Modified")); err == nil {
+		query.ModifiedAfter = time.Now().Add(-modified)
+	}
+	query.Page, _ = strconv.Atoi(getPage(creq.Values))
 
+	browseService := createFileBrowseService(c, creq, token.AccessToken)
+	fileSelectOptions, hasMore, err := browseService.SearchFiles(query)
 	if err != nil {
 		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Request failed during file search")))
 		return
 	}
+	if hasMore {
+		_, hasPages := creq.Values["Page"]
+		fileSelectOptions = append(fileSelectOptions, createMoreResultsOption("files", query.Page, hasPages))
+	}
 
-	files := FileSearchResp.FileResponse
+	c.JSON(http.StatusOK, apps.NewLookupResponse(fileSelectOptions))
+}
+
+// FileUploadFolderLookup searches the folders of the upload form page by page, big accounts have too many folders for one list.
+func FileUploadFolderLookup(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
 
-	if len(files) == 0 {
-		c.JSON(http.StatusOK, apps.CallResponse{Type: apps.CallResponseTypeError, Text: "Files not found"})
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
 
-	searchService := SearchSelectOptionsImpl{}
-	fileSelectOptions := searchService.CreateFileSelectOptions(files)
-
-	folderSearchBody := createSearchRequestBody(userId, "")
-	folderSearchResp, folderSearchError := fileSearchRequestService.sendFileSearchRequest(folderSearchBody)
-
-	if folderSearchError != nil {
+	browseService := createFileBrowseService(c, creq, token.AccessToken)
+	folders, hasMore, err := browseService.SearchFiles(FileSearchQuery{Name: creq.Query, Folders: true, Page: 1})
+	if err != nil {
 		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Request failed during folder search")))
 		return
 	}
 
-	folderSelectOptions, defaultSelectOption := searchService.CreateFolderSelectOptions(*folderSearchResp, userId, "Root", "", true)
+	options := []apps.SelectOption{{Label: "Root", Value: "/"}}
+	for _, folder := range folders {
+		options = append(options, apps.SelectOption{Label: folder.Label, Value: folder.Value + "/"})
+	}
+	if hasMore {
+		options = append(options, createMoreResultsOption("folders", 1, false))
+	}
 
-	if creq.Values["Folder"] != nil {
-		for _, so := range folderSelectOptions {
-			if folderName == so.Value {
-				defaultSelectOption = so
-				break
-			}
+	c.JSON(http.StatusOK, apps.NewLookupResponse(options))
+}
+
+// createMoreResultsOption tells that the lookup shows only the first results. Choosing it selects nothing.
+func createMoreResultsOption(items string, page int, hasPages bool) apps.SelectOption {
+	label := fmt.Sprintf("More %s found, type more of the name to narrow the search", items)
+	if hasPages {
+		label = fmt.Sprintf("More %s found, type more of the name or set Page to %d", items, page+1)
+	}
+	return apps.SelectOption{Label: label, Value: moreResultsValue}
+}
+
+// getSelectedFiles returns the chosen files without the option which only tells about more results.
+func getSelectedFiles(values map[string]interface{}) []apps.SelectOption {
+	files := make([]apps.SelectOption, 0)
+	for _, option := range getSelectedOptions(values, "Files") {
+		if option.Value != moreResultsValue {
+			files = append(files, option)
 		}
 	}
+	return files
+}
+
+func createShareWithFields(values map[string]interface{}, shareWithOptions []apps.SelectOption, shareWithOption apps.SelectOption) []apps.Field {
+	fields := []apps.Field{
+		{
+			Type:                "static_select",
+			Name:                "ShareWith",
+			Label:               "Share with",
+			IsRequired:          true,
+			SelectRefresh:       true,
+			SelectStaticOptions: shareWithOptions,
+			Value:               shareWithOption,
+		},
+	}
 
-	if len(fileSelectOptions) == 0 {
-		log.Error("Files do not have display name field")
-		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Files do not have display name. The outdated version of Nextcloud is used. Contact system administrator")))
+	switch shareWithOption.Value {
+	case ShareWithUsers:
+		fields = append(fields, apps.Field{
+			Type:          apps.FieldTypeUser,
+			Name:          "Users",
+			Label:         "Users",
+			Description:   "Only users who have connected their Nextcloud account get access",
+			IsRequired:    true,
+			SelectIsMulti: true,
+			Value:         getSelectedOptions(values, "Users"),
+		})
+	case ShareWithGroup:
+		fields = append(fields, apps.Field{
+			Type:       "dynamic_select",
+			Name:       "Group",
+			Label:      "Group",
+			IsRequired: true,
+			Value:      getSelectedOption(values, "Group"),
+			SelectDynamicLookup: apps.NewCall("/file/share/group/lookup").WithExpand(apps.Expand{
+				ActingUserAccessToken: apps.ExpandAll,
+				OAuth2App:             apps.ExpandAll,
+				OAuth2User:            apps.ExpandAll,
+				ActingUser:            apps.ExpandAll,
+			}),
+		})
+	}
+	return fields
+}
+
+func FileShareGroupLookup(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
 
-	sort.Slice(fileSelectOptions, func(i, j int) bool {
-		return fileSelectOptions[i].Label < fileSelectOptions[j].Label
-	})
+	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
+	shareeService := ShareeServiceImpl{
+		Url:    remoteUrl + "/ocs/v2.php/apps/files_sharing/api/v1/sharees",
+		Client: settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, token.AccessToken),
+	}
+	groupOptions, err := shareeService.SearchGroups(creq.Query)
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Request failed during group search")))
+		return
+	}
 
-	sort.Slice(folderSelectOptions, func(i, j int) bool {
-		return folderSelectOptions[i].Label < folderSelectOptions[j].Label
-	})
+	c.JSON(http.StatusOK, apps.NewLookupResponse(groupOptions))
+}
 
-	preSelectedFilesOptions := make([]apps.SelectOption, 0)
-	if creq.Values["Files"] != nil {
+// getShareRecipients returns the Nextcloud users or the group to share with and the usernames of users
+// without a connected account. A public link has one empty recipient.
+func getShareRecipients(creq apps.CallRequest, shareWith string) ([]string, []string, error) {
+	switch shareWith {
+	case ShareWithGroup:
+		group := getSelectedValue(creq.Values, "Group")
+		if len(group) == 0 {
+			return nil, nil, errors.New("Please, choose a group to share with")
+		}
+		return []string{group}, nil, nil
+	case ShareWithUsers, ShareWithChannel:
+	default:
+		return []string{""}, nil, nil
+	}
 
-		preSelectedFiles := creq.Values["Files"].([]interface{})
+	users, err := getShareUsers(creq, shareWith)
+	if err != nil {
+		log.Errorf("Error during getting of users to share with. Error: %s", err)
+		return nil, nil, errors.New("Request failed during getting of users to share with")
+	}
 
-		if len(preSelectedFiles) > 0 {
+	recipientsService := ShareRecipientsServiceImpl{AsBot: appclient.AsBot(creq.Context)}
+	recipients := recipientsService.ResolveUsers(users, creq.Context.ActingUser.Id)
+	if len(recipients.NcUserIds) == 0 {
+		if len(recipients.NotConnected) == 0 {
+			return nil, nil, errors.New("There is nobody else to share with")
+		}
+		return nil, nil, fmt.Errorf("Nobody has got access, these users have not connected a Nextcloud account: @%s", strings.Join(recipients.NotConnected, ", @"))
+	}
+	return recipients.NcUserIds, recipients.NotConnected, nil
+}
 
-			for _, file := range preSelectedFiles {
-				label := file.(map[string]interface{})["label"].(string)
-				value := file.(map[string]interface{})["value"].(string)
-				option := apps.SelectOption{Label: label, Value: value}
-				preSelectedFilesOptions = append(preSelectedFilesOptions, option)
-			}
+func getShareUsers(creq apps.CallRequest, shareWith string) ([]*model.User, error) {
+	asActingUser := appclient.AsActingUser(creq.Context)
+	if shareWith == ShareWithUsers {
+		userIds := make([]string, 0)
+		for _, option := range getSelectedOptions(creq.Values, "Users") {
+			userIds = append(userIds, option.Value)
 		}
+		if len(userIds) == 0 {
+			return nil, errors.New("no users are selected")
+		}
+		users, _, err := asActingUser.GetUsersByIds(userIds)
+		return users, err
 	}
 
-	form := &apps.Form{
-		Title: "File share ",
-		Icon:  "icon.png",
-		Fields: []apps.Field{
-			{
-				Type:                "static_select",
-				Name:                "Folder",
-				Label:               "Folder",
-				IsRequired:          true,
-				SelectRefresh:       true,
-				SelectStaticOptions: folderSelectOptions,
-				Value:               defaultSelectOption,
-			},
-			{
-				Type:                "static_select",
-				Name:                "Files",
-				Label:               "Files",
-				IsRequired:          true,
-				SelectIsMulti:       true,
-				SelectStaticOptions: fileSelectOptions,
-				Value:               preSelectedFilesOptions,
-			},
-		},
-		Source: apps.NewCall("/file/search/form").WithExpand(apps.Expand{
-			ActingUserAccessToken: apps.ExpandAll,
-			OAuth2App:             apps.ExpandAll,
-			OAuth2User:            apps.ExpandAll,
-			Channel:               apps.ExpandAll,
-			ActingUser:            apps.ExpandAll,
-		}),
-		Submit: apps.NewCall("/file-share").WithExpand(apps.Expand{
-			ActingUserAccessToken: apps.ExpandAll,
-			OAuth2App:             apps.ExpandAll,
-			OAuth2User:            apps.ExpandAll,
-			Channel:               apps.ExpandAll,
-			ActingUser:            apps.ExpandAll,
-		}),
+	members := make([]*model.User, 0)
+	for page := 0; ; page++ {
+		users, _, err := asActingUser.GetUsersInChannel(creq.Context.Channel.Id, page, channelMembersPerPage, "")
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, users...)
+		if len(users) < channelMembersPerPage {
+			return members, nil
+		}
 	}
+}
 
-	c.JSON(http.StatusOK, apps.NewFormResponse(*form))
+// createBrowseFolderOptions returns the current folder first, then .. to go up and the subfolders to open.
+func createBrowseFolderOptions(folderName string, subfolderOptions []apps.SelectOption) ([]apps.SelectOption, apps.SelectOption) {
+	currentFolderOption := apps.SelectOption{Label: "Root", Value: ""}
+	if len(folderName) != 0 {
+		currentFolderOption = apps.SelectOption{Label: strings.TrimPrefix(folderName, "/"), Value: folderName}
+	}
+	folderSelectOptions := []apps.SelectOption{currentFolderOption}
+	if len(folderName) != 0 {
+		parent := parentFolder(folderName)
+		folderSelectOptions = append(folderSelectOptions, apps.SelectOption{Label: "..", Value: parent})
+	}
+	return append(folderSelectOptions, subfolderOptions...), currentFolderOption
+}
+
+func createFileBrowseService(c *gin.Context, creq apps.CallRequest, accessToken string) FileBrowseServiceImpl {
+	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
+	userId := creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string)
+	return FileBrowseServiceImpl{
+		Url:    fmt.Sprintf("%s%s", remoteUrl, "/remote.php/dav/"),
+		UserId: userId,
+		Client: settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, accessToken),
+	}
+}
+
+func getUserLocation(creq apps.CallRequest) *time.Location {
+	loc := calendar.CalendarTimePostService{}.GetMMUserLocation(creq)
+	if loc == nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func getSelectedOption(values map[string]interface{}, name string) interface{} {
+	option, ok := values[name].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	label, _ := option["label"].(string)
+	value, _ := option["value"].(string)
+	return apps.SelectOption{Label: label, Value: value}
+}
+
+func getSelectedValue(values map[string]interface{}, name string) string {
+	option, ok := values[name].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	value, _ := option["value"].(string)
+	return value
+}
+
+func getSelectedOptions(values map[string]interface{}, name string) []apps.SelectOption {
+	selectedOptions := make([]apps.SelectOption, 0)
+	options, _ := values[name].([]interface{})
+	for _, o := range options {
+		option, ok := o.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		label, _ := option["label"].(string)
+		value, _ := option["value"].(string)
+		selectedOptions = append(selectedOptions, apps.SelectOption{Label: label, Value: value})
+	}
+	return selectedOptions
+}
+
+func getPage(values map[string]interface{}) string {
+	page := strings.TrimSpace(fmt.Sprint(values["Page"]))
+	if number, err := strconv.Atoi(page); err != nil || number < 1 {
+		return "1"
+	}
+	return page
 }
 
 func FileShare(c *gin.Context) {
 	creq := apps.CallRequest{}
 	json.NewDecoder(c.Request.Body).Decode(&creq)
-	oauthService := oauth.OauthServiceImpl{creq}
-	token, refreshErr := oauthService.RefreshToken()
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
 
-	if refreshErr != nil {
-		c.JSON(http.StatusOK, apps.NewErrorResponse(refreshErr))
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
-	asActingUser := appclient.AsActingUser(creq.Context)
-	asActingUser.StoreOAuth2User(*token)
-	accessToken := token.AccessToken
 	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
 	url := fmt.Sprintf("%s%s", remoteUrl, "/ocs/v2.php/apps/files_sharing/api/v1/shares")
 
-	fileShareService := FileShareServiceImpl{Url: url, Token: accessToken}
-	fileSharesInfo := FileSharesInfo{fileShareService}
+	client := settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, token.AccessToken)
+	capabilitiesService := ShareCapabilitiesServiceImpl{Url: remoteUrl + "/ocs/v2.php/cloud/capabilities?format=json", Client: client}
+	capabilities, err := capabilitiesService.GetShareCapabilities()
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Request failed during getting of share settings")))
+		return
+	}
 
-	files := creq.Values["Files"].([]interface{})
-	botService := user.BotServiceImpl{creq}
-	botService.AddBot()
-	asBot := appclient.AsBot(creq.Context)
+	shareWith := getSelectedValue(creq.Values, "ShareWith")
+	shareType := GetShareType(shareWith)
+	options, fieldErrors := ParseFileShareOptions(creq.Values, *capabilities, shareType, time.Now().In(getUserLocation(creq)))
+	if len(fieldErrors) != 0 {
+		c.JSON(http.StatusOK, apps.CallResponse{
+			Type: apps.CallResponseTypeError,
+			Text: "Share options are not valid",
+			Data: map[string]interface{}{"errors": fieldErrors},
+		})
+		return
+	}
 
-	if len(files) == 0 {
-		msg := fmt.Sprintf("Please, choose a file to share")
+	files, _ := creq.Values["Files"].([]interface{})
+	newFolder, err := getNewFolderPath(creq.Values, getSelectedValue(creq.Values, "Folder"))
+	if err != nil {
+		c.JSON(http.StatusOK, createNewFolderErrorResponse(err))
+		return
+	}
+	if len(files) == 0 && len(newFolder) == 0 {
+		msg := fmt.Sprintf("Please, choose a file to share or type a new subfolder")
 		log.Error(msg)
 		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New(msg)))
 		return
 	}
 
+	recipients, notConnected, err := getShareRecipients(creq, shareWith)
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(err))
+		return
+	}
+
+	if len(newFolder) != 0 {
+		folderService := createFolderService(creq, client)
+		if err := folderService.CreateFolder(newFolder); err != nil {
+			c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Errorf("Folder %s was not created", newFolder)))
+			return
+		}
+		files = append(files, map[string]interface{}{"value": newFolder})
+	}
+
+	fileShareService := FileShareServiceImpl{Url: url, Client: client}
+	fileSharesInfo := FileSharesInfo{fileShareService}
+	browseService := createFileBrowseService(c, creq, token.AccessToken)
+	location := getUserLocation(creq)
+
+	botService := user.BotServiceImpl{Creq: creq}
+	botService.AddBot()
+	asBot := appclient.AsBot(creq.Context)
+
+	notSharedFiles := make([]string, 0)
 	for _, file := range files {
 		f := file.(map[string]interface{})["value"].(string)
-		sm, err := fileSharesInfo.GetSharesInfo(f, 3)
-		if err == nil {
-			var userId string
-			asBot.KVGet("", fmt.Sprintf("nc-user-%s", sm.UidFileOwner), &userId)
-			u, _, _ := asBot.GetUser(userId, "")
-			attachmentService := FileSharePostAttachementsImpl{user: u, sm: sm}
-			post := attachmentService.CreateFileSharePostWithAttachments(creq)
-			asBot.CreatePost(post)
+		if f == moreResultsValue {
+			continue
+		}
+		details, _ := browseService.GetFileDetails(f)
+		itemOptions := options.ForItem(f == newFolder || (details != nil && details.IsFolder))
+		var sm *FileShareModel
+		sharedWith := make([]string, 0)
+		for _, recipient := range recipients {
+			recipientOptions := itemOptions
+			recipientOptions.ShareWith = recipient
+			share, err := fileSharesInfo.GetSharesInfo(f, shareType, recipientOptions)
+			if err != nil {
+				if len(recipient) == 0 {
+					notSharedFiles = append(notSharedFiles, f)
+				} else {
+					notSharedFiles = append(notSharedFiles, fmt.Sprintf("%s with %s", f, recipient))
+				}
+				continue
+			}
+			sm = share
+			if len(recipient) != 0 {
+				sharedWith = append(sharedWith, share.GetShareWithName())
+			}
+		}
+		if sm == nil {
+			continue
+		}
+		var userId string
+		asBot.KVGet("", oauth.NcUserKvKey+sm.UidFileOwner, &userId)
+		u, _, _ := asBot.GetUser(userId, "")
+		attachmentService := FileSharePostAttachementsImpl{
+			user:       u,
+			sm:         sm,
+			remoteUrl:  remoteUrl,
+			sharedWith: sharedWith,
+			details:    details,
+			officeApp:  capabilities.OfficeApp,
+			location:   location,
+		}
+		post := attachmentService.CreateFileSharePostWithAttachments(creq)
+		asBot.CreatePost(post)
+	}
 
+	if attach, _ := creq.Values[attachField].(bool); attach {
+		paths := make([]string, 0)
+		for _, file := range files {
+			if filePath := file.(map[string]interface{})["value"].(string); filePath != newFolder && filePath != moreResultsValue {
+				paths = append(paths, filePath)
+			}
+		}
+		if err := startFileAttach(creq, token.AccessToken, paths, ""); err != nil {
+			c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Wrap(err, "Files were shared, but not attached")))
+			return
 		}
 	}
+
+	if len(notSharedFiles) != 0 {
+		msg := fmt.Sprintf("Files were not shared: %s. Nextcloud may reject a password which does not match its password policy", strings.Join(notSharedFiles, ", "))
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New(msg)))
+		return
+	}
+	if len(notConnected) != 0 {
+		c.JSON(http.StatusOK, apps.NewTextResponse("These users have not connected a Nextcloud account and did not get access: @%s", strings.Join(notConnected, ", @")))
+		return
+	}
 	c.JSON(http.StatusOK, apps.NewTextResponse(""))
 }
 
@@ -288,25 +705,62 @@ func FileUpload(c *gin.Context) {
 	creq := apps.CallRequest{}
 	json.NewDecoder(c.Request.Body).Decode(&creq)
 
-	oauthService := oauth.OauthServiceImpl{creq}
-	token, refreshErr := oauthService.RefreshToken()
-	if refreshErr != nil {
-		c.JSON(http.StatusOK, apps.NewErrorResponse(refreshErr))
+	startFileUpload(c, creq)
+}
+
+func FileUploadRetry(c *gin.Context) {
+	log.Info("File upload retry request")
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+
+	creq.Values = GetUploadRetry(creq).Values()
+	startFileUpload(c, creq)
+}
+
+// startFileUpload validates the files and uploads them by a background job. The job posts the progress
+// and the summary to the user, because a big upload takes longer than a call may last.
+func startFileUpload(c *gin.Context, creq apps.CallRequest) {
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
-	asActingUser := appclient.AsActingUser(creq.Context)
-	asActingUser.StoreOAuth2User(*token)
 
-	files := creq.Values["Files"].([]interface{})
+	files, _ := creq.Values["Files"].([]interface{})
+	folder := getSelectedValue(creq.Values, "Folder")
+	if folder == moreResultsValue {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Please, choose a folder")))
+		return
+	}
 
 	asBot := appclient.AsBot(creq.Context)
-	botService := user.BotServiceImpl{Creq: creq}
-	botService.AddBot()
-	chunkFileService := FileChunkServiceImpl{Token: token.AccessToken}
-	mmFileService := MMFileServiceImpl{creq.Context.BotAccessToken}
-	chunkUploadService := ChunkFileUploadServiceImpl{&chunkFileService, mmFileService}
-	fileService := FileFullUploadServiceImpl{token.AccessToken}
-	fileUploadService := FileUploadServiceImpl{&fileService, &chunkUploadService}
+	if creq.Context.Channel != nil {
+		botService := user.BotServiceImpl{Creq: creq}
+		botService.AddBot()
+	}
+	appSettings := settings.ForCall(creq)
+	client := appSettings.NewUserClient(context.Background(), creq, token.AccessToken)
+	// chunks and whole files are streamed, a slow link must not hit the timeout of a single request
+	streamingClient := client.ForStreaming()
+	chunkFileService := FileChunkServiceImpl{Client: streamingClient}
+	mmFileService := MMFileServiceImpl{client: appSettings.NewClient(context.Background(), creq.Context.BotAccessToken).ForStreaming()}
+	chunkUploadService := ChunkFileUploadServiceImpl{
+		fileChunkService: &chunkFileService,
+		MMFileService:    mmFileService,
+		stateStore:       KVChunkUploadStateStore{AsBot: asBot},
+		chunkSize:        appSettings.ChunkFileSizeInBytes(),
+		retries:          appSettings.MaxRequestRetries,
+	}
+	fileService := FileFullUploadServiceImpl{Client: streamingClient}
+	progress := &UploadProgressPost{Client: asBot, UserId: creq.Context.ActingUser.Id, Folder: folder, Total: len(files)}
+	fileUploadService := FileUploadServiceImpl{
+		fileFullUploadService:  &fileService,
+		fileChunkUploadService: &chunkUploadService,
+		folderFilesService:     FolderFilesServiceImpl{Client: client},
+		settings:               appSettings,
+		progress:               progress,
+	}
 
 	validFiles, errMsg := fileUploadService.ValidateFiles(asBot, files)
 	if !validFiles {
@@ -314,6 +768,27 @@ func FileUpload(c *gin.Context) {
 		return
 	}
 
-	uploadedFiles := fileUploadService.UploadFiles(creq, files, asBot)
-	c.JSON(http.StatusOK, apps.NewTextResponse("Uploaded files:  %s", strings.Join(uploadedFiles, ",")))
+	newFolder, err := getNewFolderPath(creq.Values, folder)
+	if err != nil {
+		c.JSON(http.StatusOK, createNewFolderErrorResponse(err))
+		return
+	}
+	if len(newFolder) != 0 {
+		folderService := createFolderService(creq, client)
+		if err := folderService.CreateFolder(newFolder); err != nil {
+			c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Errorf("Folder %s was not created", newFolder)))
+			return
+		}
+		folder = newFolder + "/"
+		creq.Values["Folder"] = map[string]interface{}{"value": folder}
+		delete(creq.Values, newFolderField)
+		progress.Folder = folder
+	}
+
+	if err := progress.Start(); err != nil {
+		log.Warnf("Upload progress was not posted for user %s. Error: %s", creq.Context.ActingUser.Id, err)
+	}
+	StartUploadJob(UploadJob{Creq: creq, Files: files, AsBot: asBot, UploadService: fileUploadService, Progress: progress})
+
+	c.JSON(http.StatusOK, apps.NewTextResponse("Upload of %d files is started, the progress is posted in a direct message from the Nextcloud bot", len(files)))
 }
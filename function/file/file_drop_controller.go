@@ -0,0 +1,314 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/settings"
+	"github.com/prokhorind/nextcloud/function/user"
+	log "github.com/sirupsen/logrus"
+)
+
+const notifyField = "Notify"
+
+func HandleFileDropForm(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	folderName := getSelectedValue(creq.Values, "Folder")
+	browseService := createFileBrowseService(c, creq, token.AccessToken)
+	subfolderOptions, err := browseService.ListSubfolders(folderName)
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Request failed during folder search")))
+		return
+	}
+	folderSelectOptions, currentFolderOption := createBrowseFolderOptions(folderName, subfolderOptions)
+
+	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
+	capabilitiesService := ShareCapabilitiesServiceImpl{Url: remoteUrl + "/ocs/v2.php/cloud/capabilities?format=json", Client: browseService.Client}
+	capabilities, err := capabilitiesService.GetShareCapabilities()
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Request failed during getting of share settings")))
+		return
+	}
+	if !capabilities.Public.Enabled {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Nextcloud does not allow shared links")))
+		return
+	}
+
+	fields := []apps.Field{
+		{
+			Type:                "static_select",
+			Name:                "Folder",
+			Label:               "Folder",
+			Description:         "Choose a subfolder to open it, or .. to go up. Files are dropped to the chosen folder",
+			IsRequired:          true,
+			SelectRefresh:       true,
+			SelectStaticOptions: folderSelectOptions,
+			Value:               currentFolderOption,
+		},
+		{
+			Type:        "text",
+			Name:        newFolderField,
+			Label:       "New subfolder",
+			Description: "Optional path like Customers/ACME, it is created in the chosen folder and used for the drop",
+			Value:       creq.Values[newFolderField],
+		},
+	}
+	now := time.Now().In(getUserLocation(creq))
+	for _, field := range createFileShareOptionFields(creq.Values, *capabilities, PublicLinkShareType, now) {
+		if field.Name != "AllowEditing" && field.Name != "HideDownload" {
+			fields = append(fields, field)
+		}
+	}
+	fields = append(fields, apps.Field{
+		Type:        "bool",
+		Name:        notifyField,
+		Label:       "Notify me",
+		Description: "Send me a direct message when a file is dropped. Nextcloud must allow the app to register webhooks",
+		Value:       creq.Values[notifyField],
+	})
+
+	expand := apps.Expand{
+		ActingUserAccessToken: apps.ExpandAll,
+		OAuth2App:             apps.ExpandAll,
+		OAuth2User:            apps.ExpandAll,
+		Channel:               apps.ExpandAll,
+		ActingUser:            apps.ExpandAll,
+	}
+	form := &apps.Form{
+		Title:  "Create a file drop",
+		Icon:   "icon.png",
+		Fields: fields,
+		Source: apps.NewCall("/file/drop/form").WithExpand(expand),
+		Submit: apps.NewCall("/file/drop").WithExpand(expand),
+	}
+
+	c.JSON(http.StatusOK, apps.NewFormResponse(*form))
+}
+
+func HandleFileDrop(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
+	client := settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, token.AccessToken)
+	capabilitiesService := ShareCapabilitiesServiceImpl{Url: remoteUrl + "/ocs/v2.php/cloud/capabilities?format=json", Client: client}
+	capabilities, err := capabilitiesService.GetShareCapabilities()
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Request failed during getting of share settings")))
+		return
+	}
+
+	options, fieldErrors := ParseFileShareOptions(creq.Values, *capabilities, PublicLinkShareType, time.Now().In(getUserLocation(creq)))
+	if len(fieldErrors) != 0 {
+		c.JSON(http.StatusOK, apps.CallResponse{
+			Type: apps.CallResponseTypeError,
+			Text: "Drop options are not valid",
+			Data: map[string]interface{}{"errors": fieldErrors},
+		})
+		return
+	}
+	options.Permissions = DropPermission
+
+	folder := getSelectedValue(creq.Values, "Folder")
+	newFolder, err := getNewFolderPath(creq.Values, folder)
+	if err != nil {
+		c.JSON(http.StatusOK, createNewFolderErrorResponse(err))
+		return
+	}
+	if len(newFolder) != 0 {
+		folderService := createFolderService(creq, client)
+		if err := folderService.CreateFolder(newFolder); err != nil {
+			c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Errorf("Folder %s was not created", newFolder)))
+			return
+		}
+		folder = newFolder
+	}
+	folder = strings.TrimSuffix(folder, "/")
+	if len(folder) == 0 {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Please, choose a folder or type a new subfolder, the root folder cannot be used for a drop")))
+		return
+	}
+
+	fileShareService := FileShareServiceImpl{Url: remoteUrl + "/ocs/v2.php/apps/files_sharing/api/v1/shares", Client: client}
+	share, err := fileShareService.CreateUserShare(folder, PublicLinkShareType, options)
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Errorf("Drop link for %s was not created. Nextcloud may reject a password which does not match its password policy", folder)))
+		return
+	}
+
+	botService := user.BotServiceImpl{Creq: creq}
+	botService.AddBot()
+	asBot := appclient.AsBot(creq.Context)
+	attachmentService := FileSharePostAttachementsImpl{user: creq.Context.ActingUser, sm: share, remoteUrl: remoteUrl}
+	if _, err := asBot.CreatePost(attachmentService.CreateFileSharePostWithAttachments(creq)); err != nil {
+		log.Errorf("Drop post was not created in channel %s. Error: %s", creq.Context.Channel.Id, err)
+	}
+
+	if notify, _ := creq.Values[notifyField].(bool); notify {
+		if err := registerFileDrop(creq, client, folder); err != nil {
+			c.JSON(http.StatusOK, apps.NewTextResponse("The drop is created, but you will not be notified about dropped files: %s", err))
+			return
+		}
+		c.JSON(http.StatusOK, apps.NewTextResponse("The drop is created, you will get a direct message about every dropped file"))
+		return
+	}
+	c.JSON(http.StatusOK, apps.NewTextResponse(""))
+}
+
+// registerFileDrop asks Nextcloud to call the app for files created in the folder and keeps the owner of the drop.
+func registerFileDrop(creq apps.CallRequest, client nextcloud.Client, folder string) error {
+	key := model.NewId()
+	ncUserId := creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string)
+	webhookUrl := creq.Context.MattermostSiteURL + creq.Context.AppPath + fileDropWebhookPath + key
+
+	webhookService := FileDropWebhookServiceImpl{Url: creq.Context.OAuth2.OAuth2App.RemoteRootURL, Client: client}
+	webhookId, err := webhookService.RegisterWebhook(webhookUrl, ncUserId, folder)
+	if err != nil {
+		return errors.New("Nextcloud did not accept the webhook, it needs the webhook_listeners app and an admin account")
+	}
+
+	store := KVFileDropStore{AsBot: appclient.AsBot(creq.Context)}
+	drop := FileDrop{Folder: folder, UserId: creq.Context.ActingUser.Id, NcUserId: ncUserId, WebhookId: webhookId}
+	if err := store.SaveDrop(key, drop); err != nil {
+		log.Errorf("Drop of folder %s was not saved. Error: %s", folder, err)
+		return errors.New("the drop was not saved")
+	}
+	return nil
+}
+
+// HandleFileDropWebhook is called by Nextcloud for every file created in a drop folder.
+func HandleFileDropWebhook(c *gin.Context) {
+	creq := apps.CallRequest{}
+	if err := json.NewDecoder(c.Request.Body).Decode(&creq); err != nil {
+		log.Errorf("Webhook of drop %s was not decoded. Error: %s", c.Param("key"), err)
+		c.JSON(http.StatusBadRequest, apps.NewErrorResponse(errors.New("request is not valid")))
+		return
+	}
+
+	asBot := appclient.AsBot(creq.Context)
+	store := KVFileDropStore{AsBot: asBot}
+	drop := store.GetDrop(c.Param("key"))
+	if drop == nil {
+		log.Warnf("Webhook of unknown drop %s was ignored", c.Param("key"))
+		c.JSON(http.StatusOK, apps.NewTextResponse(""))
+		return
+	}
+
+	event := FileDropEvent{}
+	data, _ := json.Marshal(creq.Values["data"])
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.Errorf("Webhook event of drop %s was not decoded. Error: %s", c.Param("key"), err)
+	}
+	if name := event.DroppedFile(*drop); len(name) != 0 {
+		message := fmt.Sprintf("A file was dropped to **%s**: %s", drop.Folder, name)
+		if _, err := asBot.DMPost(drop.UserId, &model.Post{Message: message}); err != nil {
+			log.Errorf("Drop notification was not sent to user %s. Error: %s", drop.UserId, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, apps.NewTextResponse(""))
+}
+
+// HandleFileDropsForm lets the user choose a drop whose notifications are not needed anymore.
+func HandleFileDropsForm(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+
+	store := KVFileDropStore{AsBot: appclient.AsBot(creq.Context)}
+	options := make([]apps.SelectOption, 0)
+	for _, key := range store.GetUserDrops(creq.Context.ActingUser.Id) {
+		if drop := store.GetDrop(key); drop != nil {
+			options = append(options, apps.SelectOption{Label: drop.Folder, Value: key})
+		}
+	}
+	if len(options) == 0 {
+		c.JSON(http.StatusOK, apps.NewTextResponse("You have no drops with notifications"))
+		return
+	}
+
+	form := &apps.Form{
+		Title: "Remove a file drop notification",
+		Icon:  "icon.png",
+		Fields: []apps.Field{
+			{
+				Type:                "static_select",
+				Name:                "Drop",
+				Label:               "Drop",
+				Description:         "Nextcloud stops calling the app for this folder. The drop link stays, remove it by shares",
+				IsRequired:          true,
+				SelectStaticOptions: options,
+			},
+		},
+		Submit: apps.NewCall("/file/drop/remove").WithExpand(apps.Expand{
+			ActingUserAccessToken: apps.ExpandAll,
+			OAuth2App:             apps.ExpandAll,
+			OAuth2User:            apps.ExpandAll,
+			ActingUser:            apps.ExpandAll,
+		}),
+	}
+	c.JSON(http.StatusOK, apps.NewFormResponse(*form))
+}
+
+func HandleFileDropRemove(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	store := KVFileDropStore{AsBot: appclient.AsBot(creq.Context)}
+	key := getSelectedValue(creq.Values, "Drop")
+	drop := store.GetDrop(key)
+	if drop == nil || drop.UserId != creq.Context.ActingUser.Id {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("The drop was not found")))
+		return
+	}
+
+	client := settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, token.AccessToken)
+	webhookService := FileDropWebhookServiceImpl{Url: creq.Context.OAuth2.OAuth2App.RemoteRootURL, Client: client}
+	if err := RemoveFileDrop(store, webhookService, key); err != nil {
+		log.Errorf("Drop %s was not removed. Error: %s", key, err)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Errorf("Notifications about %s were not removed", drop.Folder)))
+		return
+	}
+	c.JSON(http.StatusOK, apps.NewTextResponse("You will not be notified about files dropped to %s", drop.Folder))
+}
+
+// ForgetUserDrops is the disconnect hook of the drops, it deletes their webhooks in Nextcloud and the drops.
+func ForgetUserDrops(creq apps.CallRequest, mmUserId string, accessToken string, forgetEverything bool) error {
+	store := KVFileDropStore{AsBot: appclient.AsBot(creq.Context)}
+	client := settings.ForCall(creq).NewUserClient(context.Background(), creq, accessToken)
+	webhookService := FileDropWebhookServiceImpl{Url: creq.Context.OAuth2.OAuth2App.RemoteRootURL, Client: client}
+	return RemoveUserFileDrops(store, webhookService, mmUserId, forgetEverything)
+}
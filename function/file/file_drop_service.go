@@ -0,0 +1,224 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	FileDropKvKey        = "file-drop-"
+	UserFileDropsKvKey   = "user-file-drops-"
+	nodeCreatedEvent     = "OCP\\Files\\Events\\Node\\NodeCreatedEvent"
+	fileDropWebhookPath  = "/webhook/file-drop/"
+	webhookListenersPath = "/ocs/v2.php/apps/webhook_listeners/api/v1/webhooks"
+)
+
+// FileDrop is kept for a drop box whose owner is notified about uploads. The key is a part of the webhook
+// url, so only Nextcloud knows it.
+type FileDrop struct {
+	Folder    string `json:"folder"`
+	UserId    string `json:"user_id"`
+	NcUserId  string `json:"nc_user_id"`
+	WebhookId int    `json:"webhook_id"`
+}
+
+type FileDropStore interface {
+	GetDrop(key string) *FileDrop
+	SaveDrop(key string, drop FileDrop) error
+	DeleteDrop(key string) error
+	GetUserDrops(userId string) []string
+}
+
+type KVFileDropStore struct {
+	AsBot UploadStateKVClient
+}
+
+func (s KVFileDropStore) GetDrop(key string) *FileDrop {
+	drop := FileDrop{}
+	if err := s.AsBot.KVGet("", FileDropKvKey+key, &drop); err != nil || len(drop.Folder) == 0 {
+		return nil
+	}
+	return &drop
+}
+
+// SaveDrop keeps the drop and adds its key to the drops of the owner, so the owner can remove them later.
+func (s KVFileDropStore) SaveDrop(key string, drop FileDrop) error {
+	if _, err := s.AsBot.KVSet("", FileDropKvKey+key, drop); err != nil {
+		return err
+	}
+	keys := s.GetUserDrops(drop.UserId)
+	for _, k := range keys {
+		if k == key {
+			return nil
+		}
+	}
+	_, err := s.AsBot.KVSet("", UserFileDropsKvKey+drop.UserId, append(keys, key))
+	return err
+}
+
+func (s KVFileDropStore) DeleteDrop(key string) error {
+	drop := s.GetDrop(key)
+	if err := s.AsBot.KVDelete("", FileDropKvKey+key); err != nil {
+		return err
+	}
+	if drop == nil {
+		return nil
+	}
+	keys := make([]string, 0)
+	for _, k := range s.GetUserDrops(drop.UserId) {
+		if k != key {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return s.AsBot.KVDelete("", UserFileDropsKvKey+drop.UserId)
+	}
+	_, err := s.AsBot.KVSet("", UserFileDropsKvKey+drop.UserId, keys)
+	return err
+}
+
+// GetUserDrops returns the keys of the drops the user is notified about.
+func (s KVFileDropStore) GetUserDrops(userId string) []string {
+	keys := make([]string, 0)
+	s.AsBot.KVGet("", UserFileDropsKvKey+userId, &keys)
+	return keys
+}
+
+type FileDropWebhookService interface {
+	RegisterWebhook(webhookUrl string, ncUserId string, folder string) (int, error)
+	DeleteWebhook(id int) error
+}
+
+// FileDropWebhookServiceImpl registers and deletes webhooks by the webhook_listeners app of Nextcloud. Url is the Nextcloud address.
+type FileDropWebhookServiceImpl struct {
+	Url    string
+	Client nextcloud.Client
+}
+
+type webhookRequestBody struct {
+	HttpMethod  string            `json:"httpMethod"`
+	Uri         string            `json:"uri"`
+	Event       string            `json:"event"`
+	EventFilter map[string]string `json:"eventFilter"`
+	AuthMethod  string            `json:"authMethod"`
+}
+
+type webhookResponseBody struct {
+	Ocs struct {
+		Data struct {
+			Id int `json:"id"`
+		} `json:"data"`
+	} `json:"ocs"`
+}
+
+// RegisterWebhook asks Nextcloud to call the webhook for every file created in the folder. Nextcloud allows it only
+// for admins, so the caller has to handle a rejected registration.
+func (s FileDropWebhookServiceImpl) RegisterWebhook(webhookUrl string, ncUserId string, folder string) (int, error) {
+	payload := webhookRequestBody{
+		HttpMethod:  http.MethodPost,
+		Uri:         webhookUrl,
+		Event:       nodeCreatedEvent,
+		EventFilter: map[string]string{"event.node.path": createDropPathFilter(ncUserId, folder)},
+		AuthMethod:  "none",
+	}
+	body, _ := json.Marshal(payload)
+
+	req, _ := http.NewRequest("POST", s.Url+webhookListenersPath+"?format=json", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("OCS-APIRequest", "true")
+
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if err != nil {
+		log.Errorf("Webhook for folder %s was not registered. Error: %s", folder, err)
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	webhookResp := webhookResponseBody{}
+	if err := json.NewDecoder(resp.Body).Decode(&webhookResp); err != nil {
+		return 0, errors.Wrap(err, "webhook response is not valid")
+	}
+	return webhookResp.Ocs.Data.Id, nil
+}
+
+// DeleteWebhook stops the calls of the webhook. A webhook which is already gone is not an error.
+func (s FileDropWebhookServiceImpl) DeleteWebhook(id int) error {
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s%s/%d?format=json", s.Url, webhookListenersPath, id), nil)
+	req.Header.Set("OCS-APIRequest", "true")
+
+	resp, err := s.Client.Do(req, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		log.Errorf("Webhook %d was not deleted. Error: %s", id, err)
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// RemoveFileDrop deletes the webhook of the drop in Nextcloud and then the drop itself. The drop is kept when
+// the webhook is not deleted, so the removal can be retried.
+func RemoveFileDrop(store FileDropStore, webhookService FileDropWebhookService, key string) error {
+	drop := store.GetDrop(key)
+	if drop == nil {
+		return nil
+	}
+	if err := webhookService.DeleteWebhook(drop.WebhookId); err != nil {
+		return errors.Wrapf(err, "webhook of the drop %s was not deleted", drop.Folder)
+	}
+	return store.DeleteDrop(key)
+}
+
+// RemoveUserFileDrops removes all drops of the user. With forgetEverything a drop is removed even if its webhook
+// is not deleted, Nextcloud then calls the app for an unknown drop, which is ignored.
+func RemoveUserFileDrops(store FileDropStore, webhookService FileDropWebhookService, userId string, forgetEverything bool) error {
+	for _, key := range store.GetUserDrops(userId) {
+		err := RemoveFileDrop(store, webhookService, key)
+		if err == nil {
+			continue
+		}
+		if !forgetEverything {
+			return err
+		}
+		log.Warnf("Drop %s of user %s is removed with its webhook left. Error: %s", key, userId, err)
+		if err := store.DeleteDrop(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createDropPathFilter matches the files in the folder and its subfolders. The paths of the events start
+// with the owner, e.g. /alice/files/Customers/report.pdf.
+func createDropPathFilter(ncUserId string, folder string) string {
+	prefix := fmt.Sprintf("/%s/files/%s/", ncUserId, strings.Trim(folder, "/"))
+	return "/^" + strings.ReplaceAll(regexp.QuoteMeta(prefix), "/", "\\/") + "/"
+}
+
+// FileDropEvent is the part of the webhook_listeners payload the app needs.
+type FileDropEvent struct {
+	Event struct {
+		Class string `json:"class"`
+		Node  struct {
+			Id   int    `json:"id"`
+			Path string `json:"path"`
+		} `json:"node"`
+	} `json:"event"`
+}
+
+// DroppedFile returns the path of the uploaded file within the drop folder, or an empty string for other events.
+func (e FileDropEvent) DroppedFile(drop FileDrop) string {
+	prefix := fmt.Sprintf("/%s/files/%s/", drop.NcUserId, strings.Trim(drop.Folder, "/"))
+	if e.Event.Class != nodeCreatedEvent || !strings.HasPrefix(e.Event.Node.Path, prefix) {
+		return ""
+	}
+	return path.Clean(strings.TrimPrefix(e.Event.Node.Path, prefix))
+}
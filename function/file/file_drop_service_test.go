@@ -0,0 +1,168 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+)
+
+func TestDropPathFilterMatchesOnlyDropFolder(t *testing.T) {
+	filter := createDropPathFilter("john.doe", "/Customers (ACME)/")
+	pattern := regexp.MustCompile(filter[1 : len(filter)-1])
+
+	if !pattern.MatchString("/john.doe/files/Customers (ACME)/report.pdf") {
+		t.Errorf("File in the drop folder should match %q", filter)
+	}
+	if pattern.MatchString("/johnXdoe/files/Customers (ACME)/report.pdf") || pattern.MatchString("/john.doe/files/Customers (ACME) old/report.pdf") {
+		t.Errorf("Other files should not match %q", filter)
+	}
+}
+
+func TestDropWebhookIsRegistered(t *testing.T) {
+	var body webhookRequestBody
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Write([]byte(`{"ocs":{"data":{"id":12}}}`))
+	}))
+	defer server.Close()
+	testedInstance := FileDropWebhookServiceImpl{Url: server.URL, Client: nextcloud.NewClient("token")}
+
+	id, err := testedInstance.RegisterWebhook("https://mm/webhook/file-drop/key", "alice", "/Drop")
+
+	if err != nil || id != 12 {
+		t.Fatalf("Webhook should be registered, actual id %d, error %v", id, err)
+	}
+	if path != webhookListenersPath || body.Event != nodeCreatedEvent || body.Uri != "https://mm/webhook/file-drop/key" {
+		t.Errorf("Unexpected webhook %q %+v", path, body)
+	}
+	if body.EventFilter["event.node.path"] != `/^\/alice\/files\/Drop\//` {
+		t.Errorf("Unexpected filter %q", body.EventFilter["event.node.path"])
+	}
+}
+
+func TestRejectedWebhookIsReported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	testedInstance := FileDropWebhookServiceImpl{Url: server.URL, Client: nextcloud.NewClient("token")}
+
+	if _, err := testedInstance.RegisterWebhook("https://mm/webhook", "alice", "/Drop"); err == nil {
+		t.Error("Registration should fail for not admin users")
+	}
+}
+
+func TestDroppedFileIsFoundInEvent(t *testing.T) {
+	drop := FileDrop{Folder: "/Drop", NcUserId: "alice"}
+	event := FileDropEvent{}
+	json.Unmarshal([]byte(`{"event":{"class":"OCP\\Files\\Events\\Node\\NodeCreatedEvent","node":{"id":5,"path":"/alice/files/Drop/scan.pdf"}}}`), &event)
+
+	if actual := event.DroppedFile(drop); actual != "scan.pdf" {
+		t.Errorf(" expected %q, actual %q", "scan.pdf", actual)
+	}
+	event.Event.Node.Path = "/alice/files/Other/scan.pdf"
+	if actual := event.DroppedFile(drop); actual != "" {
+		t.Errorf("File outside the drop should be ignored, actual %q", actual)
+	}
+}
+
+func TestDropPostShowsUploadOnlyAccess(t *testing.T) {
+	fm := &FileShareModel{FileTarget: "/Drop", ShareType: "3", Permissions: "4", ItemType: "folder"}
+	testedInstance := FileSharePostAttachementsImpl{user: &model.User{Username: "username"}, sm: fm}
+
+	attachment := testedInstance.createAttachments()[0]
+
+	if attachment.Fields[2].Value != "Upload only" || len(attachment.Pretext) == 0 {
+		t.Errorf("Drop post should describe the drop, actual %q, %q", attachment.Fields[2].Value, attachment.Pretext)
+	}
+}
+
+type FileDropWebhookServiceMock struct {
+	deleted []int
+	err     error
+}
+
+func (m *FileDropWebhookServiceMock) RegisterWebhook(webhookUrl string, ncUserId string, folder string) (int, error) {
+	return 0, nil
+}
+
+func (m *FileDropWebhookServiceMock) DeleteWebhook(id int) error {
+	m.deleted = append(m.deleted, id)
+	return m.err
+}
+
+func TestFileDropIsRemovedWithItsWebhook(t *testing.T) {
+	store := KVFileDropStore{AsBot: KVStoreMock{values: map[string][]byte{}}}
+	store.SaveDrop("first", FileDrop{Folder: "/Drop", UserId: "user", WebhookId: 12})
+	store.SaveDrop("second", FileDrop{Folder: "/Other", UserId: "user", WebhookId: 13})
+	webhookService := &FileDropWebhookServiceMock{}
+
+	if err := RemoveFileDrop(store, webhookService, "first"); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if len(webhookService.deleted) != 1 || webhookService.deleted[0] != 12 {
+		t.Errorf("Webhook 12 should be deleted, actual %v", webhookService.deleted)
+	}
+	if store.GetDrop("first") != nil {
+		t.Error("Removed drop should not be kept")
+	}
+	if keys := store.GetUserDrops("user"); len(keys) != 1 || keys[0] != "second" {
+		t.Errorf("Only the other drop should be left, actual %v", keys)
+	}
+}
+
+func TestFileDropIsKeptWhenWebhookIsNotDeleted(t *testing.T) {
+	store := KVFileDropStore{AsBot: KVStoreMock{values: map[string][]byte{}}}
+	store.SaveDrop("first", FileDrop{Folder: "/Drop", UserId: "user", WebhookId: 12})
+
+	if err := RemoveFileDrop(store, &FileDropWebhookServiceMock{err: errors.New("forbidden")}, "first"); err == nil {
+		t.Error("Removal should fail")
+	}
+	if store.GetDrop("first") == nil || len(store.GetUserDrops("user")) != 1 {
+		t.Error("Drop should be kept for another attempt")
+	}
+}
+
+func TestDropWebhookIsDeleted(t *testing.T) {
+	var request string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r.Method + " " + r.URL.Path
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	testedInstance := FileDropWebhookServiceImpl{Url: server.URL, Client: nextcloud.NewClient("token")}
+
+	if err := testedInstance.DeleteWebhook(12); err != nil {
+		t.Errorf("Webhook which is already gone should not fail, actual %s", err)
+	}
+	if request != "DELETE "+webhookListenersPath+"/12" {
+		t.Errorf("Unexpected request %q", request)
+	}
+}
+
+func TestUserFileDropsAreRemovedOnDisconnect(t *testing.T) {
+	store := KVFileDropStore{AsBot: KVStoreMock{values: map[string][]byte{}}}
+	store.SaveDrop("first", FileDrop{Folder: "/Drop", UserId: "user", WebhookId: 12})
+	store.SaveDrop("second", FileDrop{Folder: "/Other", UserId: "user", WebhookId: 13})
+	failing := &FileDropWebhookServiceMock{err: errors.New("forbidden")}
+
+	if err := RemoveUserFileDrops(store, failing, "user", false); err == nil || store.GetDrop("first") == nil {
+		t.Error("Drops should be kept when their webhooks are not deleted")
+	}
+	if err := RemoveUserFileDrops(store, failing, "user", true); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if store.GetDrop("first") != nil || store.GetDrop("second") != nil || len(store.GetUserDrops("user")) != 0 {
+		t.Error("Drops should be removed with forget everything flag")
+	}
+}
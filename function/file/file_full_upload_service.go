@@ -2,43 +2,29 @@ package file
 
 import (
 	"bytes"
-	"fmt"
-	"github.com/hashicorp/go-retryablehttp"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
 	log "github.com/sirupsen/logrus"
 	"net/http"
-	"os"
-	"strconv"
 )
 
 type FileFullUploadService interface {
-	UploadFile(file []byte, url string) (*http.Response, error)
+	UploadFile(file []byte, url string, condition UploadCondition) (*http.Response, error)
 }
 
 type FileFullUploadServiceImpl struct {
-	Token string
+	Client nextcloud.Client
 }
 
-func (s *FileFullUploadServiceImpl) UploadFile(file []byte, url string) (*http.Response, error) {
+func (s *FileFullUploadServiceImpl) UploadFile(file []byte, url string, condition UploadCondition) (*http.Response, error) {
 	req, _ := http.NewRequest("PUT", url, bytes.NewBuffer(file))
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.Token))
+	condition.setHeaders(req)
 
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
-
-	client := retryClient.StandardClient()
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
+	resp, err := s.Client.Do(req, http.StatusNoContent, http.StatusCreated)
 	if err != nil {
 		log.Errorf("Error during file uploading. Error: %s", err)
 		return nil, err
 	}
-
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusCreated {
-		log.Errorf("request failed with status %s", resp.Status)
-		error := fmt.Errorf("request failed with code %d", resp.StatusCode)
-		return nil, error
-	}
+	resp.Body.Close()
 
 	return resp, err
 }
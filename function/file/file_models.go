@@ -2,7 +2,9 @@ package file
 
 import (
 	"encoding/xml"
+	"fmt"
 	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"strconv"
 )
 
 type FileSearchResponseBody struct {
@@ -28,12 +30,16 @@ type propertyStat struct {
 }
 
 type property struct {
-	Text           string `xml:",chardata"`
-	Fileid         string `xml:"fileid"`
-	Getcontenttype string `xml:"getcontenttype"`
-	Getetag        string `xml:"getetag"`
-	Size           string `xml:"size"`
-	Displayname    string `xml:"displayname"`
+	Text            string `xml:",chardata"`
+	Fileid          string `xml:"fileid"`
+	Getcontenttype  string `xml:"getcontenttype"`
+	Getetag         string `xml:"getetag"`
+	Getlastmodified string `xml:"getlastmodified"`
+	Size            string `xml:"size"`
+	Displayname     string `xml:"displayname"`
+	Resourcetype    struct {
+		Collection *struct{} `xml:"collection"`
+	} `xml:"resourcetype"`
 }
 
 type DynamicSelectResponse struct {
@@ -104,6 +110,51 @@ type FileShareModel struct {
 }
 
 type FileShareRequestBody struct {
-	Path      string `json:"path"`
-	ShareType int32  `json:"shareType"`
+	Path        string `json:"path"`
+	ShareType   int32  `json:"shareType"`
+	Permissions int    `json:"permissions,omitempty"`
+	Password    string `json:"password,omitempty"`
+	ExpireDate  string `json:"expireDate,omitempty"`
+	Note        string `json:"note,omitempty"`
+	Label       string `json:"label,omitempty"`
+	ShareWith   string `json:"shareWith,omitempty"`
+}
+
+// IsPasswordProtected checks share_with too, because older Nextcloud versions keep the password hash there for links.
+func (m FileShareModel) IsPasswordProtected() bool {
+	return len(m.Password) != 0 || (m.ShareType == strconv.Itoa(PublicLinkShareType) && len(m.ShareWith) != 0)
+}
+
+func (m FileShareModel) GetPermissions() int {
+	permissions, _ := strconv.Atoi(m.Permissions)
+	return permissions
+}
+
+// IsFileDrop reports whether visitors of the share can only upload, its permissions have no read bit.
+func (m FileShareModel) IsFileDrop() bool {
+	permissions := m.GetPermissions()
+	return permissions&DropPermission != 0 && permissions&ReadPermission == 0
+}
+
+func (m FileShareModel) AllowsEditing() bool {
+	return m.GetPermissions()&UpdatePermission != 0
+}
+
+// GetShareWithName returns the display name of the user or group the file is shared with.
+func (m FileShareModel) GetShareWithName() string {
+	if len(m.ShareWithDisplayname) != 0 {
+		return m.ShareWithDisplayname
+	}
+	return m.ShareWith
+}
+
+// GetRecipient describes who has access by the share. The share_with of a link may keep a password hash.
+func (m FileShareModel) GetRecipient() string {
+	if m.ShareType != strconv.Itoa(PublicLinkShareType) {
+		return m.GetShareWithName()
+	}
+	if len(m.Label) != 0 {
+		return fmt.Sprintf("Anyone with the link (%s)", m.Label)
+	}
+	return "Anyone with the link"
 }
@@ -2,14 +2,16 @@ package file
 
 import (
 	"fmt"
-	"github.com/google/uuid"
 	"github.com/mattermost/mattermost-plugin-apps/apps"
 	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
 	"github.com/mattermost/mattermost-server/v6/model"
-	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	"github.com/prokhorind/nextcloud/function/settings"
 	log "github.com/sirupsen/logrus"
-	"os"
+	"net/url"
 	"strconv"
+	"strings"
 )
 
 type GetFileInfo interface {
@@ -23,12 +25,15 @@ type GetFile interface {
 
 type FilesUploadService interface {
 	ValidateFiles(asBot *appclient.Client, files []interface{}) (bool, *string)
-	UploadFiles(creq apps.CallRequest, files []interface{}, asBot *appclient.Client, token oauth.Token) []string
+	UploadFiles(creq apps.CallRequest, files []interface{}, asBot GetFile) []UploadResult
 }
 
 type FileUploadServiceImpl struct {
 	fileFullUploadService  FileFullUploadService
 	fileChunkUploadService ChunkFileUploadService
+	folderFilesService     FolderFilesService
+	settings               settings.Settings
+	progress               UploadProgress
 }
 
 func (fileUpload FileUploadServiceImpl) ValidateFiles(asBot GetFileInfo, files []interface{}) (bool, *string) {
@@ -38,13 +43,8 @@ func (fileUpload FileUploadServiceImpl) ValidateFiles(asBot GetFileInfo, files [
 		return false, &msg
 	}
 
-	maxFileSizeString := os.Getenv("MAX_FILE_SIZE_MB")
-	maxFileSize, _ := strconv.Atoi(maxFileSizeString)
-	maxFileSizeInBytes := int64(maxFileSize * 1024 * 1024)
-
-	maxFilesSizeString := os.Getenv("MAX_FILES_SIZE_MB")
-	maxFilesSize, _ := strconv.Atoi(maxFilesSizeString)
-	maxFilesSizeInBytes := int64(maxFilesSize * 1024 * 1024)
+	maxFileSizeInBytes := fileUpload.settings.MaxFileSizeInBytes()
+	maxFilesSizeInBytes := fileUpload.settings.MaxFilesSizeInBytes()
 	var filesSize int64
 	for _, file := range files {
 		f := file.(map[string]interface{})["value"].(string)
@@ -58,13 +58,13 @@ func (fileUpload FileUploadServiceImpl) ValidateFiles(asBot GetFileInfo, files [
 		}
 		filesSize = filesSize + fileInfo.Size
 		if filesSize > maxFilesSizeInBytes {
-			msg := fmt.Sprintf("Size of uploading files above %s MB cannot be uploaded", maxFilesSizeString)
+			msg := fmt.Sprintf("Size of uploading files above %d MB cannot be uploaded", fileUpload.settings.MaxFilesSizeMb)
 			log.Error(msg)
 			return false, &msg
 		}
 
 		if fileInfo.Size > maxFileSizeInBytes {
-			msg := fmt.Sprintf("File above %s MB cannot be uploaded: %s", maxFileSizeString, fileInfo.Name)
+			msg := fmt.Sprintf("File above %d MB cannot be uploaded: %s", fileUpload.settings.MaxFileSizeMb, fileInfo.Name)
 			log.Error(msg)
 			return false, &msg
 		}
@@ -73,72 +73,167 @@ func (fileUpload FileUploadServiceImpl) ValidateFiles(asBot GetFileInfo, files [
 	return true, nil
 }
 
-func (fileUpload FileUploadServiceImpl) UploadFiles(creq apps.CallRequest, files []interface{}, asBot GetFile) []string {
-	chunkFileSize, _ := strconv.Atoi(os.Getenv("CHUNK_FILE_SIZE_MB"))
-	chunkFileSizeInBytes := int64(chunkFileSize * 1024 * 1024)
+// UploadFiles uploads the files one by one and reports every file to the progress. Files which already exist
+// in the folder are renamed, overwritten or skipped as chosen in the form.
+func (fileUpload FileUploadServiceImpl) UploadFiles(creq apps.CallRequest, files []interface{}, asBot GetFile) []UploadResult {
 	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
 	userId := creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string)
 	folder := creq.Values["Folder"].(map[string]interface{})["value"].(string)
 
-	fileUrl := fmt.Sprintf("%s%s%s%s", remoteUrl, "/remote.php/dav/files/", userId, folder)
-	var uploadedFiles []string
+	fileUrl := createDavFolderUrl(remoteUrl, userId, folder)
+	progress := fileUpload.getProgress()
+	conflicts := GetUploadConflicts(creq)
+	existing := fileUpload.listExistingFiles(fileUrl)
+	results := make([]UploadResult, 0)
 
 	for _, file := range files {
 		f := file.(map[string]interface{})["value"].(string)
-		fileInfo, _, _ := asBot.GetFileInfo(f)
-		destination := fmt.Sprintf("%s%s", fileUrl, fileInfo.Name)
+		fileInfo, _, err := asBot.GetFileInfo(f)
+		if err != nil {
+			log.Errorf("Could not get file info for file %s with error %s", f, err.Error())
+			result := UploadResult{FileId: f, Name: f, Err: errors.New("file was not found in Mattermost")}
+			progress.FileFinished(result)
+			results = append(results, result)
+			continue
+		}
+		progress.FileStarted(fileInfo.Name)
+
+		result := fileUpload.uploadFile(creq, asBot, f, fileInfo, fileUrl, conflicts, existing)
+		if result.Err == nil && !result.Skipped {
+			result.Url = createFileLink(remoteUrl, result.Url, folder)
+		}
+
+		progress.FileFinished(result)
+		results = append(results, result)
+	}
+	return results
+}
+
+// uploadFile checks the name against the files in the folder. When the destination changes in Nextcloud meanwhile,
+// the conditional request fails and the folder is listed again to resolve the new conflict.
+func (fileUpload FileUploadServiceImpl) uploadFile(creq apps.CallRequest, asBot GetFile, f string, fileInfo *model.FileInfo, fileUrl string, conflicts UploadConflicts, existing map[string]string) UploadResult {
+	chunkFileSizeInBytes := fileUpload.settings.ChunkFileSizeInBytes()
+
+	var result UploadResult
+	for attempt := 0; attempt < maxConflictAttempts; attempt++ {
+		target := conflicts.resolve(fileInfo.Id, fileInfo.Name, existing)
+		if target.Skip {
+			log.Infof("File %s already exists in the folder and is skipped", fileInfo.Name)
+			return UploadResult{FileId: f, Name: fileInfo.Name, Skipped: true}
+		}
 
+		destination := fileUrl + url.PathEscape(target.Name)
 		if fileInfo.Size <= chunkFileSizeInBytes {
-			uploadedFiles = fileUpload.fullFileUpload(asBot, f, destination, fileInfo, uploadedFiles)
+			result = fileUpload.fullFileUpload(asBot, f, destination, fileInfo, target.Condition)
 		} else {
-			uploadedFiles = fileUpload.chunkFileUpload(creq, fileInfo, chunkFileSizeInBytes, destination, uploadedFiles)
+			result = fileUpload.chunkFileUpload(creq, fileInfo, destination, target.Condition)
+		}
+		result.Conflict = conflicts.For(fileInfo.Id)
+		if target.Name != fileInfo.Name {
+			result.UploadedAs = target.Name
+		}
+
+		if result.Err == nil {
+			existing[target.Name] = ""
+			return result
+		}
+		if !errors.Is(result.Err, nextcloud.ErrPreconditionFailed) {
+			return result
+		}
+		if len(target.Condition.Etag) != 0 {
+			result.Err = errors.New("file was changed in Nextcloud after the upload was started, it was not overwritten")
+			return result
 		}
 
+		log.Infof("File %s was created in Nextcloud during the upload, checking the conflict again", target.Name)
+		existing[target.Name] = ""
+		for name, etag := range fileUpload.listExistingFiles(fileUrl) {
+			existing[name] = etag
+		}
 	}
-	return uploadedFiles
+
+	result.Err = errors.New("file name is taken in Nextcloud by other uploads")
+	return result
 }
 
-func (fileUpload FileUploadServiceImpl) chunkFileUpload(creq apps.CallRequest, fileInfo *model.FileInfo, chunkFileSizeInBytes int64, destination string, uploadedFiles []string) []string {
+// listExistingFiles returns the names in the folder with their etags. If the folder cannot be listed, the upload
+// goes on and the conditional requests still keep the existing files.
+func (fileUpload FileUploadServiceImpl) listExistingFiles(fileUrl string) map[string]string {
+	existing, err := fileUpload.folderFilesService.ListFileEtags(fileUrl)
+	if err != nil {
+		log.Warnf("Files of %s were not listed, conflicts are checked by Nextcloud only. Error: %s", fileUrl, err)
+		return map[string]string{}
+	}
+	return existing
+}
+
+func (fileUpload FileUploadServiceImpl) getProgress() UploadProgress {
+	if fileUpload.progress == nil {
+		return noUploadProgress{}
+	}
+	return fileUpload.progress
+}
+
+func (fileUpload FileUploadServiceImpl) chunkFileUpload(creq apps.CallRequest, fileInfo *model.FileInfo, destination string, condition UploadCondition) UploadResult {
 	log.Info("Chunk file uploading")
 	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
 	userId := creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string)
-	chunkFolder := fmt.Sprintf("/%s-%s", "temp", uuid.New().String())
-	chunkUrl := fmt.Sprintf("%s%s%s%s", remoteUrl, "/remote.php/dav/uploads/", userId, chunkFolder)
-	mmfileUrl := fmt.Sprintf("%s/%s/%s", creq.Context.MattermostSiteURL, "api/v4/files", fileInfo.Id)
-	_, err := fileUpload.fileChunkUploadService.getFileChunkService().createChunkFolder(chunkUrl)
-
-	if err != nil {
-		log.Errorf("Chunk folder was not created %s", err.Error())
-		return uploadedFiles
+	progress := fileUpload.getProgress()
+	upload := ChunkUpload{
+		FileInfo:    fileInfo,
+		UploadsUrl:  remoteUrl + "/remote.php/dav/uploads/" + url.PathEscape(userId),
+		Destination: destination,
+		MMFileUrl:   fmt.Sprintf("%s/%s/%s", creq.Context.MattermostSiteURL, "api/v4/files", fileInfo.Id),
+		StateKey:    CreateChunkUploadStateKey(fileInfo.Id, destination),
+		Condition:   condition,
+		Progress: func(uploaded int, total int) {
+			progress.ChunksUploaded(fileInfo.Name, uploaded, total)
+		},
 	}
-	allChunksUpload := fileUpload.fileChunkUploadService.uploadChunks(chunkFileSizeInBytes, fileInfo, chunkUrl, mmfileUrl)
-
-	if allChunksUpload {
-		_, err := fileUpload.fileChunkUploadService.getFileChunkService().assembleChunk(destination, chunkUrl)
 
-		if err != nil {
-			log.Errorf("Chunk was not assembled to NC destination %s with error %s", destination, err.Error())
-			return uploadedFiles
-		}
-		uploadedFiles = append(uploadedFiles, fileInfo.Name)
-		log.Infof("file was uploaded %s", destination)
+	ncFileId, err := fileUpload.fileChunkUploadService.uploadFile(upload)
+	if err != nil {
+		log.Errorf("File %s was not uploaded to NC destination %s with error %s", fileInfo.Name, destination, err.Error())
+		return UploadResult{FileId: fileInfo.Id, Name: fileInfo.Name, Err: err}
 	}
-	return uploadedFiles
+	log.Infof("file was uploaded %s", destination)
+	return UploadResult{FileId: fileInfo.Id, Name: fileInfo.Name, Url: ncFileId}
 }
 
-func (fileUpload FileUploadServiceImpl) fullFileUpload(asBot GetFile, f string, destination string, fileInfo *model.FileInfo, uploadedFiles []string) []string {
+func (fileUpload FileUploadServiceImpl) fullFileUpload(asBot GetFile, f string, destination string, fileInfo *model.FileInfo, condition UploadCondition) UploadResult {
 	log.Info("Full file uploading")
 	file, _, err := asBot.GetFile(f)
 	if err != nil {
 		log.Errorf("File was not downloaded from MM %s with error %s", f, err.Error())
-		return uploadedFiles
+		return UploadResult{FileId: f, Name: fileInfo.Name, Err: errors.New("file was not downloaded from Mattermost")}
 	}
-	_, uploadError := fileUpload.fileFullUploadService.UploadFile(file, destination)
+	resp, uploadError := fileUpload.fileFullUploadService.UploadFile(file, destination, condition)
 	if uploadError != nil {
-		log.Errorf("File %s was not auploaded to NC  with error %s", fileInfo.Id, err.Error())
-		return uploadedFiles
+		log.Errorf("File %s was not uploaded to NC with error %s", fileInfo.Id, uploadError.Error())
+		return UploadResult{FileId: f, Name: fileInfo.Name, Err: uploadError}
 	}
-	uploadedFiles = append(uploadedFiles, fileInfo.Name)
 	log.Infof("file was uploaded %s", fileInfo.Name)
-	return uploadedFiles
+
+	result := UploadResult{FileId: f, Name: fileInfo.Name}
+	if resp != nil {
+		result.Url = resp.Header.Get(fileIdHeader)
+	}
+	return result
+}
+
+// createDavFolderUrl escapes the user id and the folder, so names with spaces or # stay in the path.
+// The url ends with a slash, so file names can be appended.
+func createDavFolderUrl(remoteUrl string, userId string, folder string) string {
+	return remoteUrl + "/remote.php/dav/files/" + escapePath(userId+strings.TrimSuffix(folder, "/")) + "/"
+}
+
+// createFileLink returns the link which opens the uploaded file. The OC-FileId header is the file id padded with zeros
+// and followed by the instance id. Without it the link opens the folder.
+func createFileLink(remoteUrl string, ncFileId string, folder string) string {
+	if i := strings.Index(ncFileId, "oc"); i > 0 {
+		if id, err := strconv.ParseInt(ncFileId[:i], 10, 64); err == nil {
+			return fmt.Sprintf("%s/index.php/f/%d", remoteUrl, id)
+		}
+	}
+	return fmt.Sprintf("%s/index.php/apps/files/?dir=%s", remoteUrl, url.QueryEscape(folder))
 }
@@ -5,8 +5,9 @@ import (
 	"github.com/mattermost/mattermost-plugin-apps/apps"
 	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
 	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	"github.com/prokhorind/nextcloud/function/settings"
 	"net/http"
-	"os"
 	"testing"
 )
 
@@ -26,48 +27,52 @@ func (mock Client4Mock) GetFile(fileId string) ([]byte, *model.Response, error)
 }
 
 type FileFullUploadServiceMock struct {
-	used bool
+	used       bool
+	urls       []string
+	conditions []UploadCondition
+	failures   int
 }
 
-func (f *FileFullUploadServiceMock) UploadFile(file []byte, url string) (*http.Response, error) {
+func (f *FileFullUploadServiceMock) UploadFile(file []byte, url string, condition UploadCondition) (*http.Response, error) {
 	f.used = true
+	f.urls = append(f.urls, url)
+	f.conditions = append(f.conditions, condition)
+	if f.failures > 0 {
+		f.failures--
+		return nil, &nextcloud.RequestError{Method: "PUT", StatusCode: http.StatusPreconditionFailed, Err: nextcloud.ErrPreconditionFailed}
+	}
 	return nil, nil
 }
 
-type FileChunkUploadServiceMock struct {
-	used bool
+type FolderFilesServiceMock struct {
+	listings []map[string]string
 }
 
-func (f FileChunkUploadServiceMock) createChunkFolder(url string) (*http.Response, error) {
-	return nil, nil
+func (f *FolderFilesServiceMock) ListFileEtags(folderUrl string) (map[string]string, error) {
+	if len(f.listings) == 0 {
+		return map[string]string{}, nil
+	}
+	listing := f.listings[0]
+	if len(f.listings) > 1 {
+		f.listings = f.listings[1:]
+	}
+	files := map[string]string{}
+	for name, etag := range listing {
+		files[name] = etag
+	}
+	return files, nil
 }
 
-func (f FileChunkUploadServiceMock) uploadFileChunk(file []byte, start string, end string, baseurl string) (*http.Response, error) {
-	return nil, nil
+type FileChunkUploadServiceMock struct {
+	used bool
 }
 
-func (f *FileChunkUploadServiceMock) assembleChunk(dest string, baseurl string) (*http.Response, error) {
+func (f *FileChunkUploadServiceMock) uploadFile(upload ChunkUpload) (string, error) {
 	f.used = true
-	return nil, nil
-}
-
-func (f FileChunkUploadServiceMock) abortChunkUpload(url string) (*http.Response, error) {
-	return nil, nil
-}
-
-type FileServiceMock struct {
-}
-
-func (s FileServiceMock) GetChunkedFile(path string, from string, to string) (chunk []byte, err error) {
-	return nil, nil
+	return "00000042ocinstance", nil
 }
 
 func TestFileSizeIsValid(t *testing.T) {
-	os.Setenv("MAX_FILE_SIZE_MB", "1")
-	defer os.Unsetenv("MAX_FILE_SIZE_MB")
-	os.Setenv("MAX_FILES_SIZE_MB", "2")
-	defer os.Unsetenv("MAX_FILES_SIZE_MB")
-
 	model := model.NewInfo("name")
 	model.Size = 1024
 	mock := Client4Mock{fileInfo: model}
@@ -77,7 +82,7 @@ func TestFileSizeIsValid(t *testing.T) {
 	var arr []interface{}
 	arr = append(arr, m)
 
-	testedInstance := FileUploadServiceImpl{}
+	testedInstance := FileUploadServiceImpl{settings: settings.Settings{MaxFileSizeMb: 1, MaxFilesSizeMb: 2}}
 	isValid, _ := testedInstance.ValidateFiles(mock, arr)
 
 	if !isValid {
@@ -86,14 +91,6 @@ func TestFileSizeIsValid(t *testing.T) {
 }
 
 func TestFullFileUploadIsUsing(t *testing.T) {
-	os.Setenv("MAX_FILE_SIZE_MB", "1")
-	defer os.Unsetenv("MAX_FILE_SIZE_MB")
-	os.Setenv("CHUNK_FILE_SIZE_MB", "2")
-	defer os.Unsetenv("CHUNK_FILE_SIZE_MB")
-
-	os.Setenv("MAX_FILES_SIZE_MB", "3")
-	defer os.Unsetenv("MAX_FILES_SIZE_MB")
-
 	m := map[string]interface{}{
 		"value": "name",
 	}
@@ -132,21 +129,15 @@ func TestFullFileUploadIsUsing(t *testing.T) {
 	asBotMock := Client4Mock{fileInfo: model}
 
 	fullUpload := FileFullUploadServiceMock{}
-	testedInstance := FileUploadServiceImpl{fileFullUploadService: &fullUpload}
+	testedInstance := FileUploadServiceImpl{fileFullUploadService: &fullUpload, folderFilesService: &FolderFilesServiceMock{}, settings: settings.Settings{MaxFileSizeMb: 1, ChunkFileSizeMb: 2, MaxFilesSizeMb: 3}}
 
 	uploaded := testedInstance.UploadFiles(creq, arr, asBotMock)
-	if len(uploaded) == 0 || !fullUpload.used {
+	if len(uploaded) == 0 || uploaded[0].Err != nil || !fullUpload.used {
 		t.Error("File should upload by full flow")
 	}
 }
 
 func TestChunkFileUploadIsUsing(t *testing.T) {
-	os.Setenv("MAX_FILE_SIZE_MB", "1")
-	defer os.Unsetenv("MAX_FILE_SIZE_MB")
-	os.Setenv("CHUNK_FILE_SIZE_MB", "1")
-	os.Setenv("MAX_FILES_SIZE_MB", "3")
-	defer os.Unsetenv("MAX_FILES_SIZE_MB")
-
 	m := map[string]interface{}{
 		"value": "name",
 	}
@@ -184,23 +175,19 @@ func TestChunkFileUploadIsUsing(t *testing.T) {
 	model.Size = 1024 * 1025
 	asBotMock := Client4Mock{fileInfo: model}
 
-	fileServiceMock := FileServiceMock{}
 	fileChunkUploadServiceMock := FileChunkUploadServiceMock{}
-	chunkFileUploadService := ChunkFileUploadServiceImpl{fileChunkService: &fileChunkUploadServiceMock, MMFileService: fileServiceMock}
-	testedInstance := FileUploadServiceImpl{fileChunkUploadService: &chunkFileUploadService}
+	testedInstance := FileUploadServiceImpl{fileChunkUploadService: &fileChunkUploadServiceMock, folderFilesService: &FolderFilesServiceMock{}, settings: settings.Settings{MaxFileSizeMb: 1, ChunkFileSizeMb: 1, MaxFilesSizeMb: 3}}
 
 	uploaded := testedInstance.UploadFiles(creq, arr, asBotMock)
-	if len(uploaded) == 0 || !fileChunkUploadServiceMock.used {
+	if len(uploaded) == 0 || uploaded[0].Err != nil || !fileChunkUploadServiceMock.used {
 		t.Error("File should upload")
 	}
+	if uploaded[0].Url != "remoteUrk/index.php/f/42" {
+		t.Errorf(" expected %q, actual %q", "remoteUrk/index.php/f/42", uploaded[0].Url)
+	}
 }
 
 func TestFileSizeIsNotValid(t *testing.T) {
-	os.Setenv("MAX_FILES_SIZE_MB", "2")
-	defer os.Unsetenv("MAX_FILES_SIZE_MB")
-	os.Setenv("MAX_FILE_SIZE_MB", "1")
-	defer os.Unsetenv("MAX_FILE_SIZE_MB")
-
 	fileInfo := model.NewInfo("name")
 	fileInfo.Size = 1024 * 1025
 	mock := Client4Mock{fileInfo: fileInfo}
@@ -210,7 +197,7 @@ func TestFileSizeIsNotValid(t *testing.T) {
 	var arr []interface{}
 	arr = append(arr, m)
 
-	testedInstance := FileUploadServiceImpl{}
+	testedInstance := FileUploadServiceImpl{settings: settings.Settings{MaxFileSizeMb: 1, MaxFilesSizeMb: 2}}
 	isValid, _ := testedInstance.ValidateFiles(mock, arr)
 
 	if isValid {
@@ -219,11 +206,6 @@ func TestFileSizeIsNotValid(t *testing.T) {
 }
 
 func TestFilesSizeIsNotValid(t *testing.T) {
-	os.Setenv("MAX_FILES_SIZE_MB", "2")
-	defer os.Unsetenv("MAX_FILES_SIZE_MB")
-	os.Setenv("MAX_FILE_SIZE_MB", "1")
-	defer os.Unsetenv("MAX_FILE_SIZE_MB")
-
 	fileInfo := model.NewInfo("name")
 	fileInfo.Size = 1024 * 1024
 	mock := Client4Mock{fileInfo: fileInfo}
@@ -236,7 +218,7 @@ func TestFilesSizeIsNotValid(t *testing.T) {
 	arr = append(arr, m)
 	arr = append(arr, m)
 
-	testedInstance := FileUploadServiceImpl{}
+	testedInstance := FileUploadServiceImpl{settings: settings.Settings{MaxFileSizeMb: 1, MaxFilesSizeMb: 2}}
 	isValid, _ := testedInstance.ValidateFiles(mock, arr)
 
 	if isValid {
@@ -245,8 +227,6 @@ func TestFilesSizeIsNotValid(t *testing.T) {
 }
 
 func TestFileNotFound(t *testing.T) {
-	os.Setenv("MAX_FILE_SIZE_MB", "1")
-	defer os.Unsetenv("MAX_FILE_SIZE_MB")
 	expected := "Could not get file info for file name with error error"
 
 	mock := Client4Mock{error: errors.New("error")}
@@ -256,7 +236,7 @@ func TestFileNotFound(t *testing.T) {
 	var arr []interface{}
 	arr = append(arr, m)
 
-	testedInstance := FileUploadServiceImpl{}
+	testedInstance := FileUploadServiceImpl{settings: settings.Settings{MaxFileSizeMb: 1}}
 	_, errMsg := testedInstance.ValidateFiles(mock, arr)
 
 	if expected != *errMsg {
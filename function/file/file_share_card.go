@@ -0,0 +1,154 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+const (
+	richdocumentsApp = "richdocuments"
+	onlyofficeApp    = "onlyoffice"
+	previewSize      = 256
+)
+
+var officeMimetypePrefixes = []string{
+	"application/vnd.openxmlformats-officedocument.",
+	"application/vnd.oasis.opendocument.",
+	"application/vnd.ms-excel",
+	"application/vnd.ms-powerpoint",
+	"application/msword",
+}
+
+// mimetypeIcons maps mimetypes to the file type icons of the Nextcloud core, the first matching prefix wins.
+var mimetypeIcons = []struct {
+	prefix string
+	icon   string
+}{
+	{directoryMimeType, "folder"},
+	{"image/", "image"},
+	{"video/", "video"},
+	{"audio/", "audio"},
+	{"application/pdf", "application-pdf"},
+	{"application/vnd.openxmlformats-officedocument.wordprocessingml", "x-office-document"},
+	{"application/vnd.oasis.opendocument.text", "x-office-document"},
+	{"application/msword", "x-office-document"},
+	{"application/vnd.openxmlformats-officedocument.spreadsheetml", "x-office-spreadsheet"},
+	{"application/vnd.oasis.opendocument.spreadsheet", "x-office-spreadsheet"},
+	{"application/vnd.ms-excel", "x-office-spreadsheet"},
+	{"text/csv", "x-office-spreadsheet"},
+	{"application/vnd.openxmlformats-officedocument.presentationml", "x-office-presentation"},
+	{"application/vnd.oasis.opendocument.presentation", "x-office-presentation"},
+	{"application/vnd.ms-powerpoint", "x-office-presentation"},
+	{"application/zip", "package-x-generic"},
+	{"application/x-", "package-x-generic"},
+	{"text/", "text"},
+}
+
+// ShareCopy is the state of the button which saves a shared file to the Nextcloud of the user who clicks it.
+type ShareCopy struct {
+	ShareType  string `json:"share_type"`
+	Token      string `json:"token,omitempty"`
+	ItemSource string `json:"item_source"`
+	Owner      string `json:"owner"`
+	Path       string `json:"path"`
+}
+
+func GetShareCopy(creq apps.CallRequest) ShareCopy {
+	shareCopy := ShareCopy{}
+	data, _ := json.Marshal(creq.Call.State)
+	json.Unmarshal(data, &shareCopy)
+	return shareCopy
+}
+
+func mimetypeIcon(mimetype string) string {
+	for _, m := range mimetypeIcons {
+		if strings.HasPrefix(mimetype, m.prefix) {
+			return m.icon
+		}
+	}
+	return "file"
+}
+
+func isOfficeMimetype(mimetype string) bool {
+	for _, prefix := range officeMimetypePrefixes {
+		if strings.HasPrefix(mimetype, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatFileSize returns the size in the largest unit which keeps it above 1, e.g. 1.5 MB.
+func formatFileSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	for _, unit := range []string{"KB", "MB", "GB"} {
+		value /= 1024
+		if value < 1024 {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+	}
+	return fmt.Sprintf("%.1f TB", value/1024)
+}
+
+// previewUrl returns the thumbnail of the file. A public link has its own preview endpoint, other shares use
+// the core preview, which is shown to users who are logged in to Nextcloud.
+func previewUrl(remoteUrl string, share FileShareModel) string {
+	if share.HasPreview != "1" && share.HasPreview != "true" {
+		return ""
+	}
+	size := strconv.Itoa(previewSize)
+	if share.ShareType == strconv.Itoa(PublicLinkShareType) {
+		return fmt.Sprintf("%s/index.php/apps/files_sharing/publicpreview/%s?x=%s&y=%s&a=1", remoteUrl, url.PathEscape(share.Token), size, size)
+	}
+	return fmt.Sprintf("%s/index.php/core/preview?fileId=%s&x=%s&y=%s&a=1", remoteUrl, url.QueryEscape(share.ItemSource), size, size)
+}
+
+// officeUrl returns the link which opens the file in the installed office app. Recipients of a public link
+// get the office viewer on the link page, so only user and group shares get the link.
+func officeUrl(remoteUrl string, officeApp string, share FileShareModel) string {
+	if share.ShareType == strconv.Itoa(PublicLinkShareType) || !isOfficeMimetype(share.Mimetype) {
+		return ""
+	}
+	switch officeApp {
+	case richdocumentsApp:
+		return fmt.Sprintf("%s/index.php/apps/richdocuments/index?fileId=%s", remoteUrl, url.QueryEscape(share.ItemSource))
+	case onlyofficeApp:
+		return fmt.Sprintf("%s/index.php/apps/onlyoffice/%s", remoteUrl, url.PathEscape(share.ItemSource))
+	}
+	return ""
+}
+
+func createShareCardBinding(openUrl string, officeLink string, share FileShareModel) apps.Binding {
+	buttons := []apps.Binding{
+		{Location: "open", Label: "Open in Nextcloud", Submit: apps.NewCall("/file/open").WithState(openUrl)},
+	}
+	if len(officeLink) != 0 {
+		buttons = append(buttons, apps.Binding{Location: "office", Label: "Open in Office editor", Submit: apps.NewCall("/file/open").WithState(officeLink)})
+	}
+	if share.ItemType == "file" {
+		shareCopy := ShareCopy{ShareType: share.ShareType, Token: share.Token, ItemSource: share.ItemSource, Owner: share.UidFileOwner, Path: share.Path}
+		buttons = append(buttons, apps.Binding{
+			Location: "save",
+			Label:    "Save copy to my Nextcloud",
+			Submit: apps.NewCall("/file/share/save").WithExpand(apps.Expand{
+				ActingUserAccessToken: apps.ExpandAll,
+				OAuth2App:             apps.ExpandAll,
+				OAuth2User:            apps.ExpandAll,
+				ActingUser:            apps.ExpandAll,
+			}).WithState(shareCopy),
+		})
+	}
+	return apps.Binding{
+		Location: "embedded",
+		AppID:    "nextcloud",
+		Bindings: buttons,
+	}
+}
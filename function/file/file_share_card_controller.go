@@ -0,0 +1,57 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/settings"
+)
+
+func HandleFileOpen(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	link := fmt.Sprint(creq.State)
+	c.JSON(http.StatusOK, apps.CallResponse{Type: apps.CallResponseTypeNavigate, NavigateToURL: link})
+}
+
+func HandleShareSave(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	shareCopy := GetShareCopy(creq)
+	if len(shareCopy.Path) == 0 || (shareCopy.ShareType == strconv.Itoa(PublicLinkShareType) && len(shareCopy.Token) == 0) {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Only shared files can be saved")))
+		return
+	}
+
+	client := settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, token.AccessToken)
+	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
+	// the public link is downloaded and uploaded as a stream, a big file must not hit the timeout of a single request
+	copyService := ShareCopyServiceImpl{
+		Url:                remoteUrl + "/remote.php/dav/",
+		RemoteUrl:          remoteUrl,
+		UserId:             creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string),
+		Client:             client.ForStreaming(),
+		folderFilesService: FolderFilesServiceImpl{Client: client},
+	}
+	name, err := copyService.SaveCopy(shareCopy)
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, apps.NewTextResponse("The copy is saved to your Nextcloud as %s", name))
+}
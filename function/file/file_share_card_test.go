@@ -0,0 +1,172 @@
+package file
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+)
+
+func TestFileSizeIsHumanReadable(t *testing.T) {
+	cases := map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KB", 5 * 1024 * 1024: "5.0 MB", 3 * 1024 * 1024 * 1024: "3.0 GB"}
+	for size, expected := range cases {
+		if actual := formatFileSize(size); actual != expected {
+			t.Errorf(" expected %q, actual %q", expected, actual)
+		}
+	}
+}
+
+func TestMimetypeIconIsChosen(t *testing.T) {
+	cases := map[string]string{
+		directoryMimeType: "folder",
+		"image/png":       "image",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": "x-office-spreadsheet",
+		"application/pdf":          "application-pdf",
+		"text/markdown":            "text",
+		"application/octet-stream": "file",
+	}
+	for mimetype, expected := range cases {
+		if actual := mimetypeIcon(mimetype); actual != expected {
+			t.Errorf(" expected %q, actual %q", expected, actual)
+		}
+	}
+}
+
+func TestPreviewIsShownOnlyForFilesWithPreview(t *testing.T) {
+	link := FileShareModel{ShareType: "3", Token: "abc", ItemSource: "42", HasPreview: "1"}
+	share := FileShareModel{ShareType: "0", ItemSource: "42", HasPreview: "true"}
+
+	if actual := previewUrl("https://cloud", link); actual != "https://cloud/index.php/apps/files_sharing/publicpreview/abc?x=256&y=256&a=1" {
+		t.Errorf("Unexpected link preview %q", actual)
+	}
+	if actual := previewUrl("https://cloud", share); actual != "https://cloud/index.php/core/preview?fileId=42&x=256&y=256&a=1" {
+		t.Errorf("Unexpected share preview %q", actual)
+	}
+	share.HasPreview = ""
+	if actual := previewUrl("https://cloud", share); actual != "" {
+		t.Errorf("File without preview should have no thumbnail, actual %q", actual)
+	}
+}
+
+func TestOfficeLinkIsAddedForOfficeFiles(t *testing.T) {
+	share := FileShareModel{ShareType: "0", FileTarget: "/plan.odt", ItemSource: "42", ItemType: "file", Mimetype: "application/vnd.oasis.opendocument.text"}
+	testedInstance := FileSharePostAttachementsImpl{
+		user:      &model.User{Username: "username"},
+		sm:        &share,
+		remoteUrl: "https://cloud",
+		officeApp: richdocumentsApp,
+		details:   &FileDetails{Size: 2048, LastModified: time.Date(2030, 1, 2, 15, 4, 0, 0, time.UTC)},
+	}
+	creq := apps.CallRequest{Context: apps.Context{ExpandedContext: apps.ExpandedContext{Channel: &model.Channel{Id: "channelId"}}}}
+
+	post := testedInstance.CreateFileSharePostWithAttachments(creq)
+
+	buttons := post.GetProps()["app_bindings"].([]apps.Binding)[0].Bindings
+	if len(buttons) != 3 || buttons[1].Submit.State != "https://cloud/index.php/apps/richdocuments/index?fileId=42" {
+		t.Fatalf("Open, office and save buttons expected, actual %+v", buttons)
+	}
+	attachment := post.Attachments()[0]
+	if attachment.Fields[0].Value != "2.0 KB" || attachment.Fields[1].Value != "Jan 2, 2030 15:04 UTC" {
+		t.Errorf("Unexpected details %v, %v", attachment.Fields[0].Value, attachment.Fields[1].Value)
+	}
+	if attachment.FooterIcon != "https://cloud/core/img/filetypes/x-office-document.svg" {
+		t.Errorf("Unexpected icon %q", attachment.FooterIcon)
+	}
+}
+
+func TestFolderShareCannotBeSaved(t *testing.T) {
+	share := FileShareModel{ShareType: "3", URL: "https://cloud/s/abc", ItemType: "folder", Mimetype: directoryMimeType}
+
+	binding := createShareCardBinding(share.URL, officeUrl("https://cloud", onlyofficeApp, share), share)
+
+	if len(binding.Bindings) != 1 || binding.Bindings[0].Submit.State != share.URL {
+		t.Errorf("Only the open button expected, actual %+v", binding.Bindings)
+	}
+}
+
+func TestPublicLinkIsCopiedWithFreeName(t *testing.T) {
+	var uploaded, ifNoneMatch, user string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			user, _, _ = r.BasicAuth()
+			w.Write([]byte("content"))
+		case "PUT":
+			body, _ := io.ReadAll(r.Body)
+			uploaded = r.URL.EscapedPath() + ":" + string(body)
+			ifNoneMatch = r.Header.Get("If-None-Match")
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+	testedInstance := ShareCopyServiceImpl{
+		Url:                server.URL + "/remote.php/dav/",
+		RemoteUrl:          server.URL,
+		UserId:             "user",
+		Client:             nextcloud.NewClient("token"),
+		folderFilesService: &FolderFilesServiceMock{listings: []map[string]string{{"report.pdf": "1"}}},
+	}
+
+	name, err := testedInstance.SaveCopy(ShareCopy{ShareType: "3", Token: "abc", Path: "/Docs/report.pdf"})
+
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if name != "report (2).pdf" || uploaded != "/remote.php/dav/files/user/report%20%282%29.pdf:content" {
+		t.Errorf("Unexpected copy %q, %q", name, uploaded)
+	}
+	if user != "abc" || ifNoneMatch != "*" {
+		t.Errorf("Link should be read by its token and copy should not overwrite files, actual %q, %q", user, ifNoneMatch)
+	}
+}
+
+func TestSharedFileIsCopiedFromRecipientFolder(t *testing.T) {
+	var source, destination, overwrite string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.Write([]byte(`<ocs><data><element><item_source>7</item_source><file_target>/Shared/plan.odt</file_target></element></data></ocs>`))
+		case "COPY":
+			source = r.URL.EscapedPath()
+			destination = r.Header.Get("Destination")
+			overwrite = r.Header.Get("Overwrite")
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+	testedInstance := ShareCopyServiceImpl{
+		Url:                server.URL + "/remote.php/dav/",
+		RemoteUrl:          server.URL,
+		UserId:             "recipient",
+		Client:             nextcloud.NewClient("token"),
+		folderFilesService: &FolderFilesServiceMock{},
+	}
+
+	_, err := testedInstance.SaveCopy(ShareCopy{ShareType: "0", ItemSource: "7", Owner: "owner", Path: "/Team/plan.odt"})
+
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if source != "/remote.php/dav/files/recipient/Shared/plan.odt" || destination != server.URL+"/remote.php/dav/files/recipient/plan.odt" || overwrite != "F" {
+		t.Errorf("Unexpected copy from %q to %q, overwrite %q", source, destination, overwrite)
+	}
+}
+
+func TestOfficeAppIsFoundInCapabilities(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ocs":{"data":{"capabilities":{"files_sharing":{},"onlyoffice":{"editors_check_interval":0}}}}}`))
+	}))
+	defer server.Close()
+	testedInstance := ShareCapabilitiesServiceImpl{Url: server.URL, Client: nextcloud.NewClient("")}
+
+	capabilities, err := testedInstance.GetShareCapabilities()
+
+	if err != nil || capabilities.OfficeApp != onlyofficeApp {
+		t.Errorf(" expected %q, actual %+v, error %v", onlyofficeApp, capabilities, err)
+	}
+}
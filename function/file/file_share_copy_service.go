@@ -0,0 +1,113 @@
+package file
+
+import (
+	"encoding/xml"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	log "github.com/sirupsen/logrus"
+)
+
+type ShareCopyService interface {
+	SaveCopy(shareCopy ShareCopy) (string, error)
+}
+
+// ShareCopyServiceImpl saves a shared file to the root folder of the user. Url is the dav root and RemoteUrl is
+// the Nextcloud address, e.g. https://cloud.
+type ShareCopyServiceImpl struct {
+	Url                string
+	RemoteUrl          string
+	UserId             string
+	Client             nextcloud.Client
+	folderFilesService FolderFilesService
+}
+
+// SaveCopy returns the name of the copy. An existing file is never overwritten, the copy gets a free name instead.
+func (s ShareCopyServiceImpl) SaveCopy(shareCopy ShareCopy) (string, error) {
+	rootUrl := s.Url + "files/" + escapePath(s.UserId) + "/"
+	existing, err := s.folderFilesService.ListFileEtags(rootUrl)
+	if err != nil {
+		return "", errors.New("Your Nextcloud folder was not found")
+	}
+	name := path.Base(shareCopy.Path)
+	if _, ok := existing[name]; ok {
+		name = createFreeName(name, existing)
+	}
+
+	if shareCopy.ShareType == strconv.Itoa(PublicLinkShareType) {
+		return name, s.copyPublicLink(shareCopy.Token, rootUrl+escapePath(name))
+	}
+	return name, s.copyShare(shareCopy, rootUrl+escapePath(name))
+}
+
+// copyPublicLink downloads the file of the link and uploads it, the user may have no access to the shared file itself.
+func (s ShareCopyServiceImpl) copyPublicLink(token string, destination string) error {
+	req, _ := http.NewRequest("GET", s.RemoteUrl+"/public.php/webdav/", nil)
+	req.SetBasicAuth(token, "")
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if errors.Is(err, nextcloud.ErrUnauthorized) {
+		return errors.New("The link is password protected, open it in Nextcloud to save the file")
+	}
+	if err != nil {
+		log.Errorf("Shared file of link %s was not downloaded. Error: %s", token, err)
+		return errors.New("The shared file is not available anymore")
+	}
+	defer resp.Body.Close()
+
+	upload, _ := http.NewRequest("PUT", destination, resp.Body)
+	upload.ContentLength = resp.ContentLength
+	UploadCondition{}.setHeaders(upload)
+	if _, err := s.Client.Do(upload, http.StatusCreated); err != nil {
+		log.Errorf("Copy of link %s was not uploaded. Error: %s", token, err)
+		return errors.New("The copy was not saved to your Nextcloud")
+	}
+	return nil
+}
+
+// copyShare copies a file which is shared with the user or owned by the user on the server.
+func (s ShareCopyServiceImpl) copyShare(shareCopy ShareCopy, destination string) error {
+	source := shareCopy.Path
+	if shareCopy.Owner != s.UserId {
+		target, err := s.findSharedFile(shareCopy.ItemSource)
+		if err != nil {
+			return err
+		}
+		source = target
+	}
+
+	req, _ := http.NewRequest("COPY", s.Url+"files/"+escapePath(s.UserId+source), nil)
+	req.Header.Set("Destination", destination)
+	req.Header.Set("Overwrite", "F")
+	if _, err := s.Client.Do(req, http.StatusCreated); err != nil {
+		log.Errorf("Shared file %s was not copied. Error: %s", source, err)
+		return errors.New("The copy was not saved to your Nextcloud")
+	}
+	return nil
+}
+
+// findSharedFile returns the path of the shared file in the folders of the user.
+func (s ShareCopyServiceImpl) findSharedFile(itemSource string) (string, error) {
+	req, _ := http.NewRequest("GET", s.RemoteUrl+"/ocs/v2.php/apps/files_sharing/api/v1/shares?shared_with_me=true", nil)
+	req.Header.Set("OCS-APIRequest", "true")
+
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if err != nil {
+		log.Errorf("Shares of user %s were not listed. Error: %s", s.UserId, err)
+		return "", errors.New("Request failed during getting of your shares")
+	}
+	defer resp.Body.Close()
+
+	xmlResp := SharedFilesResponseBody{}
+	if err := xml.NewDecoder(resp.Body).Decode(&xmlResp); err != nil {
+		return "", errors.New("Request failed during getting of your shares")
+	}
+	for _, share := range xmlResp.Data.Element {
+		if share.ItemSource == itemSource {
+			return share.FileTarget, nil
+		}
+	}
+	return "", errors.New("The file is not shared with you")
+}
@@ -0,0 +1,197 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/settings"
+	log "github.com/sirupsen/logrus"
+)
+
+func HandleSharesCommand(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	shareService := createFileShareService(c, creq, token.AccessToken)
+	shares, err := shareService.GetAllUserShares()
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Request failed during getting of your shares")))
+		return
+	}
+
+	postService := FileShareListPostServiceImpl{Location: getUserLocation(creq)}
+	post := postService.CreateSharesPost(shares.Data.Element, GetShareAction(creq).Page)
+
+	asBot := appclient.AsBot(creq.Context)
+	if _, err := asBot.DMPost(creq.Context.ActingUser.Id, post); err != nil {
+		log.Errorf("Shares post was not sent to user %s. Error: %s", creq.Context.ActingUser.Id, err)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Your shares were not sent")))
+		return
+	}
+
+	c.JSON(http.StatusOK, apps.NewTextResponse("Your shares are sent in a direct message from the Nextcloud bot"))
+}
+
+func HandleShareEditForm(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	action := GetShareAction(creq)
+	shareService := createFileShareService(c, creq, token.AccessToken)
+	share, err := shareService.GetShare(action.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Share was not found, it may be already removed")))
+		return
+	}
+
+	var field apps.Field
+	if action.Edit == ShareEditPermissions {
+		if share.IsFileDrop() {
+			c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Editing of a file drop cannot be changed")))
+			return
+		}
+		field = apps.Field{
+			Type:  apps.FieldTypeBool,
+			Name:  "AllowEditing",
+			Label: "Allow editing",
+			Value: share.AllowsEditing(),
+		}
+	} else {
+		field = apps.Field{
+			Type:        apps.FieldTypeText,
+			Name:        "Expiration",
+			Label:       "Expiration",
+			Description: "YYYY-MM-DD, leave empty to keep the share without an expiration date",
+			Value:       strings.Split(share.Expiration, " ")[0],
+		}
+	}
+
+	form := &apps.Form{
+		Title:  fmt.Sprintf("Share of %s", share.Path),
+		Icon:   "icon.png",
+		Fields: []apps.Field{field},
+		Submit: shareActionCall("/shares/update", action),
+	}
+
+	c.JSON(http.StatusOK, apps.NewFormResponse(*form))
+}
+
+func HandleShareUpdate(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	action := GetShareAction(creq)
+	shareService := createFileShareService(c, creq, token.AccessToken)
+	capabilitiesService := ShareCapabilitiesServiceImpl{
+		Url:    creq.Context.OAuth2.OAuth2App.RemoteRootURL + "/ocs/v2.php/cloud/capabilities?format=json",
+		Client: shareService.Client,
+	}
+	capabilities, err := capabilitiesService.GetShareCapabilities()
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Request failed during getting of share settings")))
+		return
+	}
+
+	shareType, _ := strconv.Atoi(action.ShareType)
+	options, fieldErrors := ParseFileShareOptions(creq.Values, *capabilities, int32(shareType), time.Now().In(getUserLocation(creq)))
+
+	var values map[string]string
+	if action.Edit == ShareEditPermissions {
+		share, err := shareService.GetShare(action.Id)
+		if err != nil {
+			c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Share was not found, it may be already removed")))
+			return
+		}
+		allowEditing, _ := creq.Values["AllowEditing"].(bool)
+		permissions, err := ToggleEditing(*share, allowEditing)
+		if err != nil {
+			c.JSON(http.StatusOK, apps.NewErrorResponse(err))
+			return
+		}
+		values = map[string]string{"permissions": strconv.Itoa(permissions)}
+	} else {
+		if len(fieldErrors["Expiration"]) != 0 {
+			c.JSON(http.StatusOK, apps.CallResponse{
+				Type: apps.CallResponseTypeError,
+				Text: "Expiration date is not valid",
+				Data: map[string]interface{}{"errors": map[string]string{"Expiration": fieldErrors["Expiration"]}},
+			})
+			return
+		}
+		values = map[string]string{"expireDate": options.ExpireDate}
+	}
+
+	if _, err := shareService.UpdateShare(action.Id, values); err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Share was not updated, Nextcloud may not allow this change")))
+		return
+	}
+
+	c.JSON(http.StatusOK, apps.NewTextResponse("Share of %s is updated", action.Path))
+}
+
+func HandleShareLink(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+
+	action := GetShareAction(creq)
+	c.JSON(http.StatusOK, apps.NewTextResponse("Link to %s:\n```\n%s\n```", action.Path, action.Link))
+}
+
+func HandleShareDelete(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	action := GetShareAction(creq)
+	shareService := createFileShareService(c, creq, token.AccessToken)
+	if err := shareService.DeleteShare(action.Id); err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Share was not removed")))
+		return
+	}
+
+	c.JSON(http.StatusOK, apps.NewTextResponse("Share of %s is removed", action.Path))
+}
+
+func createFileShareService(c *gin.Context, creq apps.CallRequest, accessToken string) FileShareServiceImpl {
+	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
+	return FileShareServiceImpl{
+		Url:    remoteUrl + "/ocs/v2.php/apps/files_sharing/api/v1/shares",
+		Client: settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, accessToken),
+	}
+}
@@ -0,0 +1,177 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	SharesPerPage        = 10
+	ShareEditExpiration  = "expiration"
+	ShareEditPermissions = "permissions"
+)
+
+var shareTypeNames = map[string]string{
+	"0":  "User",
+	"1":  "Group",
+	"3":  "Public link",
+	"4":  "Email",
+	"6":  "Federated",
+	"10": "Talk conversation",
+}
+
+// ShareAction is the state of the buttons in the share list.
+type ShareAction struct {
+	Id        string `json:"id,omitempty"`
+	ShareType string `json:"share_type,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Link      string `json:"link,omitempty"`
+	Edit      string `json:"edit,omitempty"`
+	Page      int    `json:"page"`
+}
+
+func GetShareAction(creq apps.CallRequest) ShareAction {
+	action := ShareAction{}
+	data, _ := json.Marshal(creq.Call.State)
+	json.Unmarshal(data, &action)
+	return action
+}
+
+type FileShareListPostService interface {
+	CreateSharesPost(shares []FileShareModel, page int) *model.Post
+}
+
+type FileShareListPostServiceImpl struct {
+	Location *time.Location
+}
+
+// CreateSharesPost shows one page of the shares, the newest first. Every share has buttons to manage it.
+func (s FileShareListPostServiceImpl) CreateSharesPost(shares []FileShareModel, page int) *model.Post {
+	post := model.Post{}
+	if len(shares) == 0 {
+		post.Message = "You have no shares in Nextcloud"
+		return &post
+	}
+
+	sort.SliceStable(shares, func(i, j int) bool {
+		first, _ := strconv.ParseInt(shares[i].Stime, 10, 64)
+		second, _ := strconv.ParseInt(shares[j].Stime, 10, 64)
+		return first > second
+	})
+
+	pages := (len(shares) + SharesPerPage - 1) / SharesPerPage
+	if page < 0 {
+		page = 0
+	}
+	if page >= pages {
+		page = pages - 1
+	}
+	post.Message = fmt.Sprintf("Your Nextcloud shares, page %d of %d", page+1, pages)
+
+	bindings := make([]apps.Binding, 0)
+	end := page*SharesPerPage + SharesPerPage
+	if end > len(shares) {
+		end = len(shares)
+	}
+	for _, share := range shares[page*SharesPerPage : end] {
+		bindings = append(bindings, s.createShareBinding(share, page))
+	}
+	if pages > 1 {
+		bindings = append(bindings, s.createPagesBinding(page, pages))
+	}
+
+	post.SetProps(map[string]interface{}{"app_bindings": bindings})
+	return &post
+}
+
+func (s FileShareListPostServiceImpl) createShareBinding(share FileShareModel, page int) apps.Binding {
+	action := ShareAction{Id: share.ID, ShareType: share.ShareType, Path: share.Path, Page: page}
+
+	expiresAction, permissionsAction := action, action
+	expiresAction.Edit = ShareEditExpiration
+	permissionsAction.Edit = ShareEditPermissions
+	buttons := []apps.Binding{
+		{Location: apps.Location("expiration-" + share.ID), Label: "Change expiry", Submit: shareActionCall("/shares/edit-form", expiresAction)},
+	}
+	// a file drop has no editing to toggle, its visitors only upload
+	if !share.IsFileDrop() {
+		buttons = append(buttons, apps.Binding{Location: apps.Location("permissions-" + share.ID), Label: "Change permissions", Submit: shareActionCall("/shares/edit-form", permissionsAction)})
+	}
+	if len(share.URL) != 0 {
+		linkAction := action
+		linkAction.Link = share.URL
+		buttons = append(buttons, apps.Binding{Location: apps.Location("link-" + share.ID), Label: "Copy link", Submit: shareActionCall("/shares/link", linkAction)})
+	}
+	buttons = append(buttons, apps.Binding{Location: apps.Location("unshare-" + share.ID), Label: "Unshare", Submit: shareActionCall("/shares/delete", action)})
+
+	return apps.Binding{
+		Location:    "embedded",
+		AppID:       "nextcloud",
+		Label:       share.Path,
+		Description: s.createShareDescription(share),
+		Bindings:    buttons,
+	}
+}
+
+func (s FileShareListPostServiceImpl) createShareDescription(share FileShareModel) string {
+	shareType, ok := shareTypeNames[share.ShareType]
+	if !ok {
+		shareType = "Other"
+	}
+	expires := "Never"
+	if len(share.Expiration) != 0 {
+		expires = strings.Split(share.Expiration, " ")[0]
+	}
+	created := "unknown"
+	if stime, err := strconv.ParseInt(share.Stime, 10, 64); err == nil {
+		created = time.Unix(stime, 0).In(s.Location).Format("Jan 2, 2006 15:04 MST")
+	}
+
+	return fmt.Sprintf("**Type:** %s | **Recipient:** %s | **Expires:** %s | **Created:** %s",
+		shareType, share.GetRecipient(), expires, created)
+}
+
+func (s FileShareListPostServiceImpl) createPagesBinding(page int, pages int) apps.Binding {
+	buttons := make([]apps.Binding, 0)
+	if page > 0 {
+		buttons = append(buttons, apps.Binding{Location: "previous", Label: "Previous page", Submit: shareActionCall("/shares", ShareAction{Page: page - 1})})
+	}
+	if page < pages-1 {
+		buttons = append(buttons, apps.Binding{Location: "next", Label: "Next page", Submit: shareActionCall("/shares", ShareAction{Page: page + 1})})
+	}
+	return apps.Binding{
+		Location: "embedded",
+		AppID:    "nextcloud",
+		Bindings: buttons,
+	}
+}
+
+func shareActionCall(path string, action ShareAction) *apps.Call {
+	return apps.NewCall(path).WithExpand(apps.Expand{
+		OAuth2App:             apps.ExpandAll,
+		OAuth2User:            apps.ExpandAll,
+		ActingUserAccessToken: apps.ExpandAll,
+		ActingUser:            apps.ExpandAll,
+	}).WithState(action)
+}
+
+// ToggleEditing returns the permissions of the share with editing allowed or not. Editing a folder needs the create
+// and delete permissions too, Nextcloud rejects a folder link with only some of them. Resharing is kept.
+func ToggleEditing(share FileShareModel, allowEditing bool) (int, error) {
+	if share.IsFileDrop() {
+		return 0, errors.New("Editing of a file drop cannot be changed")
+	}
+	options := FileShareOptions{Permissions: ReadPermission}
+	if allowEditing {
+		options = FileShareOptions{Permissions: EditPermission}.ForItem(share.ItemType == "folder")
+	}
+	return options.Permissions | share.GetPermissions()&ResharePermission, nil
+}
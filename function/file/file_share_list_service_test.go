@@ -0,0 +1,159 @@
+package file
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+)
+
+func createTestShares(count int) []FileShareModel {
+	shares := make([]FileShareModel, 0)
+	for i := 0; i < count; i++ {
+		shares = append(shares, FileShareModel{ID: fmt.Sprint(i), Path: fmt.Sprintf("/file-%d", i), ShareType: "0", ShareWith: "alice", Stime: fmt.Sprint(1600000000 + i)})
+	}
+	return shares
+}
+
+func TestSharesPostIsPaginated(t *testing.T) {
+	testedInstance := FileShareListPostServiceImpl{Location: time.UTC}
+
+	post := testedInstance.CreateSharesPost(createTestShares(SharesPerPage+2), 1)
+	bindings := post.GetProps()["app_bindings"].([]apps.Binding)
+
+	if post.Message != "Your Nextcloud shares, page 2 of 2" {
+		t.Errorf("Unexpected message %q", post.Message)
+	}
+	if len(bindings) != 3 {
+		t.Fatalf("expected 2 shares and pagination, actual %d bindings", len(bindings))
+	}
+	if bindings[0].Label != "/file-1" || bindings[1].Label != "/file-0" {
+		t.Errorf("The oldest shares should be on the last page, actual %q, %q", bindings[0].Label, bindings[1].Label)
+	}
+	if len(bindings[2].Bindings) != 1 || bindings[2].Bindings[0].Label != "Previous page" {
+		t.Error("Last page should have only a previous page button")
+	}
+}
+
+func TestShareButtons(t *testing.T) {
+	shares := []FileShareModel{
+		{ID: "1", Path: "/link.txt", ShareType: "3", URL: "https://nc/s/abc", Expiration: "2030-01-02 00:00:00", Stime: "1600000000"},
+		{ID: "2", Path: "/user.txt", ShareType: "0", ShareWith: "bob", ShareWithDisplayname: "Bob", Stime: "1500000000"},
+	}
+	testedInstance := FileShareListPostServiceImpl{Location: time.UTC}
+
+	bindings := testedInstance.CreateSharesPost(shares, 0).GetProps()["app_bindings"].([]apps.Binding)
+
+	if len(bindings[0].Bindings) != 4 || bindings[0].Bindings[2].Label != "Copy link" {
+		t.Error("Public link should have a copy link button")
+	}
+	if len(bindings[1].Bindings) != 3 {
+		t.Error("User share should not have a copy link button")
+	}
+	expected := "**Type:** Public link | **Recipient:** Anyone with the link | **Expires:** 2030-01-02 | **Created:** Sep 13, 2020 12:26 UTC"
+	if bindings[0].Description != expected {
+		t.Errorf(" expected %q, actual %q", expected, bindings[0].Description)
+	}
+	if !strings.Contains(bindings[1].Description, "**Recipient:** Bob") {
+		t.Errorf("Unexpected description %q", bindings[1].Description)
+	}
+}
+
+func TestNoSharesPost(t *testing.T) {
+	post := FileShareListPostServiceImpl{Location: time.UTC}.CreateSharesPost(nil, 0)
+
+	if post.Message != "You have no shares in Nextcloud" {
+		t.Errorf("Unexpected message %q", post.Message)
+	}
+}
+
+func TestUpdateAndDeleteShare(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Write([]byte(`<ocs><data><id>7</id><expiration>2030-01-02 00:00:00</expiration></data></ocs>`))
+	}))
+	defer server.Close()
+	testedInstance := FileShareServiceImpl{Url: server.URL + "/shares", Client: nextcloud.Client{}}
+
+	share, err := testedInstance.UpdateShare("7", map[string]string{"expireDate": "2030-01-02"})
+	if err != nil || share.Expiration != "2030-01-02 00:00:00" {
+		t.Errorf("Unexpected update result %v, %v", share, err)
+	}
+	if err := testedInstance.DeleteShare("7"); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+
+	if strings.Join(requests, ",") != "PUT /shares/7,DELETE /shares/7" {
+		t.Errorf("Unexpected requests %v", requests)
+	}
+}
+
+func TestLinkIsRemovedWhenDownloadIsNotHidden(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == "PUT" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`<ocs><data><id>7</id></data></ocs>`))
+	}))
+	defer server.Close()
+	testedInstance := FileShareServiceImpl{Url: server.URL + "/shares", Client: nextcloud.Client{}}
+
+	share, err := testedInstance.CreateUserShare("/file.txt", PublicLinkShareType, FileShareOptions{Permissions: ReadPermission, HideDownload: true})
+
+	if err == nil || share != nil {
+		t.Error("Link with a visible download should not be returned")
+	}
+	if strings.Join(requests, ",") != "POST /shares,PUT /shares/7,DELETE /shares/7" {
+		t.Errorf("Unexpected requests %v", requests)
+	}
+}
+
+func TestToggleEditingByItemType(t *testing.T) {
+	tests := []struct {
+		itemType     string
+		permissions  string
+		allowEditing bool
+		expected     int
+	}{
+		{"file", "1", true, 3},
+		{"file", "3", false, 1},
+		{"file", "19", false, 17},
+		{"file", "17", true, 19},
+		{"folder", "1", true, 15},
+		{"folder", "15", false, 1},
+		{"folder", "31", false, 17},
+		{"folder", "3", true, 15},
+	}
+	for _, test := range tests {
+		actual, err := ToggleEditing(FileShareModel{ItemType: test.itemType, Permissions: test.permissions}, test.allowEditing)
+		if err != nil || actual != test.expected {
+			t.Errorf(" expected %d, actual %d", test.expected, actual)
+		}
+	}
+
+	if _, err := ToggleEditing(FileShareModel{Permissions: "4"}, true); err == nil {
+		t.Error("Editing of a file drop should not be changed")
+	}
+}
+
+func TestFileDropHasNoPermissionsButton(t *testing.T) {
+	shares := []FileShareModel{{ID: "1", Path: "/Drop", ShareType: "3", Permissions: "4", URL: "https://nc/s/drop", Stime: "1600000000"}}
+	testedInstance := FileShareListPostServiceImpl{Location: time.UTC}
+
+	bindings := testedInstance.CreateSharesPost(shares, 0).GetProps()["app_bindings"].([]apps.Binding)
+
+	for _, button := range bindings[0].Bindings {
+		if button.Label == "Change permissions" {
+			t.Error("File drop should not have a permissions button")
+		}
+	}
+}
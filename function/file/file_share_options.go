@@ -0,0 +1,246 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	PublicLinkShareType = 3
+	ReadPermission      = 1
+	// UpdatePermission is the bit of the permissions which allows to change the content of a share.
+	UpdatePermission = 2
+	// EditPermission allows to read and update a shared file.
+	EditPermission = 3
+	// FolderEditPermission allows to read, update, create and delete the files of a shared folder.
+	FolderEditPermission = 15
+	// ResharePermission lets the recipient of a user or group share share it further.
+	ResharePermission = 16
+	// DropPermission lets visitors of a folder link upload files without seeing the content of the folder.
+	DropPermission  = 4
+	shareDateFormat = "2006-01-02"
+)
+
+type FileShareOptions struct {
+	ExpireDate   string
+	Password     string
+	Permissions  int
+	HideDownload bool
+	Note         string
+	Label        string
+	// ShareWith is the Nextcloud user or group of a user or group share.
+	ShareWith string
+}
+
+// IsDefault reports whether the options describe a plain read-only share, which can be reused from an existing share.
+func (o FileShareOptions) IsDefault() bool {
+	return o == FileShareOptions{Permissions: ReadPermission, ShareWith: o.ShareWith}
+}
+
+// ForItem returns the options for a file or a folder, editing a folder needs the create and delete permissions as well.
+func (o FileShareOptions) ForItem(isFolder bool) FileShareOptions {
+	if isFolder && o.Permissions == EditPermission {
+		o.Permissions = FolderEditPermission
+	}
+	return o
+}
+
+type ShareCapabilities struct {
+	Public struct {
+		Enabled  bool `json:"enabled"`
+		Password struct {
+			Enforced bool `json:"enforced"`
+		} `json:"password"`
+		ExpireDate struct {
+			Enabled  bool `json:"enabled"`
+			Days     int  `json:"days"`
+			Enforced bool `json:"enforced"`
+		} `json:"expire_date"`
+	} `json:"public"`
+	// OfficeApp is richdocuments or onlyoffice if one of them is installed.
+	OfficeApp string `json:"-"`
+}
+
+type shareCapabilitiesResponse struct {
+	Ocs struct {
+		Data struct {
+			Capabilities struct {
+				FilesSharing  ShareCapabilities `json:"files_sharing"`
+				Richdocuments json.RawMessage   `json:"richdocuments"`
+				Onlyoffice    json.RawMessage   `json:"onlyoffice"`
+			} `json:"capabilities"`
+		} `json:"data"`
+	} `json:"ocs"`
+}
+
+type ShareCapabilitiesService interface {
+	GetShareCapabilities() (*ShareCapabilities, error)
+}
+
+type ShareCapabilitiesServiceImpl struct {
+	Url    string
+	Client nextcloud.Client
+}
+
+func (s ShareCapabilitiesServiceImpl) GetShareCapabilities() (*ShareCapabilities, error) {
+	req, _ := http.NewRequest("GET", s.Url, nil)
+	req.Header.Set("OCS-APIRequest", "true")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if err != nil {
+		log.Errorf("Error during getting of share capabilities. Error: %s", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	capabilities := shareCapabilitiesResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&capabilities); err != nil {
+		return nil, errors.Wrap(err, "Nextcloud capabilities response is not valid")
+	}
+	result := capabilities.Ocs.Data.Capabilities
+	switch {
+	case len(result.Richdocuments) != 0:
+		result.FilesSharing.OfficeApp = richdocumentsApp
+	case len(result.Onlyoffice) != 0:
+		result.FilesSharing.OfficeApp = onlyofficeApp
+	}
+	return &result.FilesSharing, nil
+}
+
+// DefaultExpireDate returns the expiration date proposed by the server, or an empty string if there is no default.
+func (c ShareCapabilities) DefaultExpireDate(now time.Time) string {
+	if !c.Public.ExpireDate.Enabled || c.Public.ExpireDate.Days <= 0 {
+		return ""
+	}
+	return now.AddDate(0, 0, c.Public.ExpireDate.Days).Format(shareDateFormat)
+}
+
+// ParseFileShareOptions reads the options from the share form and checks them against the server policy.
+// A password, a label and a hidden download exist only for public links. A returned map contains errors for the form fields.
+func ParseFileShareOptions(values map[string]interface{}, capabilities ShareCapabilities, shareType int32, now time.Time) (FileShareOptions, map[string]string) {
+	options := FileShareOptions{Permissions: ReadPermission}
+	fieldErrors := map[string]string{}
+	isLink := shareType == PublicLinkShareType
+
+	options.ExpireDate = strings.TrimSpace(getString(values, "Expiration"))
+	options.Note = strings.TrimSpace(getString(values, "Note"))
+	if allowEditing, _ := values["AllowEditing"].(bool); allowEditing {
+		options.Permissions = EditPermission
+	}
+	if isLink {
+		options.Password = getString(values, "Password")
+		options.Label = strings.TrimSpace(getString(values, "Label"))
+		options.HideDownload, _ = values["HideDownload"].(bool)
+	}
+
+	if len(options.ExpireDate) == 0 {
+		if isLink && capabilities.Public.ExpireDate.Enforced {
+			fieldErrors["Expiration"] = "Nextcloud requires an expiration date for shared links"
+		}
+	} else if expireDate, err := time.ParseInLocation(shareDateFormat, options.ExpireDate, now.Location()); err != nil {
+		fieldErrors["Expiration"] = "Expiration date must have YYYY-MM-DD format"
+	} else if !expireDate.After(now) {
+		fieldErrors["Expiration"] = "Expiration date must be in the future"
+	} else if isLink && capabilities.Public.ExpireDate.Enforced && capabilities.Public.ExpireDate.Days > 0 &&
+		expireDate.After(now.AddDate(0, 0, capabilities.Public.ExpireDate.Days)) {
+		fieldErrors["Expiration"] = fmt.Sprintf("Nextcloud allows shared links for at most %d days", capabilities.Public.ExpireDate.Days)
+	}
+
+	if isLink && len(options.Password) == 0 && capabilities.Public.Password.Enforced {
+		fieldErrors["Password"] = "Nextcloud requires a password for shared links"
+	}
+
+	return options, fieldErrors
+}
+
+func createFileShareOptionFields(values map[string]interface{}, capabilities ShareCapabilities, shareType int32, now time.Time) []apps.Field {
+	allowEditing, _ := values["AllowEditing"].(bool)
+	if shareType != PublicLinkShareType {
+		return []apps.Field{
+			{
+				Type:        "text",
+				Name:        "Expiration",
+				Label:       "Expiration",
+				Description: "The access ends after this date, YYYY-MM-DD",
+				Value:       getString(values, "Expiration"),
+			},
+			{
+				Type:  "bool",
+				Name:  "AllowEditing",
+				Label: "Allow editing",
+				Value: allowEditing,
+			},
+			{
+				Type:        "text",
+				TextSubtype: apps.TextFieldSubtypeTextarea,
+				Name:        "Note",
+				Label:       "Note",
+				Value:       getString(values, "Note"),
+			},
+		}
+	}
+
+	expiration := getString(values, "Expiration")
+	if _, ok := values["Expiration"]; !ok {
+		expiration = capabilities.DefaultExpireDate(now)
+	}
+	hideDownload, _ := values["HideDownload"].(bool)
+
+	return []apps.Field{
+		{
+			Type:        "text",
+			Name:        "Expiration",
+			Label:       "Expiration",
+			Description: "The link stops working after this date, YYYY-MM-DD",
+			IsRequired:  capabilities.Public.ExpireDate.Enforced,
+			Value:       expiration,
+		},
+		{
+			Type:        "text",
+			TextSubtype: apps.TextFieldSubtypePassword,
+			Name:        "Password",
+			Label:       "Password",
+			IsRequired:  capabilities.Public.Password.Enforced,
+			Value:       getString(values, "Password"),
+		},
+		{
+			Type:  "bool",
+			Name:  "AllowEditing",
+			Label: "Allow editing",
+			Value: allowEditing,
+		},
+		{
+			Type:  "bool",
+			Name:  "HideDownload",
+			Label: "Hide download",
+			Value: hideDownload,
+		},
+		{
+			Type:  "text",
+			Name:  "Label",
+			Label: "Label",
+			Value: getString(values, "Label"),
+		},
+		{
+			Type:        "text",
+			TextSubtype: apps.TextFieldSubtypeTextarea,
+			Name:        "Note",
+			Label:       "Note",
+			Value:       getString(values, "Note"),
+		},
+	}
+}
+
+func getString(values map[string]interface{}, name string) string {
+	value, _ := values[name].(string)
+	return value
+}
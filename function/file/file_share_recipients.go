@@ -0,0 +1,149 @@
+package file
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	"github.com/prokhorind/nextcloud/function/oauth"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	UserShareType  = 0
+	GroupShareType = 1
+
+	ShareWithLink    = "link"
+	ShareWithUsers   = "users"
+	ShareWithGroup   = "group"
+	ShareWithChannel = "channel"
+)
+
+// ShareWithOptions lists who can get a file. A public link is offered only if Nextcloud allows it.
+func ShareWithOptions(publicLinksEnabled bool) []apps.SelectOption {
+	options := make([]apps.SelectOption, 0)
+	if publicLinksEnabled {
+		options = append(options, apps.SelectOption{Label: "Public link", Value: ShareWithLink})
+	}
+	return append(options,
+		apps.SelectOption{Label: "Mattermost users", Value: ShareWithUsers},
+		apps.SelectOption{Label: "Nextcloud group", Value: ShareWithGroup},
+		apps.SelectOption{Label: "Connected members of this channel", Value: ShareWithChannel},
+	)
+}
+
+// GetShareType returns the Nextcloud share type for the share form choice.
+func GetShareType(shareWith string) int32 {
+	switch shareWith {
+	case ShareWithUsers, ShareWithChannel:
+		return UserShareType
+	case ShareWithGroup:
+		return GroupShareType
+	default:
+		return PublicLinkShareType
+	}
+}
+
+type KVClient interface {
+	KVGet(prefix, id string, ref interface{}) error
+}
+
+type ShareRecipients struct {
+	NcUserIds    []string
+	NotConnected []string
+}
+
+type ShareRecipientsService interface {
+	ResolveUsers(users []*model.User, actingUserId string) ShareRecipients
+}
+
+type ShareRecipientsServiceImpl struct {
+	AsBot KVClient
+}
+
+// ResolveUsers finds the Nextcloud accounts of the Mattermost users. Bots and the acting user are skipped,
+// users without a connected account are returned by username.
+func (s ShareRecipientsServiceImpl) ResolveUsers(users []*model.User, actingUserId string) ShareRecipients {
+	recipients := ShareRecipients{NcUserIds: make([]string, 0), NotConnected: make([]string, 0)}
+	added := map[string]bool{}
+
+	for _, u := range users {
+		if u.Id == actingUserId || u.IsBot {
+			continue
+		}
+		var ncUserId string
+		s.AsBot.KVGet("", oauth.MmUserKvKey+u.Id, &ncUserId)
+		if len(ncUserId) == 0 {
+			recipients.NotConnected = append(recipients.NotConnected, u.Username)
+			continue
+		}
+		if !added[ncUserId] {
+			added[ncUserId] = true
+			recipients.NcUserIds = append(recipients.NcUserIds, ncUserId)
+		}
+	}
+	return recipients
+}
+
+type shareesResponse struct {
+	Ocs struct {
+		Data struct {
+			Exact struct {
+				Groups []sharee `json:"groups"`
+			} `json:"exact"`
+			Groups []sharee `json:"groups"`
+		} `json:"data"`
+	} `json:"ocs"`
+}
+
+type sharee struct {
+	Label string `json:"label"`
+	Value struct {
+		ShareWith string `json:"shareWith"`
+	} `json:"value"`
+}
+
+type ShareeService interface {
+	SearchGroups(query string) ([]apps.SelectOption, error)
+}
+
+type ShareeServiceImpl struct {
+	Url    string
+	Client nextcloud.Client
+}
+
+func (s ShareeServiceImpl) SearchGroups(query string) ([]apps.SelectOption, error) {
+	params := url.Values{}
+	params.Set("search", query)
+	params.Set("itemType", "file")
+	params.Set("shareType", strconv.Itoa(GroupShareType))
+	params.Set("perPage", strconv.Itoa(FileSearchLimit))
+	params.Set("format", "json")
+
+	req, _ := http.NewRequest("GET", s.Url+"?"+params.Encode(), nil)
+	req.Header.Set("OCS-APIRequest", "true")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if err != nil {
+		log.Errorf("Error during search of groups. Error: %s", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	sharees := shareesResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&sharees); err != nil {
+		return nil, errors.Wrap(err, "Nextcloud sharees response is not valid")
+	}
+
+	options := make([]apps.SelectOption, 0)
+	for _, group := range append(sharees.Ocs.Data.Exact.Groups, sharees.Ocs.Data.Groups...) {
+		options = append(options, apps.SelectOption{Label: group.Label, Value: group.Value.ShareWith})
+	}
+	return options, nil
+}
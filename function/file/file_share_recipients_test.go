@@ -0,0 +1,72 @@
+package file
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+)
+
+type KVClientMock struct {
+	values map[string]string
+}
+
+func (m KVClientMock) KVGet(prefix, id string, ref interface{}) error {
+	*ref.(*string) = m.values[id]
+	return nil
+}
+
+func TestResolveUsersReportsNotConnectedUsers(t *testing.T) {
+	kv := KVClientMock{values: map[string]string{"mm-user-u1": "alice", "mm-user-u2": "alice", "mm-user-me": "me"}}
+	users := []*model.User{
+		{Id: "me", Username: "me"},
+		{Id: "u1", Username: "alice"},
+		{Id: "u2", Username: "alice2"},
+		{Id: "u3", Username: "bob"},
+		{Id: "bot", Username: "bot", IsBot: true},
+	}
+	testedInstance := ShareRecipientsServiceImpl{AsBot: kv}
+
+	recipients := testedInstance.ResolveUsers(users, "me")
+
+	if len(recipients.NcUserIds) != 1 || recipients.NcUserIds[0] != "alice" {
+		t.Errorf("Unexpected Nextcloud users %v", recipients.NcUserIds)
+	}
+	if len(recipients.NotConnected) != 1 || recipients.NotConnected[0] != "bob" {
+		t.Errorf("Unexpected not connected users %v", recipients.NotConnected)
+	}
+}
+
+func TestSearchGroups(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Write([]byte(`{"ocs":{"data":{"exact":{"groups":[{"label":"Admins","value":{"shareType":1,"shareWith":"admin"}}]},"groups":[{"label":"Admins team","value":{"shareType":1,"shareWith":"admin-team"}}]}}}`))
+	}))
+	defer server.Close()
+
+	testedInstance := ShareeServiceImpl{Url: server.URL, Client: nextcloud.Client{}}
+	options, err := testedInstance.SearchGroups("admin")
+
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if !strings.Contains(query, "search=admin") || !strings.Contains(query, "shareType=1") {
+		t.Errorf("Unexpected query %q", query)
+	}
+	if len(options) != 2 || options[0].Value != "admin" || options[1].Label != "Admins team" {
+		t.Errorf("Unexpected groups %v", options)
+	}
+}
+
+func TestShareTypeForShareWith(t *testing.T) {
+	expected := map[string]int32{ShareWithLink: PublicLinkShareType, ShareWithUsers: UserShareType, ShareWithChannel: UserShareType, ShareWithGroup: GroupShareType}
+	for shareWith, shareType := range expected {
+		if actual := GetShareType(shareWith); actual != shareType {
+			t.Errorf("%s: expected %d, actual %d", shareWith, shareType, actual)
+		}
+	}
+}
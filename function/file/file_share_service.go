@@ -5,20 +5,28 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"fmt"
-	"github.com/hashicorp/go-retryablehttp"
 	"github.com/mattermost/mattermost-plugin-apps/apps"
 	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
 	log "github.com/sirupsen/logrus"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type FileSharesInfo struct {
 	shareService FileShareService
 }
 
-func (s FileSharesInfo) GetSharesInfo(filePath string, shareType int32) (*FileShareModel, error) {
+// GetSharesInfo returns a share of the file. An existing link is reused only if it is a plain read-only link,
+// a link with options is created every time, so it gets exactly the requested protection.
+// Nextcloud keeps one share per user or group, so an existing one is reused and updated with the requested options.
+func (s FileSharesInfo) GetSharesInfo(filePath string, shareType int32, options FileShareOptions) (*FileShareModel, error) {
+	if shareType == PublicLinkShareType && !options.IsDefault() {
+		return s.shareService.CreateUserShare(filePath, shareType, options)
+	}
+
 	shares, err := s.shareService.GetAllUserShares()
 
 	if err != nil {
@@ -26,48 +34,58 @@ func (s FileSharesInfo) GetSharesInfo(filePath string, shareType int32) (*FileSh
 	}
 
 	for _, el := range shares.Data.Element {
-		if el.Path == filePath {
-			return &el, nil
+		if el.Path == filePath && el.ShareType == strconv.Itoa(int(shareType)) && el.ShareWith == options.ShareWith {
+			if shareType == PublicLinkShareType {
+				return &el, nil
+			}
+			return s.updateExistingShare(el, options)
 		}
 	}
 
-	return s.shareService.CreateUserShare(filePath, shareType)
+	return s.shareService.CreateUserShare(filePath, shareType, options)
+}
+
+// updateExistingShare applies the requested permissions, and the expiry and the note when they are given,
+// if they differ from the existing share. Resharing of the existing share is kept.
+func (s FileSharesInfo) updateExistingShare(share FileShareModel, options FileShareOptions) (*FileShareModel, error) {
+	values := map[string]string{}
+	if permissions := options.Permissions | share.GetPermissions()&ResharePermission; permissions != share.GetPermissions() {
+		values["permissions"] = strconv.Itoa(permissions)
+	}
+	if len(options.ExpireDate) != 0 && !strings.HasPrefix(share.Expiration, options.ExpireDate) {
+		values["expireDate"] = options.ExpireDate
+	}
+	if len(options.Note) != 0 && options.Note != share.Note {
+		values["note"] = options.Note
+	}
+	if len(values) == 0 {
+		return &share, nil
+	}
+	return s.shareService.UpdateShare(share.ID, values)
 }
 
 type FileShareService interface {
 	GetAllUserShares() (*SharedFilesResponseBody, error)
-	CreateUserShare(filePath string, shareType int32) (*FileShareModel, error)
+	CreateUserShare(filePath string, shareType int32, options FileShareOptions) (*FileShareModel, error)
+	UpdateShare(id string, values map[string]string) (*FileShareModel, error)
 }
 
 type FileShareServiceImpl struct {
-	Url   string
-	Token string
+	Url    string
+	Client nextcloud.Client
 }
 
 func (s FileShareServiceImpl) GetAllUserShares() (*SharedFilesResponseBody, error) {
 
 	req, _ := http.NewRequest("GET", s.Url, nil)
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.Token))
 	req.Header.Set("OCS-APIRequest", "true")
 
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
-
-	client := retryClient.StandardClient()
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
-
+	resp, err := s.Client.Do(req, http.StatusOK)
 	if err != nil {
 		log.Errorf("Error during getting of user shares. Error: %s", err)
 		return nil, err
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Errorf("request failed with status %s", resp.Status)
-		error := fmt.Errorf("request failed with code %d", resp.StatusCode)
-		return nil, error
-	}
+	defer resp.Body.Close()
 
 	xmlResp := SharedFilesResponseBody{}
 	xml.NewDecoder(resp.Body).Decode(&xmlResp)
@@ -75,38 +93,110 @@ func (s FileShareServiceImpl) GetAllUserShares() (*SharedFilesResponseBody, erro
 	return &xmlResp, err
 }
 
-func (s FileShareServiceImpl) CreateUserShare(filePath string, shareType int32) (*FileShareModel, error) {
-	payload := FileShareRequestBody{filePath, shareType}
+func (s FileShareServiceImpl) CreateUserShare(filePath string, shareType int32, options FileShareOptions) (*FileShareModel, error) {
+	payload := FileShareRequestBody{
+		Path:        filePath,
+		ShareType:   shareType,
+		Permissions: options.Permissions,
+		Password:    options.Password,
+		ExpireDate:  options.ExpireDate,
+		Note:        options.Note,
+		Label:       options.Label,
+		ShareWith:   options.ShareWith,
+	}
 	body, _ := json.Marshal(payload)
 
 	req, _ := http.NewRequest("POST", s.Url, bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.Token))
 	req.Header.Set("OCS-APIRequest", "true")
 
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
-
-	client := retryClient.StandardClient()
-	resp, err := client.Do(req)
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if err != nil {
+		log.Errorf("Error during creating of user share. Error: %s", err)
+		return nil, err
+	}
 	defer resp.Body.Close()
 
+	xmlResp := SharedFileResponseBody{}
+	xml.NewDecoder(resp.Body).Decode(&xmlResp)
+
+	if options.HideDownload {
+		return s.hideDownload(xmlResp.Data)
+	}
+	return &xmlResp.Data, err
+}
+
+// hideDownload updates the created share, because the OCS API accepts hideDownload only for existing shares.
+// A link which stays downloadable is removed, so it is never handed out with less protection than requested.
+func (s FileShareServiceImpl) hideDownload(share FileShareModel) (*FileShareModel, error) {
+	updated, err := s.UpdateShare(share.ID, map[string]string{"hideDownload": "true"})
 	if err != nil {
-		log.Errorf("Error during creating of user share. Error: %s", err)
+		if deleteErr := s.DeleteShare(share.ID); deleteErr != nil {
+			log.Errorf("Share %s with a visible download was not removed. Error: %s", share.ID, deleteErr)
+		}
 		return nil, err
 	}
+	return updated, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		log.Errorf("request failed with status %s", resp.Status)
-		error := fmt.Errorf("request failed with code %d", resp.StatusCode)
-		return nil, error
+type FileShareManagementService interface {
+	GetShare(id string) (*FileShareModel, error)
+	UpdateShare(id string, values map[string]string) (*FileShareModel, error)
+	DeleteShare(id string) error
+}
+
+func (s FileShareServiceImpl) GetShare(id string) (*FileShareModel, error) {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/%s", s.Url, id), nil)
+	req.Header.Set("OCS-APIRequest", "true")
+
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if err != nil {
+		log.Errorf("Error during getting of share %s. Error: %s", id, err)
+		return nil, err
 	}
+	defer resp.Body.Close()
+
+	xmlResp := SharedFilesResponseBody{}
+	xml.NewDecoder(resp.Body).Decode(&xmlResp)
+	if len(xmlResp.Data.Element) == 0 {
+		return nil, nextcloud.ErrNotFound
+	}
+
+	return &xmlResp.Data.Element[0], nil
+}
+
+// UpdateShare changes the given attributes of the share, e.g. expireDate or permissions.
+func (s FileShareServiceImpl) UpdateShare(id string, values map[string]string) (*FileShareModel, error) {
+	body, _ := json.Marshal(values)
+
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("%s/%s", s.Url, id), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("OCS-APIRequest", "true")
+
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if err != nil {
+		log.Errorf("Error during updating of share %s. Error: %s", id, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
 
 	xmlResp := SharedFileResponseBody{}
 	xml.NewDecoder(resp.Body).Decode(&xmlResp)
 
-	return &xmlResp.Data, err
+	return &xmlResp.Data, nil
+}
+
+func (s FileShareServiceImpl) DeleteShare(id string) error {
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/%s", s.Url, id), nil)
+	req.Header.Set("OCS-APIRequest", "true")
+
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if err != nil {
+		log.Errorf("Error during deleting of share %s. Error: %s", id, err)
+		return err
+	}
+	resp.Body.Close()
+	return nil
 }
 
 type FileSharePostAttachements interface {
@@ -114,8 +204,13 @@ type FileSharePostAttachements interface {
 }
 
 type FileSharePostAttachementsImpl struct {
-	user *model.User
-	sm   *FileShareModel
+	user       *model.User
+	sm         *FileShareModel
+	remoteUrl  string
+	sharedWith []string
+	details    *FileDetails
+	officeApp  string
+	location   *time.Location
 }
 
 func (f FileSharePostAttachementsImpl) CreateFileSharePostWithAttachments(creq apps.CallRequest) *model.Post {
@@ -124,6 +219,7 @@ func (f FileSharePostAttachementsImpl) CreateFileSharePostWithAttachments(creq a
 	post.ChannelId = creq.Context.Channel.Id
 	attachments := f.createAttachments()
 	post.AddProp("attachments", attachments)
+	post.AddProp("app_bindings", []apps.Binding{createShareCardBinding(f.titleLink(), officeUrl(f.remoteUrl, f.officeApp, *f.sm), *f.sm)})
 	return &post
 }
 
@@ -131,11 +227,77 @@ func (f FileSharePostAttachementsImpl) createAttachments() []*model.SlackAttachm
 	attachment := model.SlackAttachment{}
 	attachment.AuthorName = f.user.Username
 	attachment.Title = f.sm.FileTarget[1:]
-	attachment.TitleLink = f.sm.URL
+	if f.sm.Permissions == strconv.Itoa(DropPermission) {
+		attachment.Pretext = "File drop: anyone with the link can upload files to this folder without seeing its content"
+	}
+	attachment.TitleLink = f.titleLink()
 	attachment.Footer = f.sm.Mimetype
+	attachment.FooterIcon = fmt.Sprintf("%s/core/img/filetypes/%s.svg", f.remoteUrl, mimetypeIcon(f.sm.Mimetype))
+	attachment.ThumbURL = previewUrl(f.remoteUrl, *f.sm)
+	attachment.Fields = append(f.createDetailsFields(), f.createShareFields()...)
 
 	attachments := make([]*model.SlackAttachment, 0)
 
 	attachments = append(attachments, &attachment)
 	return attachments
 }
+
+// titleLink returns the public link, or the internal link of the file for user and group shares.
+func (f FileSharePostAttachementsImpl) titleLink() string {
+	if len(f.sm.URL) != 0 {
+		return f.sm.URL
+	}
+	return fmt.Sprintf("%s/index.php/f/%s", f.remoteUrl, f.sm.ItemSource)
+}
+
+// createDetailsFields shows the size and the last modification when the details of the file were found.
+func (f FileSharePostAttachementsImpl) createDetailsFields() []*model.SlackAttachmentField {
+	if f.details == nil {
+		return []*model.SlackAttachmentField{}
+	}
+	location := f.location
+	if location == nil {
+		location = time.UTC
+	}
+	fields := []*model.SlackAttachmentField{{Title: "Size", Value: formatFileSize(f.details.Size), Short: true}}
+	if !f.details.LastModified.IsZero() {
+		fields = append(fields, &model.SlackAttachmentField{Title: "Modified", Value: f.details.LastModified.In(location).Format("Jan 2, 2006 15:04 MST"), Short: true})
+	}
+	return fields
+}
+
+func (f FileSharePostAttachementsImpl) createShareFields() []*model.SlackAttachmentField {
+	expires := "Never"
+	if len(f.sm.Expiration) != 0 {
+		expires = strings.Split(f.sm.Expiration, " ")[0]
+	}
+	protection := "No password"
+	if f.sm.IsPasswordProtected() {
+		protection = "Password protected"
+	}
+	access := "View only"
+	if f.sm.Permissions == strconv.Itoa(DropPermission) {
+		access = "Upload only"
+	} else if f.sm.Permissions != "" && f.sm.Permissions != strconv.Itoa(ReadPermission) {
+		access = "Editing allowed"
+	}
+	if f.sm.HideDownload == "1" {
+		access += ", download hidden"
+	}
+
+	fields := []*model.SlackAttachmentField{{Title: "Expires", Value: expires, Short: true}}
+	if f.sm.ShareType == strconv.Itoa(PublicLinkShareType) {
+		fields = append(fields, &model.SlackAttachmentField{Title: "Protection", Value: protection, Short: true})
+	}
+	fields = append(fields, &model.SlackAttachmentField{Title: "Access", Value: access, Short: true})
+	if len(f.sharedWith) != 0 {
+		fields = append(fields, &model.SlackAttachmentField{Title: "Shared with", Value: strings.Join(f.sharedWith, ", ")})
+	}
+	if len(f.sm.Label) != 0 {
+		fields = append(fields, &model.SlackAttachmentField{Title: "Label", Value: f.sm.Label, Short: true})
+	}
+	if len(f.sm.Note) != 0 {
+		fields = append(fields, &model.SlackAttachmentField{Title: "Note", Value: f.sm.Note})
+	}
+	return fields
+}
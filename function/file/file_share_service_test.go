@@ -1,9 +1,13 @@
 package file
 
 import (
+	"fmt"
+
 	"github.com/mattermost/mattermost-plugin-apps/apps"
 	"github.com/mattermost/mattermost-server/v6/model"
+	"strconv"
 	"testing"
+	"time"
 )
 
 type FileShareServiceTestMock struct {
@@ -13,14 +17,18 @@ type FileShareServiceTestMock struct {
 
 func (s FileShareServiceTestMock) GetAllUserShares() (*SharedFilesResponseBody, error) {
 	responseBody := SharedFilesResponseBody{}
-	model := FileShareModel{Path: "/test-path"}
+	model := FileShareModel{Path: "/test-path", ShareType: "3"}
 	oneSlice := []FileShareModel{model}
 	responseBody.Data = Data{Element: oneSlice}
 	return &responseBody, nil
 }
 
-func (s FileShareServiceTestMock) CreateUserShare(filePath string, shareType int32) (*FileShareModel, error) {
-	return &FileShareModel{Path: filePath, ShareType: string(shareType)}, nil
+func (s FileShareServiceTestMock) CreateUserShare(filePath string, shareType int32, options FileShareOptions) (*FileShareModel, error) {
+	return &FileShareModel{Path: filePath, ShareType: strconv.Itoa(int(shareType)), Expiration: options.ExpireDate}, nil
+}
+
+func (s FileShareServiceTestMock) UpdateShare(id string, values map[string]string) (*FileShareModel, error) {
+	return &FileShareModel{ID: id}, nil
 }
 
 func TestFindExistedShareByPath(t *testing.T) {
@@ -28,7 +36,7 @@ func TestFindExistedShareByPath(t *testing.T) {
 	service := FileShareServiceTestMock{}
 	testedInstance := FileSharesInfo{service}
 
-	model, _ := testedInstance.GetSharesInfo(expectedFilePath, 3)
+	model, _ := testedInstance.GetSharesInfo(expectedFilePath, PublicLinkShareType, FileShareOptions{Permissions: ReadPermission})
 	actual := model.Path
 
 	if expectedFilePath != actual {
@@ -41,7 +49,7 @@ func TestCreateNewShareIfExistedNotFound(t *testing.T) {
 	service := FileShareServiceTestMock{Url: expectedFilePath}
 	testedInstance := FileSharesInfo{service}
 
-	model, _ := testedInstance.GetSharesInfo(expectedFilePath, 3)
+	model, _ := testedInstance.GetSharesInfo(expectedFilePath, PublicLinkShareType, FileShareOptions{Permissions: ReadPermission})
 	actual := model.Path
 
 	if expectedFilePath != actual {
@@ -84,3 +92,129 @@ func TestCreateFileSharePostAttachment(t *testing.T) {
 		t.Errorf(" expected %q, actual  %q", expectedHeader, actualAttachment.Title)
 	}
 }
+
+func TestNewShareIsCreatedForOptions(t *testing.T) {
+	expectedExpiration := "2030-01-02"
+	service := FileShareServiceTestMock{}
+	testedInstance := FileSharesInfo{service}
+
+	model, _ := testedInstance.GetSharesInfo("/test-path", PublicLinkShareType, FileShareOptions{Permissions: ReadPermission, ExpireDate: expectedExpiration})
+
+	if model.Expiration != expectedExpiration {
+		t.Error("Existing share should not be reused for a link with options")
+	}
+}
+
+func TestFileSharePostShowsProtection(t *testing.T) {
+	fm := &FileShareModel{
+		FileTarget:  "/fileTarget.png",
+		ShareType:   "3",
+		Permissions: "1",
+		Expiration:  "2030-01-02 00:00:00",
+		Password:    "hash",
+	}
+	testedInstance := FileSharePostAttachementsImpl{user: &model.User{Username: "username"}, sm: fm}
+
+	fields := testedInstance.createShareFields()
+
+	if fields[0].Value != "2030-01-02" || fields[1].Value != "Password protected" || fields[2].Value != "View only" {
+		t.Errorf("Unexpected share fields %v, %v, %v", fields[0].Value, fields[1].Value, fields[2].Value)
+	}
+}
+
+func TestShareOptionsRespectServerPolicy(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	capabilities := ShareCapabilities{}
+	capabilities.Public.Password.Enforced = true
+	capabilities.Public.ExpireDate.Enabled = true
+	capabilities.Public.ExpireDate.Enforced = true
+	capabilities.Public.ExpireDate.Days = 7
+
+	_, fieldErrors := ParseFileShareOptions(map[string]interface{}{"Expiration": "2030-02-01"}, capabilities, PublicLinkShareType, now)
+	if len(fieldErrors["Expiration"]) == 0 || len(fieldErrors["Password"]) == 0 {
+		t.Errorf("Enforced policy should be validated, actual %v", fieldErrors)
+	}
+
+	values := map[string]interface{}{"Expiration": "2030-01-05", "Password": "secret", "AllowEditing": true, "HideDownload": true}
+	options, fieldErrors := ParseFileShareOptions(values, capabilities, PublicLinkShareType, now)
+	if len(fieldErrors) != 0 {
+		t.Errorf("unexpected errors %v", fieldErrors)
+	}
+	if options.Permissions != EditPermission || !options.HideDownload || options.ExpireDate != "2030-01-05" {
+		t.Errorf("Unexpected options %v", options)
+	}
+	if capabilities.DefaultExpireDate(now) != "2030-01-08" {
+		t.Errorf("expected %s, actual %s", "2030-01-08", capabilities.DefaultExpireDate(now))
+	}
+}
+
+func TestExistingUserShareIsReused(t *testing.T) {
+	service := &FileShareServiceUserMock{}
+	testedInstance := FileSharesInfo{service}
+
+	share, _ := testedInstance.GetSharesInfo("/test-path", UserShareType, FileShareOptions{Permissions: ReadPermission, ShareWith: "alice"})
+	if share.ID != "existing" || service.updated != nil {
+		t.Error("Existing share with the user should be reused as it is")
+	}
+
+	share, _ = testedInstance.GetSharesInfo("/test-path", UserShareType, FileShareOptions{Permissions: EditPermission, ExpireDate: "2030-01-05", Note: "note", ShareWith: "alice"})
+	expected := map[string]string{"permissions": "19", "expireDate": "2030-01-05", "note": "note"}
+	if share.ID != "existing" || fmt.Sprint(service.updated) != fmt.Sprint(expected) {
+		t.Errorf(" expected %v, actual %v", expected, service.updated)
+	}
+
+	share, _ = testedInstance.GetSharesInfo("/test-path", UserShareType, FileShareOptions{Permissions: ReadPermission, ShareWith: "bob"})
+	if share.ID != "new" || share.ShareWith != "bob" {
+		t.Error("New share should be created for another user")
+	}
+}
+
+type FileShareServiceUserMock struct {
+	updated map[string]string
+}
+
+func (s *FileShareServiceUserMock) GetAllUserShares() (*SharedFilesResponseBody, error) {
+	responseBody := SharedFilesResponseBody{}
+	responseBody.Data = Data{Element: []FileShareModel{
+		{ID: "link", Path: "/test-path", ShareType: "3"},
+		{ID: "existing", Path: "/test-path", ShareType: "0", ShareWith: "alice", Permissions: "17", Expiration: "2030-01-01 00:00:00"},
+	}}
+	return &responseBody, nil
+}
+
+func (s *FileShareServiceUserMock) UpdateShare(id string, values map[string]string) (*FileShareModel, error) {
+	s.updated = values
+	return &FileShareModel{ID: id, Permissions: values["permissions"]}, nil
+}
+
+func (s *FileShareServiceUserMock) CreateUserShare(filePath string, shareType int32, options FileShareOptions) (*FileShareModel, error) {
+	return &FileShareModel{ID: "new", Path: filePath, ShareWith: options.ShareWith}, nil
+}
+
+func TestLinkOptionsAreIgnoredForUserShares(t *testing.T) {
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	capabilities := ShareCapabilities{}
+	capabilities.Public.Password.Enforced = true
+	capabilities.Public.ExpireDate.Enforced = true
+
+	values := map[string]interface{}{"Password": "secret", "Label": "label", "HideDownload": true, "AllowEditing": true}
+	options, fieldErrors := ParseFileShareOptions(values, capabilities, UserShareType, now)
+
+	if len(fieldErrors) != 0 {
+		t.Errorf("Link policy should not be applied to user shares, actual %v", fieldErrors)
+	}
+	if options != (FileShareOptions{Permissions: EditPermission}) {
+		t.Errorf("Unexpected options %v", options)
+	}
+}
+
+func TestFolderEditingAllowsCreateAndDelete(t *testing.T) {
+	options := FileShareOptions{Permissions: EditPermission}
+
+	if options.ForItem(true).Permissions != FolderEditPermission || options.ForItem(false).Permissions != EditPermission {
+		t.Error("Editing of a folder should allow to create and delete files")
+	}
+	if (FileShareOptions{Permissions: ReadPermission}).ForItem(true).Permissions != ReadPermission {
+		t.Error("Read-only folder share should stay read-only")
+	}
+}
@@ -0,0 +1,238 @@
+package file
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ConflictRename      = "rename"
+	ConflictOverwrite   = "overwrite"
+	ConflictSkip        = "skip"
+	conflictsField      = "Conflicts"
+	conflictFieldPrefix = "Conflict-"
+	maxConflictAttempts = 3
+)
+
+var ConflictOptions = []apps.SelectOption{
+	{Label: "Keep both, rename the new file", Value: ConflictRename},
+	{Label: "Overwrite, the old file is kept as a version", Value: ConflictOverwrite},
+	{Label: "Skip the file", Value: ConflictSkip},
+}
+
+// UploadConflicts tells what to do with the files which already exist in the folder.
+type UploadConflicts struct {
+	Default string
+	// Files are the choices made for single files, by Mattermost file id.
+	Files map[string]string
+	// Etags are the versions of the existing files which were shown in the form, by file name.
+	Etags map[string]string
+}
+
+// uploadConflictState is the state of the upload form submit.
+type uploadConflictState struct {
+	Etags map[string]string `json:"etags,omitempty"`
+}
+
+func GetUploadConflicts(creq apps.CallRequest) UploadConflicts {
+	conflicts := UploadConflicts{
+		Default: getSelectedValue(creq.Values, conflictsField),
+		Files:   map[string]string{},
+		Etags:   map[string]string{},
+	}
+	if len(conflicts.Default) == 0 {
+		conflicts.Default = ConflictRename
+	}
+	for name := range creq.Values {
+		if !strings.HasPrefix(name, conflictFieldPrefix) {
+			continue
+		}
+		if choice := getSelectedValue(creq.Values, name); len(choice) != 0 {
+			conflicts.Files[strings.TrimPrefix(name, conflictFieldPrefix)] = choice
+		}
+	}
+
+	state := uploadConflictState{}
+	data, _ := json.Marshal(creq.Call.State)
+	json.Unmarshal(data, &state)
+	for name, etag := range state.Etags {
+		conflicts.Etags[name] = etag
+	}
+	return conflicts
+}
+
+func (c UploadConflicts) For(fileId string) string {
+	if choice, ok := c.Files[fileId]; ok {
+		return choice
+	}
+	return c.Default
+}
+
+// UploadCondition guards the destination against changes made after the conflicts were checked.
+type UploadCondition struct {
+	// Etag is the version which may be overwritten. Without it the destination must not exist.
+	Etag string
+}
+
+func (c UploadCondition) setHeaders(req *http.Request) {
+	if len(c.Etag) == 0 {
+		req.Header.Set("If-None-Match", "*")
+		return
+	}
+	req.Header.Set("If-Match", c.Etag)
+}
+
+type uploadTarget struct {
+	Name      string
+	Condition UploadCondition
+	Skip      bool
+}
+
+// resolve chooses how the file is uploaded. Existing maps the names in the folder to their etags.
+func (c UploadConflicts) resolve(fileId string, name string, existing map[string]string) uploadTarget {
+	etag, exists := existing[name]
+	if !exists {
+		return uploadTarget{Name: name}
+	}
+
+	switch c.For(fileId) {
+	case ConflictSkip:
+		return uploadTarget{Name: name, Skip: true}
+	case ConflictOverwrite:
+		if shown, ok := c.Etags[name]; ok {
+			etag = shown
+		}
+		return uploadTarget{Name: name, Condition: UploadCondition{Etag: etag}}
+	}
+	return uploadTarget{Name: createFreeName(name, existing)}
+}
+
+// createFreeName numbers the file the way Nextcloud does, e.g. report (2).pdf.
+func createFreeName(name string, existing map[string]string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if len(base) == 0 {
+		base, ext = name, ""
+	}
+	for i := 2; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, ok := existing[candidate]; !ok {
+			return candidate
+		}
+	}
+}
+
+type FolderFilesService interface {
+	ListFileEtags(folderUrl string) (map[string]string, error)
+}
+
+type FolderFilesServiceImpl struct {
+	Client nextcloud.Client
+}
+
+// ListFileEtags returns the names of the files and folders in the folder with their etags.
+func (s FolderFilesServiceImpl) ListFileEtags(folderUrl string) (map[string]string, error) {
+	etags, err := listEtags(s.Client, folderUrl, "1")
+	if err != nil {
+		return nil, err
+	}
+
+	folderPath := ""
+	if u, err := url.Parse(folderUrl); err == nil {
+		folderPath = strings.TrimSuffix(u.Path, "/")
+	}
+	files := map[string]string{}
+	for filePath, etag := range etags {
+		if filePath == folderPath {
+			continue
+		}
+		files[path.Base(filePath)] = etag
+	}
+	return files, nil
+}
+
+// listEtags returns the etags by unescaped paths without the trailing slash.
+func listEtags(client nextcloud.Client, resourceUrl string, depth string) (map[string]string, error) {
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+	<d:propfind xmlns:d="DAV:">
+		<d:prop>
+			<d:getetag/>
+		</d:prop>
+	</d:propfind>`
+
+	req, _ := http.NewRequest("PROPFIND", resourceUrl, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/xml")
+	req.Header.Set("Depth", depth)
+
+	resp, err := client.Do(req, http.StatusMultiStatus)
+	if err != nil {
+		log.Errorf("Etags of %s were not listed. Error: %s", resourceUrl, err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	xmlResp := FileSearchResponseBody{}
+	if err := xml.NewDecoder(resp.Body).Decode(&xmlResp); err != nil {
+		return nil, err
+	}
+
+	etags := map[string]string{}
+	for _, f := range xmlResp.FileResponse {
+		filePath, err := url.PathUnescape(f.Href)
+		if err != nil || len(f.PropertyStats) == 0 {
+			continue
+		}
+		etags[strings.TrimSuffix(filePath, "/")] = f.PropertyStats[0].Property.Getetag
+	}
+	return etags, nil
+}
+
+// createConflictFields asks what to do with the selected files which already exist in the folder.
+func createConflictFields(values map[string]interface{}, files []apps.SelectOption, existing map[string]string) ([]apps.Field, map[string]string) {
+	etags := map[string]string{}
+	fileFields := make([]apps.Field, 0)
+	for _, file := range files {
+		etag, ok := existing[file.Label]
+		if !ok {
+			continue
+		}
+		etags[file.Label] = etag
+		fileFields = append(fileFields, apps.Field{
+			Type:                "static_select",
+			Name:                conflictFieldPrefix + file.Value,
+			Label:               file.Label,
+			Description:         "Already exists in the folder, leave empty to use the choice for all files",
+			SelectStaticOptions: ConflictOptions,
+			Value:               getSelectedOption(values, conflictFieldPrefix+file.Value),
+		})
+	}
+	if len(fileFields) == 0 {
+		return fileFields, etags
+	}
+
+	all := getSelectedOption(values, conflictsField)
+	if all == nil {
+		all = ConflictOptions[0]
+	}
+	fields := []apps.Field{
+		{
+			Type:                "static_select",
+			Name:                conflictsField,
+			Label:               "Existing files",
+			Description:         fmt.Sprintf("%d of the selected files already exist in the folder", len(fileFields)),
+			IsRequired:          true,
+			SelectStaticOptions: ConflictOptions,
+			Value:               all,
+		},
+	}
+	return append(fields, fileFields...), etags
+}
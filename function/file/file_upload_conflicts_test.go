@@ -0,0 +1,181 @@
+package file
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	"github.com/prokhorind/nextcloud/function/settings"
+)
+
+func createConflictUploadTest(values map[string]interface{}, state interface{}, listings ...map[string]string) (*FileFullUploadServiceMock, FileUploadServiceImpl, apps.CallRequest, Client4Mock) {
+	values["Folder"] = map[string]interface{}{"value": "/Docs/"}
+	creq := apps.CallRequest{
+		Call:   apps.Call{State: state},
+		Values: values,
+		Context: apps.Context{
+			ExpandedContext: apps.ExpandedContext{
+				OAuth2: apps.OAuth2Context{
+					OAuth2App: apps.OAuth2App{RemoteRootURL: "https://cloud"},
+					User:      map[string]interface{}{"user_id": "user"},
+				},
+			},
+		},
+	}
+
+	fileInfo := model.NewInfo("report.pdf")
+	fileInfo.Id = "file-id"
+	fileInfo.Size = 1024
+
+	fullUpload := &FileFullUploadServiceMock{}
+	testedInstance := FileUploadServiceImpl{
+		fileFullUploadService: fullUpload,
+		folderFilesService:    &FolderFilesServiceMock{listings: listings},
+		settings:              settings.Settings{MaxFileSizeMb: 1, ChunkFileSizeMb: 2, MaxFilesSizeMb: 3},
+	}
+	return fullUpload, testedInstance, creq, Client4Mock{fileInfo: fileInfo}
+}
+
+func uploadedFiles() []interface{} {
+	return []interface{}{map[string]interface{}{"value": "file-id"}}
+}
+
+func TestNewFileIsUploadedOnlyIfItDoesNotExist(t *testing.T) {
+	fullUpload, testedInstance, creq, asBot := createConflictUploadTest(map[string]interface{}{}, nil)
+
+	results := testedInstance.UploadFiles(creq, uploadedFiles(), asBot)
+
+	if results[0].Err != nil || len(results[0].UploadedAs) != 0 {
+		t.Fatalf("File should be uploaded with its name, actual %+v", results[0])
+	}
+	if fullUpload.urls[0] != "https://cloud/remote.php/dav/files/user/Docs/report.pdf" || fullUpload.conditions[0].Etag != "" {
+		t.Errorf("File should be created, actual %q %+v", fullUpload.urls[0], fullUpload.conditions[0])
+	}
+}
+
+func TestExistingFileIsRenamedByDefault(t *testing.T) {
+	fullUpload, testedInstance, creq, asBot := createConflictUploadTest(map[string]interface{}{}, nil,
+		map[string]string{"report.pdf": `"e1"`, "report (2).pdf": `"e2"`})
+
+	results := testedInstance.UploadFiles(creq, uploadedFiles(), asBot)
+
+	if results[0].Err != nil || results[0].UploadedAs != "report (3).pdf" {
+		t.Fatalf("File should be renamed, actual %+v", results[0])
+	}
+	expected := "https://cloud/remote.php/dav/files/user/Docs/report%20%283%29.pdf"
+	if fullUpload.urls[0] != expected {
+		t.Errorf(" expected %q, actual %q", expected, fullUpload.urls[0])
+	}
+}
+
+func TestExistingFileIsOverwrittenWithEtagShownInForm(t *testing.T) {
+	values := map[string]interface{}{
+		conflictsField:                  map[string]interface{}{"value": ConflictSkip},
+		conflictFieldPrefix + "file-id": map[string]interface{}{"value": ConflictOverwrite},
+	}
+	state := map[string]interface{}{"etags": map[string]interface{}{"report.pdf": `"shown"`}}
+	fullUpload, testedInstance, creq, asBot := createConflictUploadTest(values, state, map[string]string{"report.pdf": `"current"`})
+
+	results := testedInstance.UploadFiles(creq, uploadedFiles(), asBot)
+
+	if results[0].Err != nil || results[0].Skipped {
+		t.Fatalf("File should be overwritten, actual %+v", results[0])
+	}
+	if fullUpload.conditions[0].Etag != `"shown"` {
+		t.Errorf(" expected %q, actual %q", `"shown"`, fullUpload.conditions[0].Etag)
+	}
+}
+
+func TestExistingFileIsSkipped(t *testing.T) {
+	values := map[string]interface{}{conflictsField: map[string]interface{}{"value": ConflictSkip}}
+	fullUpload, testedInstance, creq, asBot := createConflictUploadTest(values, nil, map[string]string{"report.pdf": `"e1"`})
+
+	results := testedInstance.UploadFiles(creq, uploadedFiles(), asBot)
+
+	if !results[0].Skipped || fullUpload.used {
+		t.Errorf("File should be skipped, actual %+v", results[0])
+	}
+}
+
+func TestFileCreatedDuringUploadIsRenamed(t *testing.T) {
+	fullUpload, testedInstance, creq, asBot := createConflictUploadTest(map[string]interface{}{}, nil,
+		map[string]string{}, map[string]string{"report.pdf": `"e1"`})
+	fullUpload.failures = 1
+
+	results := testedInstance.UploadFiles(creq, uploadedFiles(), asBot)
+
+	if results[0].Err != nil || results[0].UploadedAs != "report (2).pdf" || len(fullUpload.urls) != 2 {
+		t.Errorf("File should be renamed after the conflict, actual %+v", results[0])
+	}
+}
+
+func TestChangedFileIsNotOverwritten(t *testing.T) {
+	values := map[string]interface{}{conflictsField: map[string]interface{}{"value": ConflictOverwrite}}
+	fullUpload, testedInstance, creq, asBot := createConflictUploadTest(values, nil, map[string]string{"report.pdf": `"e1"`})
+	fullUpload.failures = 1
+
+	results := testedInstance.UploadFiles(creq, uploadedFiles(), asBot)
+
+	if results[0].Err == nil || len(fullUpload.urls) != 1 {
+		t.Errorf("Changed file should not be overwritten, actual %+v", results[0])
+	}
+	if results[0].Conflict != ConflictOverwrite {
+		t.Errorf(" expected %q, actual %q", ConflictOverwrite, results[0].Conflict)
+	}
+}
+
+func TestFreeNameIsCreated(t *testing.T) {
+	existing := map[string]string{"notes": "", "notes (2)": "", ".env": ""}
+	cases := map[string]string{
+		"notes":          "notes (3)",
+		".env":           ".env (2)",
+		"archive.tar.gz": "archive.tar (2).gz",
+	}
+	for name, expected := range cases {
+		if actual := createFreeName(name, existing); actual != expected {
+			t.Errorf(" expected %q, actual %q", expected, actual)
+		}
+	}
+}
+
+func TestConflictFieldsAreShownForExistingFiles(t *testing.T) {
+	files := []apps.SelectOption{{Label: "a.txt", Value: "1"}, {Label: "b.txt", Value: "2"}}
+
+	fields, etags := createConflictFields(map[string]interface{}{}, files, map[string]string{"b.txt": `"e2"`})
+
+	if len(fields) != 2 || fields[0].Name != conflictsField || fields[1].Name != conflictFieldPrefix+"2" {
+		t.Errorf("Choice for all and for b.txt expected, actual %+v", fields)
+	}
+	if len(etags) != 1 || etags["b.txt"] != `"e2"` {
+		t.Errorf("Etag of b.txt expected, actual %v", etags)
+	}
+}
+
+func TestFolderFilesAreListedWithEtags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" || r.Header.Get("Depth") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(`<d:multistatus xmlns:d="DAV:">
+			<d:response><d:href>/remote.php/dav/files/user/My%20Docs/</d:href><d:propstat><d:prop><d:getetag>"f"</d:getetag></d:prop></d:propstat></d:response>
+			<d:response><d:href>/remote.php/dav/files/user/My%20Docs/a%20b.txt</d:href><d:propstat><d:prop><d:getetag>"1"</d:getetag></d:prop></d:propstat></d:response>
+			<d:response><d:href>/remote.php/dav/files/user/My%20Docs/Sub/</d:href><d:propstat><d:prop><d:getetag>"2"</d:getetag></d:prop></d:propstat></d:response>
+		</d:multistatus>`))
+	}))
+	defer server.Close()
+	testedInstance := FolderFilesServiceImpl{Client: nextcloud.Client{}}
+
+	files, err := testedInstance.ListFileEtags(server.URL + "/remote.php/dav/files/user/My%20Docs/")
+
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(files) != 2 || files["a b.txt"] != `"1"` || files["Sub"] != `"2"` {
+		t.Errorf("File and folder expected, actual %v", files)
+	}
+}
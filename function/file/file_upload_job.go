@@ -0,0 +1,92 @@
+package file
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	log "github.com/sirupsen/logrus"
+)
+
+// UploadRetry is the state of the retry button, it keeps the folder, the Mattermost ids of the failed files
+// and the choices for the files which existed in the folder.
+type UploadRetry struct {
+	Folder    string            `json:"folder"`
+	Files     []string          `json:"files"`
+	Conflicts map[string]string `json:"conflicts,omitempty"`
+}
+
+func GetUploadRetry(creq apps.CallRequest) UploadRetry {
+	retry := UploadRetry{}
+	data, _ := json.Marshal(creq.Call.State)
+	json.Unmarshal(data, &retry)
+	return retry
+}
+
+// Values returns the retried files in the format of the upload form.
+func (r UploadRetry) Values() map[string]interface{} {
+	files := make([]interface{}, 0)
+	for _, file := range r.Files {
+		files = append(files, map[string]interface{}{"value": file})
+	}
+	values := map[string]interface{}{
+		"Files":  files,
+		"Folder": map[string]interface{}{"value": r.Folder},
+	}
+	for file, choice := range r.Conflicts {
+		values[conflictFieldPrefix+file] = map[string]interface{}{"value": choice}
+	}
+	return values
+}
+
+func createUploadRetryBinding(retry UploadRetry) apps.Binding {
+	return apps.Binding{
+		Location: "embedded",
+		AppID:    "nextcloud",
+		Bindings: []apps.Binding{
+			{
+				Location: "retry",
+				Label:    "Retry failed files",
+				Submit: apps.NewCall("/file-upload/retry").WithExpand(apps.Expand{
+					ActingUserAccessToken: apps.ExpandAll,
+					OAuth2App:             apps.ExpandAll,
+					OAuth2User:            apps.ExpandAll,
+					ActingUser:            apps.ExpandAll,
+				}).WithState(retry),
+			},
+		},
+	}
+}
+
+type UploadJob struct {
+	Creq          apps.CallRequest
+	Files         []interface{}
+	AsBot         GetFile
+	UploadService FileUploadServiceImpl
+	Progress      *UploadProgressPost
+}
+
+func (j UploadJob) Run() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Upload job of user %s failed: %v", j.Progress.UserId, r)
+		}
+	}()
+
+	results := j.UploadService.UploadFiles(j.Creq, j.Files, j.AsBot)
+	j.Progress.Finish(results)
+}
+
+func StartUploadJob(job UploadJob) {
+	startBackgroundJob(job.Run)
+}
+
+// startBackgroundJob runs the job after the call returns, so a long transfer does not hit the call timeout.
+// AWS Lambda freezes the process after the response, so there the job runs within the call.
+func startBackgroundJob(run func()) {
+	if len(os.Getenv("AWS_LAMBDA_FUNCTION_NAME")) != 0 {
+		run()
+		return
+	}
+	go run()
+}
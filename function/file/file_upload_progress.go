@@ -0,0 +1,191 @@
+package file
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+	log "github.com/sirupsen/logrus"
+)
+
+// progressUpdateInterval limits how often the progress post is updated for chunks.
+const progressUpdateInterval = 2 * time.Second
+
+type UploadResult struct {
+	FileId string
+	Name   string
+	// Url opens the uploaded file in Nextcloud.
+	Url string
+	// UploadedAs is the new name of a file which was renamed because of a conflict.
+	UploadedAs string
+	// Skipped files already existed in the folder and were not uploaded.
+	Skipped bool
+	// Conflict is the choice which was applied to the file, it is kept for the retry.
+	Conflict string
+	Err      error
+}
+
+type UploadProgress interface {
+	FileStarted(name string)
+	ChunksUploaded(name string, uploaded int, total int)
+	FileFinished(result UploadResult)
+}
+
+type noUploadProgress struct{}
+
+func (noUploadProgress) FileStarted(name string)                             {}
+func (noUploadProgress) ChunksUploaded(name string, uploaded int, total int) {}
+func (noUploadProgress) FileFinished(result UploadResult)                    {}
+
+type ProgressPostClient interface {
+	DMPost(userID string, post *model.Post) (*model.Post, error)
+	PatchPost(postId string, patch *model.PostPatch) (*model.Post, *model.Response, error)
+}
+
+// UploadProgressPost keeps one direct message of the bot up to date while the files are uploaded.
+type UploadProgressPost struct {
+	Client ProgressPostClient
+	UserId string
+	Folder string
+	Total  int
+
+	mu         sync.Mutex
+	postId     string
+	finished   int
+	current    string
+	lastUpdate time.Time
+}
+
+func (p *UploadProgressPost) Start() error {
+	p.mu.Lock()
+	message := p.createMessage()
+	p.mu.Unlock()
+
+	post, err := p.Client.DMPost(p.UserId, &model.Post{Message: message})
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.postId = post.Id
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *UploadProgressPost) FileStarted(name string) {
+	p.mu.Lock()
+	p.current = fmt.Sprintf("Uploading %s", name)
+	p.mu.Unlock()
+	p.update(true)
+}
+
+func (p *UploadProgressPost) ChunksUploaded(name string, uploaded int, total int) {
+	p.mu.Lock()
+	p.current = fmt.Sprintf("Uploading %s: %d of %d chunks", name, uploaded, total)
+	p.mu.Unlock()
+	p.update(false)
+}
+
+func (p *UploadProgressPost) FileFinished(result UploadResult) {
+	p.mu.Lock()
+	p.finished++
+	p.current = ""
+	p.mu.Unlock()
+	p.update(true)
+}
+
+// Finish replaces the progress with the summary. Failed files get a button to upload them again.
+func (p *UploadProgressPost) Finish(results []UploadResult) {
+	message := createUploadSummary(p.Folder, results)
+	props := model.StringInterface{}
+
+	retry := UploadRetry{Folder: p.Folder, Files: make([]string, 0), Conflicts: map[string]string{}}
+	for _, result := range results {
+		if result.Err != nil {
+			retry.Files = append(retry.Files, result.FileId)
+			if len(result.Conflict) != 0 {
+				retry.Conflicts[result.FileId] = result.Conflict
+			}
+		}
+	}
+	if len(retry.Files) != 0 {
+		props["app_bindings"] = []apps.Binding{createUploadRetryBinding(retry)}
+	}
+
+	p.mu.Lock()
+	postId := p.postId
+	p.mu.Unlock()
+	if len(postId) == 0 {
+		if _, err := p.Client.DMPost(p.UserId, &model.Post{Message: message, Props: props}); err != nil {
+			log.Errorf("Upload summary was not sent to user %s. Error: %s", p.UserId, err)
+		}
+		return
+	}
+	if _, _, err := p.Client.PatchPost(postId, &model.PostPatch{Message: &message, Props: &props}); err != nil {
+		log.Errorf("Upload summary was not posted for user %s. Error: %s", p.UserId, err)
+	}
+}
+
+func (p *UploadProgressPost) update(force bool) {
+	p.mu.Lock()
+	if len(p.postId) == 0 || (!force && time.Since(p.lastUpdate) < progressUpdateInterval) {
+		p.mu.Unlock()
+		return
+	}
+	p.lastUpdate = time.Now()
+	postId := p.postId
+	message := p.createMessage()
+	p.mu.Unlock()
+
+	if _, _, err := p.Client.PatchPost(postId, &model.PostPatch{Message: &message}); err != nil {
+		log.Warnf("Upload progress was not updated for user %s. Error: %s", p.UserId, err)
+	}
+}
+
+func (p *UploadProgressPost) createMessage() string {
+	message := fmt.Sprintf("Uploading files to Nextcloud folder `%s`: %d of %d done", getFolderName(p.Folder), p.finished, p.Total)
+	if len(p.current) != 0 {
+		message += "\n" + p.current
+	}
+	return message
+}
+
+func createUploadSummary(folder string, results []UploadResult) string {
+	uploaded := make([]string, 0)
+	skipped := make([]string, 0)
+	failed := make([]string, 0)
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			failed = append(failed, fmt.Sprintf("- %s: %s", result.Name, result.Err))
+		case result.Skipped:
+			skipped = append(skipped, "- "+result.Name)
+		case len(result.UploadedAs) != 0:
+			uploaded = append(uploaded, fmt.Sprintf("- [%s](%s), saved as %s", result.Name, result.Url, result.UploadedAs))
+		default:
+			uploaded = append(uploaded, fmt.Sprintf("- [%s](%s)", result.Name, result.Url))
+		}
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("Uploaded %d of %d files to Nextcloud folder `%s`", len(uploaded), len(results), getFolderName(folder)))
+	if len(uploaded) != 0 {
+		builder.WriteString("\n" + strings.Join(uploaded, "\n"))
+	}
+	if len(skipped) != 0 {
+		builder.WriteString("\n\nSkipped, already in the folder:\n" + strings.Join(skipped, "\n"))
+	}
+	if len(failed) != 0 {
+		builder.WriteString("\n\nNot uploaded:\n" + strings.Join(failed, "\n"))
+	}
+	return builder.String()
+}
+
+func getFolderName(folder string) string {
+	if len(folder) == 0 {
+		return "/"
+	}
+	return folder
+}
@@ -0,0 +1,95 @@
+package file
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+type ProgressPostClientMock struct {
+	mu      sync.Mutex
+	posts   []*model.Post
+	patches []*model.PostPatch
+}
+
+func (m *ProgressPostClientMock) DMPost(userID string, post *model.Post) (*model.Post, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	post.Id = "post-id"
+	m.posts = append(m.posts, post)
+	return post, nil
+}
+
+func (m *ProgressPostClientMock) PatchPost(postId string, patch *model.PostPatch) (*model.Post, *model.Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.patches = append(m.patches, patch)
+	return nil, nil, nil
+}
+
+func TestProgressPostIsUpdatedAndFinished(t *testing.T) {
+	client := &ProgressPostClientMock{}
+	testedInstance := &UploadProgressPost{Client: client, UserId: "user", Folder: "/Docs/", Total: 2}
+
+	testedInstance.Start()
+	testedInstance.FileStarted("a.pdf")
+	testedInstance.ChunksUploaded("a.pdf", 1, 3)
+	testedInstance.FileFinished(UploadResult{Name: "a.pdf"})
+	results := []UploadResult{
+		{FileId: "f1", Name: "a.pdf", Url: "https://nc/index.php/f/1"},
+		{FileId: "f2", Name: "b.mp4", Err: errors.New("chunk 3 was not uploaded")},
+	}
+	testedInstance.Finish(results)
+
+	if len(client.posts) != 1 || client.posts[0].Message != "Uploading files to Nextcloud folder `/Docs/`: 0 of 2 done" {
+		t.Fatalf("Unexpected progress post %v", client.posts)
+	}
+	if *client.patches[0].Message != "Uploading files to Nextcloud folder `/Docs/`: 0 of 2 done\nUploading a.pdf" {
+		t.Errorf("Unexpected progress %q", *client.patches[0].Message)
+	}
+	summary := client.patches[len(client.patches)-1]
+	expected := "Uploaded 1 of 2 files to Nextcloud folder `/Docs/`\n- [a.pdf](https://nc/index.php/f/1)\n\nNot uploaded:\n- b.mp4: chunk 3 was not uploaded"
+	if *summary.Message != expected {
+		t.Errorf(" expected %q, actual %q", expected, *summary.Message)
+	}
+	bindings := (*summary.Props)["app_bindings"].([]apps.Binding)
+	retry := bindings[0].Bindings[0].Submit.State.(UploadRetry)
+	if retry.Folder != "/Docs/" || len(retry.Files) != 1 || retry.Files[0] != "f2" {
+		t.Errorf("Retry button should upload only failed files, actual %v", retry)
+	}
+}
+
+func TestChunkProgressIsThrottled(t *testing.T) {
+	client := &ProgressPostClientMock{}
+	testedInstance := &UploadProgressPost{Client: client, UserId: "user", Total: 1}
+
+	testedInstance.Start()
+	testedInstance.FileStarted("video.mp4")
+	for i := 1; i <= 10; i++ {
+		testedInstance.ChunksUploaded("video.mp4", i, 10)
+	}
+
+	if len(client.patches) != 1 {
+		t.Errorf("expected 1 update, actual %d", len(client.patches))
+	}
+}
+
+func TestUploadRetryValues(t *testing.T) {
+	values := UploadRetry{Folder: "/Docs/", Files: []string{"f1"}}.Values()
+
+	if getSelectedValue(values, "Folder") != "/Docs/" || len(getSelectedOptions(values, "Files")) != 1 {
+		t.Errorf("Unexpected values %v", values)
+	}
+}
+
+func TestFileLinkWithoutFileId(t *testing.T) {
+	link := createFileLink("https://nc", "", "/My Docs/")
+
+	if !strings.HasPrefix(link, "https://nc/index.php/apps/files/?dir=%2FMy+Docs%2F") {
+		t.Errorf("Unexpected link %q", link)
+	}
+}
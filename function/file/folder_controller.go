@@ -0,0 +1,73 @@
+package file
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/settings"
+)
+
+func HandleMkdirCommand(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
+		return
+	}
+
+	path, _ := creq.Values["path"].(string)
+	folderPath, err := ValidateFolderPath(path)
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Wrap(err, "Folder was not created")))
+		return
+	}
+
+	client := settings.ForCall(creq).NewUserClient(c.Request.Context(), creq, token.AccessToken)
+	folderService := createFolderService(creq, client)
+	if err := folderService.CreateFolder(folderPath); err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Errorf("Folder %s was not created", folderPath)))
+		return
+	}
+
+	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
+	c.JSON(http.StatusOK, apps.NewTextResponse("Folder [%s](%s/index.php/apps/files/?dir=%s) is created", folderPath, remoteUrl, url.QueryEscape(folderPath)))
+}
+
+// getNewFolderPath returns the subfolder typed in the form within the chosen folder, or an empty path.
+func getNewFolderPath(values map[string]interface{}, folder string) (string, error) {
+	newFolder, _ := values[newFolderField].(string)
+	if len(strings.TrimSpace(newFolder)) == 0 {
+		return "", nil
+	}
+	folderPath, err := ValidateFolderPath(newFolder)
+	if err != nil {
+		return "", err
+	}
+	return joinFolderPath(folder, folderPath), nil
+}
+
+func createNewFolderErrorResponse(err error) apps.CallResponse {
+	return apps.CallResponse{
+		Type: apps.CallResponseTypeError,
+		Text: "New subfolder is not valid",
+		Data: map[string]interface{}{"errors": map[string]string{newFolderField: err.Error()}},
+	}
+}
+
+func createFolderService(creq apps.CallRequest, client nextcloud.Client) FolderServiceImpl {
+	return FolderServiceImpl{
+		Url:    creq.Context.OAuth2.OAuth2App.RemoteRootURL + "/remote.php/dav/",
+		UserId: creq.Context.OAuth2.User.(map[string]interface{})["user_id"].(string),
+		Client: client,
+	}
+}
@@ -0,0 +1,79 @@
+package file
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	newFolderField      = "NewFolder"
+	maxFolderNameLength = 250
+	forbiddenCharacters = `\/:*?"<>|`
+)
+
+var reservedNames = map[string]bool{".": true, "..": true, ".htaccess": true}
+
+// ValidateFolderPath checks every folder of the path against the names which Nextcloud does not allow
+// and returns the path relative to the user root, e.g. /Reports/2024.
+func ValidateFolderPath(folderPath string) (string, error) {
+	folderPath = strings.Trim(strings.TrimSpace(folderPath), "/")
+	if len(folderPath) == 0 {
+		return "", errors.New("folder path is empty")
+	}
+
+	for _, name := range strings.Split(folderPath, "/") {
+		switch {
+		case len(name) == 0:
+			return "", errors.New("folder path has an empty folder name")
+		case reservedNames[strings.ToLower(name)]:
+			return "", fmt.Errorf("%s is not allowed as a folder name", name)
+		case strings.TrimSpace(name) != name:
+			return "", fmt.Errorf("folder name %q cannot start or end with a space", name)
+		case utf8.RuneCountInString(name) > maxFolderNameLength:
+			return "", fmt.Errorf("folder name %s... is longer than %d characters", string([]rune(name)[:20]), maxFolderNameLength)
+		case strings.ContainsAny(name, forbiddenCharacters) || strings.IndexFunc(name, unicode.IsControl) >= 0:
+			return "", fmt.Errorf("folder name %s cannot contain any of %s", name, forbiddenCharacters)
+		}
+	}
+	return "/" + folderPath, nil
+}
+
+// joinFolderPath appends the new path to the chosen folder. Both paths are relative to the user root.
+func joinFolderPath(folder string, newPath string) string {
+	return strings.TrimSuffix(folder, "/") + newPath
+}
+
+type FolderService interface {
+	CreateFolder(folderPath string) error
+}
+
+// FolderServiceImpl creates folders of one user, Url is the dav root, e.g. https://cloud/remote.php/dav/.
+type FolderServiceImpl struct {
+	Url    string
+	UserId string
+	Client nextcloud.Client
+}
+
+// CreateFolder creates the folder and the missing folders above it. Existing folders are kept.
+func (s FolderServiceImpl) CreateFolder(folderPath string) error {
+	current := ""
+	for _, name := range strings.Split(strings.Trim(folderPath, "/"), "/") {
+		current += "/" + name
+		req, _ := http.NewRequest("MKCOL", s.Url+"files/"+escapePath(s.UserId+current), nil)
+
+		resp, err := s.Client.Do(req, http.StatusCreated, http.StatusMethodNotAllowed)
+		if err != nil {
+			log.Errorf("Folder %s was not created. Error: %s", current, err)
+			return errors.Wrapf(err, "folder %s was not created", current)
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package file
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+)
+
+func TestFolderPathIsValidated(t *testing.T) {
+	valid := map[string]string{
+		" Reports/2024 ":   "/Reports/2024",
+		"/Team #1/Q&A/":    "/Team #1/Q&A",
+		"Фото/літо 2024":   "/Фото/літо 2024",
+		"notes.d/.private": "/notes.d/.private",
+	}
+	for path, expected := range valid {
+		actual, err := ValidateFolderPath(path)
+		if err != nil || actual != expected {
+			t.Errorf(" expected %q, actual %q, error %v", expected, actual, err)
+		}
+	}
+
+	invalid := []string{"", "/", "a//b", "a/../b", "what?", "a:b", "a/b|c", " a/ b", "a/.htaccess", "tab\tname", strings.Repeat("x", 251)}
+	for _, path := range invalid {
+		if _, err := ValidateFolderPath(path); err == nil {
+			t.Errorf("Path %q should not be valid", path)
+		}
+	}
+}
+
+func TestFolderIsJoinedToChosenFolder(t *testing.T) {
+	cases := map[string]string{"": "/New", "/": "/New", "/Docs/": "/Docs/New", "/Docs": "/Docs/New"}
+	for folder, expected := range cases {
+		if actual := joinFolderPath(folder, "/New"); actual != expected {
+			t.Errorf(" expected %q, actual %q", expected, actual)
+		}
+	}
+}
+
+func TestFolderIsCreatedWithMissingParents(t *testing.T) {
+	created := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "MKCOL" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.EscapedPath() == "/remote.php/dav/files/john%20doe/Team%20%231" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		created = append(created, r.URL.EscapedPath())
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	testedInstance := FolderServiceImpl{Url: server.URL + "/remote.php/dav/", UserId: "john doe", Client: nextcloud.Client{}}
+
+	err := testedInstance.CreateFolder("/Team #1/Q&A")
+
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	expected := "/remote.php/dav/files/john%20doe/Team%20%231/Q&A"
+	if len(created) != 1 || created[0] != expected {
+		t.Errorf(" expected %q, actual %v", expected, created)
+	}
+}
+
+func TestFolderIsNotCreatedInsideFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer server.Close()
+	testedInstance := FolderServiceImpl{Url: server.URL + "/remote.php/dav/", UserId: "user", Client: nextcloud.Client{}}
+
+	if err := testedInstance.CreateFolder("/report.pdf/New"); err == nil {
+		t.Error("Folder should not be created")
+	}
+}
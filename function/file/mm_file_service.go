@@ -2,41 +2,30 @@ package file
 
 import (
 	"fmt"
-	"github.com/hashicorp/go-retryablehttp"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"net/http"
-	"os"
-	"strconv"
 )
 
 type MMFileService interface {
-	GetChunkedFile(path string, from string, to string) (chunk []byte, err error)
+	GetFileRange(path string, from int64, to int64) (io.ReadCloser, error)
 }
 
 type MMFileServiceImpl struct {
-	token string
+	client nextcloud.Client
 }
 
-func (s MMFileServiceImpl) GetChunkedFile(path string, from string, to string) (chunk []byte, err error) {
+// GetFileRange returns the stream of the inclusive byte range, so a chunk is never buffered in memory.
+func (s MMFileServiceImpl) GetFileRange(path string, from int64, to int64) (io.ReadCloser, error) {
 	req, _ := http.NewRequest("GET", path, nil)
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.token))
-	req.Header.Set("Range", fmt.Sprintf("bytes=%s-%s", from, to))
-
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
-
-	client := retryClient.StandardClient()
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", from, to))
 
+	resp, err := s.client.Do(req, http.StatusPartialContent)
 	if err != nil {
-		log.Errorf("Error during getting of chunked files. Error: %s", err)
+		log.Errorf("Error during getting of file range. Error: %s", err)
 		return nil, err
 	}
 
-	data, err := io.ReadAll(resp.Body)
-
-	return data, err
+	return resp.Body, nil
 }
@@ -7,6 +7,9 @@ import (
 	"github.com/prokhorind/nextcloud/function/help"
 	"github.com/prokhorind/nextcloud/function/install"
 	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/scheduler"
+	"github.com/prokhorind/nextcloud/function/settings"
+	"github.com/prokhorind/nextcloud/function/status"
 )
 
 func InitHandlers(r *gin.Engine) {
@@ -17,7 +20,26 @@ func InitHandlers(r *gin.Engine) {
 	r.POST("/oauth2/complete", oauth.Oauth2Complete)
 	r.POST("/oauth2/connect", oauth.Oauth2Connect)
 	r.POST("/file/search/form", file.FileShareForm)
+	r.POST("/file/search/lookup", file.FileSearchLookup)
+	r.POST("/file/share/group/lookup", file.FileShareGroupLookup)
 	r.POST("/file-share", file.FileShare)
+	r.POST("/file/attach/form", file.FileAttachForm)
+	r.POST("/file/attach", file.FileAttach)
+	r.POST("/file/open", file.HandleFileOpen)
+	r.POST("/file/share/save", file.HandleShareSave)
+	r.POST("/file/drop/form", file.HandleFileDropForm)
+	r.POST("/file/drop", file.HandleFileDrop)
+	r.POST("/file/drops", file.HandleFileDropsForm)
+	r.POST("/file/drop/remove", file.HandleFileDropRemove)
+	r.POST("/webhook/file-drop/:key", file.HandleFileDropWebhook)
+	r.POST("/shares", file.HandleSharesCommand)
+	r.POST("/shares/edit-form", file.HandleShareEditForm)
+	r.POST("/shares/update", file.HandleShareUpdate)
+	r.POST("/shares/link", file.HandleShareLink)
+	r.POST("/shares/delete", file.HandleShareDelete)
+	r.POST("/mkdir", file.HandleMkdirCommand)
+	r.POST("/channel/link-folder", file.HandleLinkFolder)
+	r.POST("/channel/unlink-folder", file.HandleUnlinkFolder)
 	r.POST("/create-calendar-event", calendar.HandleCreateEvent)
 	r.POST("/create-calendar-event-form", calendar.HandleCreateEventForm)
 	r.POST("/get-calendar-events-today", calendar.HandleGetEventsToday)
@@ -25,15 +47,33 @@ func InitHandlers(r *gin.Engine) {
 	r.POST("/get-calendar-events-select-date-form", calendar.GetUserSelectedEventsDate)
 	r.POST("/get-calendar-events-select-date/:calendar", calendar.HandleGetEventsAtSelectedDay)
 	r.POST("/delete-event/:calendarId/events/:eventId", calendar.HandleDeleteCalendarEvent)
+	r.POST("/edit-calendar-event-form/:calendarId/events/:eventId", calendar.HandleEditEventForm)
+	r.POST("/edit-calendar-event/:calendarId/events/:eventId", calendar.HandleEditEvent)
+	r.POST("/reminders/on", calendar.HandleEnableReminders)
+	r.POST("/reminders/off", calendar.HandleDisableReminders)
+	r.POST("/agenda", calendar.HandleAgenda)
+	r.POST("/digest/form", calendar.HandleDigestForm)
+	r.POST("/digest", calendar.HandleSaveDigest)
 	r.POST("/do-nothing", calendar.DoNothing)
 	r.POST("/redirect/meeting", calendar.RedirectToAMeeting)
 	r.POST("/help", help.HandleHelpCommand)
+	r.POST("/settings/form", settings.HandleSettingsForm)
+	r.POST("/settings", settings.HandleSaveSettings)
+	r.POST("/status", status.HandleStatusCommand)
 
 	r.POST("/get-parsed-date", calendar.HandleGetParsedCalendarDate)
 	r.POST("/file-upload-form", file.FileUploadForm)
+	r.POST("/file/upload/folder/lookup", file.FileUploadFolderLookup)
 	r.POST("/file-upload", file.FileUpload)
+	r.POST("/file-upload/retry", file.FileUploadRetry)
 
 	r.POST("/ping", install.Ping)
 	r.POST("/calendars", calendar.HandleGetUserCalendars)
 	r.POST("/users/:userId/calendars/:calendarId/events/:eventId/status/:status", calendar.HandleChangeEventStatus)
+
+	scheduler.Register(scheduler.Job{Name: "event-reminders", Interval: calendar.ReminderInterval, Run: calendar.SendEventReminders})
+	scheduler.Register(scheduler.Job{Name: "agenda-digests", Interval: calendar.DigestInterval, Run: calendar.SendAgendaDigests})
+
+	oauth.RegisterDisconnectHook(calendar.ForgetUser)
+	oauth.RegisterDisconnectHook(file.ForgetUserDrops)
 }
@@ -23,8 +23,28 @@ func HandleHelpCommand(c *gin.Context) {
 	builder.WriteString("\n")
 	builder.WriteString(helpService.createHelpForSingleCommand("share"))
 	builder.WriteString("\n")
+	builder.WriteString(helpService.createHelpForSingleCommand("shares"))
+	builder.WriteString("\n")
+	builder.WriteString(helpService.createHelpForSingleCommand("attach"))
+	builder.WriteString("\n")
+	builder.WriteString(helpService.createHelpForSingleCommand("drop"))
+	builder.WriteString("\n")
+	builder.WriteString(helpService.createHelpForSingleCommand("drops"))
+	builder.WriteString("\n")
+	builder.WriteString(helpService.createHelpForSingleCommand("mkdir"))
+	builder.WriteString("\n")
+	builder.WriteString(helpService.createHelpForSingleCommand("channel"))
+	builder.WriteString("\n")
 	builder.WriteString(helpService.createHelpForSingleCommand("calendars"))
 	builder.WriteString("\n")
+	builder.WriteString(helpService.createHelpForSingleCommand("agenda"))
+	builder.WriteString("\n")
+	builder.WriteString(helpService.createHelpForSingleCommand("digest"))
+	builder.WriteString("\n")
+	builder.WriteString(helpService.createHelpForSingleCommand("reminders"))
+	builder.WriteString("\n")
+	builder.WriteString(helpService.createHelpForSingleCommand("status"))
+	builder.WriteString("\n")
 	builder.WriteString(helpService.createHelpForSingleCommand("disconnect"))
 	builder.WriteString("\n")
 	builder.WriteString("\n")
@@ -18,13 +18,13 @@ type HelpServiceImpl struct {
 
 func (h HelpServiceImpl) getSingleHelpMessage(message string) string {
 	locale := h.request.Context.ActingUser.Locale
-	messageSource := locales.MessageSource{h.c, locale}
+	messageSource := locales.MessageSource{C: h.c, Locale: locale}
 	return messageSource.GetMessage("help." + message)
 }
 
 func (h HelpServiceImpl) createHelpForSingleCommand(command string) string {
 	locale := h.request.Context.ActingUser.Locale
-	messageSource := locales.MessageSource{h.c, locale}
+	messageSource := locales.MessageSource{C: h.c, Locale: locale}
 	description := messageSource.GetMessage(fmt.Sprintf("help.%s", command))
 	return fmt.Sprintf("/nextcloud %s - %s", command, description)
 }
@@ -8,7 +8,10 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
 	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/scheduler"
+	log "github.com/sirupsen/logrus"
 )
 
 //go:embed manifest.json
@@ -36,6 +39,7 @@ func Ping(c *gin.Context) {
 func Bindings(c *gin.Context) {
 	creq := apps.CallRequest{}
 	json.NewDecoder(c.Request.Body).Decode(&creq)
+	scheduler.Capture(creq)
 
 	commandBinding := apps.Binding{
 		Icon:        "icon.png",
@@ -46,6 +50,11 @@ func Bindings(c *gin.Context) {
 
 	token := oauth.Token{}
 	remarshal(&token, creq.Context.OAuth2.User)
+	if len(token.UserID) != 0 && creq.Context.ActingUser != nil && len(creq.Context.BotAccessToken) != 0 {
+		if err := oauth.BackfillUserMapping(appclient.AsBot(creq.Context), creq.Context.ActingUser.Id, token.UserID); err != nil {
+			log.Errorf("Nextcloud account mapping of user %s was not saved. Error: %s", creq.Context.ActingUser.Id, err)
+		}
+	}
 
 	var upload apps.Binding
 	if token.AccessToken == "" {
@@ -72,14 +81,142 @@ func Bindings(c *gin.Context) {
 			}),
 		})
 
+		commandBinding.Bindings = append(commandBinding.Bindings, apps.Binding{
+			Location: "attach",
+			Label:    "attach",
+			Submit: apps.NewCall("/file/attach/form").WithExpand(apps.Expand{
+				OAuth2App:             apps.ExpandAll,
+				OAuth2User:            apps.ExpandAll,
+				ActingUserAccessToken: apps.ExpandAll,
+				ActingUser:            apps.ExpandAll,
+				Channel:               apps.ExpandAll,
+			}),
+		})
+
+		commandBinding.Bindings = append(commandBinding.Bindings, apps.Binding{
+			Location: "drop",
+			Label:    "drop",
+			Submit: apps.NewCall("/file/drop/form").WithExpand(apps.Expand{
+				OAuth2App:             apps.ExpandAll,
+				OAuth2User:            apps.ExpandAll,
+				ActingUserAccessToken: apps.ExpandAll,
+				ActingUser:            apps.ExpandAll,
+				Channel:               apps.ExpandAll,
+			}),
+		})
+
+		commandBinding.Bindings = append(commandBinding.Bindings, apps.Binding{
+			Location: "drops",
+			Label:    "drops",
+			Submit: apps.NewCall("/file/drops").WithExpand(apps.Expand{
+				ActingUser: apps.ExpandAll,
+			}),
+		})
+
+		commandBinding.Bindings = append(commandBinding.Bindings, apps.Binding{
+			Location: "shares",
+			Label:    "shares",
+			Submit: apps.NewCall("/shares").WithExpand(apps.Expand{
+				OAuth2App:             apps.ExpandAll,
+				OAuth2User:            apps.ExpandAll,
+				ActingUserAccessToken: apps.ExpandAll,
+				ActingUser:            apps.ExpandAll,
+			}),
+		})
+
+		commandBinding.Bindings = append(commandBinding.Bindings,
+			apps.Binding{
+				Location: "mkdir",
+				Label:    "mkdir",
+				Form: &apps.Form{
+					Title: "Create a Nextcloud folder",
+					Icon:  "icon.png",
+					Fields: []apps.Field{
+						{
+							Type:                 apps.FieldTypeText,
+							Name:                 "path",
+							Label:                "path",
+							Description:          "Folder path like Reports/2024, missing folders above it are created too",
+							IsRequired:           true,
+							AutocompletePosition: 1,
+						},
+					},
+					Submit: apps.NewCall("/mkdir").WithExpand(apps.Expand{
+						ActingUserAccessToken: apps.ExpandAll,
+						ActingUser:            apps.ExpandAll,
+						OAuth2App:             apps.ExpandAll,
+						OAuth2User:            apps.ExpandAll,
+					}),
+				},
+			})
+
+		commandBinding.Bindings = append(commandBinding.Bindings,
+			apps.Binding{
+				Location: "channel",
+				Label:    "channel",
+				Bindings: []apps.Binding{
+					{
+						Location: "link-folder",
+						Label:    "link-folder",
+						Form: &apps.Form{
+							Title: "Link the channel to a Nextcloud folder",
+							Icon:  "icon.png",
+							Fields: []apps.Field{
+								{
+									Type:                 apps.FieldTypeText,
+									Name:                 "path",
+									Label:                "path",
+									Description:          "Folder path like Projects/Apollo, files of the channel are uploaded there by default",
+									IsRequired:           true,
+									AutocompletePosition: 1,
+								},
+							},
+							Submit: apps.NewCall("/channel/link-folder").WithExpand(apps.Expand{
+								ActingUserAccessToken: apps.ExpandAll,
+								ActingUser:            apps.ExpandAll,
+								OAuth2App:             apps.ExpandAll,
+								OAuth2User:            apps.ExpandAll,
+								Channel:               apps.ExpandAll,
+								ChannelMember:         apps.ExpandAll,
+								TeamMember:            apps.ExpandAll,
+							}),
+						},
+					},
+					{
+						Location: "unlink-folder",
+						Label:    "unlink-folder",
+						Submit: apps.NewCall("/channel/unlink-folder").WithExpand(apps.Expand{
+							ActingUser:    apps.ExpandAll,
+							Channel:       apps.ExpandAll,
+							ChannelMember: apps.ExpandAll,
+							TeamMember:    apps.ExpandAll,
+						}),
+					},
+				},
+			})
+
 		commandBinding.Bindings = append(commandBinding.Bindings,
 			apps.Binding{
 				Location: "disconnect",
 				Label:    "disconnect",
-				Submit: apps.NewCall("/disconnect").WithExpand(apps.Expand{
-					ActingUserAccessToken: apps.ExpandAll,
-					ActingUser:            apps.ExpandAll,
-				}),
+				Form: &apps.Form{
+					Title: "Disconnect from Nextcloud",
+					Icon:  "icon.png",
+					Fields: []apps.Field{
+						{
+							Type:        apps.FieldTypeBool,
+							Name:        "forget_everything",
+							Label:       "forget-everything",
+							Description: "Remove the connection even if the token cannot be revoked at Nextcloud",
+						},
+					},
+					Submit: apps.NewCall("/disconnect").WithExpand(apps.Expand{
+						ActingUserAccessToken: apps.ExpandAll,
+						ActingUser:            apps.ExpandAll,
+						OAuth2App:             apps.ExpandAll,
+						OAuth2User:            apps.ExpandAll,
+					}),
+				},
 			})
 
 		commandBinding.Bindings = append(commandBinding.Bindings,
@@ -96,6 +233,70 @@ func Bindings(c *gin.Context) {
 				}),
 			})
 
+		commandBinding.Bindings = append(commandBinding.Bindings,
+			apps.Binding{
+				Location: "agenda",
+				Label:    "agenda",
+				Form: &apps.Form{
+					Title: "Show your agenda",
+					Icon:  "icon.png",
+					Fields: []apps.Field{
+						{
+							Type:        apps.FieldTypeStaticSelect,
+							Name:        "range",
+							Label:       "range",
+							Description: "today, tomorrow or week, events of all your calendars",
+							SelectStaticOptions: []apps.SelectOption{
+								{Label: "today", Value: "today"},
+								{Label: "tomorrow", Value: "tomorrow"},
+								{Label: "week", Value: "week"},
+							},
+							AutocompletePosition: 1,
+						},
+					},
+					Submit: apps.NewCall("/agenda").WithExpand(apps.Expand{
+						ActingUserAccessToken: apps.ExpandAll,
+						ActingUser:            apps.ExpandAll,
+						OAuth2App:             apps.ExpandAll,
+						OAuth2User:            apps.ExpandAll,
+					}),
+				},
+			})
+
+		commandBinding.Bindings = append(commandBinding.Bindings,
+			apps.Binding{
+				Location: "digest",
+				Label:    "digest",
+				Submit: apps.NewCall("/digest/form").WithExpand(apps.Expand{
+					ActingUser: apps.ExpandAll,
+				}),
+			})
+
+		commandBinding.Bindings = append(commandBinding.Bindings,
+			apps.Binding{
+				Location: "reminders",
+				Label:    "reminders",
+				Bindings: []apps.Binding{
+					{
+						Location: "on",
+						Label:    "on",
+						Submit: apps.NewCall("/reminders/on").WithExpand(apps.Expand{
+							ActingUserAccessToken: apps.ExpandAll,
+							ActingUser:            apps.ExpandAll,
+							OAuth2App:             apps.ExpandAll,
+							OAuth2User:            apps.ExpandAll,
+						}),
+					},
+					{
+						Location: "off",
+						Label:    "off",
+						Submit: apps.NewCall("/reminders/off").WithExpand(apps.Expand{
+							ActingUser: apps.ExpandAll,
+						}),
+					},
+				},
+			})
+
 		upload = apps.Binding{
 			Label:    "Upload file to Nextcloud",
 			Location: apps.Location("id"),
@@ -111,6 +312,17 @@ func Bindings(c *gin.Context) {
 
 	}
 
+	commandBinding.Bindings = append(commandBinding.Bindings, apps.Binding{
+		Location: "status",
+		Label:    "status",
+		Submit: apps.NewCall("/status").WithExpand(apps.Expand{
+			ActingUserAccessToken: apps.ExpandAll,
+			ActingUser:            apps.ExpandAll,
+			OAuth2App:             apps.ExpandAll,
+			OAuth2User:            apps.ExpandAll,
+		}),
+	})
+
 	if creq.Context.ActingUser.IsSystemAdmin() {
 		configure := apps.Binding{
 			Location: "configure",
@@ -146,6 +358,15 @@ func Bindings(c *gin.Context) {
 			},
 		}
 		commandBinding.Bindings = append(commandBinding.Bindings, configure)
+
+		commandBinding.Bindings = append(commandBinding.Bindings, apps.Binding{
+			Location: "settings",
+			Label:    "settings",
+			Submit: apps.NewCall("/settings/form").WithExpand(apps.Expand{
+				ActingUserAccessToken: apps.ExpandAll,
+				ActingUser:            apps.ExpandAll,
+			}),
+		})
 	}
 
 	commandBinding.Bindings = append(commandBinding.Bindings, apps.Binding{
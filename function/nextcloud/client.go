@@ -0,0 +1,194 @@
+package nextcloud
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-retryablehttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	RequestIdHeader = "X-Request-ID"
+	defaultTimeout  = 60 * time.Second
+	// streamHeaderTimeout is how long a streaming request waits for the response headers after its body is sent.
+	streamHeaderTimeout = 60 * time.Second
+)
+
+// streamingHttpClient has no total timeout, a big body on a slow link takes as long as it needs.
+// Only the connection setup and the wait for the response headers are limited.
+var streamingHttpClient = &http.Client{Transport: &http.Transport{
+	Proxy:                 http.ProxyFromEnvironment,
+	DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: streamHeaderTimeout,
+	IdleConnTimeout:       90 * time.Second,
+	MaxIdleConnsPerHost:   10,
+}}
+
+// Client sends requests to Nextcloud with the same auth, retry and error policy for every service.
+// Only idempotent methods with a replayable body are retried, so a failed MOVE or POST is never sent twice.
+type Client struct {
+	Token    string
+	RetryMax int
+	Timeout  time.Duration
+	// Streaming requests are not limited by Timeout, they end with the context of the client.
+	Streaming bool
+	// OnSuccess is called after every response with an expected status.
+	OnSuccess func()
+	ctx       context.Context
+}
+
+// NewClient creates a client which sends every request once. Set RetryMax to retry idempotent requests.
+func NewClient(token string) Client {
+	return Client{Token: token, Timeout: defaultTimeout}
+}
+
+// WithContext returns a copy of the client which cancels its requests together with ctx.
+func (c Client) WithContext(ctx context.Context) Client {
+	c.ctx = ctx
+	return c
+}
+
+// ForStreaming returns a copy of the client for uploads and downloads of file content.
+func (c Client) ForStreaming() Client {
+	c.Streaming = true
+	return c
+}
+
+// Do sends the request and checks the response status. If no expected statuses are passed, any 2xx status is accepted.
+// A response with another status is closed and returned as *RequestError.
+func (c Client) Do(req *http.Request, expectedStatuses ...int) (*http.Response, error) {
+	requestId := uuid.New().String()
+	logger := log.WithFields(log.Fields{"request_id": requestId, "method": req.Method, "url": req.URL.Redacted()})
+
+	ctx := req.Context()
+	if c.ctx != nil {
+		ctx = c.ctx
+	}
+	var cancel context.CancelFunc
+	if c.Streaming {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		timeout := c.Timeout
+		if timeout == 0 {
+			timeout = defaultTimeout
+		}
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set(RequestIdHeader, requestId)
+	if len(c.Token) != 0 && len(req.Header.Get("Authorization")) == 0 {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	logger.Debug("Sending request to Nextcloud")
+	resp, err := c.send(req, logger)
+	if err != nil {
+		cancel()
+		logger.Errorf("Request to Nextcloud failed. Error: %s", err)
+		return nil, &RequestError{Method: req.Method, Url: req.URL.Redacted(), RequestId: requestId, Err: err}
+	}
+	resp.Body = cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+
+	if !isExpectedStatus(resp.StatusCode, expectedStatuses) {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		logger.Errorf("Request to Nextcloud failed with status %s: %s", resp.Status, message)
+		return nil, newStatusError(req, resp.StatusCode, requestId)
+	}
+
+	logger.Debugf("Request to Nextcloud finished with status %s", resp.Status)
+	if c.OnSuccess != nil {
+		c.OnSuccess()
+	}
+	return resp, nil
+}
+
+func (c Client) send(req *http.Request, logger *log.Entry) (*http.Response, error) {
+	httpClient := http.DefaultClient
+	if c.Streaming {
+		httpClient = streamingHttpClient
+	}
+	if !isRetryable(req) || c.RetryMax == 0 {
+		return httpClient.Do(req)
+	}
+
+	retryReq, err := retryablehttp.FromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	retryClient := retryablehttp.NewClient()
+	if c.Streaming {
+		retryClient.HTTPClient = httpClient
+	}
+	retryClient.RetryMax = c.RetryMax
+	retryClient.Logger = requestLogger{logger}
+	retryClient.ErrorHandler = retryablehttp.PassthroughErrorHandler
+	return retryClient.Do(retryReq)
+}
+
+// isRetryable reports whether sending the request twice has the same effect as sending it once.
+// Streamed bodies cannot be replayed, so requests without GetBody are sent only once.
+func isRetryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, "PROPFIND", "REPORT", "SEARCH":
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	}
+	return false
+}
+
+func isExpectedStatus(status int, expectedStatuses []int) bool {
+	if len(expectedStatuses) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, s := range expectedStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnCloseBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+type requestLogger struct {
+	entry *log.Entry
+}
+
+func (l requestLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.entry.Error(formatLogMessage(msg, keysAndValues))
+}
+
+func (l requestLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.entry.Info(formatLogMessage(msg, keysAndValues))
+}
+
+func (l requestLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.entry.Debug(formatLogMessage(msg, keysAndValues))
+}
+
+func (l requestLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.entry.Warn(formatLogMessage(msg, keysAndValues))
+}
+
+func formatLogMessage(msg string, keysAndValues []interface{}) string {
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		msg = fmt.Sprintf("%s %v=%v", msg, keysAndValues[i], keysAndValues[i+1])
+	}
+	return msg
+}
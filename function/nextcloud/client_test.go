@@ -0,0 +1,152 @@
+package nextcloud
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBearerAuthAndRequestIdAreSent(t *testing.T) {
+	var authorization, requestId string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		requestId = r.Header.Get(RequestIdHeader)
+		w.WriteHeader(http.StatusMultiStatus)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("PROPFIND", server.URL, nil)
+	resp, err := Client{Token: "token"}.Do(req, http.StatusMultiStatus)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	resp.Body.Close()
+
+	if authorization != "Bearer token" {
+		t.Errorf(" expected %q, actual %q", "Bearer token", authorization)
+	}
+	if len(requestId) == 0 {
+		t.Error("Request id header should be sent")
+	}
+}
+
+func TestStatusIsMappedToTypedError(t *testing.T) {
+	statuses := map[int]error{
+		http.StatusUnauthorized:        ErrUnauthorized,
+		http.StatusForbidden:           ErrForbidden,
+		http.StatusNotFound:            ErrNotFound,
+		http.StatusConflict:            ErrConflict,
+		http.StatusPreconditionFailed:  ErrPreconditionFailed,
+		http.StatusLocked:              ErrLocked,
+		http.StatusInsufficientStorage: ErrInsufficientStorage,
+	}
+
+	for status, expected := range statuses {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+
+		req, _ := http.NewRequest("MKCOL", server.URL, nil)
+		_, err := Client{}.Do(req, http.StatusCreated)
+		server.Close()
+
+		var requestError *RequestError
+		if !errors.Is(err, expected) || !errors.As(err, &requestError) || requestError.StatusCode != status {
+			t.Errorf("status %d should be returned as %q, actual %v", status, expected, err)
+		}
+	}
+}
+
+func TestIdempotentRequestIsRetried(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("PUT", server.URL, strings.NewReader("chunk"))
+	resp, err := Client{RetryMax: 2}.Do(req, http.StatusCreated)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	resp.Body.Close()
+
+	if calls != 2 {
+		t.Errorf("expected 2 calls, actual %d", calls)
+	}
+}
+
+func TestNotIdempotentRequestIsNotRetried(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("MOVE", server.URL, nil)
+	_, err := Client{RetryMax: 2}.Do(req, http.StatusCreated)
+
+	if err == nil {
+		t.Error("Failed request should return an error")
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, actual %d", calls)
+	}
+}
+
+func TestRequestIsCancelledAfterTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	_, err := Client{Timeout: 50 * time.Millisecond}.Do(req)
+
+	if err == nil {
+		t.Error("Request should fail after timeout")
+	}
+}
+
+func TestStreamingIsNotLimitedByTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 3; i++ {
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			time.Sleep(40 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+	client := Client{Timeout: 50 * time.Millisecond}
+
+	read := func(client Client) error {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+
+	if err := read(client); err == nil {
+		t.Error("Body read after the timeout should fail")
+	}
+	if err := read(client.ForStreaming()); err != nil {
+		t.Errorf("Streamed body should be read completely, error %s", err)
+	}
+}
@@ -0,0 +1,63 @@
+package nextcloud
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrBadRequest          = errors.New("bad request")
+	ErrUnauthorized        = errors.New("unauthorized")
+	ErrForbidden           = errors.New("forbidden")
+	ErrNotFound            = errors.New("not found")
+	ErrConflict            = errors.New("conflict")
+	ErrPreconditionFailed  = errors.New("precondition failed")
+	ErrLocked              = errors.New("locked")
+	ErrInsufficientStorage = errors.New("insufficient storage")
+)
+
+var statusErrors = map[int]error{
+	http.StatusBadRequest:          ErrBadRequest,
+	http.StatusUnauthorized:        ErrUnauthorized,
+	http.StatusForbidden:           ErrForbidden,
+	http.StatusNotFound:            ErrNotFound,
+	http.StatusConflict:            ErrConflict,
+	http.StatusPreconditionFailed:  ErrPreconditionFailed,
+	http.StatusLocked:              ErrLocked,
+	http.StatusInsufficientStorage: ErrInsufficientStorage,
+}
+
+// RequestError is returned for failed requests. Known statuses wrap one of the Err* values,
+// so callers can check them with errors.Is.
+type RequestError struct {
+	Method     string
+	Url        string
+	StatusCode int
+	RequestId  string
+	Err        error
+}
+
+func newStatusError(req *http.Request, statusCode int, requestId string) *RequestError {
+	return &RequestError{
+		Method:     req.Method,
+		Url:        req.URL.Redacted(),
+		StatusCode: statusCode,
+		RequestId:  requestId,
+		Err:        statusErrors[statusCode],
+	}
+}
+
+func (e *RequestError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%s request failed (request id %s): %s", e.Method, e.RequestId, e.Err)
+	}
+	if e.Err == nil {
+		return fmt.Sprintf("%s request failed with code %d (request id %s)", e.Method, e.StatusCode, e.RequestId)
+	}
+	return fmt.Sprintf("%s request failed with code %d, %s (request id %s)", e.Method, e.StatusCode, e.Err, e.RequestId)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
@@ -1,33 +1,54 @@
 package oauth
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/mattermost/mattermost-plugin-apps/apps"
 	"github.com/pkg/errors"
-	"net/http"
-	"os"
-	"strings"
+)
+
+const (
+	StaticPathPrefix = "/static/"
+	ManifestPath     = "/manifest.json"
 )
 
 func JWTMiddleWare() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if isPublicPath(c.Request.URL.Path) {
+			return
+		}
 
-		var err error
-		if !strings.Contains(c.Request.RequestURI, "static") && (c.Request.RequestURI != "/manifest.json") {
-			_, err = checkJWT(c)
+		claims, err := checkJWT(c)
+		if err == nil {
+			err = checkActingUser(c, claims)
 		}
 
 		if err != nil {
-			c.JSON(http.StatusBadRequest, apps.NewErrorResponse(err))
+			c.AbortWithStatusJSON(http.StatusUnauthorized, apps.NewErrorResponse(err))
 			return
 		}
 	}
 }
 
+func isPublicPath(path string) bool {
+	return strings.HasPrefix(path, StaticPathPrefix) || path == ManifestPath
+}
+
 func checkJWT(c *gin.Context) (*apps.JWTClaims, error) {
 	secret := []byte(os.Getenv("JWT_SECRET"))
+	if len(secret) == 0 {
+		// Anyone could sign a token with an empty key, so no call is trusted without the secret.
+		return nil, errors.New("JWT_SECRET is not set, the app does not accept calls")
+	}
 
 	authValue := c.GetHeader(apps.OutgoingAuthHeader)
 	if !strings.HasPrefix(authValue, "Bearer ") {
@@ -45,5 +66,38 @@ func checkJWT(c *gin.Context) (*apps.JWTClaims, error) {
 	if err != nil {
 		return nil, err
 	}
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.New("JWT is expired or has no expiration time")
+	}
 	return &claims, nil
 }
+
+// checkActingUser compares the acting user of the call with the one Mattermost signed the JWT for.
+// The request body is restored, so handlers can decode the call again.
+func checkActingUser(c *gin.Context, claims *apps.JWTClaims) error {
+	if c.Request.Body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the call")
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	if len(body) == 0 {
+		return nil
+	}
+	creq := apps.CallRequest{}
+	if err := json.Unmarshal(body, &creq); err != nil {
+		return errors.Wrap(err, "failed to decode the call")
+	}
+
+	actingUserId := ""
+	if creq.Context.ActingUser != nil {
+		actingUserId = creq.Context.ActingUser.Id
+	}
+	if len(actingUserId) != 0 && actingUserId != claims.ActingUserID {
+		return errors.New("acting user of the call does not match the JWT")
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package oauth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+const testJWTSecret = "test-secret"
+
+func createJWT(actingUserId string, expiresAt int64) string {
+	claims := apps.JWTClaims{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: expiresAt},
+		ActingUserID:   actingUserId,
+	}
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testJWTSecret))
+	return token
+}
+
+func serveWithJWTMiddleware(t *testing.T, path string, jwtoken string, body string) (int, string) {
+	t.Setenv("JWT_SECRET", testJWTSecret)
+	gin.SetMode(gin.TestMode)
+
+	handledBody := ""
+	r := gin.New()
+	r.Use(JWTMiddleWare())
+	r.Any(path, func(c *gin.Context) {
+		b, _ := io.ReadAll(c.Request.Body)
+		handledBody = string(b)
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	if len(jwtoken) != 0 {
+		req.Header.Set(apps.OutgoingAuthHeader, "Bearer "+jwtoken)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code, handledBody
+}
+
+func TestValidJWTIsAccepted(t *testing.T) {
+	body := `{"context":{"acting_user":{"id":"user-id"}}}`
+	jwtoken := createJWT("user-id", time.Now().Add(time.Minute).Unix())
+
+	status, handledBody := serveWithJWTMiddleware(t, "/configure", jwtoken, body)
+
+	if status != http.StatusOK {
+		t.Errorf("expected %d, actual %d", http.StatusOK, status)
+	}
+	if handledBody != body {
+		t.Error("Call body should be available for the handler")
+	}
+}
+
+func TestCallWithoutJWTIsRejected(t *testing.T) {
+	status, _ := serveWithJWTMiddleware(t, "/configure", "", "{}")
+
+	if status != http.StatusUnauthorized {
+		t.Errorf("expected %d, actual %d", http.StatusUnauthorized, status)
+	}
+}
+
+func TestExpiredJWTIsRejected(t *testing.T) {
+	expired := createJWT("user-id", time.Now().Add(-time.Minute).Unix())
+	withoutExpiration := createJWT("user-id", 0)
+
+	for _, jwtoken := range []string{expired, withoutExpiration} {
+		status, _ := serveWithJWTMiddleware(t, "/configure", jwtoken, `{"context":{"acting_user":{"id":"user-id"}}}`)
+		if status != http.StatusUnauthorized {
+			t.Errorf("expected %d, actual %d", http.StatusUnauthorized, status)
+		}
+	}
+}
+
+func TestJWTOfAnotherUserIsRejected(t *testing.T) {
+	jwtoken := createJWT("another-user-id", time.Now().Add(time.Minute).Unix())
+
+	status, _ := serveWithJWTMiddleware(t, "/configure", jwtoken, `{"context":{"acting_user":{"id":"user-id"}}}`)
+
+	if status != http.StatusUnauthorized {
+		t.Errorf("expected %d, actual %d", http.StatusUnauthorized, status)
+	}
+}
+
+func TestPublicPathsDoNotNeedJWT(t *testing.T) {
+	for _, path := range []string{ManifestPath, StaticPathPrefix + "icon.png"} {
+		status, _ := serveWithJWTMiddleware(t, path, "", "")
+		if status != http.StatusOK {
+			t.Errorf("%s: expected %d, actual %d", path, http.StatusOK, status)
+		}
+	}
+
+	status, _ := serveWithJWTMiddleware(t, "/configure/static", "", "{}")
+	if status != http.StatusUnauthorized {
+		t.Errorf("expected %d, actual %d", http.StatusUnauthorized, status)
+	}
+}
+
+func TestJWTIsRejectedWithoutSecret(t *testing.T) {
+	claims := apps.JWTClaims{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix()},
+		ActingUserID:   "user-id",
+	}
+	jwtoken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(""))
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "")
+
+	r := gin.New()
+	r.Use(JWTMiddleWare())
+	r.POST("/configure", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest("POST", "/configure", strings.NewReader(`{}`))
+	req.Header.Set(apps.OutgoingAuthHeader, "Bearer "+jwtoken)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d, actual %d", http.StatusUnauthorized, w.Code)
+	}
+}
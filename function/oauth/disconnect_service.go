@@ -0,0 +1,187 @@
+package oauth
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	"github.com/prokhorind/nextcloud/function/user"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	NcUserKvKey = "nc-user-"
+	// MmUserKvKey maps a Mattermost user to the Nextcloud account, so other users can share files with it.
+	MmUserKvKey = "mm-user-"
+)
+
+// mappedUsers keeps the users whose mapping was checked by this instance, so bindings do not read the KV store every time.
+var mappedUsers sync.Map
+
+type UserMappingKVClient interface {
+	KVGet(prefix, id string, ref interface{}) error
+	KVSet(prefix, id string, in interface{}) (bool, error)
+}
+
+// SaveUserMapping maps the Mattermost user and the Nextcloud account in both directions.
+func SaveUserMapping(asBot UserMappingKVClient, mmUserId string, ncUserId string) error {
+	if _, err := asBot.KVSet("", NcUserKvKey+ncUserId, mmUserId); err != nil {
+		return err
+	}
+	if _, err := asBot.KVSet("", MmUserKvKey+mmUserId, ncUserId); err != nil {
+		return err
+	}
+	mappedUsers.Store(mmUserId, ncUserId)
+	return nil
+}
+
+// BackfillUserMapping saves the mapping of a user who connected before the Mattermost user mapping existed.
+func BackfillUserMapping(asBot UserMappingKVClient, mmUserId string, ncUserId string) error {
+	if mapped, ok := mappedUsers.Load(mmUserId); ok && mapped == ncUserId {
+		return nil
+	}
+	var mappedNcUserId string
+	asBot.KVGet("", MmUserKvKey+mmUserId, &mappedNcUserId)
+	if mappedNcUserId == ncUserId {
+		mappedUsers.Store(mmUserId, ncUserId)
+		return nil
+	}
+	log.Infof("Saving the Nextcloud account mapping of user %s", mmUserId)
+	return SaveUserMapping(asBot, mmUserId, ncUserId)
+}
+
+type UserDataStore interface {
+	DeleteUserData(mmUserId string, ncUserId string) error
+}
+
+// MMUserDataStore keeps the data of the connected user in Mattermost: the OAuth2 token and its refreshed copy,
+// the user mappings in both directions, the user settings and the last call time.
+type MMUserDataStore struct {
+	Creq apps.CallRequest
+}
+
+func (s MMUserDataStore) DeleteUserData(mmUserId string, ncUserId string) error {
+	asActingUser := appclient.AsActingUser(s.Creq.Context)
+	if err := asActingUser.StoreOAuth2User(nil); err != nil {
+		return errors.Wrap(err, "Nextcloud token was not removed")
+	}
+
+	asBot := appclient.AsBot(s.Creq.Context)
+	if len(ncUserId) != 0 {
+		var mappedUserId string
+		asBot.KVGet("", NcUserKvKey+ncUserId, &mappedUserId)
+		if mappedUserId == mmUserId {
+			if err := asBot.KVDelete("", NcUserKvKey+ncUserId); err != nil {
+				return errors.Wrap(err, "Nextcloud user mapping was not removed")
+			}
+		}
+	}
+
+	if err := asBot.KVDelete("", MmUserKvKey+mmUserId); err != nil {
+		return errors.Wrap(err, "Mattermost user mapping was not removed")
+	}
+	if err := asBot.KVDelete("", user.UserSettingsKvKey+mmUserId); err != nil {
+		return errors.Wrap(err, "User settings were not removed")
+	}
+	if err := asBot.KVDelete("", user.LastCallKvKey+mmUserId); err != nil {
+		return errors.Wrap(err, "Last call time was not removed")
+	}
+	if err := asBot.KVDelete("", LatestTokenKvKey+mmUserId); err != nil {
+		return errors.Wrap(err, "Refreshed token was not removed")
+	}
+	return nil
+}
+
+// DisconnectHook removes the data a feature keeps for the user, e.g. reminders or file drops. It is called before
+// the token is revoked, so it can clean up in Nextcloud with the access token, which is empty when the token is
+// not available. With forgetEverything the local data has to be removed even if Nextcloud cannot be reached.
+type DisconnectHook func(creq apps.CallRequest, mmUserId string, accessToken string, forgetEverything bool) error
+
+var (
+	hooksMu         sync.RWMutex
+	disconnectHooks []DisconnectHook
+)
+
+func RegisterDisconnectHook(hook DisconnectHook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	disconnectHooks = append(disconnectHooks, hook)
+}
+
+func getDisconnectHooks() []DisconnectHook {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+	return append([]DisconnectHook{}, disconnectHooks...)
+}
+
+type DisconnectService interface {
+	Disconnect(mmUserId string, ncUserId string, forgetEverything bool) error
+}
+
+type DisconnectServiceImpl struct {
+	RemoteUrl    string
+	Client       nextcloud.Client
+	TokenService TokenService
+	Store        UserDataStore
+	Creq         apps.CallRequest
+	Hooks        []DisconnectHook
+}
+
+// Disconnect removes the data of the features, revokes the token at Nextcloud and removes the user data.
+// If the data or the token cannot be removed, the connection is kept, so the user can retry.
+// With forgetEverything the data is removed anyway.
+func (s DisconnectServiceImpl) Disconnect(mmUserId string, ncUserId string, forgetEverything bool) error {
+	token, tokenErr := s.TokenService.GetActualToken()
+	accessToken := ""
+	if tokenErr == nil {
+		accessToken = token.AccessToken
+	}
+	for _, hook := range s.Hooks {
+		if err := hook(s.Creq, mmUserId, accessToken, forgetEverything); err != nil {
+			if !forgetEverything {
+				return errors.Wrap(err, "Data of the app was not removed. Use the forget-everything flag to remove the connection anyway")
+			}
+			log.Warnf("Data of user %s was not removed from Nextcloud, removing local data anyway. Error: %s", mmUserId, err)
+		}
+	}
+
+	err := tokenErr
+	if err == nil {
+		err = s.revokeToken(accessToken)
+	}
+	if err != nil {
+		if !forgetEverything {
+			return errors.Wrap(err, "Nextcloud token was not revoked. Use the forget-everything flag to remove the connection anyway")
+		}
+		log.Warnf("Nextcloud token of user %s was not revoked, removing local data anyway. Error: %s", mmUserId, err)
+	}
+
+	if err := s.Store.DeleteUserData(mmUserId, ncUserId); err != nil {
+		log.Errorf("Data of user %s was not removed. Error: %s", mmUserId, err)
+		return err
+	}
+	ForgetToken(mmUserId)
+	mappedUsers.Delete(mmUserId)
+	return nil
+}
+
+func (s DisconnectServiceImpl) revokeToken(accessToken string) error {
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/ocs/v2.php/core/apppassword", s.RemoteUrl), nil)
+	req.Header.Set("OCS-APIRequest", "true")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if errors.Is(err, nextcloud.ErrUnauthorized) {
+		log.Info("Nextcloud token is already revoked")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
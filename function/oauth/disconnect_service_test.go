@@ -0,0 +1,154 @@
+package oauth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+)
+
+type TokenServiceMock struct {
+	err error
+}
+
+func (s TokenServiceMock) GetActualToken() (*Token, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &Token{AccessToken: "access-token", UserID: "nc-user"}, nil
+}
+
+type UserDataStoreMock struct {
+	deleted bool
+}
+
+func (s *UserDataStoreMock) DeleteUserData(mmUserId string, ncUserId string) error {
+	s.deleted = true
+	return nil
+}
+
+func createRevokeServer(status int, revoked *bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "DELETE" && r.URL.Path == "/ocs/v2.php/core/apppassword" && r.Header.Get("Authorization") == "Bearer access-token" {
+			*revoked = true
+		}
+		w.WriteHeader(status)
+	}))
+}
+
+func TestTokenIsRevokedOnDisconnect(t *testing.T) {
+	revoked := false
+	server := createRevokeServer(http.StatusOK, &revoked)
+	defer server.Close()
+	store := &UserDataStoreMock{}
+	testedInstance := DisconnectServiceImpl{RemoteUrl: server.URL, Client: nextcloud.NewClient(""), TokenService: TokenServiceMock{}, Store: store}
+
+	err := testedInstance.Disconnect("mm-user", "nc-user", false)
+
+	if err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+	if !revoked || !store.deleted {
+		t.Error("Token should be revoked and user data removed")
+	}
+}
+
+func TestConnectionIsKeptWhenRevokeFails(t *testing.T) {
+	revoked := false
+	server := createRevokeServer(http.StatusInternalServerError, &revoked)
+	defer server.Close()
+	store := &UserDataStoreMock{}
+	testedInstance := DisconnectServiceImpl{RemoteUrl: server.URL, Client: nextcloud.NewClient(""), TokenService: TokenServiceMock{}, Store: store}
+
+	err := testedInstance.Disconnect("mm-user", "nc-user", false)
+
+	if err == nil || store.deleted {
+		t.Error("User data should be kept when the token is not revoked")
+	}
+}
+
+func TestForgetEverythingRemovesDataWhenRevokeFails(t *testing.T) {
+	store := &UserDataStoreMock{}
+	testedInstance := DisconnectServiceImpl{TokenService: TokenServiceMock{err: errors.New("refresh failed")}, Store: store}
+
+	err := testedInstance.Disconnect("mm-user", "nc-user", true)
+
+	if err != nil || !store.deleted {
+		t.Error("User data should be removed with forget everything flag")
+	}
+}
+
+func TestHooksGetTokenBeforeItIsRevoked(t *testing.T) {
+	revoked := false
+	server := createRevokeServer(http.StatusOK, &revoked)
+	defer server.Close()
+	store := &UserDataStoreMock{}
+	var hookToken string
+	hook := func(creq apps.CallRequest, mmUserId string, accessToken string, forgetEverything bool) error {
+		if revoked {
+			t.Error("Hook should be called before the token is revoked")
+		}
+		hookToken = accessToken
+		return nil
+	}
+	testedInstance := DisconnectServiceImpl{RemoteUrl: server.URL, Client: nextcloud.NewClient(""), TokenService: TokenServiceMock{}, Store: store, Hooks: []DisconnectHook{hook}}
+
+	if err := testedInstance.Disconnect("mm-user", "nc-user", false); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+	if hookToken != "access-token" || !revoked || !store.deleted {
+		t.Errorf("Unexpected disconnect, hook token %q, revoked %t, deleted %t", hookToken, revoked, store.deleted)
+	}
+}
+
+func TestConnectionIsKeptWhenHookFails(t *testing.T) {
+	store := &UserDataStoreMock{}
+	hook := func(creq apps.CallRequest, mmUserId string, accessToken string, forgetEverything bool) error {
+		return errors.New("webhook was not deleted")
+	}
+	testedInstance := DisconnectServiceImpl{TokenService: TokenServiceMock{err: errors.New("refresh failed")}, Store: store, Hooks: []DisconnectHook{hook}}
+
+	if err := testedInstance.Disconnect("mm-user", "nc-user", false); err == nil || store.deleted {
+		t.Error("User data should be kept when the hook fails")
+	}
+	if err := testedInstance.Disconnect("mm-user", "nc-user", true); err != nil || !store.deleted {
+		t.Error("User data should be removed with forget everything flag")
+	}
+}
+
+type UserMappingKVMock struct {
+	values map[string]string
+	sets   int
+}
+
+func (m *UserMappingKVMock) KVGet(prefix, id string, ref interface{}) error {
+	if value, ok := m.values[id]; ok {
+		*ref.(*string) = value
+	}
+	return nil
+}
+
+func (m *UserMappingKVMock) KVSet(prefix, id string, in interface{}) (bool, error) {
+	m.sets++
+	m.values[id] = in.(string)
+	return true, nil
+}
+
+func TestMappingOfUserConnectedEarlierIsBackfilled(t *testing.T) {
+	kv := &UserMappingKVMock{values: map[string]string{NcUserKvKey + "nc-user": "mm-user"}}
+
+	if err := BackfillUserMapping(kv, "mm-user", "nc-user"); err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+	BackfillUserMapping(kv, "mm-user", "nc-user")
+
+	if kv.values[MmUserKvKey+"mm-user"] != "nc-user" {
+		t.Errorf(" expected %q, actual %q", "nc-user", kv.values[MmUserKvKey+"mm-user"])
+	}
+	if kv.sets != 2 {
+		t.Errorf("Mapping should be saved once, actual %d writes", kv.sets)
+	}
+}
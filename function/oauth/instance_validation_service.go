@@ -0,0 +1,209 @@
+package oauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	log "github.com/sirupsen/logrus"
+)
+
+// detectedApps are the apps which publish capabilities. Calendar does not, it is served by the DAV endpoint.
+var detectedApps = []string{"files_sharing", "spreed", "deck"}
+
+type InstanceInfo struct {
+	Url     string
+	Version string
+	Apps    map[string]bool
+}
+
+func (i InstanceInfo) Summary() string {
+	enabled := make([]string, 0)
+	disabled := make([]string, 0)
+	for _, app := range detectedApps {
+		if i.Apps[app] {
+			enabled = append(enabled, app)
+		} else {
+			disabled = append(disabled, app)
+		}
+	}
+	summary := fmt.Sprintf("Nextcloud %s at %s.", i.Version, i.Url)
+	if len(enabled) != 0 {
+		summary = fmt.Sprintf("%s Enabled apps: %s.", summary, strings.Join(enabled, ", "))
+	}
+	if len(disabled) != 0 {
+		summary = fmt.Sprintf("%s Disabled apps: %s.", summary, strings.Join(disabled, ", "))
+	}
+	return summary
+}
+
+type InstanceValidationService interface {
+	Validate(instanceUrl string) (*InstanceInfo, error)
+}
+
+type InstanceValidationServiceImpl struct {
+	Client nextcloud.Client
+}
+
+type instanceStatus struct {
+	Installed     bool   `json:"installed"`
+	Maintenance   bool   `json:"maintenance"`
+	NeedsDbUpdate bool   `json:"needsDbUpgrade"`
+	VersionString string `json:"versionstring"`
+}
+
+type capabilitiesResponse struct {
+	Ocs struct {
+		Data struct {
+			Capabilities map[string]json.RawMessage `json:"capabilities"`
+		} `json:"data"`
+	} `json:"ocs"`
+}
+
+type tokenErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Validate checks that the instance is a working Nextcloud with enabled OAuth2 and DAV endpoints.
+// The client credentials cannot be checked without a user, Nextcloud verifies them on the first connect.
+// The returned info contains the normalized url, which should be stored instead of the entered one.
+func (s InstanceValidationServiceImpl) Validate(instanceUrl string) (*InstanceInfo, error) {
+	normalizedUrl, err := NormalizeInstanceUrl(instanceUrl)
+	if err != nil {
+		return nil, err
+	}
+	info := InstanceInfo{Url: normalizedUrl, Apps: map[string]bool{}}
+
+	status, err := s.getStatus(normalizedUrl)
+	if err != nil {
+		return nil, err
+	}
+	info.Version = status.VersionString
+
+	capabilities, err := s.getCapabilities(normalizedUrl)
+	if err != nil {
+		return nil, err
+	}
+	for _, app := range detectedApps {
+		_, info.Apps[app] = capabilities[app]
+	}
+
+	if err := s.checkOauth2App(normalizedUrl); err != nil {
+		return nil, err
+	}
+	if err := s.checkDav(normalizedUrl); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// NormalizeInstanceUrl removes the parts of the url which are added to it by the app, like a trailing slash or index.php.
+func NormalizeInstanceUrl(instanceUrl string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(instanceUrl))
+	if err != nil {
+		return "", errors.Wrap(err, "Instance url is not valid")
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", errors.Errorf("Instance url %q must start with https:// or http://", instanceUrl)
+	}
+	if len(u.Host) == 0 {
+		return "", errors.Errorf("Instance url %q does not contain a host", instanceUrl)
+	}
+	if len(u.RawQuery) != 0 || len(u.Fragment) != 0 {
+		return "", errors.Errorf("Instance url %q must not contain a query or a fragment", instanceUrl)
+	}
+
+	path := strings.TrimRight(u.Path, "/")
+	path = strings.TrimSuffix(path, "/index.php")
+	path = strings.TrimRight(path, "/")
+
+	return fmt.Sprintf("%s://%s%s", u.Scheme, u.Host, path), nil
+}
+
+func (s InstanceValidationServiceImpl) getStatus(instanceUrl string) (*instanceStatus, error) {
+	req, _ := http.NewRequest("GET", instanceUrl+"/status.php", nil)
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if err != nil {
+		log.Errorf("Nextcloud status was not received. Error: %s", err)
+		return nil, errors.Wrapf(err, "%s is not reachable or is not a Nextcloud instance", instanceUrl)
+	}
+	defer resp.Body.Close()
+
+	status := instanceStatus{}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil || len(status.VersionString) == 0 {
+		return nil, errors.Errorf("%s/status.php did not return a Nextcloud status. Check that the url points to Nextcloud", instanceUrl)
+	}
+	if !status.Installed {
+		return nil, errors.Errorf("Nextcloud at %s is not installed", instanceUrl)
+	}
+	if status.Maintenance {
+		return nil, errors.Errorf("Nextcloud at %s is in maintenance mode", instanceUrl)
+	}
+	if status.NeedsDbUpdate {
+		return nil, errors.Errorf("Nextcloud at %s needs a database upgrade", instanceUrl)
+	}
+	return &status, nil
+}
+
+func (s InstanceValidationServiceImpl) getCapabilities(instanceUrl string) (map[string]json.RawMessage, error) {
+	req, _ := http.NewRequest("GET", instanceUrl+"/ocs/v2.php/cloud/capabilities?format=json", nil)
+	req.Header.Set("OCS-APIRequest", "true")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if err != nil {
+		log.Errorf("Nextcloud capabilities were not received. Error: %s", err)
+		return nil, errors.Wrap(err, "Nextcloud capabilities endpoint does not respond")
+	}
+	defer resp.Body.Close()
+
+	capabilities := capabilitiesResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&capabilities); err != nil {
+		return nil, errors.Wrap(err, "Nextcloud capabilities response is not valid")
+	}
+	return capabilities.Ocs.Data.Capabilities, nil
+}
+
+// checkOauth2App sends a token request with an unknown code. A working OAuth2 app answers it with a JSON error,
+// while a disabled one returns 404. The code is looked up before the client, so the error says nothing about the client.
+func (s InstanceValidationServiceImpl) checkOauth2App(instanceUrl string) error {
+	body, _ := json.Marshal(RequestTokenBody{Code: "nextcloud-app-configuration-check", GrantType: "authorization_code"})
+	req, _ := http.NewRequest("POST", instanceUrl+"/index.php/apps/oauth2/api/v1/token", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	resp, err := s.Client.Do(req, http.StatusBadRequest)
+	if errors.Is(err, nextcloud.ErrNotFound) {
+		return errors.New("Nextcloud OAuth2 app is not enabled")
+	}
+	if err != nil {
+		log.Errorf("Nextcloud OAuth2 app check failed. Error: %s", err)
+		return errors.Wrap(err, "Nextcloud OAuth2 token endpoint does not respond as expected")
+	}
+	defer resp.Body.Close()
+
+	tokenError := tokenErrorResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenError); err != nil || len(tokenError.Error) == 0 {
+		return errors.New("Nextcloud OAuth2 token endpoint does not respond as expected")
+	}
+	return nil
+}
+
+// checkDav expects DAV to ask for credentials, because the request is sent without them.
+func (s InstanceValidationServiceImpl) checkDav(instanceUrl string) error {
+	req, _ := http.NewRequest("PROPFIND", instanceUrl+"/remote.php/dav/", nil)
+	req.Header.Set("Depth", "0")
+
+	resp, err := s.Client.Do(req, http.StatusUnauthorized, http.StatusMultiStatus)
+	if err != nil {
+		log.Errorf("Nextcloud DAV check failed. Error: %s", err)
+		return errors.Wrap(err, "Nextcloud DAV endpoint does not respond")
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,107 @@
+package oauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+)
+
+type fakeInstance struct {
+	maintenance    bool
+	oauth2Disabled bool
+	tokenError     string
+}
+
+func (f fakeInstance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/status.php":
+		if f.maintenance {
+			w.Write([]byte(`{"installed":true,"maintenance":true,"needsDbUpgrade":false,"versionstring":"27.1.2"}`))
+			return
+		}
+		w.Write([]byte(`{"installed":true,"maintenance":false,"needsDbUpgrade":false,"versionstring":"27.1.2"}`))
+	case "/ocs/v2.php/cloud/capabilities":
+		w.Write([]byte(`{"ocs":{"data":{"capabilities":{"files_sharing":{},"spreed":{}}}}}`))
+	case "/index.php/apps/oauth2/api/v1/token":
+		if f.oauth2Disabled {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"` + f.tokenError + `"}`))
+	case "/remote.php/dav/":
+		w.WriteHeader(http.StatusUnauthorized)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestValidInstanceIsDetected(t *testing.T) {
+	server := httptest.NewServer(fakeInstance{tokenError: "invalid_request"})
+	defer server.Close()
+
+	testedInstance := InstanceValidationServiceImpl{Client: nextcloud.NewClient("")}
+	info, err := testedInstance.Validate(" " + server.URL + "/index.php/ ")
+
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if info.Url != server.URL {
+		t.Errorf("expected %s, actual %s", server.URL, info.Url)
+	}
+	if info.Version != "27.1.2" {
+		t.Errorf("expected %s, actual %s", "27.1.2", info.Version)
+	}
+	if !info.Apps["files_sharing"] || !info.Apps["spreed"] || info.Apps["deck"] {
+		t.Errorf("Enabled apps are not detected: %v", info.Apps)
+	}
+}
+
+func TestInstanceInMaintenanceIsRejected(t *testing.T) {
+	server := httptest.NewServer(fakeInstance{maintenance: true})
+	defer server.Close()
+
+	testedInstance := InstanceValidationServiceImpl{Client: nextcloud.NewClient("")}
+	_, err := testedInstance.Validate(server.URL)
+
+	if err == nil || !strings.Contains(err.Error(), "maintenance") {
+		t.Errorf("Instance in maintenance should be rejected, actual %v", err)
+	}
+}
+
+func TestDisabledOauth2AppIsRejected(t *testing.T) {
+	server := httptest.NewServer(fakeInstance{oauth2Disabled: true})
+	defer server.Close()
+
+	testedInstance := InstanceValidationServiceImpl{Client: nextcloud.NewClient("")}
+	_, err := testedInstance.Validate(server.URL)
+
+	if err == nil || !strings.Contains(err.Error(), "OAuth2 app is not enabled") {
+		t.Errorf("Disabled OAuth2 app should be rejected, actual %v", err)
+	}
+}
+
+func TestInstanceUrlIsNormalized(t *testing.T) {
+	urls := map[string]string{
+		"https://cloud.example.com/":              "https://cloud.example.com",
+		"https://cloud.example.com/nextcloud//":   "https://cloud.example.com/nextcloud",
+		"https://cloud.example.com/index.php":     "https://cloud.example.com",
+		" http://localhost:8080/index.php/ ":      "http://localhost:8080",
+		"https://cloud.example.com/nc/index.php/": "https://cloud.example.com/nc",
+	}
+	for instanceUrl, expected := range urls {
+		actual, err := NormalizeInstanceUrl(instanceUrl)
+		if err != nil || actual != expected {
+			t.Errorf("%q: expected %s, actual %s, error %v", instanceUrl, expected, actual, err)
+		}
+	}
+
+	for _, instanceUrl := range []string{"cloud.example.com", "https://", "https://cloud.example.com/?a=b"} {
+		if _, err := NormalizeInstanceUrl(instanceUrl); err == nil {
+			t.Errorf("%q should be rejected", instanceUrl)
+		}
+	}
+}
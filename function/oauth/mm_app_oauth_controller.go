@@ -4,10 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mattermost/mattermost-plugin-apps/apps"
 	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/settings"
+	log "github.com/sirupsen/logrus"
 )
 
 func Configure(c *gin.Context) {
@@ -18,15 +22,27 @@ func Configure(c *gin.Context) {
 	clientId, _ := creq.Values["client_id"].(string)
 	clientSecret, _ := creq.Values["client_secret"].(string)
 
+	validationService := InstanceValidationServiceImpl{Client: settings.ForCall(creq).NewClient(c.Request.Context(), "")}
+	instanceInfo, err := validationService.Validate(instanceUrl)
+	if err != nil {
+		log.Errorf("Nextcloud instance %s is not valid. Error: %s", instanceUrl, err)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Wrap(err, "OAuth client credentials were not updated")))
+		return
+	}
+
 	asUser := appclient.AsActingUser(creq.Context)
 
-	asUser.StoreOAuth2App(apps.OAuth2App{
-		RemoteRootURL: instanceUrl,
+	err = asUser.StoreOAuth2App(apps.OAuth2App{
+		RemoteRootURL: instanceInfo.Url,
 		ClientID:      clientId,
 		ClientSecret:  clientSecret,
 	})
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Wrap(err, "OAuth client credentials were not updated")))
+		return
+	}
 
-	c.JSON(http.StatusOK, apps.NewTextResponse("updated OAuth client credentials"))
+	c.JSON(http.StatusOK, apps.NewTextResponse("updated OAuth client credentials, Nextcloud checks them when a user connects. %s", instanceInfo.Summary()))
 
 }
 
@@ -43,19 +59,38 @@ func Oauth2Connect(c *gin.Context) {
 	creq := apps.CallRequest{}
 	json.NewDecoder(c.Request.Body).Decode(&creq)
 
-	url := buildConnectUrl(&creq)
+	state, _ := creq.Values["state"].(string)
+	stateService := OauthStateService{Store: BotOauthStateStore{Creq: creq}}
+	challenge, err := stateService.CreateCodeChallenge(state, creq.Context.ActingUser.Id)
+	if err != nil {
+		log.Errorf("OAuth2 connect url was not created. Error: %s", err)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(err))
+		return
+	}
+
+	connectUrl := buildConnectUrl(&creq, challenge)
 
-	c.JSON(http.StatusOK, apps.NewDataResponse(url))
+	c.JSON(http.StatusOK, apps.NewDataResponse(connectUrl))
 }
 
 func Oauth2Complete(c *gin.Context) {
 	creq := apps.CallRequest{}
 	json.NewDecoder(c.Request.Body).Decode(&creq)
 
-	resp, refreshErr := getToken(creq)
+	code, _ := creq.Values["code"].(string)
+	state, _ := creq.Values["state"].(string)
 
-	if refreshErr != nil {
-		c.JSON(http.StatusOK, apps.NewErrorResponse(refreshErr))
+	stateService := OauthStateService{Store: BotOauthStateStore{Creq: creq}}
+	codeVerifier, stateErr := stateService.RedeemState(state, creq.Context.ActingUser.Id)
+	if stateErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(stateErr))
+		return
+	}
+
+	oauthService := OauthServiceImpl{Creq: creq}
+	resp, tokenErr := oauthService.GetToken(code, codeVerifier)
+	if tokenErr != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(tokenErr))
 		return
 	}
 
@@ -63,30 +98,51 @@ func Oauth2Complete(c *gin.Context) {
 	asActingUser.StoreOAuth2User(*resp)
 
 	asBot := appclient.AsBot(creq.Context)
-	asBot.KVSet("", fmt.Sprintf("nc-user-%s", resp.UserID), creq.Context.ActingUser.Id)
+	if err := SaveUserMapping(asBot, creq.Context.ActingUser.Id, resp.UserID); err != nil {
+		log.Errorf("Nextcloud account mapping of user %s was not saved. Error: %s", creq.Context.ActingUser.Id, err)
+	}
 
 	//ConfigureWebhooks(creq, resp.AccessToken, true)
 
 	c.JSON(http.StatusOK, apps.NewTextResponse("completed oauth"))
 }
 
-func buildConnectUrl(creq *apps.CallRequest) string {
+func buildConnectUrl(creq *apps.CallRequest, codeChallenge string) string {
 	remoteUrl := creq.Context.ExpandedContext.OAuth2.OAuth2App.RemoteRootURL
 	clientId := creq.Context.ExpandedContext.OAuth2.OAuth2App.ClientID
 	state := creq.Values["state"].(string)
 
-	url := fmt.Sprintf("%s/index.php/apps/oauth2/authorize?response_type=code&state=%s&client_id=%s", remoteUrl, state, clientId)
-	return url
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("state", state)
+	query.Set("client_id", clientId)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	if completeUrl := creq.Context.ExpandedContext.OAuth2.CompleteURL; len(completeUrl) != 0 {
+		query.Set("redirect_uri", completeUrl)
+	}
+
+	return fmt.Sprintf("%s/index.php/apps/oauth2/authorize?%s", remoteUrl, query.Encode())
 }
 
 func Disconnect(c *gin.Context) {
 	creq := apps.CallRequest{}
 	json.NewDecoder(c.Request.Body).Decode(&creq)
 
-	asActingUser := appclient.AsActingUser(creq.Context)
-	err := asActingUser.StoreOAuth2User(nil)
+	forgetEverything, _ := creq.Values["forget_everything"].(bool)
+	disconnectService := DisconnectServiceImpl{
+		RemoteUrl:    creq.Context.OAuth2.OAuth2App.RemoteRootURL,
+		Client:       settings.ForCall(creq).NewClient(c.Request.Context(), ""),
+		TokenService: TokenServiceImpl{Creq: creq},
+		Store:        MMUserDataStore{Creq: creq},
+		Creq:         creq,
+		Hooks:        getDisconnectHooks(),
+	}
+
+	err := disconnectService.Disconnect(creq.Context.ActingUser.Id, GetStoredToken(creq).UserID, forgetEverything)
 	if err != nil {
-		panic(err)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(err))
+		return
 	}
 
 	c.JSON(http.StatusOK, apps.CallResponse{
@@ -10,12 +10,13 @@ func TestShouldGenerateUrl(t *testing.T) {
 	creq := apps.CallRequest{}
 	creq.Context.ExpandedContext.OAuth2.OAuth2App.RemoteRootURL = "http://localhost:8082"
 	creq.Context.ExpandedContext.OAuth2.OAuth2App.ClientID = "CLIENT_ID"
+	creq.Context.ExpandedContext.OAuth2.CompleteURL = "http://mattermost/oauth2/complete"
 	m := make(map[string]interface{})
 	m["state"] = "state"
 	creq.Values = m
 
-	expected := "http://localhost:8082/index.php/apps/oauth2/authorize?response_type=code&state=state&client_id=CLIENT_ID"
-	actual := buildConnectUrl(&creq)
+	expected := "http://localhost:8082/index.php/apps/oauth2/authorize?client_id=CLIENT_ID&code_challenge=CHALLENGE&code_challenge_method=S256&redirect_uri=http%3A%2F%2Fmattermost%2Foauth2%2Fcomplete&response_type=code&state=state"
+	actual := buildConnectUrl(&creq, "CHALLENGE")
 
 	if expected != actual {
 		t.Errorf(" expected %q, actual %q", expected, actual)
@@ -1,5 +1,7 @@
 package oauth
 
+import "time"
+
 type Connect struct {
 	Poll struct {
 		Token    string `json:"token"`
@@ -20,11 +22,25 @@ type Token struct {
 	ExpiresIn    int    `json:"expires_in"`
 	RefreshToken string `json:"refresh_token"`
 	UserID       string `json:"user_id"`
+	ExpiresAt    int64  `json:"expires_at,omitempty"`
+}
+
+func (t *Token) SetExpiresAt(now time.Time) {
+	t.ExpiresAt = now.Add(time.Duration(t.ExpiresIn) * time.Second).Unix()
+}
+
+func (t Token) IsValid() bool {
+	if len(t.AccessToken) == 0 || t.ExpiresAt == 0 {
+		return false
+	}
+	return time.Now().Add(tokenRefreshMargin).Unix() < t.ExpiresAt
 }
 
 type RequestTokenBody struct {
-	Code      string `json:"code"`
-	GrantType string `json:"grant_type"`
+	Code         string `json:"code"`
+	GrantType    string `json:"grant_type"`
+	RedirectUri  string `json:"redirect_uri,omitempty"`
+	CodeVerifier string `json:"code_verifier,omitempty"`
 }
 
 type RefreshTokenBody struct {
@@ -38,3 +54,10 @@ type CreateWebhooksBody struct {
 	CalendarEventUpdatedURL string `json:"calendar_event_updated_url"`
 	WebhookSecret           string `json:"webhook_secret"`
 }
+
+// OauthState is kept between the connect and the complete calls of one OAuth2 flow.
+type OauthState struct {
+	CodeVerifier string `json:"code_verifier"`
+	UserId       string `json:"user_id"`
+	CreatedAt    int64  `json:"created_at"`
+}
@@ -2,34 +2,34 @@ package oauth
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"github.com/hashicorp/go-retryablehttp"
 	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	"github.com/prokhorind/nextcloud/function/settings"
 	log "github.com/sirupsen/logrus"
 	"net/http"
-	"os"
-	"strconv"
+	"time"
 
 	"github.com/mattermost/mattermost-plugin-apps/apps"
 )
 
 type OauthService interface {
-	RefreshToken() (*Token, error)
+	RefreshToken(refreshToken string) (*Token, error)
 }
 
 type OauthServiceImpl struct {
 	Creq apps.CallRequest
 }
 
-func (s OauthServiceImpl) RefreshToken() (*Token, error) {
+func (s OauthServiceImpl) RefreshToken(refreshToken string) (*Token, error) {
 
 	clientId := s.Creq.Context.OAuth2.OAuth2App.ClientID
 	clientSecret := s.Creq.Context.OAuth2.OAuth2App.ClientSecret
 	remoteUrl := s.Creq.Context.OAuth2.OAuth2App.RemoteRootURL
 
 	reqUrl := fmt.Sprintf("%s/index.php/apps/oauth2/api/v1/token", remoteUrl)
-	refreshToken := s.Creq.Context.OAuth2.User.(map[string]interface{})["refresh_token"].(string)
 
 	payload := RefreshTokenBody{
 		RefreshToken: refreshToken,
@@ -42,43 +42,41 @@ func (s OauthServiceImpl) RefreshToken() (*Token, error) {
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 	req.SetBasicAuth(clientId, clientSecret)
 
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
-	client := retryClient.StandardClient()
-	resp, err := client.Do(req)
-	defer resp.Body.Close()
-
-	log.Infof("refresh token response status %s", resp.Status)
+	resp, err := settings.ForCall(s.Creq).NewClient(context.Background(), "").Do(req)
 	if err != nil {
 		log.Errorf("Error during refreshing of the token. Error: %s", err)
+		return nil, errors.Wrap(err, "Request for Nextcloud token refresh is failed")
 	}
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		log.Errorf("refresh token response status code %d for user", resp.StatusCode)
-		return nil, errors.New("Request for Nextcloud token refresh is failed")
-	}
+	defer resp.Body.Close()
 
 	jsonResp := Token{}
 	json.NewDecoder(resp.Body).Decode(&jsonResp)
+	// storing a token without the refresh token would disconnect the user at the next expiry
+	if len(jsonResp.AccessToken) == 0 || len(jsonResp.RefreshToken) == 0 {
+		log.Errorf("Nextcloud token refresh response status code %d does not contain a token", resp.StatusCode)
+		return nil, errors.New("Nextcloud token refresh response does not contain an access and a refresh token")
+	}
+	jsonResp.SetExpiresAt(time.Now())
 	log.Infof("refresh token response status code %d for user %s", resp.StatusCode, jsonResp.UserID)
 
 	return &jsonResp, nil
 
 }
 
-func getToken(creq apps.CallRequest) (*Token, error) {
-	code, _ := creq.Values["code"].(string)
-
-	clientId := creq.Context.OAuth2.OAuth2App.ClientID
-	clientSecret := creq.Context.OAuth2.OAuth2App.ClientSecret
-	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
+// GetToken exchanges the authorization code for a token. The code verifier proves that the code is redeemed
+// by the app which started the flow.
+func (s OauthServiceImpl) GetToken(code string, codeVerifier string) (*Token, error) {
+	clientId := s.Creq.Context.OAuth2.OAuth2App.ClientID
+	clientSecret := s.Creq.Context.OAuth2.OAuth2App.ClientSecret
+	remoteUrl := s.Creq.Context.OAuth2.OAuth2App.RemoteRootURL
 
 	reqUrl := fmt.Sprintf("%s/index.php/apps/oauth2/api/v1/token", remoteUrl)
 
 	payload := RequestTokenBody{
-		Code:      code,
-		GrantType: "authorization_code",
+		Code:         code,
+		GrantType:    "authorization_code",
+		RedirectUri:  s.Creq.Context.OAuth2.CompleteURL,
+		CodeVerifier: codeVerifier,
 	}
 
 	body, _ := json.Marshal(payload)
@@ -87,22 +85,22 @@ func getToken(creq apps.CallRequest) (*Token, error) {
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
 	req.SetBasicAuth(clientId, clientSecret)
 
-	maxRetries, _ := strconv.Atoi(os.Getenv("MAX_REQUEST_RETRIES"))
-	retryClient := retryablehttp.NewClient()
-	retryClient.RetryMax = maxRetries
-	client := retryClient.StandardClient()
-	resp, err := client.Do(req)
+	resp, err := settings.ForCall(s.Creq).NewClient(context.Background(), "").Do(req)
+	if errors.Is(err, nextcloud.ErrBadRequest) {
+		log.Errorf("Nextcloud rejected the authorization code. Error: %s", err)
+		return nil, errors.New("Nextcloud rejected the authorization code, it has expired or was already used. Please run `/nextcloud connect` again")
+	}
 	if err != nil {
 		log.Errorf("Error during getting of the token. Error: %s", err)
-	}
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		log.Errorf("get token response status code %d for user", resp.StatusCode)
-		return nil, errors.New("Request for Nextcloud token is failed")
+		return nil, errors.Wrap(err, "Request for Nextcloud token is failed")
 	}
 
 	defer resp.Body.Close()
 	jsonResp := Token{}
 	json.NewDecoder(resp.Body).Decode(&jsonResp)
+	if len(jsonResp.AccessToken) == 0 {
+		return nil, errors.New("Nextcloud token response does not contain an access token")
+	}
+	jsonResp.SetExpiresAt(time.Now())
 	return &jsonResp, nil
 }
@@ -0,0 +1,46 @@
+package oauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+func TestRefreshedTokenIsReceived(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token":"new-access-token","refresh_token":"new-refresh-token","expires_in":3600,"user_id":"nc-user"}`))
+	}))
+	defer server.Close()
+	creq := apps.CallRequest{}
+	creq.Context.OAuth2.OAuth2App.RemoteRootURL = server.URL
+
+	token, err := OauthServiceImpl{Creq: creq}.RefreshToken("refresh-token")
+
+	if err != nil || token.AccessToken != "new-access-token" || token.RefreshToken != "new-refresh-token" {
+		t.Errorf("Refreshed token should be received, error %v", err)
+	}
+}
+
+func TestRefreshResponseWithoutTokenIsRejected(t *testing.T) {
+	responses := []string{
+		`{}`,
+		`{"refresh_token":"new-refresh-token","expires_in":3600}`,
+		`{"access_token":"new-access-token","expires_in":3600}`,
+	}
+	for _, response := range responses {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(response))
+		}))
+		creq := apps.CallRequest{}
+		creq.Context.OAuth2.OAuth2App.RemoteRootURL = server.URL
+
+		token, err := OauthServiceImpl{Creq: creq}.RefreshToken("refresh-token")
+		server.Close()
+
+		if err == nil || token != nil {
+			t.Errorf("Response %s should be rejected", response)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	OauthStateKvKey = "oauth-state-"
+	// oauthStateTTL is how long a user has to finish the connect flow in Nextcloud.
+	oauthStateTTL = 10 * time.Minute
+)
+
+var ErrInvalidOauthState = errors.New("The connect link has expired or was already used. Please run `/nextcloud connect` again")
+
+type OauthStateStore interface {
+	SaveState(state string, oauthState OauthState) error
+	// TakeState returns the saved state and removes it, so every state is redeemed once.
+	TakeState(state string) (*OauthState, error)
+}
+
+type BotOauthStateStore struct {
+	Creq apps.CallRequest
+}
+
+func (s BotOauthStateStore) SaveState(state string, oauthState OauthState) error {
+	asBot := appclient.AsBot(s.Creq.Context)
+	_, err := asBot.KVSet("", oauthStateKey(state), oauthState)
+	return err
+}
+
+func (s BotOauthStateStore) TakeState(state string) (*OauthState, error) {
+	asBot := appclient.AsBot(s.Creq.Context)
+	oauthState := OauthState{}
+	if err := asBot.KVGet("", oauthStateKey(state), &oauthState); err != nil {
+		return nil, err
+	}
+	if len(oauthState.CodeVerifier) == 0 {
+		return nil, nil
+	}
+	if err := asBot.KVDelete("", oauthStateKey(state)); err != nil {
+		return nil, err
+	}
+	return &oauthState, nil
+}
+
+// oauthStateKey hashes the state, so the key length does not depend on the state generated by Mattermost.
+func oauthStateKey(state string) string {
+	hash := sha256.Sum256([]byte(state))
+	return OauthStateKvKey + hex.EncodeToString(hash[:16])
+}
+
+type OauthStateService struct {
+	Store OauthStateStore
+}
+
+// CreateCodeChallenge generates a PKCE code verifier for the state and returns its S256 challenge.
+func (s OauthStateService) CreateCodeChallenge(state string, userId string) (string, error) {
+	if len(state) == 0 {
+		return "", errors.New("OAuth2 state is missing")
+	}
+	verifier, err := generateCodeVerifier()
+	if err != nil {
+		return "", err
+	}
+	err = s.Store.SaveState(state, OauthState{CodeVerifier: verifier, UserId: userId, CreatedAt: time.Now().Unix()})
+	if err != nil {
+		return "", errors.Wrap(err, "OAuth2 state was not saved")
+	}
+	return codeChallenge(verifier), nil
+}
+
+// RedeemState checks that the state was created for the user and is not stale, and returns its code verifier.
+func (s OauthStateService) RedeemState(state string, userId string) (string, error) {
+	if len(state) == 0 {
+		return "", ErrInvalidOauthState
+	}
+	oauthState, err := s.Store.TakeState(state)
+	if err != nil {
+		log.Errorf("OAuth2 state was not loaded. Error: %s", err)
+		return "", errors.Wrap(err, "OAuth2 state was not loaded")
+	}
+	if oauthState == nil {
+		log.Warnf("Unknown or already used OAuth2 state for user %s", userId)
+		return "", ErrInvalidOauthState
+	}
+	if oauthState.UserId != userId {
+		log.Warnf("OAuth2 state of user %s was redeemed by user %s", oauthState.UserId, userId)
+		return "", ErrInvalidOauthState
+	}
+	if time.Since(time.Unix(oauthState.CreatedAt, 0)) > oauthStateTTL {
+		log.Warnf("Stale OAuth2 state for user %s", userId)
+		return "", ErrInvalidOauthState
+	}
+	return oauthState.CodeVerifier, nil
+}
+
+func generateCodeVerifier() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", errors.Wrap(err, "PKCE code verifier was not generated")
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func codeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
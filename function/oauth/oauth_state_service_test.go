@@ -0,0 +1,124 @@
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+)
+
+type OauthStateStoreMock struct {
+	states map[string]OauthState
+}
+
+func (s *OauthStateStoreMock) SaveState(state string, oauthState OauthState) error {
+	s.states[state] = oauthState
+	return nil
+}
+
+func (s *OauthStateStoreMock) TakeState(state string) (*OauthState, error) {
+	oauthState, ok := s.states[state]
+	if !ok {
+		return nil, nil
+	}
+	delete(s.states, state)
+	return &oauthState, nil
+}
+
+// createTokenEndpoint emulates Nextcloud, which accepts every code once and only with the verifier of its challenge.
+func createTokenEndpoint(challenges map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := RequestTokenBody{}
+		json.NewDecoder(r.Body).Decode(&body)
+
+		challenge, ok := challenges[body.Code]
+		if !ok || codeChallenge(body.CodeVerifier) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_request"}`))
+			return
+		}
+		delete(challenges, body.Code)
+		w.Write([]byte(`{"access_token":"access-token","refresh_token":"refresh-token","expires_in":3600,"user_id":"nc-user"}`))
+	}))
+}
+
+func TestConnectFlowIsCompletedWithCodeVerifier(t *testing.T) {
+	stateService := OauthStateService{Store: &OauthStateStoreMock{states: map[string]OauthState{}}}
+	challenge, err := stateService.CreateCodeChallenge("state", "mm-user")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	server := createTokenEndpoint(map[string]string{"code": challenge})
+	defer server.Close()
+	creq := apps.CallRequest{}
+	creq.Context.OAuth2.OAuth2App.RemoteRootURL = server.URL
+
+	codeVerifier, err := stateService.RedeemState("state", "mm-user")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	token, err := OauthServiceImpl{Creq: creq}.GetToken("code", codeVerifier)
+
+	if err != nil || token.AccessToken != "access-token" {
+		t.Errorf("Token should be received, error %v", err)
+	}
+}
+
+func TestStateIsRedeemedOnce(t *testing.T) {
+	stateService := OauthStateService{Store: &OauthStateStoreMock{states: map[string]OauthState{}}}
+	stateService.CreateCodeChallenge("state", "mm-user")
+
+	_, firstErr := stateService.RedeemState("state", "mm-user")
+	_, secondErr := stateService.RedeemState("state", "mm-user")
+
+	if firstErr != nil || secondErr != ErrInvalidOauthState {
+		t.Errorf("State should be redeemed once, errors %v, %v", firstErr, secondErr)
+	}
+}
+
+func TestStateOfAnotherUserIsRejected(t *testing.T) {
+	stateService := OauthStateService{Store: &OauthStateStoreMock{states: map[string]OauthState{}}}
+	stateService.CreateCodeChallenge("state", "mm-user")
+
+	_, err := stateService.RedeemState("state", "another-mm-user")
+
+	if err != ErrInvalidOauthState {
+		t.Errorf("State of another user should be rejected, actual %v", err)
+	}
+}
+
+func TestStaleStateIsRejected(t *testing.T) {
+	store := &OauthStateStoreMock{states: map[string]OauthState{}}
+	store.SaveState("state", OauthState{CodeVerifier: "verifier", UserId: "mm-user", CreatedAt: time.Now().Add(-time.Hour).Unix()})
+	stateService := OauthStateService{Store: store}
+
+	_, err := stateService.RedeemState("state", "mm-user")
+
+	if err != ErrInvalidOauthState {
+		t.Errorf("Stale state should be rejected, actual %v", err)
+	}
+}
+
+func TestDuplicateCodeRedemptionIsRejected(t *testing.T) {
+	verifier, _ := generateCodeVerifier()
+	server := createTokenEndpoint(map[string]string{"code": codeChallenge(verifier)})
+	defer server.Close()
+	creq := apps.CallRequest{}
+	creq.Context.OAuth2.OAuth2App.RemoteRootURL = server.URL
+	oauthService := OauthServiceImpl{Creq: creq}
+
+	_, firstErr := oauthService.GetToken("code", verifier)
+	_, secondErr := oauthService.GetToken("code", verifier)
+
+	if firstErr != nil {
+		t.Errorf("unexpected error %s", firstErr)
+	}
+	if secondErr == nil || !strings.Contains(secondErr.Error(), "already used") {
+		t.Errorf("Duplicate code should be rejected with a user visible error, actual %v", secondErr)
+	}
+}
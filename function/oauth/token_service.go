@@ -0,0 +1,179 @@
+package oauth
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	log "github.com/sirupsen/logrus"
+)
+
+// tokenRefreshMargin is how long before the expiry an access token is already treated as expired,
+// so a token never runs out in the middle of a request sequence.
+const tokenRefreshMargin = 2 * time.Minute
+
+// LatestTokenKvKey keeps the last refreshed token of a Mattermost user.
+const LatestTokenKvKey = "oauth-token-"
+
+var (
+	// refreshLocks keeps one mutex per user, so only one refresh for the user is in flight.
+	// Nextcloud rotates the refresh token on every refresh, a parallel refresh with the old one fails.
+	refreshLocks sync.Map
+	// latestTokens keeps the last token refreshed by this instance. A call waiting for the lock
+	// still has the old token in its request and picks the refreshed one from here.
+	latestTokens sync.Map
+)
+
+type TokenService interface {
+	GetActualToken() (*Token, error)
+}
+
+type TokenStore interface {
+	StoreToken(token Token) error
+}
+
+type ActingUserTokenStore struct {
+	Creq apps.CallRequest
+}
+
+func (s ActingUserTokenStore) StoreToken(token Token) error {
+	asActingUser := appclient.AsActingUser(s.Creq.Context)
+	return asActingUser.StoreOAuth2User(token)
+}
+
+// SharedTokenStore keeps the last refreshed token where every instance of the app and the background jobs find it.
+type SharedTokenStore interface {
+	TokenStore
+	GetToken() Token
+}
+
+type TokenKVClient interface {
+	KVGet(prefix, id string, ref interface{}) error
+	KVSet(prefix, id string, in interface{}) (bool, error)
+	KVDelete(prefix, id string) error
+}
+
+// BotTokenStore keeps the token in the KV store of the bot. Background jobs refresh tokens without the user,
+// the next call of the user continues with the refreshed token instead of the rotated one.
+type BotTokenStore struct {
+	AsBot  TokenKVClient
+	UserId string
+}
+
+func (s BotTokenStore) StoreToken(token Token) error {
+	_, err := s.AsBot.KVSet("", LatestTokenKvKey+s.UserId, token)
+	return err
+}
+
+func (s BotTokenStore) GetToken() Token {
+	token := Token{}
+	s.AsBot.KVGet("", LatestTokenKvKey+s.UserId, &token)
+	return token
+}
+
+type TokenServiceImpl struct {
+	Creq         apps.CallRequest
+	OauthService OauthService
+	TokenStore   TokenStore
+	SharedStore  SharedTokenStore
+}
+
+func (s TokenServiceImpl) GetActualToken() (*Token, error) {
+	storedToken := GetStoredToken(s.Creq)
+	if storedToken.IsValid() {
+		return &storedToken, nil
+	}
+
+	key := s.getUserKey(storedToken)
+	lock, _ := refreshLocks.LoadOrStore(key, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	token := storedToken
+	sharedStore := s.getSharedStore(key)
+	if latest, ok := latestTokens.Load(key); ok && latest.(Token).ExpiresAt > token.ExpiresAt {
+		token = latest.(Token)
+	} else if sharedStore != nil {
+		if shared := sharedStore.GetToken(); shared.ExpiresAt > token.ExpiresAt {
+			token = shared
+		}
+	}
+
+	if token.IsValid() {
+		log.Debugf("Reusing a token refreshed by a parallel call for user %s", key)
+		return &token, nil
+	}
+
+	log.Infof("Access token expires at %d, refreshing it for user %s", token.ExpiresAt, key)
+	refreshedToken, err := s.getOauthService().RefreshToken(token.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshedToken.SetExpiresAt(time.Now())
+	latestTokens.Store(key, *refreshedToken)
+
+	err = s.getTokenStore().StoreToken(*refreshedToken)
+	if err != nil {
+		log.Errorf("Error during storing of the refreshed token for user %s. Error: %s", key, err)
+		return nil, err
+	}
+	if sharedStore != nil {
+		if err := sharedStore.StoreToken(*refreshedToken); err != nil {
+			log.Warnf("Refreshed token of user %s was not shared. Error: %s", key, err)
+		}
+	}
+
+	return refreshedToken, nil
+}
+
+// ForgetToken drops the token kept by this instance, e.g. after the user has disconnected.
+func ForgetToken(key string) {
+	latestTokens.Delete(key)
+}
+
+func (s TokenServiceImpl) getUserKey(token Token) string {
+	if s.Creq.Context.ActingUser != nil && len(s.Creq.Context.ActingUser.Id) != 0 {
+		return s.Creq.Context.ActingUser.Id
+	}
+	return token.UserID
+}
+
+func (s TokenServiceImpl) getOauthService() OauthService {
+	if s.OauthService == nil {
+		return OauthServiceImpl{Creq: s.Creq}
+	}
+	return s.OauthService
+}
+
+func (s TokenServiceImpl) getTokenStore() TokenStore {
+	if s.TokenStore == nil {
+		return ActingUserTokenStore{Creq: s.Creq}
+	}
+	return s.TokenStore
+}
+
+// getSharedStore returns the store of the token shared with the background jobs. Only the users who turned
+// the reminders or the digest on have shared their token, the token of other users is never copied to the bot KV.
+func (s TokenServiceImpl) getSharedStore(key string) SharedTokenStore {
+	if s.SharedStore != nil {
+		return s.SharedStore
+	}
+	if len(s.Creq.Context.BotAccessToken) == 0 {
+		return nil
+	}
+	store := BotTokenStore{AsBot: appclient.AsBot(s.Creq.Context), UserId: key}
+	if len(store.GetToken().RefreshToken) == 0 {
+		return nil
+	}
+	return store
+}
+
+func GetStoredToken(creq apps.CallRequest) Token {
+	token := Token{}
+	data, _ := json.Marshal(creq.Context.OAuth2.User)
+	json.Unmarshal(data, &token)
+	return token
+}
@@ -0,0 +1,130 @@
+package oauth
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-server/v6/model"
+)
+
+type OauthServiceMock struct {
+	calls int32
+}
+
+func (s *OauthServiceMock) RefreshToken(refreshToken string) (*Token, error) {
+	atomic.AddInt32(&s.calls, 1)
+	time.Sleep(10 * time.Millisecond)
+	return &Token{AccessToken: "new-access-token", RefreshToken: "new-refresh-token", ExpiresIn: 3600}, nil
+}
+
+type TokenStoreMock struct {
+	stored int32
+}
+
+func (s *TokenStoreMock) StoreToken(token Token) error {
+	atomic.AddInt32(&s.stored, 1)
+	return nil
+}
+
+func createTokenCreq(userId string, expiresAt int64) apps.CallRequest {
+	creq := apps.CallRequest{}
+	creq.Context.ActingUser = &model.User{Id: userId}
+	creq.Context.OAuth2.User = map[string]interface{}{
+		"access_token":  "access-token",
+		"refresh_token": "refresh-token",
+		"expires_in":    3600,
+		"expires_at":    expiresAt,
+		"user_id":       "nc-user",
+	}
+	return creq
+}
+
+func TestValidTokenIsReused(t *testing.T) {
+	oauthService := &OauthServiceMock{}
+	tokenStore := &TokenStoreMock{}
+	creq := createTokenCreq("valid-user", time.Now().Add(time.Hour).Unix())
+	testedInstance := TokenServiceImpl{Creq: creq, OauthService: oauthService, TokenStore: tokenStore}
+
+	token, err := testedInstance.GetActualToken()
+
+	if err != nil || token.AccessToken != "access-token" {
+		t.Error("Stored token should be reused")
+	}
+	if oauthService.calls != 0 || tokenStore.stored != 0 {
+		t.Error("Valid token should not be refreshed")
+	}
+}
+
+func TestExpiredTokenIsRefreshed(t *testing.T) {
+	oauthService := &OauthServiceMock{}
+	tokenStore := &TokenStoreMock{}
+	creq := createTokenCreq("expired-user", time.Now().Add(time.Minute).Unix())
+	testedInstance := TokenServiceImpl{Creq: creq, OauthService: oauthService, TokenStore: tokenStore}
+
+	token, err := testedInstance.GetActualToken()
+
+	if err != nil || token.AccessToken != "new-access-token" {
+		t.Error("Token close to expiry should be refreshed")
+	}
+	if token.ExpiresAt <= time.Now().Unix() {
+		t.Error("Expiry of the refreshed token should be tracked")
+	}
+	if oauthService.calls != 1 || tokenStore.stored != 1 {
+		t.Error("Refreshed token should be stored once")
+	}
+}
+
+func TestConcurrentRefreshesAreSerialized(t *testing.T) {
+	oauthService := &OauthServiceMock{}
+	tokenStore := &TokenStoreMock{}
+	creq := createTokenCreq("parallel-user", 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testedInstance := TokenServiceImpl{Creq: creq, OauthService: oauthService, TokenStore: tokenStore}
+			token, err := testedInstance.GetActualToken()
+			if err != nil || token.AccessToken != "new-access-token" {
+				t.Error("Every call should get the refreshed token")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if oauthService.calls != 1 {
+		t.Errorf("expected 1 refresh, actual %d", oauthService.calls)
+	}
+}
+
+type SharedTokenStoreMock struct {
+	TokenStoreMock
+	token Token
+}
+
+func (s *SharedTokenStoreMock) GetToken() Token {
+	return s.token
+}
+
+func TestTokenRefreshedByBackgroundJobIsReused(t *testing.T) {
+	oauthService := &OauthServiceMock{}
+	tokenStore := &TokenStoreMock{}
+	shared := &Token{AccessToken: "shared-access-token", RefreshToken: "shared-refresh-token", ExpiresIn: 3600}
+	shared.SetExpiresAt(time.Now())
+	sharedStore := &SharedTokenStoreMock{token: *shared}
+	creq := createTokenCreq("shared-user", time.Now().Add(time.Minute).Unix())
+	testedInstance := TokenServiceImpl{Creq: creq, OauthService: oauthService, TokenStore: tokenStore, SharedStore: sharedStore}
+
+	token, err := testedInstance.GetActualToken()
+
+	if err != nil || token.AccessToken != "shared-access-token" {
+		t.Error("Token refreshed by the background job should be reused")
+	}
+	if oauthService.calls != 0 {
+		t.Error("Rotated refresh token should not be used")
+	}
+}
@@ -0,0 +1,25 @@
+package function
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prokhorind/nextcloud/function/install"
+	"github.com/prokhorind/nextcloud/function/oauth"
+)
+
+// NewRouter builds the router shared by the HTTP server and the AWS Lambda entry points.
+func NewRouter() *gin.Engine {
+	r := gin.Default()
+
+	r.Use(oauth.JWTMiddleWare())
+	if staticFolder := os.Getenv("STATIC_FOLDER"); len(staticFolder) != 0 {
+		r.StaticFS(oauth.StaticPathPrefix, http.Dir(staticFolder))
+	}
+	InitHandlers(r)
+
+	r.GET(oauth.ManifestPath, install.GetManifest)
+
+	return r
+}
@@ -0,0 +1,93 @@
+package scheduler
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	log "github.com/sirupsen/logrus"
+)
+
+// Job runs periodically without an incoming call. The call request of a run has the bot, the site and
+// the OAuth2 app, the job adds the user it acts for.
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func(creq apps.CallRequest)
+}
+
+var (
+	mu          sync.RWMutex
+	jobs        []Job
+	lastContext *apps.Context
+	startOnce   sync.Once
+)
+
+func Register(job Job) {
+	mu.Lock()
+	defer mu.Unlock()
+	jobs = append(jobs, job)
+}
+
+// Capture keeps the app part of the call context for the jobs and starts them with the first captured call.
+// Only the HTTP server runs the jobs, a Lambda function does not run between calls.
+func Capture(creq apps.CallRequest) {
+	if len(creq.Context.BotAccessToken) == 0 || len(creq.Context.OAuth2.ClientID) == 0 {
+		return
+	}
+	context := appContext(creq.Context)
+	mu.Lock()
+	lastContext = &context
+	mu.Unlock()
+
+	if os.Getenv("APP_TYPE") != "HTTP" {
+		return
+	}
+	startOnce.Do(func() {
+		mu.RLock()
+		defer mu.RUnlock()
+		for _, job := range jobs {
+			log.Infof("Starting background job %s every %s", job.Name, job.Interval)
+			go schedule(job)
+		}
+	})
+}
+
+// appContext drops everything which belongs to the user of the call.
+func appContext(context apps.Context) apps.Context {
+	return apps.Context{
+		ExpandedContext: apps.ExpandedContext{
+			MattermostSiteURL: context.MattermostSiteURL,
+			AppPath:           context.AppPath,
+			BotUserID:         context.BotUserID,
+			BotAccessToken:    context.BotAccessToken,
+			OAuth2: apps.OAuth2Context{
+				OAuth2App:   context.OAuth2.OAuth2App,
+				ConnectURL:  context.OAuth2.ConnectURL,
+				CompleteURL: context.OAuth2.CompleteURL,
+			},
+		},
+	}
+}
+
+func schedule(job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		mu.RLock()
+		context := *lastContext
+		mu.RUnlock()
+		runJob(job, apps.CallRequest{Context: context})
+	}
+}
+
+// runJob keeps the ticker alive when a run fails.
+func runJob(job Job, creq apps.CallRequest) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("Background job %s failed: %v", job.Name, r)
+		}
+	}()
+	job.Run(creq)
+}
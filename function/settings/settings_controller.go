@@ -0,0 +1,120 @@
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var fieldLabels = map[string]string{
+	ChunkFileSizeMbField:   "Chunk file size",
+	MaxFileSizeMbField:     "Max file size",
+	MaxFilesSizeMbField:    "Max files size",
+	MaxRequestRetriesField: "Max request retries",
+}
+
+func HandleSettingsForm(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+
+	if !creq.Context.ActingUser.IsSystemAdmin() {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Only system admins can change app settings")))
+		return
+	}
+
+	settingsService := SettingsServiceImpl{AsBot: appclient.AsBot(creq.Context)}
+	c.JSON(http.StatusOK, apps.NewFormResponse(createSettingsForm(settingsService.GetSettings())))
+}
+
+func HandleSaveSettings(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+
+	if !creq.Context.ActingUser.IsSystemAdmin() {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.New("Only system admins can change app settings")))
+		return
+	}
+
+	appSettings, err := parseSettings(creq.Values)
+	if err == nil {
+		settingsService := SettingsServiceImpl{AsBot: appclient.AsBot(creq.Context)}
+		err = settingsService.SaveSettings(*appSettings)
+	}
+
+	if validationErr, ok := err.(ValidationError); ok {
+		c.JSON(http.StatusOK, apps.CallResponse{
+			Type: apps.CallResponseTypeError,
+			Text: validationErr.Error(),
+			Data: map[string]interface{}{"errors": validationErr},
+		})
+		return
+	}
+	if err != nil {
+		log.Errorf("App settings were not saved. Error: %s", err)
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Wrap(err, "App settings were not saved")))
+		return
+	}
+
+	c.JSON(http.StatusOK, apps.NewTextResponse("App settings were updated"))
+}
+
+func parseSettings(values map[string]interface{}) (*Settings, error) {
+	errs := ValidationError{}
+	parse := func(field string) int {
+		value := strings.TrimSpace(fmt.Sprint(values[field]))
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			errs[field] = fmt.Sprintf("%s must be a whole number", fieldLabels[field])
+		}
+		return number
+	}
+
+	appSettings := Settings{
+		ChunkFileSizeMb:   parse(ChunkFileSizeMbField),
+		MaxFileSizeMb:     parse(MaxFileSizeMbField),
+		MaxFilesSizeMb:    parse(MaxFilesSizeMbField),
+		MaxRequestRetries: parse(MaxRequestRetriesField),
+	}
+	if len(errs) != 0 {
+		return nil, errs
+	}
+	return &appSettings, nil
+}
+
+func createSettingsForm(appSettings Settings) apps.Form {
+	numberField := func(name string, value int, description string) apps.Field {
+		return apps.Field{
+			Type:        apps.FieldTypeText,
+			TextSubtype: apps.TextFieldSubtypeNumber,
+			Name:        name,
+			Label:       strings.ReplaceAll(name, "_", "-"),
+			ModalLabel:  fieldLabels[name],
+			Description: description,
+			Value:       strconv.Itoa(value),
+			IsRequired:  true,
+		}
+	}
+
+	return apps.Form{
+		Title: "Nextcloud app settings",
+		Icon:  "icon.png",
+		Fields: []apps.Field{
+			numberField(ChunkFileSizeMbField, appSettings.ChunkFileSizeMb, "Files above this size in MB are uploaded by chunks"),
+			numberField(MaxFileSizeMbField, appSettings.MaxFileSizeMb, "Max size in MB of one uploaded file"),
+			numberField(MaxFilesSizeMbField, appSettings.MaxFilesSizeMb, "Max size in MB of all files uploaded at once"),
+			numberField(MaxRequestRetriesField, appSettings.MaxRequestRetries, "How many times a failed request to Nextcloud is retried"),
+		},
+		Submit: apps.NewCall("/settings").WithExpand(apps.Expand{
+			ActingUserAccessToken: apps.ExpandAll,
+			ActingUser:            apps.ExpandAll,
+		}),
+	}
+}
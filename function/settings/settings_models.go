@@ -0,0 +1,77 @@
+package settings
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ChunkFileSizeMbField   = "chunk_file_size_mb"
+	MaxFileSizeMbField     = "max_file_size_mb"
+	MaxFilesSizeMbField    = "max_files_size_mb"
+	MaxRequestRetriesField = "max_request_retries"
+
+	maxRequestRetriesLimit = 10
+	// minChunkFileSizeMb is the smallest chunk accepted by Nextcloud chunking v2, only the last chunk may be smaller.
+	minChunkFileSizeMb = 5
+)
+
+type Settings struct {
+	ChunkFileSizeMb   int `json:"chunk_file_size_mb"`
+	MaxFileSizeMb     int `json:"max_file_size_mb"`
+	MaxFilesSizeMb    int `json:"max_files_size_mb"`
+	MaxRequestRetries int `json:"max_request_retries"`
+}
+
+func (s Settings) ChunkFileSizeInBytes() int64 {
+	return megabytesToBytes(s.ChunkFileSizeMb)
+}
+
+func (s Settings) MaxFileSizeInBytes() int64 {
+	return megabytesToBytes(s.MaxFileSizeMb)
+}
+
+func (s Settings) MaxFilesSizeInBytes() int64 {
+	return megabytesToBytes(s.MaxFilesSizeMb)
+}
+
+func megabytesToBytes(mb int) int64 {
+	return int64(mb) * 1024 * 1024
+}
+
+// ValidationError keeps a message for every invalid form field.
+type ValidationError map[string]string
+
+func (e ValidationError) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, field := range []string{ChunkFileSizeMbField, MaxFileSizeMbField, MaxFilesSizeMbField, MaxRequestRetriesField} {
+		if msg, ok := e[field]; ok {
+			messages = append(messages, msg)
+		}
+	}
+	return strings.Join(messages, ". ")
+}
+
+func (s Settings) Validate() error {
+	errs := ValidationError{}
+
+	if s.ChunkFileSizeMb < minChunkFileSizeMb {
+		errs[ChunkFileSizeMbField] = fmt.Sprintf("Chunk file size must be at least %d MB, Nextcloud does not accept smaller chunks", minChunkFileSizeMb)
+	}
+	if s.MaxFileSizeMb <= 0 {
+		errs[MaxFileSizeMbField] = "Max file size must be a positive number of MB"
+	}
+	if s.MaxFilesSizeMb <= 0 {
+		errs[MaxFilesSizeMbField] = "Max size of uploading files must be a positive number of MB"
+	} else if s.MaxFilesSizeMb < s.MaxFileSizeMb {
+		errs[MaxFilesSizeMbField] = "Max size of uploading files cannot be less than max file size"
+	}
+	if s.MaxRequestRetries < 0 || s.MaxRequestRetries > maxRequestRetriesLimit {
+		errs[MaxRequestRetriesField] = fmt.Sprintf("Max request retries must be between 0 and %d", maxRequestRetriesLimit)
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
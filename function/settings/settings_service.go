@@ -0,0 +1,103 @@
+package settings
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	"github.com/prokhorind/nextcloud/function/user"
+	log "github.com/sirupsen/logrus"
+)
+
+const SettingsKvKey = "app-settings"
+
+// Defaults are used when an env var is not set or is not a valid number.
+var defaults = Settings{
+	ChunkFileSizeMb:   15,
+	MaxFileSizeMb:     25,
+	MaxFilesSizeMb:    50,
+	MaxRequestRetries: 3,
+}
+
+type KVClient interface {
+	KVGet(prefix, id string, ref interface{}) error
+	KVSet(prefix, id string, in interface{}) (bool, error)
+}
+
+type SettingsService interface {
+	GetSettings() Settings
+	SaveSettings(s Settings) error
+}
+
+type SettingsServiceImpl struct {
+	AsBot KVClient
+}
+
+// GetSettings returns the settings saved by an admin. Values which were never saved come from env vars.
+func (s SettingsServiceImpl) GetSettings() Settings {
+	appSettings := EnvSettings()
+	if err := s.AsBot.KVGet("", SettingsKvKey, &appSettings); err != nil {
+		log.Errorf("App settings were not loaded, env settings are used. Error: %s", err)
+		return EnvSettings()
+	}
+	return appSettings
+}
+
+func (s SettingsServiceImpl) SaveSettings(appSettings Settings) error {
+	if err := appSettings.Validate(); err != nil {
+		return err
+	}
+	_, err := s.AsBot.KVSet("", SettingsKvKey, appSettings)
+	return err
+}
+
+func EnvSettings() Settings {
+	return Settings{
+		ChunkFileSizeMb:   envInt("CHUNK_FILE_SIZE_MB", defaults.ChunkFileSizeMb),
+		MaxFileSizeMb:     envInt("MAX_FILE_SIZE_MB", defaults.MaxFileSizeMb),
+		MaxFilesSizeMb:    envInt("MAX_FILES_SIZE_MB", defaults.MaxFilesSizeMb),
+		MaxRequestRetries: envInt("MAX_REQUEST_RETRIES", defaults.MaxRequestRetries),
+	}
+}
+
+func envInt(key string, fallback int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		log.Errorf("Env variable %s has invalid value %q, default %d is used", key, value, fallback)
+		return fallback
+	}
+	return number
+}
+
+// ForCall reads the app settings once for the call.
+func ForCall(creq apps.CallRequest) Settings {
+	settingsService := SettingsServiceImpl{AsBot: appclient.AsBot(creq.Context)}
+	return settingsService.GetSettings()
+}
+
+// NewClient creates a Nextcloud client which follows the request policy of the settings.
+func (s Settings) NewClient(ctx context.Context, token string) nextcloud.Client {
+	client := nextcloud.NewClient(token)
+	client.RetryMax = s.MaxRequestRetries
+	return client.WithContext(ctx)
+}
+
+// NewUserClient creates a client for the acting user, which also tracks the last successful call of the user.
+func (s Settings) NewUserClient(ctx context.Context, creq apps.CallRequest, token string) nextcloud.Client {
+	client := s.NewClient(ctx, token)
+	if creq.Context.ActingUser != nil {
+		lastCallService := user.LastCallServiceImpl{AsBot: appclient.AsBot(creq.Context)}
+		userId := creq.Context.ActingUser.Id
+		client.OnSuccess = func() {
+			lastCallService.TrackCall(userId)
+		}
+	}
+	return client
+}
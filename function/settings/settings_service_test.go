@@ -0,0 +1,83 @@
+package settings
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type KVClientMock struct {
+	stored []byte
+}
+
+func (m *KVClientMock) KVGet(prefix, id string, ref interface{}) error {
+	if m.stored == nil {
+		return nil
+	}
+	return json.Unmarshal(m.stored, ref)
+}
+
+func (m *KVClientMock) KVSet(prefix, id string, in interface{}) (bool, error) {
+	m.stored, _ = json.Marshal(in)
+	return true, nil
+}
+
+func TestEnvSettingsAreUsedByDefault(t *testing.T) {
+	t.Setenv("CHUNK_FILE_SIZE_MB", "5")
+	t.Setenv("MAX_FILE_SIZE_MB", "not-a-number")
+
+	testedInstance := SettingsServiceImpl{AsBot: &KVClientMock{}}
+	appSettings := testedInstance.GetSettings()
+
+	if appSettings.ChunkFileSizeMb != 5 {
+		t.Errorf("expected %d, actual %d", 5, appSettings.ChunkFileSizeMb)
+	}
+	if appSettings.MaxFileSizeMb != defaults.MaxFileSizeMb {
+		t.Errorf("Invalid env value should be replaced by default %d, actual %d", defaults.MaxFileSizeMb, appSettings.MaxFileSizeMb)
+	}
+}
+
+func TestSavedSettingsOverrideEnv(t *testing.T) {
+	t.Setenv("MAX_REQUEST_RETRIES", "1")
+	saved := Settings{ChunkFileSizeMb: 10, MaxFileSizeMb: 20, MaxFilesSizeMb: 30, MaxRequestRetries: 5}
+
+	testedInstance := SettingsServiceImpl{AsBot: &KVClientMock{}}
+	err := testedInstance.SaveSettings(saved)
+
+	if err != nil {
+		t.Errorf("unexpected error %s", err)
+	}
+	if testedInstance.GetSettings() != saved {
+		t.Error("Saved settings should be returned")
+	}
+}
+
+func TestInvalidSettingsAreNotSaved(t *testing.T) {
+	kv := &KVClientMock{}
+	testedInstance := SettingsServiceImpl{AsBot: kv}
+
+	err := testedInstance.SaveSettings(Settings{ChunkFileSizeMb: 0, MaxFileSizeMb: 20, MaxFilesSizeMb: 10, MaxRequestRetries: -1})
+
+	validationErr, ok := err.(ValidationError)
+	if !ok || len(validationErr) != 3 {
+		t.Errorf("expected 3 field errors, actual %v", err)
+	}
+	if kv.stored != nil {
+		t.Error("Invalid settings should not be saved")
+	}
+}
+
+func TestNotNumericValuesAreRejected(t *testing.T) {
+	values := map[string]interface{}{
+		ChunkFileSizeMbField:   "10",
+		MaxFileSizeMbField:     "ten",
+		MaxFilesSizeMbField:    "30",
+		MaxRequestRetriesField: nil,
+	}
+
+	_, err := parseSettings(values)
+
+	validationErr, ok := err.(ValidationError)
+	if !ok || len(validationErr[MaxFileSizeMbField]) == 0 || len(validationErr[MaxRequestRetriesField]) == 0 {
+		t.Errorf("Not numeric fields should be rejected, actual %v", err)
+	}
+}
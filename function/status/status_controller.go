@@ -0,0 +1,149 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/calendar"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	"github.com/prokhorind/nextcloud/function/oauth"
+	"github.com/prokhorind/nextcloud/function/settings"
+	"github.com/prokhorind/nextcloud/function/user"
+	log "github.com/sirupsen/logrus"
+)
+
+func HandleStatusCommand(c *gin.Context) {
+	creq := apps.CallRequest{}
+	json.NewDecoder(c.Request.Body).Decode(&creq)
+
+	storedToken := oauth.GetStoredToken(creq)
+	if len(storedToken.AccessToken) == 0 {
+		c.JSON(http.StatusOK, apps.NewTextResponse("You are not connected to Nextcloud. Run `/nextcloud connect` to connect your account."))
+		return
+	}
+
+	tokenService := oauth.TokenServiceImpl{Creq: creq}
+	token, tokenErr := tokenService.GetActualToken()
+	if tokenErr != nil {
+		log.Errorf("Token of user %s was not refreshed. Error: %s", creq.Context.ActingUser.Id, tokenErr)
+		sendReconnectPost(creq)
+		c.JSON(http.StatusOK, apps.NewTextResponse("Your Nextcloud token does not work anymore. Use the reconnect button to connect your account again."))
+		return
+	}
+
+	// The status requests are not tracked, the last call is the last one before the status was asked
+	lastCallService := user.LastCallServiceImpl{AsBot: appclient.AsBot(creq.Context)}
+	lastCall := lastCallService.GetLastCall(creq.Context.ActingUser.Id)
+	remoteUrl := creq.Context.OAuth2.OAuth2App.RemoteRootURL
+	statusService := StatusServiceImpl{RemoteUrl: remoteUrl, Client: settings.ForCall(creq).NewClient(c.Request.Context(), token.AccessToken)}
+	ncUser, err := statusService.GetUser()
+	if errors.Is(err, nextcloud.ErrUnauthorized) {
+		sendReconnectPost(creq)
+		c.JSON(http.StatusOK, apps.NewTextResponse("Your Nextcloud token was revoked. Use the reconnect button to connect your account again."))
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, apps.NewErrorResponse(errors.Wrap(err, "Nextcloud account details were not received")))
+		return
+	}
+
+	connectionStatus := ConnectionStatus{
+		User:          *ncUser,
+		ServerVersion: statusService.GetServerVersion(),
+		TokenExpiry:   token.ExpiresAt,
+		LastCall:      lastCall,
+	}
+
+	loc := calendar.CalendarTimePostService{}.GetMMUserLocation(creq)
+	c.JSON(http.StatusOK, apps.NewTextResponse(formatStatus(connectionStatus, remoteUrl, loc)))
+}
+
+func formatStatus(status ConnectionStatus, remoteUrl string, loc *time.Location) string {
+	lines := []string{
+		"#### Connected to Nextcloud",
+		"| | |",
+		"|:--|:--|",
+		fmt.Sprintf("| Nextcloud user | %s (%s) |", status.User.DisplayName, status.User.Id),
+		fmt.Sprintf("| Quota | %s |", formatQuota(status.User.Quota)),
+		fmt.Sprintf("| Server | %s, version %s |", remoteUrl, status.ServerVersion),
+		fmt.Sprintf("| Token expires | %s |", formatTime(status.TokenExpiry, loc)),
+		fmt.Sprintf("| Last successful call | %s |", formatTime(status.LastCall, loc)),
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatQuota(quota Quota) string {
+	used, err := quota.Used.Int64()
+	if err != nil {
+		return "unknown"
+	}
+	if quota.IsUnlimited() {
+		return fmt.Sprintf("%s used, unlimited", formatSize(used))
+	}
+	total, err := quota.Total.Int64()
+	if err != nil || total == 0 {
+		return fmt.Sprintf("%s used", formatSize(used))
+	}
+	return fmt.Sprintf("%s of %s used (%.1f%%)", formatSize(used), formatSize(total), float64(used)*100/float64(total))
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func formatTime(unix int64, loc *time.Location) string {
+	if unix == 0 {
+		return "unknown"
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	return time.Unix(unix, 0).In(loc).Format("Jan 2, 2006 15:04 MST")
+}
+
+func sendReconnectPost(creq apps.CallRequest) {
+	post := model.Post{Message: "Your Nextcloud account is disconnected"}
+	post.SetProps(map[string]interface{}{
+		"app_bindings": []apps.Binding{
+			{
+				Location:    "embedded",
+				AppID:       "nextcloud",
+				Description: "Connect your Nextcloud account again to keep using the app",
+				Bindings: []apps.Binding{
+					{
+						Location: "reconnect",
+						Label:    "Reconnect",
+						Submit: apps.NewCall("/connect").WithExpand(apps.Expand{
+							OAuth2App:             apps.ExpandAll,
+							OAuth2User:            apps.ExpandAll,
+							ActingUserAccessToken: apps.ExpandAll,
+							ActingUser:            apps.ExpandAll,
+						}),
+					},
+				},
+			},
+		},
+	})
+
+	asBot := appclient.AsBot(creq.Context)
+	if _, err := asBot.DMPost(creq.Context.ActingUser.Id, &post); err != nil {
+		log.Errorf("Reconnect post was not sent to user %s. Error: %s", creq.Context.ActingUser.Id, err)
+	}
+}
@@ -0,0 +1,41 @@
+package status
+
+import "encoding/json"
+
+type OcsUserResponse struct {
+	Ocs struct {
+		Data OcsUser `json:"data"`
+	} `json:"ocs"`
+}
+
+type OcsUser struct {
+	Id          string `json:"id"`
+	DisplayName string `json:"displayname"`
+	Email       string `json:"email"`
+	Quota       Quota  `json:"quota"`
+}
+
+type Quota struct {
+	Free     json.Number `json:"free"`
+	Used     json.Number `json:"used"`
+	Total    json.Number `json:"total"`
+	Relative json.Number `json:"relative"`
+	Quota    json.Number `json:"quota"`
+}
+
+// IsUnlimited reports whether the quota is not set. Nextcloud returns negative values for an unlimited quota.
+func (q Quota) IsUnlimited() bool {
+	quota, err := q.Quota.Int64()
+	return err == nil && quota < 0
+}
+
+type InstanceStatus struct {
+	VersionString string `json:"versionstring"`
+}
+
+type ConnectionStatus struct {
+	User          OcsUser
+	ServerVersion string
+	TokenExpiry   int64
+	LastCall      int64
+}
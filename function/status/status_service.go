@@ -0,0 +1,58 @@
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+	log "github.com/sirupsen/logrus"
+)
+
+type StatusService interface {
+	GetUser() (*OcsUser, error)
+	GetServerVersion() string
+}
+
+type StatusServiceImpl struct {
+	RemoteUrl string
+	Client    nextcloud.Client
+}
+
+func (s StatusServiceImpl) GetUser() (*OcsUser, error) {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/ocs/v2.php/cloud/user?format=json", s.RemoteUrl), nil)
+	req.Header.Set("OCS-APIRequest", "true")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if err != nil {
+		log.Errorf("Nextcloud user was not received. Error: %s", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	userResponse := OcsUserResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&userResponse); err != nil {
+		return nil, errors.Wrap(err, "Nextcloud user response is not valid")
+	}
+	return &userResponse.Ocs.Data, nil
+}
+
+func (s StatusServiceImpl) GetServerVersion() string {
+	req, _ := http.NewRequest("GET", fmt.Sprintf("%s/status.php", s.RemoteUrl), nil)
+
+	resp, err := s.Client.Do(req, http.StatusOK)
+	if err != nil {
+		log.Errorf("Nextcloud status was not received. Error: %s", err)
+		return "unknown"
+	}
+	defer resp.Body.Close()
+
+	instanceStatus := InstanceStatus{}
+	json.NewDecoder(resp.Body).Decode(&instanceStatus)
+	if len(instanceStatus.VersionString) == 0 {
+		return "unknown"
+	}
+	return instanceStatus.VersionString
+}
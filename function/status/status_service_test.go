@@ -0,0 +1,74 @@
+package status
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prokhorind/nextcloud/function/nextcloud"
+)
+
+func TestUserIsReceived(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ocs/v2.php/cloud/user" || r.Header.Get("OCS-APIRequest") != "true" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"ocs":{"data":{"id":"nc-user","displayname":"NC User","quota":{"free":1024,"used":1024,"total":2048,"relative":50,"quota":2048}}}}`))
+	}))
+	defer server.Close()
+
+	testedInstance := StatusServiceImpl{RemoteUrl: server.URL, Client: nextcloud.NewClient("token")}
+	ncUser, err := testedInstance.GetUser()
+
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if ncUser.Id != "nc-user" || ncUser.DisplayName != "NC User" {
+		t.Errorf("Unexpected user %v", ncUser)
+	}
+	if formatQuota(ncUser.Quota) != "1.0 KB of 2.0 KB used (50.0%)" {
+		t.Errorf("Unexpected quota %s", formatQuota(ncUser.Quota))
+	}
+}
+
+func TestRevokedTokenIsDetected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	testedInstance := StatusServiceImpl{RemoteUrl: server.URL, Client: nextcloud.NewClient("token")}
+	_, err := testedInstance.GetUser()
+
+	if !errors.Is(err, nextcloud.ErrUnauthorized) {
+		t.Errorf("Revoked token should be returned as unauthorized, actual %v", err)
+	}
+}
+
+func TestUnlimitedQuotaIsFormatted(t *testing.T) {
+	quota := Quota{Used: "1572864", Quota: "-3"}
+
+	if formatQuota(quota) != "1.5 MB used, unlimited" {
+		t.Errorf("Unexpected quota %s", formatQuota(quota))
+	}
+}
+
+func TestStatusIsFormatted(t *testing.T) {
+	status := ConnectionStatus{
+		User:          OcsUser{Id: "nc-user", DisplayName: "NC User"},
+		ServerVersion: "27.1.2",
+		TokenExpiry:   time.Date(2023, 1, 2, 15, 4, 0, 0, time.UTC).Unix(),
+	}
+
+	formatted := formatStatus(status, "https://cloud.example.com", time.UTC)
+
+	for _, expected := range []string{"NC User (nc-user)", "version 27.1.2", "Jan 2, 2023 15:04 UTC", "| Last successful call | unknown |"} {
+		if !strings.Contains(formatted, expected) {
+			t.Errorf("%q is missing in status %s", expected, formatted)
+		}
+	}
+}
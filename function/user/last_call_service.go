@@ -0,0 +1,45 @@
+package user
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-apps/apps/appclient"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	LastCallKvKey = "last-call-"
+	// lastCallPrecision limits KV writes to one per user in this period, a call in between is not tracked.
+	lastCallPrecision = time.Minute
+)
+
+var trackedCalls sync.Map
+
+type LastCallService interface {
+	TrackCall(userId string)
+	GetLastCall(userId string) int64
+}
+
+type LastCallServiceImpl struct {
+	AsBot *appclient.Client
+}
+
+// TrackCall stores the time of the last successful Nextcloud call of the user.
+func (s LastCallServiceImpl) TrackCall(userId string) {
+	now := time.Now()
+	if tracked, ok := trackedCalls.Load(userId); ok && now.Sub(tracked.(time.Time)) < lastCallPrecision {
+		return
+	}
+	trackedCalls.Store(userId, now)
+
+	if _, err := s.AsBot.KVSet("", LastCallKvKey+userId, now.Unix()); err != nil {
+		log.Errorf("Last call of user %s was not stored. Error: %s", userId, err)
+	}
+}
+
+func (s LastCallServiceImpl) GetLastCall(userId string) int64 {
+	var lastCall int64
+	s.AsBot.KVGet("", LastCallKvKey+userId, &lastCall)
+	return lastCall
+}
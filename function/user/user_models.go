@@ -2,6 +2,20 @@ package user
 
 type UserSettings struct {
 	DisabledCalendars []string `json:"disabled_calendars"`
+	// Reminders enables direct messages before the events which have a reminder
+	Reminders bool `json:"reminders"`
+	// Digest is the agenda the bot sends to the user
+	Digest DigestSettings `json:"digest"`
+}
+
+type DigestSettings struct {
+	Enabled bool `json:"enabled"`
+	// Time is the time of the day in the timezone of the user, e.g. 08:30
+	Time string `json:"time"`
+	// Weekdays are the days of the digest, e.g. MO
+	Weekdays []string `json:"weekdays"`
+	// Period is today or week
+	Period string `json:"period"`
 }
 
 func (u UserSettings) Contains(str string) bool {
@@ -20,13 +34,15 @@ func (u UserSettings) RemoveDisabledCalendar(el string) UserSettings {
 			temp = append(temp, x)
 		}
 	}
-	return UserSettings{temp}
+	u.DisabledCalendars = temp
+	return u
 }
 
 func (u UserSettings) AddDisabledCalendar(el string) UserSettings {
 	if u.Contains(el) {
 		return u
 	}
-	return UserSettings{append(u.DisabledCalendars, el)}
+	u.DisabledCalendars = append(u.DisabledCalendars, el)
+	return u
 
 }
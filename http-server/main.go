@@ -1,24 +1,14 @@
 package main
 
 import (
-	"github.com/prokhorind/nextcloud/function/install"
-	"github.com/prokhorind/nextcloud/function/oauth"
-	"net/http"
 	"net/url"
 	"os"
 
-	"github.com/gin-gonic/gin"
 	"github.com/prokhorind/nextcloud/function"
 )
 
 func main() {
-	r := gin.Default()
-
-	r.Use(oauth.JWTMiddleWare())
-	r.StaticFS("/static/", http.Dir(os.Getenv("STATIC_FOLDER")))
-	function.InitHandlers(r)
-
-	r.GET("/manifest.json", install.GetManifest)
+	r := function.NewRouter()
 
 	port := getPort()
 